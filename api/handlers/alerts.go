@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.uber.org/zap"
+)
+
+type AlertsHandler struct {
+	alertsRepo repos.StockAlertRepository
+	logger     *zap.Logger
+}
+
+func NewAlertsHandler(alerts repos.StockAlertRepository, logger *zap.Logger) *AlertsHandler {
+	return &AlertsHandler{alertsRepo: alerts, logger: logger}
+}
+
+// GetAllAlerts godoc
+// @Summary      List stock alerts
+// @Description  List the alerts raised for products whose stock fell to their reorder point,
+// @Description  most recently raised first. An alert resolves itself once the product is
+// @Description  restocked above its reorder point.
+// @Tags         alerts
+// @Produce      json
+// @Param        status      query     string  false  "open, acknowledged or resolved"
+// @Param        product_id  query     string  false  "Only the alerts of this product"
+// @Param        limit       query     int     false  "Items per page, at most 100"
+// @Param        cursor      query     string  false  "Cursor from the links of a previous page"
+// @Success      200         {object}  models.StockAlertList
+// @Failure      400         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /alerts [get]
+func (h *AlertsHandler) GetAllAlerts(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.StockAlertFilter{Status: c.Query("status"), ProductID: c.Query("product_id")}
+	alerts, err := h.alertsRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve alerts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+
+	total, err := h.alertsRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count alerts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+
+	items, links := pageOf(c, opts, alerts, total, func(a *models.StockAlert) string { return a.ID })
+	c.JSON(http.StatusOK, models.StockAlertList{Items: items, Total: total, Links: links})
+}
+
+// GetAlertByID godoc
+// @Summary      Get stock alert by ID
+// @Tags         alerts
+// @Produce      json
+// @Param        id   path      string  true  "Alert ID"
+// @Success      200  {object}  models.StockAlert
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /alerts/{id} [get]
+func (h *AlertsHandler) GetAlertByID(c *gin.Context) {
+	alert, err := h.alertsRepo.FindByID(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve alert", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alert"})
+		return
+	}
+
+	if notModified(c, alert.Version) {
+		return
+	}
+	setETag(c, alert.Version)
+	c.JSON(http.StatusOK, alert)
+}
+
+// AcknowledgeAlert godoc
+// @Summary      Acknowledge a stock alert
+// @Description  Mark an open alert as being handled, on behalf of the X-Actor of the request.
+// @Tags         alerts
+// @Produce      json
+// @Param        id   path      string  true  "Alert ID"
+// @Success      200  {object}  models.StockAlert
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /alerts/{id}/acknowledge [post]
+func (h *AlertsHandler) AcknowledgeAlert(c *gin.Context) {
+	alert, err := h.alertsRepo.Acknowledge(c.Request.Context(), c.Param("id"))
+	h.respond(c, alert, err, "Alert is not open", "acknowledge")
+}
+
+// ResolveAlert godoc
+// @Summary      Resolve a stock alert
+// @Description  Close an open or acknowledged alert. No new alert is raised for the product
+// @Description  until it has been restocked above its reorder point.
+// @Tags         alerts
+// @Produce      json
+// @Param        id   path      string  true  "Alert ID"
+// @Success      200  {object}  models.StockAlert
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /alerts/{id}/resolve [post]
+func (h *AlertsHandler) ResolveAlert(c *gin.Context) {
+	alert, err := h.alertsRepo.Resolve(c.Request.Context(), c.Param("id"))
+	h.respond(c, alert, err, "Alert is already resolved", "resolve")
+}
+
+// respond writes the result of moving an alert on by action.
+func (h *AlertsHandler) respond(c *gin.Context, alert *models.StockAlert, err error, conflict, action string) {
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": conflict})
+		return
+	case err != nil:
+		h.logger.Error("Failed to "+action+" alert", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " alert"})
+		return
+	}
+
+	setETag(c, alert.Version)
+	c.JSON(http.StatusOK, alert)
+}
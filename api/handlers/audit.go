@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/repos"
+	"go.uber.org/zap"
+)
+
+type AuditHandler struct {
+	auditRepo repos.AuditRepository
+	logger    *zap.Logger
+}
+
+func NewAuditHandler(audit repos.AuditRepository, logger *zap.Logger) *AuditHandler {
+	return &AuditHandler{auditRepo: audit, logger: logger}
+}
+
+// GetAuditLog godoc
+// @Summary      List audit entries
+// @Description  List the recorded changes to products and orders, newest first. entity and id
+// @Description  are shorthands for filter[entity_type] and filter[entity_id]; filters also
+// @Description  apply to actor, operation, request_id, field (a changed field) and timestamp.
+// @Tags         audit
+// @Produce      json
+// @Param        entity  query     string  false  "Entity type: product or order"
+// @Param        id      query     string  false  "Entity ID"
+// @Param        limit   query     int     false  "Items per page, at most 100"
+// @Param        cursor  query     string  false  "Cursor from the links of a previous page"
+// @Param        page    query     int     false  "Page number (legacy offset pagination)"
+// @Success      200     {object}  models.AuditList
+// @Failure      400     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /audit [get]
+func (h *AuditHandler) GetAuditLog(c *gin.Context) {
+	opts, conditions, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if entity := c.Query("entity"); entity != "" {
+		conditions = append(conditions, query.Condition{Field: "entity_type", Op: query.Eq, Values: []string{entity}})
+	}
+	if id := c.Query("id"); id != "" {
+		conditions = append(conditions, query.Condition{Field: "entity_id", Op: query.Eq, Values: []string{id}})
+	}
+	filter := repos.AuditFilter{Conditions: conditions}
+
+	entries, err := h.auditRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if errors.Is(err, query.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve audit entries", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit entries"})
+		return
+	}
+
+	total, err := h.auditRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count audit entries", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit entries"})
+		return
+	}
+
+	items, links := pageOf(c, opts, entries, total, func(e *models.AuditEntry) string { return e.ID })
+	c.JSON(http.StatusOK, models.AuditList{Items: items, Total: total, Links: links})
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
+	"go.uber.org/zap"
+)
+
+type CartsHandler struct {
+	carts     *service.CartService
+	cartsRepo repos.CartRepository
+	logger    *zap.Logger
+}
+
+func NewCartsHandler(carts *service.CartService, repo repos.CartRepository, logger *zap.Logger) *CartsHandler {
+	return &CartsHandler{carts: carts, cartsRepo: repo, logger: logger}
+}
+
+// CartInput opens a cart, for a customer or anonymously.
+type CartInput struct {
+	CustomerID string `json:"customer_id"`
+}
+
+// CartLineInput adds a product, or a variant, to a cart.
+type CartLineInput struct {
+	ProductID string `json:"product_id" binding:"required"`
+	VariantID string `json:"variant_id"`
+	Quantity  int    `json:"quantity" binding:"required"`
+}
+
+// CartQuantityInput sets the quantity of a cart line.
+type CartQuantityInput struct {
+	Quantity int `json:"quantity" binding:"required"`
+}
+
+// CartCouponInput enters a coupon code for a cart.
+type CartCouponInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// CreateCart godoc
+// @Summary      Open a cart
+// @Description  Open an empty cart, for a customer or anonymously. Carts expire when they have
+// @Description  not been changed for CART_TTL.
+// @Tags         carts
+// @Accept       json
+// @Produce      json
+// @Param        cart  body      CartInput  false  "Customer of the cart"
+// @Success      201   {object}  models.Cart
+// @Failure      400   {object}  map[string]string
+// @Failure      500   {object}  map[string]string
+// @Router       /carts [post]
+func (h *CartsHandler) CreateCart(c *gin.Context) {
+	var input CartInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			h.logger.Error("Invalid input", zap.Error(err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	cart, err := h.carts.Create(c.Request.Context(), input.CustomerID)
+	if errors.Is(err, service.ErrInvalidCart) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
+		return
+	}
+
+	setETag(c, cart.Version)
+	c.JSON(http.StatusCreated, cart)
+}
+
+// GetCartByID godoc
+// @Summary      Get a cart
+// @Description  Return the cart, with issues listing the lines whose catalog price or stock has
+// @Description  changed since they were quoted. The cart is priced as an order would be: the
+// @Description  promotions that apply, the shipping fee and the total, with the coupon codes
+// @Description  that were not applied and why.
+// @Tags         carts
+// @Produce      json
+// @Param        id   path      string  true  "Cart ID"
+// @Success      200  {object}  models.Cart
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /carts/{id} [get]
+func (h *CartsHandler) GetCartByID(c *gin.Context) {
+	cart, err := h.carts.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cart"})
+		return
+	}
+
+	setETag(c, cart.Version)
+	c.JSON(http.StatusOK, cart)
+}
+
+// DeleteCart godoc
+// @Summary      Delete a cart
+// @Tags         carts
+// @Produce      json
+// @Param        id   path      string  true  "Cart ID"
+// @Success      204  {object}  nil
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /carts/{id} [delete]
+func (h *CartsHandler) DeleteCart(c *gin.Context) {
+	err := h.cartsRepo.Delete(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete cart"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// AddCartLine godoc
+// @Summary      Add a product to a cart
+// @Description  Add quantity of a product, or variant, quoted at its catalog price. A product
+// @Description  already in the cart has its line raised. There must be enough stock for the
+// @Description  whole line.
+// @Tags         carts
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string         true   "Cart ID"
+// @Param        If-Match  header    string         false  "ETag of the version being changed"
+// @Param        line      body      CartLineInput  true   "Product and quantity"
+// @Success      200       {object}  models.Cart
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /carts/{id}/lines [post]
+func (h *CartsHandler) AddCartLine(c *gin.Context) {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	var input CartLineInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	line := models.CartLine{ProductID: input.ProductID, VariantID: input.VariantID, Quantity: input.Quantity}
+	cart, err := h.carts.AddLine(c.Request.Context(), c.Param("id"), version, line)
+	h.respond(c, cart, err, "add to")
+}
+
+// UpdateCartLine godoc
+// @Summary      Change the quantity of a cart line
+// @Description  Set the quantity of a line, quoting it again at the catalog price.
+// @Tags         carts
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string             true   "Cart ID"
+// @Param        lineId    path      string             true   "Line ID"
+// @Param        If-Match  header    string             false  "ETag of the version being changed"
+// @Param        line      body      CartQuantityInput  true   "New quantity"
+// @Success      200       {object}  models.Cart
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /carts/{id}/lines/{lineId} [put]
+func (h *CartsHandler) UpdateCartLine(c *gin.Context) {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	var input CartQuantityInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	cart, err := h.carts.UpdateLine(c.Request.Context(), c.Param("id"), version, c.Param("lineId"), input.Quantity)
+	h.respond(c, cart, err, "update")
+}
+
+// RemoveCartLine godoc
+// @Summary      Remove a line from a cart
+// @Tags         carts
+// @Produce      json
+// @Param        id        path      string  true   "Cart ID"
+// @Param        lineId    path      string  true   "Line ID"
+// @Param        If-Match  header    string  false  "ETag of the version being changed"
+// @Success      200       {object}  models.Cart
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /carts/{id}/lines/{lineId} [delete]
+func (h *CartsHandler) RemoveCartLine(c *gin.Context) {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	cart, err := h.carts.RemoveLine(c.Request.Context(), c.Param("id"), version, c.Param("lineId"))
+	h.respond(c, cart, err, "update")
+}
+
+// AddCartCoupon godoc
+// @Summary      Enter a coupon code for a cart
+// @Description  Add a coupon code to the cart. Unknown codes are refused; codes that do not
+// @Description  apply to the cart as it is are kept and listed in rejected_coupons.
+// @Tags         carts
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string           true   "Cart ID"
+// @Param        If-Match  header    string           false  "ETag of the version being changed"
+// @Param        coupon    body      CartCouponInput  true   "Coupon code"
+// @Success      200       {object}  models.Cart
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /carts/{id}/coupons [post]
+func (h *CartsHandler) AddCartCoupon(c *gin.Context) {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	var input CartCouponInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	cart, err := h.carts.AddCoupon(c.Request.Context(), c.Param("id"), version, input.Code)
+	h.respond(c, cart, err, "update")
+}
+
+// RemoveCartCoupon godoc
+// @Summary      Remove a coupon code from a cart
+// @Tags         carts
+// @Produce      json
+// @Param        id        path      string  true   "Cart ID"
+// @Param        code      path      string  true   "Coupon code"
+// @Param        If-Match  header    string  false  "ETag of the version being changed"
+// @Success      200       {object}  models.Cart
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /carts/{id}/coupons/{code} [delete]
+func (h *CartsHandler) RemoveCartCoupon(c *gin.Context) {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	cart, err := h.carts.RemoveCoupon(c.Request.Context(), c.Param("id"), version, c.Param("code"))
+	h.respond(c, cart, err, "update")
+}
+
+// CheckoutCart godoc
+// @Summary      Check out a cart
+// @Description  Place the cart as an order. Anonymous carts need a customer_id. If prices
+// @Description  changed, or stock fell short, since the lines were quoted, nothing is ordered:
+// @Description  the response is 409 with the cart, whose issues list what changed and whose
+// @Description  changed prices are quoted again, so it can be reviewed and checked out again.
+// @Description  A cart is ordered at most once. Its coupon codes must all be usable, and the
+// @Description  response is 409 if one has reached its usage limit.
+// @Tags         carts
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string           true   "Cart ID"
+// @Param        If-Match  header    string           false  "ETag of the version being checked out"
+// @Param        checkout  body      models.Checkout  false  "Customer and addresses"
+// @Success      201       {object}  models.Order
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]interface{}
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /carts/{id}/checkout [post]
+func (h *CartsHandler) CheckoutCart(c *gin.Context) {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	var checkout models.Checkout
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&checkout); err != nil {
+			h.logger.Error("Invalid input", zap.Error(err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	order, err := h.carts.Checkout(c.Request.Context(), c.Param("id"), version, &checkout)
+	var changed *service.CheckoutError
+	switch {
+	case errors.As(err, &changed):
+		c.JSON(http.StatusConflict, gin.H{"error": changed.Error(), "cart": changed.Cart})
+		return
+	case errors.Is(err, service.ErrInvalidCart), errors.Is(err, service.ErrInvalidOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	case errors.Is(err, service.ErrCartClosed), errors.Is(err, service.ErrPriceChanged), errors.Is(err, repos.ErrInsufficientStock), errors.Is(err, repos.ErrLimitReached):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Cart was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to check out cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check out cart"})
+		return
+	}
+
+	setETag(c, order.Version)
+	c.JSON(http.StatusCreated, order)
+}
+
+// respond writes the result of changing the lines of a cart.
+func (h *CartsHandler) respond(c *gin.Context, cart *models.Cart, err error, action string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidCart):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart, line or coupon not found"})
+		return
+	case errors.Is(err, service.ErrCartClosed), errors.Is(err, repos.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Cart was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to "+action+" cart", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " cart"})
+		return
+	}
+
+	setETag(c, cart.Version)
+	c.JSON(http.StatusOK, cart)
+}
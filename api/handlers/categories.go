@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/pkg/slug"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type CategoriesHandler struct {
+	categoriesRepo repos.CategoryRepository
+	productsRepo   repos.ProductRepository
+	logger         *zap.Logger
+}
+
+func NewCategoriesHandler(categories repos.CategoryRepository, products repos.ProductRepository, logger *zap.Logger) *CategoriesHandler {
+	return &CategoriesHandler{
+		categoriesRepo: categories,
+		productsRepo:   products,
+		logger:         logger,
+	}
+}
+
+// CategoryInput is the writable part of a category.
+type CategoryInput struct {
+	Name       string                `json:"name" binding:"required"`
+	Slug       string                `json:"slug"`
+	ParentID   string                `json:"parent_id"`
+	Attributes []models.AttributeDef `json:"attributes"`
+	Version    int64                 `json:"version"`
+}
+
+func (in *CategoryInput) category() (*models.Category, error) {
+	if err := models.ValidateAttributeDefs(in.Attributes); err != nil {
+		return nil, err
+	}
+	s := in.Slug
+	if s == "" {
+		s = slug.Make(in.Name)
+	}
+	return &models.Category{
+		Name:       in.Name,
+		Slug:       slug.Make(s),
+		ParentID:   in.ParentID,
+		Attributes: in.Attributes,
+		Version:    in.Version,
+	}, nil
+}
+
+// CreateCategory godoc
+// @Summary      Create a category
+// @Description  Add a category, optionally below a parent. The slug defaults to one derived from the name.
+// @Description  Attributes declare the typed fields (string, number, bool or enum) its products carry;
+// @Description  subcategories inherit them.
+// @Tags         categories
+// @Accept       json
+// @Produce      json
+// @Param        category  body      CategoryInput  true  "Category details"
+// @Success      201       {object}  models.Category
+// @Failure      400       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /categories [post]
+func (h *CategoriesHandler) CreateCategory(c *gin.Context) {
+	var input CategoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	category, err := input.category()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.categoriesRepo.Create(c.Request.Context(), category)
+	switch {
+	case errors.Is(err, repos.ErrInvalidReference):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category does not exist"})
+		return
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this slug already exists"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to create category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllCategories godoc
+// @Summary      List categories
+// @Description  List all categories ordered by path (so parents precede their children), or only the children of parent_id.
+// @Tags         categories
+// @Produce      json
+// @Param        parent_id  query     string  false  "Only list children of this category"
+// @Success      200        {array}   models.Category
+// @Failure      500        {object}  map[string]string
+// @Router       /categories [get]
+func (h *CategoriesHandler) GetAllCategories(c *gin.Context) {
+	categories, err := h.categoriesRepo.FindAll(c.Request.Context(), c.Query("parent_id"))
+	if err != nil {
+		h.logger.Error("Failed to retrieve categories", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, categories)
+}
+
+// GetCategoryByID godoc
+// @Summary      Get category by ID
+// @Tags         categories
+// @Produce      json
+// @Param        id   path      string  true  "Category ID"
+// @Success      200  {object}  models.Category
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /categories/{id} [get]
+func (h *CategoriesHandler) GetCategoryByID(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	category, err := h.categoriesRepo.FindByID(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve category"})
+		return
+	}
+
+	if notModified(c, category.Version) {
+		return
+	}
+	setETag(c, category.Version)
+	c.JSON(http.StatusOK, category)
+}
+
+// GetCategorySchema godoc
+// @Summary      Get the attribute schema of a category
+// @Description  The attributes products of this category carry: its own and those inherited
+// @Description  from its ancestors, where a category's own definition of a key wins.
+// @Tags         categories
+// @Produce      json
+// @Param        id   path      string  true  "Category ID"
+// @Success      200  {array}   models.AttributeDef
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /categories/{id}/schema [get]
+func (h *CategoriesHandler) GetCategorySchema(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	chain, err := h.categoriesRepo.Ancestors(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve category schema", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve category schema"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.MergeAttributeDefs(chain))
+}
+
+// UpdateCategory godoc
+// @Summary      Update a category
+// @Description  Rename a category or move it under another parent. Moving rewrites the
+// @Description  paths of all its descendants; renaming updates the name shown on its products.
+// @Description  The attributes of the products of the category and its descendants are checked
+// @Description  against the schemas they get; the update is rejected with 400, listing some of
+// @Description  the products, when any of them would no longer be valid.
+// @Tags         categories
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string         true   "Category ID"
+// @Param        If-Match  header    string         false  "ETag of the version being replaced"
+// @Param        category  body      CategoryInput  true   "Category details"
+// @Success      200       {object}  models.Category
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /categories/{id} [put]
+func (h *CategoriesHandler) UpdateCategory(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input CategoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	category, err := input.category()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if hasIfMatch {
+		category.Version = version
+	}
+
+	invalid, err := h.invalidProducts(c.Request.Context(), id, category)
+	if err != nil {
+		h.logger.Error("Failed to check category products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+	if len(invalid) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Products of the category would not match its attribute schema",
+			"products": invalid,
+		})
+		return
+	}
+
+	updated, err := h.categoriesRepo.Update(c.Request.Context(), id, category)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	case errors.Is(err, repos.ErrInvalidReference):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parent must be an existing category outside this one's subtree"})
+		return
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this slug already exists"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Category was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	if _, err := h.productsRepo.SetCategoryName(c.Request.Context(), id, updated.Name); err != nil {
+		h.logger.Error("Failed to propagate category name to products", zap.Error(err))
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// maxInvalidProducts bounds the products listed when a category update is
+// rejected for them.
+const maxInvalidProducts = 20
+
+// invalidProducts checks the attributes of the products of category id and
+// its descendants against the schemas they would have once category replaces
+// it, and returns the IDs of those that would not be valid.
+func (h *CategoriesHandler) invalidProducts(ctx context.Context, id string, category *models.Category) ([]string, error) {
+	var chain []*models.Category
+	if category.ParentID != "" {
+		parents, err := h.categoriesRepo.Ancestors(ctx, category.ParentID)
+		if errors.Is(err, repos.ErrNotFound) {
+			// The update fails on the parent.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain = parents
+	}
+	subtree, err := h.categoriesRepo.Descendants(ctx, id)
+	if errors.Is(err, repos.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The subtree comes in path order, so ancestors precede descendants.
+	schemas := make(map[string][]models.AttributeDef, len(subtree))
+	ids := make([]string, 0, len(subtree))
+	for _, d := range subtree {
+		defs := slices.Clone(chain)
+		for _, a := range subtree {
+			switch {
+			case a.ID == id:
+				defs = append(defs, category)
+			case strings.HasPrefix(d.Path, a.Path):
+				defs = append(defs, a)
+			}
+		}
+		schemas[d.ID] = models.MergeAttributeDefs(defs)
+		ids = append(ids, d.ID)
+	}
+
+	filter := repos.ProductFilter{
+		Conditions: []query.Condition{{Field: "category_id", Op: query.In, Values: ids}},
+	}
+	opts := repos.ListOptions{Limit: 100, Positions: pagination.Positions{}}
+	var invalid []string
+	for {
+		products, err := h.productsRepo.FindAll(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range products {
+			if _, err := models.ValidateAttributes(schemas[p.CategoryID], p.Attributes); err != nil {
+				invalid = append(invalid, p.ID)
+				if len(invalid) == maxInvalidProducts {
+					return invalid, nil
+				}
+			}
+		}
+		if len(products) < opts.Limit {
+			return invalid, nil
+		}
+		next := opts.CursorAt(products[len(products)-1].ID, false)
+		opts.Cursor = &next
+	}
+}
+
+// DeleteCategory godoc
+// @Summary      Delete a category
+// @Description  Remove a category that has neither subcategories nor products.
+// @Tags         categories
+// @Produce      json
+// @Param        id        path      string  true   "Category ID"
+// @Param        If-Match  header    string  false  "ETag of the version being deleted"
+// @Success      204       {object}  nil
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /categories/{id} [delete]
+func (h *CategoriesHandler) DeleteCategory(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	products, err := h.productsRepo.Count(c.Request.Context(), repos.ProductFilter{
+		Conditions: []query.Condition{{Field: "category_id", Op: query.Eq, Values: []string{id}}},
+	})
+	if err != nil {
+		h.logger.Error("Failed to count category products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+	if products > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category still has products"})
+		return
+	}
+
+	err = h.categoriesRepo.Delete(c.Request.Context(), id, version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	case errors.Is(err, repos.ErrInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Category still has subcategories"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Category was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete category", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetCategoryProducts godoc
+// @Summary      List products in a category
+// @Description  List the products of a category and all of its descendants. Supports the
+// @Description  same paging, filter and sort parameters as GET /products.
+// @Tags         categories
+// @Produce      json
+// @Param        id      path      string  true   "Category ID"
+// @Param        cursor  query     string  false  "Opaque cursor from links.next or links.prev"
+// @Param        page    query     int     false  "Page number (offset mode)"
+// @Param        limit   query     int     false  "Page size, at most 100"
+// @Param        sort    query     string  false  "Comma-separated sort keys, '-' for descending"
+// @Success      200     {object}  models.ProductList
+// @Failure      400     {object}  map[string]string
+// @Failure      404     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /categories/{id}/products [get]
+func (h *CategoriesHandler) GetCategoryProducts(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	opts, conditions, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tree, err := h.categoriesRepo.Descendants(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve category tree", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+
+	ids := make([]string, len(tree))
+	for i, category := range tree {
+		ids[i] = category.ID
+	}
+	filter := repos.ProductFilter{
+		Conditions: append(conditions, query.Condition{Field: "category_id", Op: query.In, Values: ids}),
+	}
+
+	products, err := h.productsRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) || errors.Is(err, query.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+
+	total, err := h.productsRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+
+	items, links := pageOf(c, opts, products, total, func(p *models.Product) string { return p.ID })
+	c.JSON(http.StatusOK, models.ProductList{Items: items, Total: total, Links: links})
+}
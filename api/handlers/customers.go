@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type CustomersHandler struct {
+	customersRepo repos.CustomerRepository
+	ordersRepo    repos.OrderRepository
+	logger        *zap.Logger
+}
+
+func NewCustomersHandler(customers repos.CustomerRepository, orders repos.OrderRepository, logger *zap.Logger) *CustomersHandler {
+	return &CustomersHandler{
+		customersRepo: customers,
+		ordersRepo:    orders,
+		logger:        logger,
+	}
+}
+
+// CustomerInput is the writable part of a customer.
+type CustomerInput struct {
+	Name      string           `json:"name" binding:"required"`
+	Email     string           `json:"email" binding:"omitempty,email"`
+	Phone     string           `json:"phone"`
+	Addresses []models.Address `json:"addresses"`
+	Note      string           `json:"note"`
+	Version   int64            `json:"version"`
+}
+
+func (in *CustomerInput) customer() *models.Customer {
+	return &models.Customer{
+		Name:      in.Name,
+		Email:     strings.ToLower(strings.TrimSpace(in.Email)),
+		Phone:     in.Phone,
+		Addresses: in.Addresses,
+		Note:      in.Note,
+		Version:   in.Version,
+	}
+}
+
+// CreateCustomer godoc
+// @Summary      Create a customer
+// @Description  Add a customer with any number of shipping and billing addresses. Addresses
+// @Description  without an id are given one. One address of each type can be the default,
+// @Description  used for orders that do not pick one. Email addresses are unique.
+// @Tags         customers
+// @Accept       json
+// @Produce      json
+// @Param        customer  body      CustomerInput  true  "Customer details"
+// @Success      201       {object}  models.Customer
+// @Failure      400       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /customers [post]
+func (h *CustomersHandler) CreateCustomer(c *gin.Context) {
+	var input CustomerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	customer := input.customer()
+	if err := customer.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.customersRepo.Create(c.Request.Context(), customer)
+	if errors.Is(err, repos.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A customer with this email already exists"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create customer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllCustomers godoc
+// @Summary      List customers
+// @Description  List customers by name.
+// @Tags         customers
+// @Produce      json
+// @Param        email   query     string  false  "Only the customer with this email address"
+// @Param        limit   query     int     false  "Items per page, at most 100"
+// @Param        cursor  query     string  false  "Cursor from the links of a previous page"
+// @Success      200     {object}  models.CustomerList
+// @Failure      400     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /customers [get]
+func (h *CustomersHandler) GetAllCustomers(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.CustomerFilter{Email: strings.ToLower(strings.TrimSpace(c.Query("email")))}
+	customers, err := h.customersRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve customers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customers"})
+		return
+	}
+
+	total, err := h.customersRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count customers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customers"})
+		return
+	}
+
+	items, links := pageOf(c, opts, customers, total, func(cu *models.Customer) string { return cu.ID })
+	c.JSON(http.StatusOK, models.CustomerList{Items: items, Total: total, Links: links})
+}
+
+// GetCustomerByID godoc
+// @Summary      Get customer by ID
+// @Tags         customers
+// @Produce      json
+// @Param        id   path      string  true  "Customer ID"
+// @Success      200  {object}  models.Customer
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /customers/{id} [get]
+func (h *CustomersHandler) GetCustomerByID(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+
+	customer, err := h.customersRepo.FindByID(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve customer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customer"})
+		return
+	}
+
+	if notModified(c, customer.Version) {
+		return
+	}
+	setETag(c, customer.Version)
+	c.JSON(http.StatusOK, customer)
+}
+
+// UpdateCustomer godoc
+// @Summary      Update a customer
+// @Description  Replace a customer and its addresses. Orders keep the addresses they were
+// @Description  placed with.
+// @Tags         customers
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string         true   "Customer ID"
+// @Param        If-Match  header    string         false  "ETag of the version being replaced"
+// @Param        customer  body      CustomerInput  true   "Customer details"
+// @Success      200       {object}  models.Customer
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /customers/{id} [put]
+func (h *CustomersHandler) UpdateCustomer(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input CustomerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	customer := input.customer()
+	if hasIfMatch {
+		customer.Version = version
+	}
+	if err := customer.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.customersRepo.Update(c.Request.Context(), id, customer)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A customer with this email already exists"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Customer was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update customer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer"})
+		return
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteCustomer godoc
+// @Summary      Delete a customer
+// @Description  Remove a customer that has no orders.
+// @Tags         customers
+// @Produce      json
+// @Param        id        path      string  true   "Customer ID"
+// @Param        If-Match  header    string  false  "ETag of the version being deleted"
+// @Success      204       {object}  nil
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /customers/{id} [delete]
+func (h *CustomersHandler) DeleteCustomer(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	orders, err := h.ordersRepo.Count(c.Request.Context(), customerOrders(id, nil))
+	if err != nil {
+		h.logger.Error("Failed to count customer orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer"})
+		return
+	}
+	if orders > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Customer has orders"})
+		return
+	}
+
+	err = h.customersRepo.Delete(c.Request.Context(), id, version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Customer was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete customer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetCustomerOrders godoc
+// @Summary      List the orders of a customer
+// @Description  The order history of a customer, newest first unless sort is given. Accepts
+// @Description  the same filter[...] parameters as the order listing.
+// @Tags         customers
+// @Produce      json
+// @Param        id      path      string  true   "Customer ID"
+// @Param        cursor  query     string  false  "Opaque cursor from links.next or links.prev"
+// @Param        page    query     int     false  "Page number (offset mode)"
+// @Param        limit   query     int     false  "Page size, at most 100"
+// @Param        sort    query     string  false  "Comma-separated sort keys, '-' for descending, e.g. -created_at"
+// @Success      200     {object}  models.OrderList
+// @Failure      400     {object}  map[string]string
+// @Failure      404     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /customers/{id}/orders [get]
+func (h *CustomersHandler) GetCustomerOrders(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+
+	opts, conditions, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = []query.Sort{{Field: "created_at", Desc: true}}
+	}
+
+	if _, err := h.customersRepo.FindByID(c.Request.Context(), id); err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		h.logger.Error("Failed to retrieve customer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
+		return
+	}
+
+	filter := customerOrders(id, conditions)
+	orders, err := h.ordersRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if errors.Is(err, query.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
+		return
+	}
+
+	total, err := h.ordersRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
+		return
+	}
+
+	items, links := pageOf(c, opts, orders, total, func(o *models.Order) string { return o.ID })
+	c.JSON(http.StatusOK, models.OrderList{Items: items, Total: total, Links: links})
+}
+
+// customerOrders selects the orders of a customer that match conditions.
+func customerOrders(customerID string, conditions []query.Condition) repos.OrderFilter {
+	conditions = append(conditions, query.Condition{Field: "customer_id", Op: query.Eq, Values: []string{customerID}})
+	return repos.OrderFilter{Conditions: conditions}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errBadPrecondition      = errors.New("malformed If-Match header")
+	errPreconditionRequired = errors.New("If-Match header required: send the ETag of the version being changed, or * for any version")
+)
+
+// etag renders a document version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag exposes the version of the returned document to the client.
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", etag(version))
+}
+
+// ifMatchVersion returns the version the client expects to overwrite.
+// ok is false when the request carries no If-Match header; "*" yields
+// version 0, which the storages treat as "any version".
+func ifMatchVersion(c *gin.Context) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return 0, false, nil
+	}
+	if header == "*" {
+		return 0, true, nil
+	}
+
+	tag := strings.TrimPrefix(header, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, true, errBadPrecondition
+	}
+	version, err = strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version < 0 {
+		return 0, true, errBadPrecondition
+	}
+	return version, true, nil
+}
+
+// missingPrecondition answers 428 and reports true when a write names no
+// version to replace: it has no If-Match header and bodyVersion, the
+// version in the body of a full update, is zero.
+func missingPrecondition(c *gin.Context, bodyVersion int64) bool {
+	if strings.TrimSpace(c.GetHeader("If-Match")) != "" || bodyVersion != 0 {
+		return false
+	}
+	c.JSON(http.StatusPreconditionRequired, gin.H{"error": errPreconditionRequired.Error()})
+	return true
+}
+
+// notModified answers a conditional GET with 304 when one of the tags in
+// If-None-Match matches the current version.
+func notModified(c *gin.Context, version int64) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			setETag(c, version)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/blob"
+	"github.com/udevs/lesson3/pkg/imaging"
+	"github.com/udevs/lesson3/service"
+	"go.uber.org/zap"
+)
+
+// multipartOverhead is allowed on top of the image size limit for the
+// multipart framing and the other form fields.
+const multipartOverhead = 64 << 10
+
+// ImageOrderInput lists the IDs of all images of a product in the order
+// they should be shown.
+type ImageOrderInput struct {
+	IDs []string `json:"ids" binding:"required"`
+}
+
+// UploadProductImage godoc
+// @Summary      Upload a product image
+// @Description  Attach a JPEG, PNG or GIF image to a product; thumbnails are generated at the
+// @Description  configured sizes. The type is taken from the content, not the file name.
+// @Tags         images
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        id        path      string  true   "Product ID"
+// @Param        If-Match  header    string  true   "ETag of the version being modified"
+// @Param        file      formData  file    true   "Image file"
+// @Param        alt       formData  string  false  "Alternative text"
+// @Success      201       {object}  models.Product
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      413       {object}  map[string]string
+// @Failure      415       {object}  map[string]string
+// @Failure      428       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /products/{id}/images [post]
+func (h *ProductsHandler) UploadProductImage(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.images.MaxBytes()+multipartOverhead)
+
+	file, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A file is required in the file form field"})
+		return
+	}
+	if file.Size > h.images.MaxBytes() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		h.logger.Error("Failed to open upload", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		h.logger.Error("Failed to read upload", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+		return
+	}
+
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	image, err := h.images.Store(c.Request.Context(), product.ID, data, c.PostForm("alt"))
+	switch {
+	case errors.Is(err, service.ErrImageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, imaging.ErrUnsupportedType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, imaging.ErrInvalidImage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to store image", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+		return
+	}
+
+	product.Images = append(product.Images, *image)
+	updated := h.save(c, product.ID, product)
+	if updated == nil {
+		if err := h.images.Discard(c.Request.Context(), image); err != nil {
+			h.logger.Error("Failed to discard image", zap.Error(err))
+		}
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusCreated, updated)
+}
+
+// DeleteProductImage godoc
+// @Summary      Delete a product image
+// @Tags         images
+// @Produce      json
+// @Param        id        path      string  true   "Product ID"
+// @Param        image_id  path      string  true   "Image ID"
+// @Param        If-Match  header    string  true   "ETag of the version being modified"
+// @Success      200       {object}  models.Product
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      428       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /products/{id}/images/{image_id} [delete]
+func (h *ProductsHandler) DeleteProductImage(c *gin.Context) {
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	imageID := c.Param("image_id")
+	for i, image := range product.Images {
+		if image.ID != imageID {
+			continue
+		}
+		product.Images = append(product.Images[:i:i], product.Images[i+1:]...)
+		updated := h.save(c, product.ID, product)
+		if updated == nil {
+			return
+		}
+		// The blobs go only once no product references them.
+		if err := h.images.Discard(c.Request.Context(), &image); err != nil {
+			h.logger.Error("Failed to discard image", zap.Error(err))
+		}
+		setETag(c, updated.Version)
+		c.JSON(http.StatusOK, updated)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+}
+
+// ReorderProductImages godoc
+// @Summary      Reorder product images
+// @Description  Set the display order of a product's images; the first is the main image.
+// @Description  ids must list every image of the product exactly once.
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string           true   "Product ID"
+// @Param        If-Match  header    string           true   "ETag of the version being modified"
+// @Param        order     body      ImageOrderInput  true   "Image IDs in display order"
+// @Success      200       {object}  models.Product
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      428       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /products/{id}/images/order [put]
+func (h *ProductsHandler) ReorderProductImages(c *gin.Context) {
+	var input ImageOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	byID := make(map[string]models.Image, len(product.Images))
+	for _, image := range product.Images {
+		byID[image.ID] = image
+	}
+	if len(input.IDs) != len(byID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must list every image of the product exactly once"})
+		return
+	}
+	ordered := make([]models.Image, 0, len(input.IDs))
+	for _, id := range input.IDs {
+		image, ok := byID[id]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ids must list every image of the product exactly once"})
+			return
+		}
+		delete(byID, id)
+		ordered = append(ordered, image)
+	}
+
+	product.Images = ordered
+	h.saveProduct(c, product.ID, product)
+}
+
+type FilesHandler struct {
+	store  blob.Store
+	logger *zap.Logger
+}
+
+func NewFilesHandler(store blob.Store, logger *zap.Logger) *FilesHandler {
+	return &FilesHandler{store: store, logger: logger}
+}
+
+// GetFile godoc
+// @Summary      Download a stored file
+// @Description  Serve a blob such as a product image or thumbnail. Image URLs on products
+// @Description  point here unless a public blob URL is configured.
+// @Tags         images
+// @Produce      octet-stream
+// @Param        key  path      string  true  "Blob key"
+// @Success      200  {file}    file
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /files/{key} [get]
+func (h *FilesHandler) GetFile(c *gin.Context) {
+	key := c.Param("key")
+	if len(key) > 0 && key[0] == '/' {
+		key = key[1:]
+	}
+	if !blob.ValidKey(key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	r, info, err := h.store.Get(c.Request.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read file", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer r.Close()
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// Keys are never reused for different content.
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, info.Size, contentType, r, nil)
+}
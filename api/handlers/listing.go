@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/repos"
+)
+
+var (
+	errInvalidPage   = errors.New("Invalid page parameter")
+	errInvalidLimit  = errors.New("Invalid limit parameter")
+	errInvalidCursor = errors.New("Invalid cursor parameter")
+	errPageAndCursor = errors.New("page and cursor cannot be combined")
+)
+
+// maxLimit caps the items per page; larger limits are lowered to it.
+const maxLimit = 100
+
+// parseListOptions reads the paging, filter and sort parameters shared by all
+// listings. Passing page selects the legacy offset mode; otherwise the listing
+// is paginated by cursor, starting from the beginning when cursor is absent.
+func parseListOptions(c *gin.Context) (repos.ListOptions, []query.Condition, error) {
+	var opts repos.ListOptions
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		return opts, nil, errInvalidLimit
+	}
+	opts.Limit = min(limit, maxLimit)
+
+	if page := c.Query("page"); page != "" {
+		opts.Page, err = strconv.Atoi(page)
+		if err != nil || opts.Page < 1 {
+			return opts, nil, errInvalidPage
+		}
+	}
+
+	if token := c.Query("cursor"); token != "" {
+		if opts.Page > 0 {
+			return opts, nil, errPageAndCursor
+		}
+		opts.Cursor, err = pagination.Decode(token)
+		if err != nil {
+			return opts, nil, errInvalidCursor
+		}
+	}
+
+	q, err := query.Parse(c.Request.URL.Query())
+	if err != nil {
+		return opts, nil, err
+	}
+	opts.Sort = q.Sort
+	if opts.Keyset() {
+		opts.Positions = pagination.Positions{}
+	}
+
+	return opts, q.Conditions, nil
+}
+
+// lookAhead asks the storage for one extra item in keyset mode so pageOf can
+// tell whether another page follows.
+func lookAhead(opts repos.ListOptions) repos.ListOptions {
+	if opts.Keyset() {
+		opts.Limit++
+	}
+	return opts
+}
+
+// pageOf drops the look-ahead item fetched for opts and builds the links to
+// the neighbouring pages.
+func pageOf[T any](c *gin.Context, opts repos.ListOptions, items []T, total int64, id func(T) string) ([]T, models.PageLinks) {
+	if items == nil {
+		items = []T{}
+	}
+	links := models.PageLinks{Self: c.Request.URL.RequestURI()}
+
+	if !opts.Keyset() {
+		if int64(opts.Page*opts.Limit) < total {
+			links.Next = pageURL(c, "page", strconv.Itoa(opts.Page+1))
+		}
+		if opts.Page > 1 {
+			links.Prev = pageURL(c, "page", strconv.Itoa(opts.Page-1))
+		}
+		return items, links
+	}
+
+	backward := opts.Cursor != nil && opts.Cursor.Backward
+	hasMore := len(items) > opts.Limit
+	if hasMore {
+		if backward {
+			items = items[1:]
+		} else {
+			items = items[:opts.Limit]
+		}
+	}
+	if len(items) == 0 {
+		return items, links
+	}
+
+	first, last := id(items[0]), id(items[len(items)-1])
+	if (backward && hasMore) || (!backward && opts.Cursor != nil) {
+		links.Prev = pageURL(c, "cursor", opts.CursorAt(first, true).Encode())
+	}
+	if backward || hasMore {
+		links.Next = pageURL(c, "cursor", opts.CursorAt(last, false).Encode())
+	}
+	return items, links
+}
+
+// pageURL returns the current request URL with one query parameter replaced.
+func pageURL(c *gin.Context, key, value string) string {
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
@@ -1,37 +1,56 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
 	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 type OrdersHandler struct {
-	orderRepo repos.OrderRepository
-	logger    *zap.Logger
+	orderRepo    repos.OrderRepository
+	orderService *service.OrderService
+	logger       *zap.Logger
 }
 
-func NewOrdersHandler(ordRepo repos.OrderRepository, log *zap.Logger) *OrdersHandler {
+func NewOrdersHandler(ordRepo repos.OrderRepository, ordService *service.OrderService, log *zap.Logger) *OrdersHandler {
 	return &OrdersHandler{
-		orderRepo: ordRepo,
-		logger:    log,
+		orderRepo:    ordRepo,
+		orderService: ordService,
+		logger:       log,
 	}
 }
 
 // CreateOrder godoc
 // @Summary      Create a new order
-// @Description  Add a new order to the database
+// @Description  Place an order for an existing customer. Line prices and the total are taken
+// @Description  from the catalog, and the ordered stock is deducted; bundle lines deduct the
+// @Description  stock of their components. The customer addresses named by shipping_address_id
+// @Description  and billing_address_id, or the default ones, are copied onto the order.
+// @Description  The warehouses stock is taken from are chosen by the fulfillment strategy, using
+// @Description  ship_to, by default the location of the shipping address, for the nearest one,
+// @Description  and listed in allocations.
+// @Description  The shipping fee is added, and the promotions that fit the order, automatic
+// @Description  ones and those of its coupon_codes, are applied: lines show their discount and
+// @Description  the order its subtotal, discounts and total. A code that cannot be used is
+// @Description  refused, and the response is 409 if one has reached its usage limit.
+// @Description  Lines are taxed after discounts at the rates for their product tax_class in
+// @Description  the region of the shipping address; the tax of each line and each rate is
+// @Description  stored on the order, and added to the total unless prices include tax.
 // @Tags         orders
 // @Accept       json
 // @Produce      json
 // @Param        order  body      models.Order  true  "Order details"
 // @Success      201    {object}  models.Order
 // @Failure      400    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
 // @Failure      500    {object}  map[string]string
 // @Router       /orders [post]
 func (h *OrdersHandler) CreateOrder(c *gin.Context) {
@@ -42,13 +61,22 @@ func (h *OrdersHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	createdOrder, err := h.orderRepo.Create(c.Request.Context(), &order)
+	createdOrder, err := h.orderService.Place(c.Request.Context(), &order)
+	if errors.Is(err, service.ErrInvalidOrder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, repos.ErrInsufficientStock) || errors.Is(err, repos.ErrLimitReached) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		h.logger.Error("Failed to create order", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 		return
 	}
 
+	setETag(c, createdOrder.Version)
 	c.JSON(http.StatusCreated, createdOrder)
 }
 
@@ -57,10 +85,13 @@ func (h *OrdersHandler) CreateOrder(c *gin.Context) {
 // @Description  Retrieve order details by its ID
 // @Tags         orders
 // @Produce      json
-// @Param        id   path      string  true  "Order ID"
+// @Param        id             path      string  true   "Order ID"
+// @Param        If-None-Match  header    string  false  "ETag of a cached copy"
 // @Success      200  {object}  models.Order
+// @Success      304  "Not modified"
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
 // @Router       /orders/{id} [get]
 func (h *OrdersHandler) GetOrderByID(c *gin.Context) {
 	id := c.Param("id")
@@ -72,64 +103,99 @@ func (h *OrdersHandler) GetOrderByID(c *gin.Context) {
 	}
 
 	order, err := h.orderRepo.FindByID(c.Request.Context(), objID.Hex())
-	if err != nil {
+	if errors.Is(err, repos.ErrNotFound) {
 		h.logger.Error("Order not found", zap.Error(err))
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve order"})
+		return
+	}
 
+	if notModified(c, order.Version) {
+		return
+	}
+
+	setETag(c, order.Version)
 	c.JSON(http.StatusOK, order)
 }
 
 // GetAllOrders godoc
 // @Summary      Get all orders
-// @Description  Retrieve all orders with optional pagination and search
+// @Description  Retrieve orders page by page. Pages are addressed by the opaque
+// @Description  cursors returned in links; page selects the legacy offset mode.
+// @Description  Filters use filter[field][op]=value with op one of eq, ne, gt, gte, lt,
+// @Description  lte, in, nin, contains; fields: status, customer_id, total_price,
+// @Description  order_date, created_at, updated_at, product_id. Dates are YYYY-MM-DD, a
+// @Description  whole day in UTC, or RFC 3339 timestamps.
 // @Tags         orders
 // @Produce      json
-// @Param        page    query     int     false  "Page number"
-// @Param        limit   query     int     false  "Page size"
-// @Param        search  query     string  false  "Search query"
-// @Success      200     {array}   models.Order
+// @Param        cursor  query     string  false  "Opaque cursor from links.next or links.prev"
+// @Param        page    query     int     false  "Page number (offset mode)"
+// @Param        limit   query     int     false  "Page size, at most 100"
+// @Param        status  query     string  false  "Order status"
+// @Param        search  query     string  false  "Deprecated alias of status"
+// @Param        sort    query     string  false  "Comma-separated sort keys, '-' for descending, e.g. -created_at"
+// @Success      200     {object}  models.OrderList
 // @Failure      400     {object}  map[string]string
 // @Failure      500     {object}  map[string]string
 // @Router       /orders [get]
 func (h *OrdersHandler) GetAllOrders(c *gin.Context) {
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
-	search := c.Query("search")
-
-	pageInt, err := strconv.ParseInt(page, 10, 64)
-	if err != nil || pageInt < 1 {
-		h.logger.Error("Invalid page parameter", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page parameter"})
+	opts, conditions, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := repos.OrderFilter{Conditions: conditions}
+	// search is the old, misleading name of the status filter.
+	status := c.DefaultQuery("status", c.Query("search"))
+	if status != "" {
+		filter.Conditions = append(filter.Conditions, query.Condition{Field: "status", Op: query.Eq, Values: []string{status}})
+	}
 
-	limitInt, err := strconv.ParseInt(limit, 10, 64)
-	if err != nil || limitInt < 1 {
-		h.logger.Error("Invalid limit parameter", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+	orders, err := h.orderRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if errors.Is(err, query.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	orders, err := h.orderRepo.FindAll(c.Request.Context(), int(pageInt), int(limitInt), search)
 	if err != nil {
 		h.logger.Error("Failed to retrieve orders", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	total, err := h.orderRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
+		return
+	}
+
+	items, links := pageOf(c, opts, orders, total, func(o *models.Order) string { return o.ID })
+	c.JSON(http.StatusOK, models.OrderList{Items: items, Total: total, Links: links})
 }
 
 // GenerateReport godoc
-// @Summary      Generate a report of orders within a date range
-// @Description  Retrieve all orders between the specified start and end dates
+// @Summary      Generate a sales report for a date range
+// @Description  Summarise the orders created between the start and end dates, both inclusive:
+// @Description  totals, revenue per customer, and quantity and revenue per product. Revenue of
+// @Description  bundle lines is attributed to the bundle components. Cost and margin use the
+// @Description  average cost of each product when the order was placed. Also counts the
+// @Description  catalog price changes made in the range. Revenue leaves out tax, which is
+// @Description  reported with a breakdown by rate. Refunds of returns are netted out of revenue,
+// @Description  tax and quantities, and goods returned to stock out of cost.
 // @Tags         orders
 // @Produce      json
 // @Param        startDate  query     string  true  "Start date in YYYY-MM-DD format"
 // @Param        endDate    query     string  true  "End date in YYYY-MM-DD format"
-// @Success      200        {array}   models.Order
+// @Success      200        {object}  models.SalesReport
 // @Failure      400        {object}  map[string]string
 // @Failure      500        {object}  map[string]string
 // @Router       /orders/report [get]
@@ -143,27 +209,70 @@ func (h *OrdersHandler) GenerateReport(c *gin.Context) {
 		return
 	}
 
-	orders, err := h.orderRepo.GenerateReport(c.Request.Context(), startDate, endDate)
+	report, err := h.orderService.Report(c.Request.Context(), startDate, endDate)
 	if err != nil {
 		h.logger.Error("Failed to generate report", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate report"})
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	c.JSON(http.StatusOK, report)
+}
+
+// RecomputeOrder godoc
+// @Summary      Recompute an order at historical prices
+// @Description  Price the lines of an order at the catalog prices in effect when it was placed,
+// @Description  from the price history, and compare them with what it charged.
+// @Tags         orders
+// @Produce      json
+// @Param        id   path      string  true  "Order ID"
+// @Success      200  {object}  models.OrderRecomputation
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /orders/{id}/recompute [get]
+func (h *OrdersHandler) RecomputeOrder(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid order ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	result, err := h.orderService.Recompute(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to recompute order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
 }
 
 // UpdateOrder godoc
 // @Summary      Update an existing order
-// @Description  Update an order's details
+// @Description  Update an order's details. If-Match, or the version in the body, must name the
+// @Description  version being replaced: the write is rejected with 428 when neither is given,
+// @Description  and with 412 when it is no longer the current version.
+// @Description  A new customer must exist. The lines of a placed order must keep their products,
+// @Description  variants and quantities; prices, discounts, tax, cost and total_price are worked
+// @Description  out when the order is placed and may be left out but not changed. The refunded
+// @Description  statuses are set by returns only.
 // @Tags         orders
 // @Accept       json
 // @Produce      json
-// @Param        id     path      string       true  "Order ID"
-// @Param        order  body      models.Order true  "Order details"
+// @Param        id        path      string       true   "Order ID"
+// @Param        If-Match  header    string       false  "ETag of the version being replaced"
+// @Param        order     body      models.Order true   "Order details"
 // @Success      200    {object}  models.Order
 // @Failure      400    {object}  map[string]string
 // @Failure      404    {object}  map[string]string
+// @Failure      412    {object}  map[string]string
+// @Failure      428    {object}  map[string]string
 // @Failure      500    {object}  map[string]string
 // @Router       /orders/{id} [put]
 func (h *OrdersHandler) UpdateOrder(c *gin.Context) {
@@ -175,32 +284,137 @@ func (h *OrdersHandler) UpdateOrder(c *gin.Context) {
 		return
 	}
 
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		h.logger.Error("Invalid If-Match header", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
 	var order models.Order
 	if err := c.ShouldBindJSON(&order); err != nil {
 		h.logger.Error("Invalid input", zap.Error(err))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	if missingPrecondition(c, order.Version) {
+		return
+	}
+	if hasIfMatch {
+		order.Version = version
+	}
+
+	h.saveOrder(c, objID.Hex(), &order)
+}
+
+// PatchOrder godoc
+// @Summary      Partially update an order
+// @Description  Change only the supplied fields of an order. If-Match must name the version
+// @Description  being modified: the write is rejected with 428 without it, and with 412 when
+// @Description  it is no longer the current version.
+// @Description  A new customer must exist. The lines of a placed order must keep their products,
+// @Description  variants and quantities; prices, discounts, tax, cost and total_price are worked
+// @Description  out when the order is placed and may be left out but not changed. The refunded
+// @Description  statuses are set by returns only.
+// @Tags         orders
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string             true   "Order ID"
+// @Param        If-Match  header    string             true   "ETag of the version being modified"
+// @Param        order     body      models.OrderPatch  true   "Fields to change"
+// @Success      200    {object}  models.Order
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Failure      412    {object}  map[string]string
+// @Failure      428    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /orders/{id} [patch]
+func (h *OrdersHandler) PatchOrder(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		h.logger.Error("Invalid order ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		h.logger.Error("Invalid If-Match header", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var patch models.OrderPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if missingPrecondition(c, 0) {
+		return
+	}
 
-	updatedOrder, err := h.orderRepo.Update(c.Request.Context(), objID.Hex(), &order)
+	order, err := h.orderRepo.FindByID(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		h.logger.Error("Order not found", zap.Error(err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
 	if err != nil {
+		h.logger.Error("Failed to retrieve order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve order"})
+		return
+	}
+
+	if hasIfMatch && version != 0 && version != order.Version {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Order was modified by another request"})
+		return
+	}
+
+	// The write is conditioned on the version we read, so a concurrent
+	// change between FindByID and Update still surfaces as 412.
+	patch.Apply(order)
+	h.saveOrder(c, objID.Hex(), order)
+}
+
+func (h *OrdersHandler) saveOrder(c *gin.Context, id string, order *models.Order) {
+	updatedOrder, err := h.orderService.Update(c.Request.Context(), id, order)
+	switch {
+	case errors.Is(err, service.ErrInvalidOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Order was modified by another request"})
+		return
+	case err != nil:
 		h.logger.Error("Failed to update order", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
 		return
 	}
 
+	setETag(c, updatedOrder.Version)
 	c.JSON(http.StatusOK, updatedOrder)
 }
 
 // DeleteOrder godoc
 // @Summary      Delete an order
-// @Description  Remove an order by its ID
+// @Description  Move an order to the trash. It can be restored until the trash is purged.
+// @Description  If-Match must name the version being deleted: 428 without it, 412 when it is
+// @Description  outdated.
 // @Tags         orders
 // @Produce      json
-// @Param        id  path      string  true  "Order ID"
+// @Param        id        path      string  true   "Order ID"
+// @Param        If-Match  header    string  true   "ETag of the version being deleted"
+// @Param        X-Actor   header    string  false  "Who is deleting, recorded as deleted_by"
 // @Success      200  {object} map[string]string
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      412  {object}  map[string]string
+// @Failure      428  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /orders/{id} [delete]
 func (h *OrdersHandler) DeleteOrder(c *gin.Context) {
@@ -212,7 +426,25 @@ func (h *OrdersHandler) DeleteOrder(c *gin.Context) {
 		return
 	}
 
-	if err := h.orderRepo.Delete(c.Request.Context(), objID.Hex()); err != nil {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		h.logger.Error("Invalid If-Match header", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	if missingPrecondition(c, 0) {
+		return
+	}
+
+	err = h.orderRepo.Delete(c.Request.Context(), objID.Hex(), version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Order was modified by another request"})
+		return
+	case err != nil:
 		h.logger.Error("Failed to delete order", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete order"})
 		return
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// maxWebhookBytes bounds the webhook requests read into memory.
+const maxWebhookBytes = 1 << 20
+
+type PaymentsHandler struct {
+	payments *service.PaymentService
+	logger   *zap.Logger
+}
+
+func NewPaymentsHandler(payments *service.PaymentService, logger *zap.Logger) *PaymentsHandler {
+	return &PaymentsHandler{
+		payments: payments,
+		logger:   logger,
+	}
+}
+
+// PaymentInput asks for a payment of an order.
+type PaymentInput struct {
+	// Amount defaults to what is still due on the order.
+	Amount float64 `json:"amount"`
+	// Method is the gateway token for the means of payment.
+	Method string `json:"method" binding:"required"`
+	// Capture takes the money at once rather than only authorizing it.
+	Capture bool `json:"capture"`
+}
+
+// PaymentAmountInput is the amount to capture or refund; zero means all
+// that can be.
+type PaymentAmountInput struct {
+	Amount float64 `json:"amount"`
+}
+
+// CreatePayment godoc
+// @Summary      Pay for an order
+// @Description  Authorize a payment for the order through the configured gateway, for the
+// @Description  amount still due unless an amount is given, and capture it at once if capture
+// @Description  is set. Some gateways confirm authorizations later, through a webhook; the
+// @Description  payment is pending until then. A declined payment is stored as failed and
+// @Description  returned with 402. The payment status of the order follows its payments, and
+// @Description  a pending order paid in full moves to processing.
+// @Tags         payments
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string        true  "Order ID"
+// @Param        payment  body      PaymentInput  true  "Payment details"
+// @Success      201      {object}  models.Payment
+// @Failure      400      {object}  map[string]string
+// @Failure      402      {object}  map[string]interface{}
+// @Failure      404      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /orders/{id}/payments [post]
+func (h *PaymentsHandler) CreatePayment(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	var input PaymentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	payment, err := h.payments.Pay(c.Request.Context(), id, input.Amount, input.Method, input.Capture)
+	if errors.Is(err, service.ErrPaymentDeclined) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error(), "payment": payment})
+		return
+	}
+	if !h.failed(c, err, "create") {
+		setETag(c, payment.Version)
+		c.JSON(http.StatusCreated, payment)
+	}
+}
+
+// GetOrderPayments godoc
+// @Summary      List the payments of an order
+// @Description  List the payments of an order, oldest first, with their history.
+// @Tags         payments
+// @Produce      json
+// @Param        id   path      string  true  "Order ID"
+// @Success      200  {array}   models.Payment
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /orders/{id}/payments [get]
+func (h *PaymentsHandler) GetOrderPayments(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	payments, err := h.payments.Payments(c.Request.Context(), id)
+	if !h.failed(c, err, "retrieve") {
+		c.JSON(http.StatusOK, payments)
+	}
+}
+
+// CapturePayment godoc
+// @Summary      Capture a payment
+// @Description  Take an authorized payment, all of it unless an amount is given; the rest of
+// @Description  the authorization is released.
+// @Tags         payments
+// @Accept       json
+// @Produce      json
+// @Param        id          path      string              true   "Order ID"
+// @Param        payment_id  path      string              true   "Payment ID"
+// @Param        If-Match    header    string              false  "ETag of the version being captured"
+// @Param        capture     body      PaymentAmountInput  false  "Amount to capture"
+// @Success      200         {object}  models.Payment
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      412         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /orders/{id}/payments/{payment_id}/capture [post]
+func (h *PaymentsHandler) CapturePayment(c *gin.Context) {
+	h.change(c, "capture", func(orderID, paymentID string, version int64, amount float64) (*models.Payment, error) {
+		return h.payments.Capture(c.Request.Context(), orderID, paymentID, version, amount)
+	})
+}
+
+// RefundPayment godoc
+// @Summary      Refund a payment
+// @Description  Give back a captured payment, all that is left of it unless an amount is given.
+// @Tags         payments
+// @Accept       json
+// @Produce      json
+// @Param        id          path      string              true   "Order ID"
+// @Param        payment_id  path      string              true   "Payment ID"
+// @Param        If-Match    header    string              false  "ETag of the version being refunded"
+// @Param        refund      body      PaymentAmountInput  false  "Amount to refund"
+// @Success      200         {object}  models.Payment
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      412         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /orders/{id}/payments/{payment_id}/refund [post]
+func (h *PaymentsHandler) RefundPayment(c *gin.Context) {
+	h.change(c, "refund", func(orderID, paymentID string, version int64, amount float64) (*models.Payment, error) {
+		return h.payments.Refund(c.Request.Context(), orderID, paymentID, version, amount)
+	})
+}
+
+// VoidPayment godoc
+// @Summary      Void a payment
+// @Description  Release an authorized payment that was not captured.
+// @Tags         payments
+// @Produce      json
+// @Param        id          path      string  true   "Order ID"
+// @Param        payment_id  path      string  true   "Payment ID"
+// @Param        If-Match    header    string  false  "ETag of the version being voided"
+// @Success      200         {object}  models.Payment
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      412         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /orders/{id}/payments/{payment_id}/void [post]
+func (h *PaymentsHandler) VoidPayment(c *gin.Context) {
+	h.change(c, "void", func(orderID, paymentID string, version int64, _ float64) (*models.Payment, error) {
+		return h.payments.Void(c.Request.Context(), orderID, paymentID, version)
+	})
+}
+
+// HandleWebhook godoc
+// @Summary      Receive a payment webhook
+// @Description  Apply a change to a payment reported by the gateway. The request must carry
+// @Description  the signature of the gateway; for the fake provider, the Fake-Signature header.
+// @Description  Repeated deliveries of an event are applied once. The response is 409 when the
+// @Description  payment changed meanwhile, so the gateway delivers the event again.
+// @Tags         payments
+// @Accept       json
+// @Produce      json
+// @Param        provider  path      string  true  "Payment provider"
+// @Success      200       {object}  models.Payment
+// @Failure      400       {object}  map[string]string
+// @Failure      401       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /webhooks/payments/{provider} [post]
+func (h *PaymentsHandler) HandleWebhook(c *gin.Context) {
+	if c.Param("provider") != h.payments.Provider() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	payment, err := h.payments.HandleWebhook(c.Request.Context(), c.Request.Header, body)
+	switch {
+	case errors.Is(err, service.ErrInvalidSignature):
+		h.logger.Warn("Rejected payment webhook", zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	case errors.Is(err, service.ErrInvalidPayment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to handle payment webhook", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle payment webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// change parses the request to capture, refund or void a payment and
+// writes the result of fn.
+func (h *PaymentsHandler) change(c *gin.Context, action string, fn func(orderID, paymentID string, version int64, amount float64) (*models.Payment, error)) {
+	orderID, paymentID := c.Param("id"), c.Param("payment_id")
+	if !primitive.IsValidObjectID(orderID) || !primitive.IsValidObjectID(paymentID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order or payment ID"})
+		return
+	}
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	var input PaymentAmountInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			h.logger.Error("Invalid input", zap.Error(err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	payment, err := fn(orderID, paymentID, version, input.Amount)
+	if !h.failed(c, err, action) {
+		setETag(c, payment.Version)
+		c.JSON(http.StatusOK, payment)
+	}
+}
+
+// failed writes the response for err, if any, and reports whether it did.
+func (h *PaymentsHandler) failed(c *gin.Context, err error, action string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, service.ErrInvalidPayment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order or payment not found"})
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Payment was modified by another request"})
+	default:
+		h.logger.Error("Failed to "+action+" payment", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " payment"})
+	}
+	return true
+}
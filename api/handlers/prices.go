@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type PricesHandler struct {
+	pricesRepo repos.PriceHistoryRepository
+	logger     *zap.Logger
+}
+
+func NewPricesHandler(prices repos.PriceHistoryRepository, logger *zap.Logger) *PricesHandler {
+	return &PricesHandler{pricesRepo: prices, logger: logger}
+}
+
+// GetPriceHistory godoc
+// @Summary      Get the price history of a product
+// @Description  List the prices a product, or one of its variants, has had, oldest first. Each
+// @Description  point holds from its effective_at until the next one. History is kept for
+// @Description  deleted products too.
+// @Tags         prices
+// @Produce      json
+// @Param        id          path      string  true   "Product ID"
+// @Param        variant_id  query     string  false  "Variant ID; omit for the product's own price"
+// @Param        from        query     string  false  "Earliest effective date, YYYY-MM-DD or RFC 3339"
+// @Param        to          query     string  false  "Latest effective date, YYYY-MM-DD or RFC 3339"
+// @Param        limit       query     int     false  "Items per page, at most 100"
+// @Param        cursor      query     string  false  "Cursor from the links of a previous page"
+// @Success      200         {object}  models.PriceHistory
+// @Failure      400         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /products/{id}/prices [get]
+func (h *PricesHandler) GetPriceHistory(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.PriceHistoryFilter{VariantID: c.Query("variant_id"), From: c.Query("from"), To: c.Query("to")}
+	for _, bound := range []string{filter.From, filter.To} {
+		if _, err := parseInstant(bound); bound != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be YYYY-MM-DD or RFC 3339 dates"})
+			return
+		}
+	}
+
+	points, err := h.pricesRepo.FindByProduct(c.Request.Context(), objID.Hex(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve price history", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price history"})
+		return
+	}
+
+	total, err := h.pricesRepo.CountByProduct(c.Request.Context(), objID.Hex(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count price history", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price history"})
+		return
+	}
+
+	items, links := pageOf(c, opts, points, total, func(p *models.PricePoint) string { return p.ID })
+	c.JSON(http.StatusOK, models.PriceHistory{Items: items, Total: total, Links: links})
+}
+
+// GetPriceAt godoc
+// @Summary      Get the price of a product at a point in time
+// @Description  Return the price point in effect at the given time. A bare date means the end of
+// @Description  that day (UTC), i.e. the last price the product had on it.
+// @Tags         prices
+// @Produce      json
+// @Param        id          path      string  true   "Product ID"
+// @Param        at          query     string  true   "YYYY-MM-DD or RFC 3339 time"
+// @Param        variant_id  query     string  false  "Variant ID; omit for the product's own price"
+// @Success      200         {object}  models.PricePoint
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /products/{id}/prices/at [get]
+func (h *PricesHandler) GetPriceAt(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	at, err := parseInstant(c.Query("at"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at must be a YYYY-MM-DD or RFC 3339 date"})
+		return
+	}
+
+	point, err := h.pricesRepo.PriceAt(c.Request.Context(), objID.Hex(), c.Query("variant_id"), at)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No price recorded for the product by that time"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to look up price", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, point)
+}
+
+// parseInstant reads an RFC 3339 time, or a date meaning the last second
+// of that day in UTC.
+func parseInstant(s string) (time.Time, error) {
+	if day, err := time.Parse(time.DateOnly, s); err == nil {
+		return day.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -1,31 +1,113 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/pkg/search"
+	"github.com/udevs/lesson3/pkg/slug"
+	"github.com/udevs/lesson3/pkg/suggest"
 	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 type ProductsHandler struct {
-	productsRepo repos.ProductRepository
-	logger       *zap.Logger
+	productsRepo   repos.ProductRepository
+	categoriesRepo repos.CategoryRepository
+	suggestions    *suggest.Index
+	images         *service.ImageService
+	productService *service.ProductService
+	logger         *zap.Logger
 }
 
-func NewProductsHandler(repo repos.ProductRepository, logger *zap.Logger) *ProductsHandler {
+func NewProductsHandler(repo repos.ProductRepository, categories repos.CategoryRepository, suggestions *suggest.Index, images *service.ImageService, productService *service.ProductService, logger *zap.Logger) *ProductsHandler {
 	return &ProductsHandler{
-		productsRepo: repo,
-		logger:       logger,
+		productsRepo:   repo,
+		categoriesRepo: categories,
+		suggestions:    suggestions,
+		images:         images,
+		productService: productService,
+		logger:         logger,
 	}
 }
 
+var (
+	errUnknownCategory  = errors.New("Unknown category; create it under /categories first")
+	errInvalidComponent = errors.New("Bundle components must be existing products that are not bundles, naming a variant where the product has variants")
+)
+
+// resolveCategory points the product at an existing category, found by
+// category_id or, for clients that only send a category name, by the slug
+// of that name. The category name is copied onto the product, and the
+// product attributes are checked against the schema of the category.
+func (h *ProductsHandler) resolveCategory(ctx context.Context, product *models.Product) error {
+	var (
+		category *models.Category
+		err      error
+	)
+	switch {
+	case product.CategoryID != "":
+		if !primitive.IsValidObjectID(product.CategoryID) {
+			return errUnknownCategory
+		}
+		category, err = h.categoriesRepo.FindByID(ctx, product.CategoryID)
+	case product.Category != "":
+		category, err = h.categoriesRepo.FindBySlug(ctx, slug.Make(product.Category))
+	default:
+		// Without a category there is no schema, so no attributes either.
+		product.Attributes, err = models.ValidateAttributes(nil, product.Attributes)
+		return err
+	}
+	if errors.Is(err, repos.ErrNotFound) {
+		return errUnknownCategory
+	}
+	if err != nil {
+		return err
+	}
+
+	product.CategoryID = category.ID
+	product.Category = category.Name
+
+	chain, err := h.categoriesRepo.Ancestors(ctx, category.ID)
+	if err != nil {
+		return err
+	}
+	product.Attributes, err = models.ValidateAttributes(models.MergeAttributeDefs(chain), product.Attributes)
+	return err
+}
+
+// writeCategoryError answers a failed resolveCategory.
+func (h *ProductsHandler) writeCategoryError(c *gin.Context, err error) {
+	if errors.Is(err, errUnknownCategory) || errors.Is(err, models.ErrInvalidAttributes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Error("Failed to resolve category", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve category"})
+}
+
 // CreateProduct godoc
 // @Summary      Create a new product
-// @Description  Add a new product to the database
+// @Description  Add a new product to the database. The category is given by category_id,
+// @Description  or by the name of an existing category. Attributes must follow the schema
+// @Description  of the category and its ancestors. The stock given is kept at the default
+// @Description  warehouse and recorded in the stock ledger as the opening balance. Once the
+// @Description  stock falls to reorder_point, a low-stock alert is raised. cost is the unit
+// @Description  cost of the opening stock, averaged with the cost of goods received later.
+// @Description  tax_class picks the tax rates orders of the product are taxed at, standard
+// @Description  when empty.
 // @Tags         products
 // @Accept       json
 // @Produce      json
@@ -41,14 +123,34 @@ func (h *ProductsHandler) CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	product.Images = nil
+	product.Locations = nil
+
+	if err := product.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.resolveCategory(c.Request.Context(), &product); err != nil {
+		h.writeCategoryError(c, err)
+		return
+	}
 
 	createdProduct, err := h.productsRepo.Create(c.Request.Context(), &product)
+	if errors.Is(err, repos.ErrInvalidReference) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidComponent.Error()})
+		return
+	}
+	if errors.Is(err, repos.ErrNoWarehouse) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Create a warehouse before adding stock"})
+		return
+	}
 	if err != nil {
 		h.logger.Error("Failed to create product", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
 
+	setETag(c, createdProduct.Version)
 	c.JSON(http.StatusCreated, createdProduct)
 }
 
@@ -57,8 +159,10 @@ func (h *ProductsHandler) CreateProduct(c *gin.Context) {
 // @Description  Retrieve product details by its ID
 // @Tags         products
 // @Produce      json
-// @Param        id   path      string  true  "Product ID"
+// @Param        id             path      string  true   "Product ID"
+// @Param        If-None-Match  header    string  false  "ETag of a cached copy"
 // @Success      200  {object}  models.Product
+// @Success      304  "Not modified"
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /products/{id} [get]
@@ -72,103 +176,340 @@ func (h *ProductsHandler) GetProductByID(c *gin.Context) {
 	}
 
 	product, err := h.productsRepo.FindByID(c.Request.Context(), objID.Hex())
-	if err != nil {
+	if errors.Is(err, repos.ErrNotFound) {
 		h.logger.Error("Product not found", zap.Error(err))
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve product", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+
+	if notModified(c, product.Version) {
+		return
+	}
 
+	setETag(c, product.Version)
 	c.JSON(http.StatusOK, product)
 }
 
 // GetAllProducts godoc
 // @Summary      Get all products
-// @Description  Retrieve all products with optional pagination and search
+// @Description  Retrieve products page by page. Pages are addressed by the opaque
+// @Description  cursors returned in links; page selects the legacy offset mode.
+// @Description  Filters use filter[field][op]=value with op one of eq, ne, gt, gte, lt,
+// @Description  lte, in, nin, contains; fields: name, sku, variant_sku, type, category_id,
+// @Description  category, price, stock, created_at, updated_at, and attr.<key> for the
+// @Description  attributes defined by categories, e.g. filter[attr.screen_size][gte]=6.
+// @Description  Dates are YYYY-MM-DD, a whole day in UTC, or RFC 3339 timestamps.
 // @Tags         products
 // @Produce      json
-// @Param        page    query     int     false  "Page number"
-// @Param        limit   query     int     false  "Page size"
-// @Param        search  query     string  false  "Search query"
-// @Success      200     {array}   models.Product
+// @Param        cursor  query     string  false  "Opaque cursor from links.next or links.prev"
+// @Param        page    query     int     false  "Page number (offset mode)"
+// @Param        limit   query     int     false  "Page size, at most 100"
+// @Param        search       query     string  false  "Match products whose name contains this text"
+// @Param        search_mode  query     string  false  "contains (default) or prefix"
+// @Param        warehouse_id query     string  false  "Only products in stock at this warehouse"
+// @Param        sort    query     string  false  "Comma-separated sort keys, '-' for descending, e.g. -price,name"
+// @Success      200     {object}  models.ProductList
+// @Failure      400     {object}  map[string]string
 // @Failure      500     {object}  map[string]string
 // @Router       /products [get]
 func (h *ProductsHandler) GetAllProducts(c *gin.Context) {
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
-	search := c.Query("search")
-
-	pageInt, err := strconv.ParseInt(page, 10, 64)
-	if err != nil || pageInt < 1 {
-		h.logger.Error("Invalid page parameter", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page parameter"})
+	opts, conditions, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	limitInt, err := strconv.ParseInt(limit, 10, 64)
-	if err != nil || limitInt < 1 {
-		h.logger.Error("Invalid limit parameter", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+	filter := repos.ProductFilter{
+		Search:       c.Query("search"),
+		SearchPrefix: c.Query("search_mode") == "prefix",
+		WarehouseID:  c.Query("warehouse_id"),
+		Conditions:   conditions,
+	}
+	if utf8.RuneCountInString(filter.Search) > search.MaxQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is too long"})
 		return
 	}
 
-	products, err := h.productsRepo.FindAll(c.Request.Context(), int(pageInt), int(limitInt), search)
+	products, err := h.productsRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if errors.Is(err, query.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		h.logger.Error("Failed to retrieve products", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
 		return
 	}
 
-	c.JSON(http.StatusOK, products)
+	total, err := h.productsRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		return
+	}
+
+	items, links := pageOf(c, opts, products, total, func(p *models.Product) string { return p.ID })
+	c.JSON(http.StatusOK, models.ProductList{Items: items, Total: total, Links: links})
+}
+
+// SearchProducts godoc
+// @Summary      Search products
+// @Description  Full-text search over name, category and description ranked by relevance,
+// @Description  with highlighted matches. When nothing matches exactly, typo-tolerant
+// @Description  matching is used and fuzzy is set. Facet counts per category, price range,
+// @Description  availability and text, enum and bool attribute value are returned for all
+// @Description  matches, not just the returned hits.
+// @Description  Accepts the same filter[...] parameters as the product listing.
+// @Tags         products
+// @Produce      json
+// @Param        q      query     string  true   "Search text"
+// @Param        limit  query     int     false  "Maximum number of hits, at most 100"
+// @Success      200    {object}  models.ProductSearchResult
+// @Failure      400    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /products/search [get]
+func (h *ProductsHandler) SearchProducts(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || utf8.RuneCountInString(q) > search.MaxQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be between 1 and 100 characters"})
+		return
+	}
+
+	opts, conditions, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid search parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.productsRepo.Search(c.Request.Context(), q, repos.ProductFilter{Conditions: conditions}, opts.Limit)
+	if errors.Is(err, query.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to search products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SuggestProducts godoc
+// @Summary      Autocomplete products
+// @Description  Typeahead lookup by name or SKU prefix, served from an in-memory index.
+// @Description  Every word of q must prefix a word of the name or SKU; frequently
+// @Description  ordered products rank higher.
+// @Tags         products
+// @Produce      json
+// @Param        q      query     string  true   "Typed prefix"
+// @Param        limit  query     int     false  "Maximum number of suggestions (default 10, max 50)"
+// @Success      200    {array}   suggest.Suggestion
+// @Failure      400    {object}  map[string]string
+// @Router       /products/suggest [get]
+func (h *ProductsHandler) SuggestProducts(c *gin.Context) {
+	q := c.Query("q")
+	if utf8.RuneCountInString(q) > search.MaxQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is too long"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidLimit.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.suggestions.Lookup(q, limit))
 }
 
 // UpdateProduct godoc
 // @Summary      Update product by ID
-// @Description  Modify an existing product
+// @Description  Modify an existing product. If-Match, or the version in the body, must name
+// @Description  the version being replaced: the write is rejected with 428 when neither is
+// @Description  given, and with 412 when it is no longer the current version. Stock is
+// @Description  left as it is; change it through /products/{id}/stock/adjust. type, tax_class,
+// @Description  options, variants, bundle and attributes keep their stored values when left
+// @Description  out of the body; send them empty to clear them.
 // @Tags         products
 // @Accept       json
 // @Produce      json
-// @Param        id      path      string          true  "Product ID"
-// @Param        product body      models.Product  true  "Product details"
+// @Param        id        path      string          true   "Product ID"
+// @Param        If-Match  header    string          false  "ETag of the version being replaced"
+// @Param        product   body      models.Product  true   "Product details"
 // @Success      200     {object}  models.Product
 // @Failure      400     {object}  map[string]string
 // @Failure      404     {object}  map[string]string
+// @Failure      412     {object}  map[string]string
+// @Failure      428     {object}  map[string]string
 // @Failure      500     {object}  map[string]string
 // @Router       /products/{id} [put]
 func (h *ProductsHandler) UpdateProduct(c *gin.Context) {
-	id := c.Param("id")
-	objID, err := primitive.ObjectIDFromHex(id)
+	version, hasIfMatch, err := ifMatchVersion(c)
 	if err != nil {
-		h.logger.Error("Invalid product ID", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		h.logger.Error("Invalid If-Match header", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
 		return
 	}
 
-	var product models.Product
-	if err := c.ShouldBindJSON(&product); err != nil {
+	var (
+		product models.Product
+		fields  map[string]json.RawMessage
+	)
+	if err := c.ShouldBindBodyWith(&product, binding.JSON); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := c.ShouldBindBodyWith(&fields, binding.JSON); err != nil {
 		h.logger.Error("Invalid input", zap.Error(err))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	stored := h.loadForWrite(c, product.Version)
+	if stored == nil {
+		return
+	}
+	keepOmitted(&product, stored, fields)
+	product.Images = nil
+	if hasIfMatch {
+		product.Version = version
+	}
 
-	updatedProduct, err := h.productsRepo.Update(c.Request.Context(), objID.Hex(), &product)
-	if err != nil {
-		h.logger.Error("Failed to update product", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+	h.saveProduct(c, stored.ID, &product)
+}
+
+// keepOmitted carries over the stored values of the fields a full update
+// leaves out of its body, so that clients unaware of variants, bundles,
+// attributes or tax classes do not wipe them.
+func keepOmitted(product, stored *models.Product, fields map[string]json.RawMessage) {
+	omitted := func(key string) bool {
+		_, ok := fields[key]
+		return !ok
+	}
+	if omitted("type") {
+		product.Type = stored.Type
+	}
+	if omitted("tax_class") {
+		product.TaxClass = stored.TaxClass
+	}
+	if omitted("options") {
+		product.Options = stored.Options
+	}
+	if omitted("variants") {
+		product.Variants = stored.Variants
+	}
+	if omitted("bundle") {
+		product.Bundle = stored.Bundle
+	}
+	if omitted("attributes") {
+		product.Attributes = stored.Attributes
+	}
+}
+
+// PatchProduct godoc
+// @Summary      Partially update product by ID
+// @Description  Change only the supplied fields of a product. If-Match must name the version
+// @Description  being modified: the write is rejected with 428 without it, and with 412 when
+// @Description  it is no longer the current version.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string               true   "Product ID"
+// @Param        If-Match  header    string               true   "ETag of the version being modified"
+// @Param        product   body      models.ProductPatch  true   "Fields to change"
+// @Success      200     {object}  models.Product
+// @Failure      400     {object}  map[string]string
+// @Failure      404     {object}  map[string]string
+// @Failure      412     {object}  map[string]string
+// @Failure      428     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /products/{id} [patch]
+func (h *ProductsHandler) PatchProduct(c *gin.Context) {
+	var patch models.ProductPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	patch.Apply(product)
+	if patch.Category != nil && patch.CategoryID == nil {
+		// Re-resolve by the new name rather than keep the old category.
+		product.CategoryID = ""
+	}
+	h.saveProduct(c, product.ID, product)
+}
 
-	c.JSON(http.StatusOK, updatedProduct)
+func (h *ProductsHandler) saveProduct(c *gin.Context, id string, product *models.Product) {
+	if updatedProduct := h.save(c, id, product); updatedProduct != nil {
+		setETag(c, updatedProduct.Version)
+		c.JSON(http.StatusOK, updatedProduct)
+	}
+}
+
+// save validates and writes the product. When that fails it answers the
+// request itself and returns nil.
+func (h *ProductsHandler) save(c *gin.Context, id string, product *models.Product) *models.Product {
+	if err := product.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil
+	}
+	if err := h.resolveCategory(c.Request.Context(), product); err != nil {
+		h.writeCategoryError(c, err)
+		return nil
+	}
+
+	updatedProduct, err := h.productsRepo.Update(c.Request.Context(), id, product)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return nil
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Product was modified by another request"})
+		return nil
+	case errors.Is(err, repos.ErrInvalidReference):
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidComponent.Error()})
+		return nil
+	case err != nil:
+		h.logger.Error("Failed to update product", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return nil
+	}
+	return updatedProduct
 }
 
 // DeleteProduct godoc
 // @Summary      Delete product by ID
-// @Description  Remove a product from the database
+// @Description  Move a product to the trash. It can be restored until the trash is purged.
+// @Description  Products referenced by orders that are not delivered, completed or cancelled
+// @Description  cannot be deleted; the 409 response lists the blocking orders. If-Match must
+// @Description  name the version being deleted: 428 without it, 412 when it is outdated.
 // @Tags         products
 // @Produce      json
-// @Param        id   path      string  true  "Product ID"
+// @Param        id        path      string  true   "Product ID"
+// @Param        If-Match  header    string  true   "ETag of the version being deleted"
+// @Param        X-Actor   header    string  false  "Who is deleting, recorded as deleted_by"
 // @Success      204  {object}  nil
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      412  {object}  map[string]string
+// @Failure      428  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /products/{id} [delete]
 func (h *ProductsHandler) DeleteProduct(c *gin.Context) {
@@ -180,7 +521,32 @@ func (h *ProductsHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	if err := h.productsRepo.Delete(c.Request.Context(), objID.Hex()); err != nil {
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		h.logger.Error("Invalid If-Match header", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+	if missingPrecondition(c, 0) {
+		return
+	}
+
+	err = h.productService.Delete(c.Request.Context(), objID.Hex(), version)
+	var inUse *service.ProductInUseError
+	switch {
+	case errors.As(err, &inUse):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Product is referenced by open orders",
+			"orders": inUse.OrderIDs,
+		})
+		return
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Product was modified by another request"})
+		return
+	case err != nil:
 		h.logger.Error("Failed to delete product", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type PromotionsHandler struct {
+	promotionsRepo repos.PromotionRepository
+	logger         *zap.Logger
+}
+
+func NewPromotionsHandler(promotions repos.PromotionRepository, logger *zap.Logger) *PromotionsHandler {
+	return &PromotionsHandler{
+		promotionsRepo: promotions,
+		logger:         logger,
+	}
+}
+
+// PromotionInput is the writable part of a promotion.
+type PromotionInput struct {
+	Name             string   `json:"name" binding:"required"`
+	Code             string   `json:"code"`
+	Type             string   `json:"type" binding:"required"`
+	Value            float64  `json:"value"`
+	BuyQuantity      int      `json:"buy_quantity"`
+	GetQuantity      int      `json:"get_quantity"`
+	ProductIDs       []string `json:"product_ids"`
+	CategoryIDs      []string `json:"category_ids"`
+	MinOrderValue    float64  `json:"min_order_value"`
+	StartsAt         string   `json:"starts_at"`
+	EndsAt           string   `json:"ends_at"`
+	UsageLimit       int      `json:"usage_limit"`
+	PerCustomerLimit int      `json:"per_customer_limit"`
+	Exclusive        bool     `json:"exclusive"`
+	Active           bool     `json:"active"`
+	Version          int64    `json:"version"`
+}
+
+func (in *PromotionInput) promotion() *models.Promotion {
+	return &models.Promotion{
+		Name:             in.Name,
+		Code:             strings.ToUpper(strings.TrimSpace(in.Code)),
+		Type:             in.Type,
+		Value:            in.Value,
+		BuyQuantity:      in.BuyQuantity,
+		GetQuantity:      in.GetQuantity,
+		ProductIDs:       in.ProductIDs,
+		CategoryIDs:      in.CategoryIDs,
+		MinOrderValue:    in.MinOrderValue,
+		StartsAt:         in.StartsAt,
+		EndsAt:           in.EndsAt,
+		UsageLimit:       in.UsageLimit,
+		PerCustomerLimit: in.PerCustomerLimit,
+		Exclusive:        in.Exclusive,
+		Active:           in.Active,
+		Version:          in.Version,
+	}
+}
+
+// CreatePromotion godoc
+// @Summary      Create a promotion
+// @Description  Add a promotion. With a code it is a coupon, applied to the orders and carts
+// @Description  the code is entered for; without one it applies automatically to every order
+// @Description  it fits. Types are percentage, fixed_amount, free_shipping and buy_x_get_y.
+// @Description  product_ids and category_ids limit the lines it applies to. Codes are unique
+// @Description  and matched regardless of case.
+// @Tags         promotions
+// @Accept       json
+// @Produce      json
+// @Param        promotion  body      PromotionInput  true  "Promotion details"
+// @Success      201        {object}  models.Promotion
+// @Failure      400        {object}  map[string]string
+// @Failure      409        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /promotions [post]
+func (h *PromotionsHandler) CreatePromotion(c *gin.Context) {
+	var input PromotionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	promotion := input.promotion()
+	if err := promotion.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.promotionsRepo.Create(c.Request.Context(), promotion)
+	if errors.Is(err, repos.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A promotion with this code already exists"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create promotion", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllPromotions godoc
+// @Summary      List promotions
+// @Description  List promotions, newest first.
+// @Tags         promotions
+// @Produce      json
+// @Param        limit   query     int     false  "Items per page, at most 100"
+// @Param        cursor  query     string  false  "Cursor from the links of a previous page"
+// @Success      200     {object}  models.PromotionList
+// @Failure      400     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /promotions [get]
+func (h *PromotionsHandler) GetAllPromotions(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	promotions, err := h.promotionsRepo.FindAll(c.Request.Context(), lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve promotions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve promotions"})
+		return
+	}
+
+	total, err := h.promotionsRepo.Count(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to count promotions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve promotions"})
+		return
+	}
+
+	items, links := pageOf(c, opts, promotions, total, func(p *models.Promotion) string { return p.ID })
+	c.JSON(http.StatusOK, models.PromotionList{Items: items, Total: total, Links: links})
+}
+
+// GetPromotionByID godoc
+// @Summary      Get promotion by ID
+// @Tags         promotions
+// @Produce      json
+// @Param        id   path      string  true  "Promotion ID"
+// @Success      200  {object}  models.Promotion
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /promotions/{id} [get]
+func (h *PromotionsHandler) GetPromotionByID(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+
+	promotion, err := h.promotionsRepo.FindByID(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve promotion", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve promotion"})
+		return
+	}
+
+	if notModified(c, promotion.Version) {
+		return
+	}
+	setETag(c, promotion.Version)
+	c.JSON(http.StatusOK, promotion)
+}
+
+// UpdatePromotion godoc
+// @Summary      Update a promotion
+// @Description  Replace the terms of a promotion. Its use count is kept, and orders keep the
+// @Description  discounts they were placed with.
+// @Tags         promotions
+// @Accept       json
+// @Produce      json
+// @Param        id         path      string          true   "Promotion ID"
+// @Param        If-Match   header    string          false  "ETag of the version being replaced"
+// @Param        promotion  body      PromotionInput  true   "Promotion details"
+// @Success      200        {object}  models.Promotion
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      409        {object}  map[string]string
+// @Failure      412        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /promotions/{id} [put]
+func (h *PromotionsHandler) UpdatePromotion(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input PromotionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	promotion := input.promotion()
+	if hasIfMatch {
+		promotion.Version = version
+	}
+	if err := promotion.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.promotionsRepo.Update(c.Request.Context(), id, promotion)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A promotion with this code already exists"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Promotion was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update promotion", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion"})
+		return
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeletePromotion godoc
+// @Summary      Delete a promotion
+// @Description  Remove a promotion and its per-customer use counts. Orders keep the discounts
+// @Description  they were placed with.
+// @Tags         promotions
+// @Produce      json
+// @Param        id        path      string  true   "Promotion ID"
+// @Param        If-Match  header    string  false  "ETag of the version being deleted"
+// @Success      204       {object}  nil
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /promotions/{id} [delete]
+func (h *PromotionsHandler) DeletePromotion(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	err = h.promotionsRepo.Delete(c.Request.Context(), id, version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Promotion was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete promotion", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete promotion"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
+	"go.uber.org/zap"
+)
+
+type PurchaseOrdersHandler struct {
+	purchasing         *service.PurchasingService
+	purchaseOrdersRepo repos.PurchaseOrderRepository
+	logger             *zap.Logger
+}
+
+func NewPurchaseOrdersHandler(purchasing *service.PurchasingService, repo repos.PurchaseOrderRepository, logger *zap.Logger) *PurchaseOrdersHandler {
+	return &PurchaseOrdersHandler{purchasing: purchasing, purchaseOrdersRepo: repo, logger: logger}
+}
+
+// PurchaseOrderLineInput is a line of a purchase order as written.
+type PurchaseOrderLineInput struct {
+	ProductID string  `json:"product_id" binding:"required"`
+	VariantID string  `json:"variant_id"`
+	Quantity  int     `json:"quantity"`
+	UnitCost  float64 `json:"unit_cost"`
+}
+
+// PurchaseOrderInput is the writable part of a purchase order.
+type PurchaseOrderInput struct {
+	SupplierID string `json:"supplier_id" binding:"required"`
+	// WarehouseID defaults to the default warehouse.
+	WarehouseID  string                   `json:"warehouse_id"`
+	Lines        []PurchaseOrderLineInput `json:"lines" binding:"required,dive"`
+	ExpectedDate string                   `json:"expected_date"`
+	Note         string                   `json:"note"`
+	Version      int64                    `json:"version"`
+}
+
+func (in *PurchaseOrderInput) purchaseOrder() *models.PurchaseOrder {
+	po := &models.PurchaseOrder{
+		SupplierID:   in.SupplierID,
+		WarehouseID:  in.WarehouseID,
+		ExpectedDate: in.ExpectedDate,
+		Note:         in.Note,
+		Version:      in.Version,
+	}
+	for _, line := range in.Lines {
+		po.Lines = append(po.Lines, models.PurchaseOrderLine{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Quantity:  line.Quantity,
+			UnitCost:  line.UnitCost,
+		})
+	}
+	return po
+}
+
+// CreatePurchaseOrder godoc
+// @Summary      Create a purchase order
+// @Description  Draft an order of products from a supplier, with the quantity and unit cost
+// @Description  of each. Goods are received into warehouse_id, by default the default
+// @Description  warehouse.
+// @Tags         purchase-orders
+// @Accept       json
+// @Produce      json
+// @Param        purchase_order  body      PurchaseOrderInput  true  "Purchase order details"
+// @Success      201             {object}  models.PurchaseOrder
+// @Failure      400             {object}  map[string]string
+// @Failure      500             {object}  map[string]string
+// @Router       /purchase-orders [post]
+func (h *PurchaseOrdersHandler) CreatePurchaseOrder(c *gin.Context) {
+	var input PurchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	created, err := h.purchasing.Create(c.Request.Context(), input.purchaseOrder())
+	if errors.Is(err, service.ErrInvalidPurchaseOrder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create purchase order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase order"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllPurchaseOrders godoc
+// @Summary      List purchase orders
+// @Description  List purchase orders, newest first.
+// @Tags         purchase-orders
+// @Produce      json
+// @Param        status       query     string  false  "draft, sent, partially_received, received or cancelled"
+// @Param        supplier_id  query     string  false  "Only the purchase orders of this supplier"
+// @Param        product_id   query     string  false  "Only the purchase orders with a line of this product"
+// @Param        limit        query     int     false  "Items per page, at most 100"
+// @Param        cursor       query     string  false  "Cursor from the links of a previous page"
+// @Success      200          {object}  models.PurchaseOrderList
+// @Failure      400          {object}  map[string]string
+// @Failure      500          {object}  map[string]string
+// @Router       /purchase-orders [get]
+func (h *PurchaseOrdersHandler) GetAllPurchaseOrders(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.PurchaseOrderFilter{SupplierID: c.Query("supplier_id"), ProductID: c.Query("product_id")}
+	if status := c.Query("status"); status != "" {
+		filter.Statuses = []string{status}
+	}
+	pos, err := h.purchaseOrdersRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve purchase orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve purchase orders"})
+		return
+	}
+
+	total, err := h.purchaseOrdersRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count purchase orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve purchase orders"})
+		return
+	}
+
+	items, links := pageOf(c, opts, pos, total, func(po *models.PurchaseOrder) string { return po.ID })
+	c.JSON(http.StatusOK, models.PurchaseOrderList{Items: items, Total: total, Links: links})
+}
+
+// GetPurchaseOrderByID godoc
+// @Summary      Get purchase order by ID
+// @Tags         purchase-orders
+// @Produce      json
+// @Param        id   path      string  true  "Purchase order ID"
+// @Success      200  {object}  models.PurchaseOrder
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /purchase-orders/{id} [get]
+func (h *PurchaseOrdersHandler) GetPurchaseOrderByID(c *gin.Context) {
+	po, err := h.purchaseOrdersRepo.FindByID(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve purchase order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve purchase order"})
+		return
+	}
+
+	if notModified(c, po.Version) {
+		return
+	}
+	setETag(c, po.Version)
+	c.JSON(http.StatusOK, po)
+}
+
+// UpdatePurchaseOrder godoc
+// @Summary      Update a draft purchase order
+// @Description  Replace a purchase order that has not been sent yet. The write is rejected
+// @Description  with 412 if If-Match (or the version in the body) does not name the current
+// @Description  version, and with 409 once the purchase order has been sent.
+// @Tags         purchase-orders
+// @Accept       json
+// @Produce      json
+// @Param        id              path      string              true   "Purchase order ID"
+// @Param        If-Match        header    string              false  "ETag of the version being replaced"
+// @Param        purchase_order  body      PurchaseOrderInput  true   "Purchase order details"
+// @Success      200             {object}  models.PurchaseOrder
+// @Failure      400             {object}  map[string]string
+// @Failure      404             {object}  map[string]string
+// @Failure      409             {object}  map[string]string
+// @Failure      412             {object}  map[string]string
+// @Failure      500             {object}  map[string]string
+// @Router       /purchase-orders/{id} [put]
+func (h *PurchaseOrdersHandler) UpdatePurchaseOrder(c *gin.Context) {
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input PurchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	po := input.purchaseOrder()
+	if hasIfMatch {
+		po.Version = version
+	}
+
+	id := c.Param("id")
+	updated, err := h.purchasing.Update(c.Request.Context(), id, po)
+	switch {
+	case errors.Is(err, service.ErrInvalidPurchaseOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		// Tell a purchase order that was sent from one that was edited.
+		current, findErr := h.purchaseOrdersRepo.FindByID(c.Request.Context(), id)
+		if findErr == nil && current.Status != models.PurchaseOrderDraft {
+			c.JSON(http.StatusConflict, gin.H{"error": "Purchase order is no longer a draft"})
+			return
+		}
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Purchase order was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update purchase order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order"})
+		return
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// SendPurchaseOrder godoc
+// @Summary      Send a purchase order
+// @Description  Mark a draft as sent to the supplier. Goods can then be received against it.
+// @Tags         purchase-orders
+// @Produce      json
+// @Param        id   path      string  true  "Purchase order ID"
+// @Success      200  {object}  models.PurchaseOrder
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /purchase-orders/{id}/send [post]
+func (h *PurchaseOrdersHandler) SendPurchaseOrder(c *gin.Context) {
+	po, err := h.purchasing.Send(c.Request.Context(), c.Param("id"))
+	h.respond(c, po, err, "Purchase order is not a draft", "send")
+}
+
+// ReceivePurchaseOrder godoc
+// @Summary      Receive goods on a purchase order
+// @Description  Book goods delivered against a sent purchase order. The stock of each product
+// @Description  goes up at the warehouse of the purchase order, recorded in the stock ledger as
+// @Description  a receipt whose unit cost is averaged into the cost of the product. Without
+// @Description  lines, everything still outstanding is received.
+// @Tags         purchase-orders
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string               true   "Purchase order ID"
+// @Param        receipt  body      models.GoodsReceipt  false  "Goods delivered"
+// @Success      200      {object}  models.PurchaseOrder
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /purchase-orders/{id}/receive [post]
+func (h *PurchaseOrdersHandler) ReceivePurchaseOrder(c *gin.Context) {
+	var receipt models.GoodsReceipt
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&receipt); err != nil {
+			h.logger.Error("Invalid input", zap.Error(err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	po, err := h.purchasing.Receive(c.Request.Context(), c.Param("id"), &receipt)
+	h.respond(c, po, err, "Purchase order is not awaiting goods, or was modified by another request", "receive")
+}
+
+// CancelPurchaseOrder godoc
+// @Summary      Cancel a purchase order
+// @Description  Drop a draft, or a sent purchase order nothing has been received on.
+// @Tags         purchase-orders
+// @Produce      json
+// @Param        id   path      string  true  "Purchase order ID"
+// @Success      200  {object}  models.PurchaseOrder
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /purchase-orders/{id}/cancel [post]
+func (h *PurchaseOrdersHandler) CancelPurchaseOrder(c *gin.Context) {
+	po, err := h.purchasing.Cancel(c.Request.Context(), c.Param("id"))
+	h.respond(c, po, err, "Purchase order has received goods or is already closed", "cancel")
+}
+
+// respond writes the result of moving a purchase order on by action.
+func (h *PurchaseOrdersHandler) respond(c *gin.Context, po *models.PurchaseOrder, err error, conflict, action string) {
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": conflict})
+		return
+	case errors.Is(err, service.ErrInvalidPurchaseOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to "+action+" purchase order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " purchase order"})
+		return
+	}
+
+	setETag(c, po.Version)
+	c.JSON(http.StatusOK, po)
+}
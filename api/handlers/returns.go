@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
+	"go.uber.org/zap"
+)
+
+type ReturnsHandler struct {
+	returns     *service.ReturnService
+	returnsRepo repos.ReturnRepository
+	logger      *zap.Logger
+}
+
+func NewReturnsHandler(returns *service.ReturnService, repo repos.ReturnRepository, logger *zap.Logger) *ReturnsHandler {
+	return &ReturnsHandler{returns: returns, returnsRepo: repo, logger: logger}
+}
+
+// ReturnLineInput is a line of a return as requested.
+type ReturnLineInput struct {
+	ProductID string `json:"product_id" binding:"required"`
+	VariantID string `json:"variant_id"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+}
+
+// ReturnInput is a request to return goods of an order.
+type ReturnInput struct {
+	OrderID string            `json:"order_id" binding:"required"`
+	Lines   []ReturnLineInput `json:"lines" binding:"required,dive"`
+}
+
+func (in *ReturnInput) ret() *models.Return {
+	ret := &models.Return{OrderID: in.OrderID}
+	for _, line := range in.Lines {
+		ret.Lines = append(ret.Lines, models.ReturnLine{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Quantity:  line.Quantity,
+			Reason:    line.Reason,
+		})
+	}
+	return ret
+}
+
+// ReturnDecisionInput is the note left approving or rejecting a return.
+type ReturnDecisionInput struct {
+	Note string `json:"note"`
+}
+
+// ReturnRefundInput is the amount to refund; zero refunds what was paid for
+// the goods.
+type ReturnRefundInput struct {
+	Amount float64 `json:"amount"`
+}
+
+// CreateReturn godoc
+// @Summary      Request a return
+// @Description  Request to send back goods of a shipped order: for each ordered product, or
+// @Description  variant, how much comes back and why. At most what was ordered can be
+// @Description  returned, counting the other returns of the order that were not rejected.
+// @Tags         returns
+// @Accept       json
+// @Produce      json
+// @Param        return  body      ReturnInput  true  "Return details"
+// @Success      201     {object}  models.Return
+// @Failure      400     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /returns [post]
+func (h *ReturnsHandler) CreateReturn(c *gin.Context) {
+	var input ReturnInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	created, err := h.returns.Request(c.Request.Context(), input.ret())
+	if errors.Is(err, service.ErrInvalidReturn) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create return", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create return"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllReturns godoc
+// @Summary      List returns
+// @Description  List returns, newest first.
+// @Tags         returns
+// @Produce      json
+// @Param        status    query     string  false  "requested, approved, rejected, received or refunded"
+// @Param        order_id  query     string  false  "Only the returns of this order"
+// @Param        limit     query     int     false  "Items per page, at most 100"
+// @Param        cursor    query     string  false  "Cursor from the links of a previous page"
+// @Success      200       {object}  models.ReturnList
+// @Failure      400       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /returns [get]
+func (h *ReturnsHandler) GetAllReturns(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.ReturnFilter{OrderID: c.Query("order_id")}
+	if status := c.Query("status"); status != "" {
+		filter.Statuses = []string{status}
+	}
+	returns, err := h.returnsRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve returns", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve returns"})
+		return
+	}
+
+	total, err := h.returnsRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count returns", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve returns"})
+		return
+	}
+
+	items, links := pageOf(c, opts, returns, total, func(r *models.Return) string { return r.ID })
+	c.JSON(http.StatusOK, models.ReturnList{Items: items, Total: total, Links: links})
+}
+
+// GetReturnByID godoc
+// @Summary      Get return by ID
+// @Tags         returns
+// @Produce      json
+// @Param        id   path      string  true  "Return ID"
+// @Success      200  {object}  models.Return
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /returns/{id} [get]
+func (h *ReturnsHandler) GetReturnByID(c *gin.Context) {
+	ret, err := h.returnsRepo.FindByID(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve return", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve return"})
+		return
+	}
+
+	if notModified(c, ret.Version) {
+		return
+	}
+	setETag(c, ret.Version)
+	c.JSON(http.StatusOK, ret)
+}
+
+// ApproveReturn godoc
+// @Summary      Approve a return
+// @Description  Accept a requested return, so its goods can be sent back.
+// @Tags         returns
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string               true   "Return ID"
+// @Param        decision  body      ReturnDecisionInput  false  "Note on the decision"
+// @Success      200       {object}  models.Return
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /returns/{id}/approve [post]
+func (h *ReturnsHandler) ApproveReturn(c *gin.Context) {
+	var input ReturnDecisionInput
+	if !h.bind(c, &input) {
+		return
+	}
+	ret, err := h.returns.Approve(c.Request.Context(), c.Param("id"), input.Note)
+	h.respond(c, ret, err, "Return is not awaiting approval, or was modified by another request", "approve")
+}
+
+// RejectReturn godoc
+// @Summary      Reject a return
+// @Description  Turn down a return whose goods have not been received.
+// @Tags         returns
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string               true   "Return ID"
+// @Param        decision  body      ReturnDecisionInput  false  "Note on the decision"
+// @Success      200       {object}  models.Return
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /returns/{id}/reject [post]
+func (h *ReturnsHandler) RejectReturn(c *gin.Context) {
+	var input ReturnDecisionInput
+	if !h.bind(c, &input) {
+		return
+	}
+	ret, err := h.returns.Reject(c.Request.Context(), c.Param("id"), input.Note)
+	h.respond(c, ret, err, "Return has been received or is already closed", "reject")
+}
+
+// ReceiveReturn godoc
+// @Summary      Receive the goods of a return
+// @Description  Book the goods of an approved return as back. Goods not listed as damaged go
+// @Description  back into stock, recorded in the stock ledger as returns: at warehouse_id if
+// @Description  given, else at the warehouse they were shipped from, else at the default one.
+// @Description  Bundles are restocked as their components.
+// @Tags         returns
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                true   "Return ID"
+// @Param        receipt  body      models.ReturnReceipt  false  "Where to restock, and damaged goods"
+// @Success      200      {object}  models.Return
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /returns/{id}/receive [post]
+func (h *ReturnsHandler) ReceiveReturn(c *gin.Context) {
+	var receipt models.ReturnReceipt
+	if !h.bind(c, &receipt) {
+		return
+	}
+	ret, err := h.returns.Receive(c.Request.Context(), c.Param("id"), &receipt)
+	h.respond(c, ret, err, "Return is not awaiting goods, or was modified by another request", "receive")
+}
+
+// RefundReturn godoc
+// @Summary      Refund a return
+// @Description  Refund a received return on its order: what was paid for the goods, after
+// @Description  discounts and with tax, unless a smaller amount is given; shipping is kept.
+// @Description  The money goes back through the captured payments of the order as far as they
+// @Description  go, the rest being refunded outside them. The order records the refund and
+// @Description  moves to refunded once every line is returned in full, partially_refunded until
+// @Description  then. If the gateway fails, the refund stays recorded and the response is 502
+// @Description  with the return; refund the rest through the payments of the order.
+// @Tags         returns
+// @Accept       json
+// @Produce      json
+// @Param        id      path      string             true   "Return ID"
+// @Param        refund  body      ReturnRefundInput  false  "Amount to refund"
+// @Success      200     {object}  models.Return
+// @Failure      400     {object}  map[string]string
+// @Failure      404     {object}  map[string]string
+// @Failure      409     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Failure      502     {object}  map[string]interface{}
+// @Router       /returns/{id}/refund [post]
+func (h *ReturnsHandler) RefundReturn(c *gin.Context) {
+	var input ReturnRefundInput
+	if !h.bind(c, &input) {
+		return
+	}
+	ret, err := h.returns.Refund(c.Request.Context(), c.Param("id"), input.Amount)
+	if errors.Is(err, service.ErrRefundIncomplete) {
+		h.logger.Error("Refund of return incomplete", zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "return": ret})
+		return
+	}
+	h.respond(c, ret, err, "Return is not awaiting a refund, or was modified by another request", "refund")
+}
+
+// bind reads an optional JSON body into input, answering 400 and
+// reporting false when it is malformed.
+func (h *ReturnsHandler) bind(c *gin.Context, input interface{}) bool {
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+	if err := c.ShouldBindJSON(input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return false
+	}
+	return true
+}
+
+// respond writes the result of moving a return on by action.
+func (h *ReturnsHandler) respond(c *gin.Context, ret *models.Return, err error, conflict, action string) {
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": conflict})
+		return
+	case errors.Is(err, service.ErrInvalidReturn):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to "+action+" return", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " return"})
+		return
+	}
+
+	setETag(c, ret.Version)
+	c.JSON(http.StatusOK, ret)
+}
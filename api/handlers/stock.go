@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type StockHandler struct {
+	inventory  *service.InventoryService
+	ledgerRepo repos.StockLedgerRepository
+	logger     *zap.Logger
+}
+
+func NewStockHandler(inventory *service.InventoryService, ledger repos.StockLedgerRepository, logger *zap.Logger) *StockHandler {
+	return &StockHandler{inventory: inventory, ledgerRepo: ledger, logger: logger}
+}
+
+// AdjustStock godoc
+// @Summary      Adjust the stock of a product
+// @Description  Record goods received (receipt), goods returned by a customer (return, optionally
+// @Description  naming the order) or a correction (adjustment, with a reason) in the stock
+// @Description  ledger, and change the stock at a warehouse by quantity; warehouse_id defaults
+// @Description  to the default warehouse. This is the only way to change stock besides placing
+// @Description  orders, shipping transfers and receiving purchase orders; product updates
+// @Description  leave it as it is.
+// @Tags         stock
+// @Accept       json
+// @Produce      json
+// @Param        id          path      string                  true  "Product ID"
+// @Param        adjustment  body      models.StockAdjustment  true  "Stock change"
+// @Success      200         {object}  models.Product
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      409         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /products/{id}/stock/adjust [post]
+func (h *StockHandler) AdjustStock(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var adj models.StockAdjustment
+	if err := c.ShouldBindJSON(&adj); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product, err := h.inventory.Adjust(c.Request.Context(), objID.Hex(), &adj)
+	if errors.Is(err, models.ErrInvalidAdjustment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if errors.Is(err, repos.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock for this adjustment"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to adjust stock", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust stock"})
+		return
+	}
+
+	setETag(c, product.Version)
+	c.JSON(http.StatusOK, product)
+}
+
+// GetStockMovements godoc
+// @Summary      List the stock movements of a product
+// @Description  List the stock ledger of a product, newest first. The stock of a product, or of
+// @Description  a variant, is the sum of the quantities of its movements.
+// @Tags         stock
+// @Produce      json
+// @Param        id            path      string  true   "Product ID"
+// @Param        variant_id    query     string  false  "Only the movements of this variant"
+// @Param        warehouse_id  query     string  false  "Only the movements at this warehouse"
+// @Param        type          query     string  false  "receipt, sale, return, adjustment or transfer"
+// @Param        limit         query     int     false  "Items per page, at most 100"
+// @Param        cursor        query     string  false  "Cursor from the links of a previous page"
+// @Success      200           {object}  models.StockMovementList
+// @Failure      400           {object}  map[string]string
+// @Failure      500           {object}  map[string]string
+// @Router       /products/{id}/stock/movements [get]
+func (h *StockHandler) GetStockMovements(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.StockMovementFilter{
+		VariantID:   c.Query("variant_id"),
+		WarehouseID: c.Query("warehouse_id"),
+		Type:        c.Query("type"),
+	}
+	movements, err := h.ledgerRepo.FindByProduct(c.Request.Context(), objID.Hex(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve stock movements", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock movements"})
+		return
+	}
+
+	total, err := h.ledgerRepo.CountByProduct(c.Request.Context(), objID.Hex(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count stock movements", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stock movements"})
+		return
+	}
+
+	items, links := pageOf(c, opts, movements, total, func(m *models.StockMovement) string { return m.ID })
+	c.JSON(http.StatusOK, models.StockMovementList{Items: items, Total: total, Links: links})
+}
+
+// GetAvailability godoc
+// @Summary      Get the stock of a product at each warehouse
+// @Description  List the warehouses holding stock of a product, by priority, with the stock of
+// @Description  each variant.
+// @Tags         stock
+// @Produce      json
+// @Param        id   path      string  true  "Product ID"
+// @Success      200  {array}   models.WarehouseStock
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /products/{id}/availability [get]
+func (h *StockHandler) GetAvailability(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	availability, err := h.inventory.Availability(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve availability", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve availability"})
+		return
+	}
+	c.JSON(http.StatusOK, availability)
+}
+
+// GetReconciliation godoc
+// @Summary      Reconcile stock with the stock ledger
+// @Description  Compare the stock of every product, and of every variant, in total and at each
+// @Description  warehouse, with the sum of its movements in the ledger and list the ones that
+// @Description  differ.
+// @Tags         stock
+// @Produce      json
+// @Success      200  {object}  models.StockReconciliation
+// @Failure      500  {object}  map[string]string
+// @Router       /stock/reconciliation [get]
+func (h *StockHandler) GetReconciliation(c *gin.Context) {
+	report, err := h.ledgerRepo.Reconcile(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to reconcile stock", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile stock"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type SuppliersHandler struct {
+	suppliersRepo      repos.SupplierRepository
+	purchaseOrdersRepo repos.PurchaseOrderRepository
+	logger             *zap.Logger
+}
+
+func NewSuppliersHandler(suppliers repos.SupplierRepository, purchaseOrders repos.PurchaseOrderRepository, logger *zap.Logger) *SuppliersHandler {
+	return &SuppliersHandler{
+		suppliersRepo:      suppliers,
+		purchaseOrdersRepo: purchaseOrders,
+		logger:             logger,
+	}
+}
+
+// SupplierInput is the writable part of a supplier.
+type SupplierInput struct {
+	Name         string `json:"name" binding:"required"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	Address      string `json:"address"`
+	LeadTimeDays int    `json:"lead_time_days" binding:"min=0"`
+	Note         string `json:"note"`
+	Version      int64  `json:"version"`
+}
+
+func (in *SupplierInput) supplier() *models.Supplier {
+	return &models.Supplier{
+		Name:         in.Name,
+		Email:        in.Email,
+		Phone:        in.Phone,
+		Address:      in.Address,
+		LeadTimeDays: in.LeadTimeDays,
+		Note:         in.Note,
+		Version:      in.Version,
+	}
+}
+
+// CreateSupplier godoc
+// @Summary      Create a supplier
+// @Tags         suppliers
+// @Accept       json
+// @Produce      json
+// @Param        supplier  body      SupplierInput  true  "Supplier details"
+// @Success      201       {object}  models.Supplier
+// @Failure      400       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /suppliers [post]
+func (h *SuppliersHandler) CreateSupplier(c *gin.Context) {
+	var input SupplierInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	created, err := h.suppliersRepo.Create(c.Request.Context(), input.supplier())
+	if err != nil {
+		h.logger.Error("Failed to create supplier", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create supplier"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllSuppliers godoc
+// @Summary      List suppliers
+// @Description  List suppliers by name.
+// @Tags         suppliers
+// @Produce      json
+// @Param        limit   query     int     false  "Items per page, at most 100"
+// @Param        cursor  query     string  false  "Cursor from the links of a previous page"
+// @Success      200     {object}  models.SupplierList
+// @Failure      400     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /suppliers [get]
+func (h *SuppliersHandler) GetAllSuppliers(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	suppliers, err := h.suppliersRepo.FindAll(c.Request.Context(), lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve suppliers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve suppliers"})
+		return
+	}
+
+	total, err := h.suppliersRepo.Count(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to count suppliers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve suppliers"})
+		return
+	}
+
+	items, links := pageOf(c, opts, suppliers, total, func(s *models.Supplier) string { return s.ID })
+	c.JSON(http.StatusOK, models.SupplierList{Items: items, Total: total, Links: links})
+}
+
+// GetSupplierByID godoc
+// @Summary      Get supplier by ID
+// @Tags         suppliers
+// @Produce      json
+// @Param        id   path      string  true  "Supplier ID"
+// @Success      200  {object}  models.Supplier
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /suppliers/{id} [get]
+func (h *SuppliersHandler) GetSupplierByID(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid supplier ID"})
+		return
+	}
+
+	supplier, err := h.suppliersRepo.FindByID(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve supplier", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve supplier"})
+		return
+	}
+
+	if notModified(c, supplier.Version) {
+		return
+	}
+	setETag(c, supplier.Version)
+	c.JSON(http.StatusOK, supplier)
+}
+
+// UpdateSupplier godoc
+// @Summary      Update a supplier
+// @Tags         suppliers
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string         true   "Supplier ID"
+// @Param        If-Match  header    string         false  "ETag of the version being replaced"
+// @Param        supplier  body      SupplierInput  true   "Supplier details"
+// @Success      200       {object}  models.Supplier
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /suppliers/{id} [put]
+func (h *SuppliersHandler) UpdateSupplier(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid supplier ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input SupplierInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	supplier := input.supplier()
+	if hasIfMatch {
+		supplier.Version = version
+	}
+
+	updated, err := h.suppliersRepo.Update(c.Request.Context(), id, supplier)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Supplier was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update supplier", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update supplier"})
+		return
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteSupplier godoc
+// @Summary      Delete a supplier
+// @Description  Remove a supplier no purchase order was made with.
+// @Tags         suppliers
+// @Produce      json
+// @Param        id        path      string  true   "Supplier ID"
+// @Param        If-Match  header    string  false  "ETag of the version being deleted"
+// @Success      204       {object}  nil
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /suppliers/{id} [delete]
+func (h *SuppliersHandler) DeleteSupplier(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid supplier ID"})
+		return
+	}
+
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	orders, err := h.purchaseOrdersRepo.Count(c.Request.Context(), repos.PurchaseOrderFilter{SupplierID: id})
+	if err != nil {
+		h.logger.Error("Failed to count supplier purchase orders", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete supplier"})
+		return
+	}
+	if orders > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Supplier has purchase orders"})
+		return
+	}
+
+	err = h.suppliersRepo.Delete(c.Request.Context(), id, version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Supplier was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete supplier", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete supplier"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type TaxRatesHandler struct {
+	ratesRepo repos.TaxRateRepository
+	logger    *zap.Logger
+}
+
+func NewTaxRatesHandler(rates repos.TaxRateRepository, logger *zap.Logger) *TaxRatesHandler {
+	return &TaxRatesHandler{
+		ratesRepo: rates,
+		logger:    logger,
+	}
+}
+
+// TaxRateInput is the writable part of a tax rate.
+type TaxRateInput struct {
+	Name     string  `json:"name" binding:"required"`
+	Country  string  `json:"country" binding:"required"`
+	Region   string  `json:"region"`
+	TaxClass string  `json:"tax_class"`
+	Rate     float64 `json:"rate"`
+	Version  int64   `json:"version"`
+}
+
+func (in *TaxRateInput) rate() *models.TaxRate {
+	return &models.TaxRate{
+		Name:     in.Name,
+		Country:  in.Country,
+		Region:   in.Region,
+		TaxClass: in.TaxClass,
+		Rate:     in.Rate,
+		Version:  in.Version,
+	}
+}
+
+// CreateTaxRate godoc
+// @Summary      Create a tax rate
+// @Description  Add the rate, a percentage, a tax class is taxed at in a country or in one of
+// @Description  its regions. Orders are taxed at the rate for the region of their shipping
+// @Description  address, or else for its country; classes without a rate are not taxed.
+// @Description  tax_class defaults to standard. There is one rate per country, region and class.
+// @Tags         tax-rates
+// @Accept       json
+// @Produce      json
+// @Param        rate  body      TaxRateInput  true  "Tax rate details"
+// @Success      201   {object}  models.TaxRate
+// @Failure      400   {object}  map[string]string
+// @Failure      409   {object}  map[string]string
+// @Failure      500   {object}  map[string]string
+// @Router       /tax-rates [post]
+func (h *TaxRatesHandler) CreateTaxRate(c *gin.Context) {
+	var input TaxRateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	rate := input.rate()
+	if err := rate.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.ratesRepo.Create(c.Request.Context(), rate)
+	if errors.Is(err, repos.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A rate for this region and tax class already exists"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create tax rate", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tax rate"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllTaxRates godoc
+// @Summary      List tax rates
+// @Description  List tax rates by country, region and tax class.
+// @Tags         tax-rates
+// @Produce      json
+// @Param        country  query     string  false  "Only the rates of this country"
+// @Param        limit    query     int     false  "Items per page, at most 100"
+// @Param        cursor   query     string  false  "Cursor from the links of a previous page"
+// @Success      200      {object}  models.TaxRateList
+// @Failure      400      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /tax-rates [get]
+func (h *TaxRatesHandler) GetAllTaxRates(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.TaxRateFilter{Country: c.Query("country")}
+	rates, err := h.ratesRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve tax rates", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tax rates"})
+		return
+	}
+
+	total, err := h.ratesRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count tax rates", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tax rates"})
+		return
+	}
+
+	items, links := pageOf(c, opts, rates, total, func(r *models.TaxRate) string { return r.ID })
+	c.JSON(http.StatusOK, models.TaxRateList{Items: items, Total: total, Links: links})
+}
+
+// GetTaxRateByID godoc
+// @Summary      Get tax rate by ID
+// @Tags         tax-rates
+// @Produce      json
+// @Param        id   path      string  true  "Tax rate ID"
+// @Success      200  {object}  models.TaxRate
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /tax-rates/{id} [get]
+func (h *TaxRatesHandler) GetTaxRateByID(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rate ID"})
+		return
+	}
+
+	rate, err := h.ratesRepo.FindByID(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rate not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve tax rate", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tax rate"})
+		return
+	}
+
+	if notModified(c, rate.Version) {
+		return
+	}
+	setETag(c, rate.Version)
+	c.JSON(http.StatusOK, rate)
+}
+
+// UpdateTaxRate godoc
+// @Summary      Update a tax rate
+// @Description  Replace a tax rate. Orders keep the tax they were placed with.
+// @Tags         tax-rates
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string        true   "Tax rate ID"
+// @Param        If-Match  header    string        false  "ETag of the version being replaced"
+// @Param        rate      body      TaxRateInput  true   "Tax rate details"
+// @Success      200       {object}  models.TaxRate
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /tax-rates/{id} [put]
+func (h *TaxRatesHandler) UpdateTaxRate(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rate ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input TaxRateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	rate := input.rate()
+	if hasIfMatch {
+		rate.Version = version
+	}
+	if err := rate.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.ratesRepo.Update(c.Request.Context(), id, rate)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rate not found"})
+		return
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A rate for this region and tax class already exists"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Tax rate was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update tax rate", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tax rate"})
+		return
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteTaxRate godoc
+// @Summary      Delete a tax rate
+// @Tags         tax-rates
+// @Produce      json
+// @Param        id        path      string  true   "Tax rate ID"
+// @Param        If-Match  header    string  false  "ETag of the version being deleted"
+// @Success      204       {object}  nil
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /tax-rates/{id} [delete]
+func (h *TaxRatesHandler) DeleteTaxRate(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rate ID"})
+		return
+	}
+
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	err = h.ratesRepo.Delete(c.Request.Context(), id, version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rate not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Tax rate was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete tax rate", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tax rate"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
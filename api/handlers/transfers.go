@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"github.com/udevs/lesson3/service"
+	"go.uber.org/zap"
+)
+
+type TransfersHandler struct {
+	transfers     *service.TransferService
+	transfersRepo repos.TransferRepository
+	logger        *zap.Logger
+}
+
+func NewTransfersHandler(transfers *service.TransferService, repo repos.TransferRepository, logger *zap.Logger) *TransfersHandler {
+	return &TransfersHandler{transfers: transfers, transfersRepo: repo, logger: logger}
+}
+
+// TransferInput is the writable part of a transfer.
+type TransferInput struct {
+	FromWarehouseID string                `json:"from_warehouse_id" binding:"required"`
+	ToWarehouseID   string                `json:"to_warehouse_id" binding:"required"`
+	Lines           []models.TransferLine `json:"lines" binding:"required"`
+	Note            string                `json:"note"`
+}
+
+// CreateTransfer godoc
+// @Summary      Create a transfer
+// @Description  Plan moving stock from one warehouse to another. The transfer starts pending;
+// @Description  no stock moves until it ships.
+// @Tags         transfers
+// @Accept       json
+// @Produce      json
+// @Param        transfer  body      TransferInput  true  "Transfer details"
+// @Success      201       {object}  models.Transfer
+// @Failure      400       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /transfers [post]
+func (h *TransfersHandler) CreateTransfer(c *gin.Context) {
+	var input TransferInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	created, err := h.transfers.Create(c.Request.Context(), &models.Transfer{
+		FromWarehouseID: input.FromWarehouseID,
+		ToWarehouseID:   input.ToWarehouseID,
+		Lines:           input.Lines,
+		Note:            input.Note,
+	})
+	if errors.Is(err, service.ErrInvalidTransfer) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create transfer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transfer"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllTransfers godoc
+// @Summary      List transfers
+// @Description  List transfers, newest first.
+// @Tags         transfers
+// @Produce      json
+// @Param        status        query     string  false  "pending, in_transit, received or cancelled"
+// @Param        warehouse_id  query     string  false  "Only transfers from or to this warehouse"
+// @Param        limit         query     int     false  "Items per page, at most 100"
+// @Param        cursor        query     string  false  "Cursor from the links of a previous page"
+// @Success      200           {object}  models.TransferList
+// @Failure      400           {object}  map[string]string
+// @Failure      500           {object}  map[string]string
+// @Router       /transfers [get]
+func (h *TransfersHandler) GetAllTransfers(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Sort = nil
+
+	filter := repos.TransferFilter{WarehouseID: c.Query("warehouse_id")}
+	if status := c.Query("status"); status != "" {
+		filter.Statuses = []string{status}
+	}
+	transfers, err := h.transfersRepo.FindAll(c.Request.Context(), filter, lookAhead(opts))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve transfers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transfers"})
+		return
+	}
+
+	total, err := h.transfersRepo.Count(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to count transfers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transfers"})
+		return
+	}
+
+	items, links := pageOf(c, opts, transfers, total, func(t *models.Transfer) string { return t.ID })
+	c.JSON(http.StatusOK, models.TransferList{Items: items, Total: total, Links: links})
+}
+
+// GetTransferByID godoc
+// @Summary      Get transfer by ID
+// @Tags         transfers
+// @Produce      json
+// @Param        id   path      string  true  "Transfer ID"
+// @Success      200  {object}  models.Transfer
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /transfers/{id} [get]
+func (h *TransfersHandler) GetTransferByID(c *gin.Context) {
+	transfer, err := h.transfersRepo.FindByID(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve transfer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transfer"})
+		return
+	}
+
+	if notModified(c, transfer.Version) {
+		return
+	}
+	setETag(c, transfer.Version)
+	c.JSON(http.StatusOK, transfer)
+}
+
+// ShipTransfer godoc
+// @Summary      Ship a transfer
+// @Description  Take the stock of a pending transfer out of the source warehouse. If a line
+// @Description  lacks the stock, nothing is taken and the transfer stays pending.
+// @Tags         transfers
+// @Produce      json
+// @Param        id   path      string  true  "Transfer ID"
+// @Success      200  {object}  models.Transfer
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /transfers/{id}/ship [post]
+func (h *TransfersHandler) ShipTransfer(c *gin.Context) {
+	transfer, err := h.transfers.Ship(c.Request.Context(), c.Param("id"))
+	h.respond(c, transfer, err, models.TransferPending, "ship")
+}
+
+// ReceiveTransfer godoc
+// @Summary      Receive a transfer
+// @Description  Put the stock of a transfer in transit into the destination warehouse.
+// @Tags         transfers
+// @Produce      json
+// @Param        id   path      string  true  "Transfer ID"
+// @Success      200  {object}  models.Transfer
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /transfers/{id}/receive [post]
+func (h *TransfersHandler) ReceiveTransfer(c *gin.Context) {
+	transfer, err := h.transfers.Receive(c.Request.Context(), c.Param("id"))
+	h.respond(c, transfer, err, models.TransferInTransit, "receive")
+}
+
+// CancelTransfer godoc
+// @Summary      Cancel a transfer
+// @Description  Drop a transfer that has not shipped.
+// @Tags         transfers
+// @Produce      json
+// @Param        id   path      string  true  "Transfer ID"
+// @Success      200  {object}  models.Transfer
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /transfers/{id}/cancel [post]
+func (h *TransfersHandler) CancelTransfer(c *gin.Context) {
+	transfer, err := h.transfers.Cancel(c.Request.Context(), c.Param("id"))
+	h.respond(c, transfer, err, models.TransferPending, "cancel")
+}
+
+// respond writes the result of moving a transfer on from status by action.
+func (h *TransfersHandler) respond(c *gin.Context, transfer *models.Transfer, err error, status, action string) {
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "Transfer is not " + status})
+		return
+	case errors.Is(err, service.ErrInvalidTransfer):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, repos.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to "+action+" transfer", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " transfer"})
+		return
+	}
+
+	setETag(c, transfer.Version)
+	c.JSON(http.StatusOK, transfer)
+}
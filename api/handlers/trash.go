@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type TrashHandler struct {
+	productsRepo repos.ProductRepository
+	ordersRepo   repos.OrderRepository
+	logger       *zap.Logger
+}
+
+func NewTrashHandler(products repos.ProductRepository, orders repos.OrderRepository, logger *zap.Logger) *TrashHandler {
+	return &TrashHandler{
+		productsRepo: products,
+		ordersRepo:   orders,
+		logger:       logger,
+	}
+}
+
+// GetTrash godoc
+// @Summary      List the trash
+// @Description  List deleted products, or deleted orders with type=orders, most recently
+// @Description  deleted first. Items stay in the trash until they are restored or purged.
+// @Tags         trash
+// @Produce      json
+// @Param        type    query     string  false  "products (default) or orders"
+// @Param        limit   query     int     false  "Items per page, at most 100"
+// @Param        cursor  query     string  false  "Cursor from the links of a previous page"
+// @Param        page    query     int     false  "Page number (legacy offset pagination)"
+// @Success      200     {object}  models.ProductList
+// @Failure      400     {object}  map[string]string
+// @Failure      500     {object}  map[string]string
+// @Router       /trash [get]
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	opts, _, err := parseListOptions(c)
+	if err != nil {
+		h.logger.Error("Invalid listing parameters", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// The trash is always ordered by deletion time.
+	opts.Sort = nil
+
+	switch c.DefaultQuery("type", "products") {
+	case "products":
+		products, err := h.productsRepo.FindDeleted(c.Request.Context(), lookAhead(opts))
+		if err != nil {
+			h.listError(c, err)
+			return
+		}
+		total, err := h.productsRepo.CountDeleted(c.Request.Context())
+		if err != nil {
+			h.listError(c, err)
+			return
+		}
+		items, links := pageOf(c, opts, products, total, func(p *models.Product) string { return p.ID })
+		c.JSON(http.StatusOK, models.ProductList{Items: items, Total: total, Links: links})
+	case "orders":
+		orders, err := h.ordersRepo.FindDeleted(c.Request.Context(), lookAhead(opts))
+		if err != nil {
+			h.listError(c, err)
+			return
+		}
+		total, err := h.ordersRepo.CountDeleted(c.Request.Context())
+		if err != nil {
+			h.listError(c, err)
+			return
+		}
+		items, links := pageOf(c, opts, orders, total, func(o *models.Order) string { return o.ID })
+		c.JSON(http.StatusOK, models.OrderList{Items: items, Total: total, Links: links})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be products or orders"})
+	}
+}
+
+func (h *TrashHandler) listError(c *gin.Context, err error) {
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+		return
+	}
+	h.logger.Error("Failed to retrieve trash", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve trash"})
+}
+
+// RestoreProduct godoc
+// @Summary      Restore a deleted product
+// @Description  Take a product out of the trash.
+// @Tags         trash
+// @Produce      json
+// @Param        id   path      string  true  "Product ID"
+// @Success      200  {object}  models.Product
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /products/{id}/restore [post]
+func (h *TrashHandler) RestoreProduct(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := h.productsRepo.Restore(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found in the trash"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to restore product", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore product"})
+		return
+	}
+
+	setETag(c, product.Version)
+	c.JSON(http.StatusOK, product)
+}
+
+// RestoreOrder godoc
+// @Summary      Restore a deleted order
+// @Description  Take an order out of the trash.
+// @Tags         trash
+// @Produce      json
+// @Param        id   path      string  true  "Order ID"
+// @Success      200  {object}  models.Order
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /orders/{id}/restore [post]
+func (h *TrashHandler) RestoreOrder(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid order ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	order, err := h.ordersRepo.Restore(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found in the trash"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to restore order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore order"})
+		return
+	}
+
+	setETag(c, order.Version)
+	c.JSON(http.StatusOK, order)
+}
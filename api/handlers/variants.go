@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// ProductOptionsInput replaces the option matrix of a product.
+type ProductOptionsInput struct {
+	Options []models.ProductOption `json:"options"`
+	// Generate adds a variant for every new combination of values.
+	Generate bool `json:"generate"`
+}
+
+// ProductVariants is the option matrix of a product and its variants.
+type ProductVariants struct {
+	Options  []models.ProductOption `json:"options"`
+	Variants []models.Variant       `json:"variants"`
+}
+
+func variantsOf(p *models.Product) ProductVariants {
+	v := ProductVariants{Options: p.Options, Variants: p.Variants}
+	if v.Options == nil {
+		v.Options = []models.ProductOption{}
+	}
+	if v.Variants == nil {
+		v.Variants = []models.Variant{}
+	}
+	return v
+}
+
+// loadForWrite reads the product named by the id path parameter for a
+// read-modify-write, writing the error response and returning nil when it
+// is missing, the write names no version or If-Match names another one.
+// bodyVersion is the version in the body of a full update, zero otherwise.
+func (h *ProductsHandler) loadForWrite(c *gin.Context, bodyVersion int64) *models.Product {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid product ID", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return nil
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		h.logger.Error("Invalid If-Match header", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return nil
+	}
+	if missingPrecondition(c, bodyVersion) {
+		return nil
+	}
+
+	product, err := h.productsRepo.FindByID(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return nil
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve product", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return nil
+	}
+
+	// The write is conditioned on the version read here, so a concurrent
+	// change between FindByID and Update still surfaces as 412.
+	if hasIfMatch && version != 0 && version != product.Version {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Product was modified by another request"})
+		return nil
+	}
+	return product
+}
+
+// GetProductVariants godoc
+// @Summary      List product variants
+// @Description  Return the option matrix of a product and its variants.
+// @Tags         variants
+// @Produce      json
+// @Param        id   path      string  true  "Product ID"
+// @Success      200  {object}  ProductVariants
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /products/{id}/variants [get]
+func (h *ProductsHandler) GetProductVariants(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := h.productsRepo.FindByID(c.Request.Context(), objID.Hex())
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve product", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product"})
+		return
+	}
+
+	setETag(c, product.Version)
+	c.JSON(http.StatusOK, variantsOf(product))
+}
+
+// SetProductOptions godoc
+// @Summary      Replace the option matrix of a product
+// @Description  Set the options (e.g. size, color) a product is sold in. Variants whose
+// @Description  values are no longer offered are removed; with generate, a variant is
+// @Description  added for every new combination.
+// @Tags         variants
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string               true   "Product ID"
+// @Param        If-Match  header    string               true   "ETag of the version being modified"
+// @Param        options   body      ProductOptionsInput  true   "Option matrix"
+// @Success      200       {object}  models.Product
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      428       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /products/{id}/options [put]
+func (h *ProductsHandler) SetProductOptions(c *gin.Context) {
+	var input ProductOptionsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	product.Options = input.Options
+	product.PruneVariants()
+	if input.Generate {
+		if _, err := product.GenerateVariants(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	h.saveProduct(c, product.ID, product)
+}
+
+// GenerateProductVariants godoc
+// @Summary      Generate product variants
+// @Description  Add a variant for every combination of option values that has none yet.
+// @Description  SKUs are derived from the product SKU and the values, e.g. TS-RED-M.
+// @Tags         variants
+// @Produce      json
+// @Param        id        path      string  true   "Product ID"
+// @Param        If-Match  header    string  true   "ETag of the version being modified"
+// @Success      200       {object}  models.Product
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      428       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /products/{id}/variants/generate [post]
+func (h *ProductsHandler) GenerateProductVariants(c *gin.Context) {
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	if _, err := product.GenerateVariants(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.saveProduct(c, product.ID, product)
+}
+
+// PatchProductVariant godoc
+// @Summary      Update a product variant
+// @Description  Change the SKU or price override of one variant. Stock is changed through
+// @Description  /products/{id}/stock/adjust.
+// @Tags         variants
+// @Accept       json
+// @Produce      json
+// @Param        id          path      string               true   "Product ID"
+// @Param        variant_id  path      string               true   "Variant ID"
+// @Param        If-Match    header    string               true   "ETag of the version being modified"
+// @Param        variant     body      models.VariantPatch  true   "Fields to change"
+// @Success      200         {object}  models.Product
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      412         {object}  map[string]string
+// @Failure      428         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /products/{id}/variants/{variant_id} [patch]
+func (h *ProductsHandler) PatchProductVariant(c *gin.Context) {
+	var patch models.VariantPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	variant := product.Variant(c.Param("variant_id"))
+	if variant == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+	patch.Apply(variant)
+	h.saveProduct(c, product.ID, product)
+}
+
+// DeleteProductVariant godoc
+// @Summary      Delete a product variant
+// @Tags         variants
+// @Produce      json
+// @Param        id          path      string  true   "Product ID"
+// @Param        variant_id  path      string  true   "Variant ID"
+// @Param        If-Match    header    string  true   "ETag of the version being modified"
+// @Success      200         {object}  models.Product
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Failure      412         {object}  map[string]string
+// @Failure      428         {object}  map[string]string
+// @Failure      500         {object}  map[string]string
+// @Router       /products/{id}/variants/{variant_id} [delete]
+func (h *ProductsHandler) DeleteProductVariant(c *gin.Context) {
+	product := h.loadForWrite(c, 0)
+	if product == nil {
+		return
+	}
+
+	variantID := c.Param("variant_id")
+	for i, v := range product.Variants {
+		if v.ID == variantID {
+			product.Variants = append(product.Variants[:i], product.Variants[i+1:]...)
+			h.saveProduct(c, product.ID, product)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type WarehousesHandler struct {
+	warehousesRepo repos.WarehouseRepository
+	productsRepo   repos.ProductRepository
+	transfersRepo  repos.TransferRepository
+	logger         *zap.Logger
+}
+
+func NewWarehousesHandler(warehouses repos.WarehouseRepository, products repos.ProductRepository, transfers repos.TransferRepository, logger *zap.Logger) *WarehousesHandler {
+	return &WarehousesHandler{
+		warehousesRepo: warehouses,
+		productsRepo:   products,
+		transfersRepo:  transfers,
+		logger:         logger,
+	}
+}
+
+// WarehouseInput is the writable part of a warehouse.
+type WarehouseInput struct {
+	Code     string           `json:"code" binding:"required"`
+	Name     string           `json:"name" binding:"required"`
+	Address  string           `json:"address"`
+	Location *models.GeoPoint `json:"location"`
+	Priority int              `json:"priority"`
+	Version  int64            `json:"version"`
+}
+
+func (in *WarehouseInput) warehouse() *models.Warehouse {
+	return &models.Warehouse{
+		Code:     in.Code,
+		Name:     in.Name,
+		Address:  in.Address,
+		Location: in.Location,
+		Priority: in.Priority,
+		Version:  in.Version,
+	}
+}
+
+// CreateWarehouse godoc
+// @Summary      Create a warehouse
+// @Description  Add a location to keep stock at. Priority orders warehouses for the priority
+// @Description  fulfillment strategy, lowest first; the first one is the default warehouse.
+// @Tags         warehouses
+// @Accept       json
+// @Produce      json
+// @Param        warehouse  body      WarehouseInput  true  "Warehouse details"
+// @Success      201        {object}  models.Warehouse
+// @Failure      400        {object}  map[string]string
+// @Failure      409        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /warehouses [post]
+func (h *WarehousesHandler) CreateWarehouse(c *gin.Context) {
+	var input WarehouseInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	created, err := h.warehousesRepo.Create(c.Request.Context(), input.warehouse())
+	switch {
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A warehouse with this code already exists"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to create warehouse", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create warehouse"})
+		return
+	}
+
+	setETag(c, created.Version)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetAllWarehouses godoc
+// @Summary      List warehouses
+// @Description  List every warehouse by priority, then code.
+// @Tags         warehouses
+// @Produce      json
+// @Success      200  {array}   models.Warehouse
+// @Failure      500  {object}  map[string]string
+// @Router       /warehouses [get]
+func (h *WarehousesHandler) GetAllWarehouses(c *gin.Context) {
+	warehouses, err := h.warehousesRepo.FindAll(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to retrieve warehouses", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve warehouses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, warehouses)
+}
+
+// GetWarehouseByID godoc
+// @Summary      Get warehouse by ID
+// @Tags         warehouses
+// @Produce      json
+// @Param        id   path      string  true  "Warehouse ID"
+// @Success      200  {object}  models.Warehouse
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /warehouses/{id} [get]
+func (h *WarehousesHandler) GetWarehouseByID(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse ID"})
+		return
+	}
+
+	warehouse, err := h.warehousesRepo.FindByID(c.Request.Context(), id)
+	if errors.Is(err, repos.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to retrieve warehouse", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve warehouse"})
+		return
+	}
+
+	if notModified(c, warehouse.Version) {
+		return
+	}
+	setETag(c, warehouse.Version)
+	c.JSON(http.StatusOK, warehouse)
+}
+
+// UpdateWarehouse godoc
+// @Summary      Update a warehouse
+// @Tags         warehouses
+// @Accept       json
+// @Produce      json
+// @Param        id         path      string          true   "Warehouse ID"
+// @Param        If-Match   header    string          false  "ETag of the version being replaced"
+// @Param        warehouse  body      WarehouseInput  true   "Warehouse details"
+// @Success      200        {object}  models.Warehouse
+// @Failure      400        {object}  map[string]string
+// @Failure      404        {object}  map[string]string
+// @Failure      409        {object}  map[string]string
+// @Failure      412        {object}  map[string]string
+// @Failure      500        {object}  map[string]string
+// @Router       /warehouses/{id} [put]
+func (h *WarehousesHandler) UpdateWarehouse(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse ID"})
+		return
+	}
+
+	version, hasIfMatch, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	var input WarehouseInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.logger.Error("Invalid input", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	warehouse := input.warehouse()
+	if hasIfMatch {
+		warehouse.Version = version
+	}
+
+	updated, err := h.warehousesRepo.Update(c.Request.Context(), id, warehouse)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	case errors.Is(err, repos.ErrDuplicate):
+		c.JSON(http.StatusConflict, gin.H{"error": "A warehouse with this code already exists"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Warehouse was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to update warehouse", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update warehouse"})
+		return
+	}
+
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteWarehouse godoc
+// @Summary      Delete a warehouse
+// @Description  Remove a warehouse that holds no stock and has no pending or in-transit transfers.
+// @Tags         warehouses
+// @Produce      json
+// @Param        id        path      string  true   "Warehouse ID"
+// @Param        If-Match  header    string  false  "ETag of the version being deleted"
+// @Success      204       {object}  nil
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Failure      412       {object}  map[string]string
+// @Failure      500       {object}  map[string]string
+// @Router       /warehouses/{id} [delete]
+func (h *WarehousesHandler) DeleteWarehouse(c *gin.Context) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse ID"})
+		return
+	}
+
+	version, _, err := ifMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	products, err := h.productsRepo.Count(c.Request.Context(), repos.ProductFilter{WarehouseID: id})
+	if err != nil {
+		h.logger.Error("Failed to count warehouse products", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete warehouse"})
+		return
+	}
+	if products > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Warehouse still holds stock"})
+		return
+	}
+	transfers, err := h.transfersRepo.Count(c.Request.Context(), repos.TransferFilter{
+		WarehouseID: id,
+		Statuses:    []string{models.TransferPending, models.TransferInTransit},
+	})
+	if err != nil {
+		h.logger.Error("Failed to count warehouse transfers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete warehouse"})
+		return
+	}
+	if transfers > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Warehouse has open transfers"})
+		return
+	}
+
+	err = h.warehousesRepo.Delete(c.Request.Context(), id, version)
+	switch {
+	case errors.Is(err, repos.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	case errors.Is(err, repos.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Warehouse was modified by another request"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete warehouse", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete warehouse"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
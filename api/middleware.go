@@ -0,0 +1,38 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxRequestIDLength bounds client-supplied request IDs.
+const maxRequestIDLength = 128
+
+// withActor records who makes the request, as named by the X-Actor header,
+// in the request context.
+func withActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if actor := strings.TrimSpace(c.GetHeader(reqctx.ActorHeader)); actor != "" {
+			c.Request = c.Request.WithContext(reqctx.WithActor(c.Request.Context(), actor))
+		}
+		c.Next()
+	}
+}
+
+// withRequestID tags the request with the X-Request-ID the client sent, or
+// a new one, and echoes it in the response so log lines and audit entries
+// can be matched to the request.
+func withRequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(c.GetHeader(reqctx.RequestIDHeader))
+		if id == "" || len(id) > maxRequestIDLength {
+			id = primitive.NewObjectID().Hex()
+		}
+		c.Request = c.Request.WithContext(reqctx.WithRequestID(c.Request.Context(), id))
+		c.Header(reqctx.RequestIDHeader, id)
+		c.Next()
+	}
+}
@@ -22,18 +22,52 @@ import (
 )
 
 type HttpService struct {
-	ordersHandler  *handlers.OrdersHandler
-	productHandler *handlers.ProductsHandler
-	logger         *zap.Logger
-	cfg            *config.Config
+	ordersHandler     *handlers.OrdersHandler
+	productHandler    *handlers.ProductsHandler
+	categoriesHandler *handlers.CategoriesHandler
+	filesHandler      *handlers.FilesHandler
+	trashHandler      *handlers.TrashHandler
+	auditHandler      *handlers.AuditHandler
+	pricesHandler     *handlers.PricesHandler
+	stockHandler      *handlers.StockHandler
+	warehousesHandler *handlers.WarehousesHandler
+	transfersHandler  *handlers.TransfersHandler
+	alertsHandler     *handlers.AlertsHandler
+	suppliersHandler  *handlers.SuppliersHandler
+	purchasingHandler *handlers.PurchaseOrdersHandler
+	customersHandler  *handlers.CustomersHandler
+	cartsHandler      *handlers.CartsHandler
+	promotionsHandler *handlers.PromotionsHandler
+	taxRatesHandler   *handlers.TaxRatesHandler
+	paymentsHandler   *handlers.PaymentsHandler
+	returnsHandler    *handlers.ReturnsHandler
+	logger            *zap.Logger
+	cfg               *config.Config
 }
 
-func NewHttpService(o *handlers.OrdersHandler, p *handlers.ProductsHandler, l *zap.Logger, c *config.Config) *HttpService {
+func NewHttpService(o *handlers.OrdersHandler, p *handlers.ProductsHandler, cat *handlers.CategoriesHandler, f *handlers.FilesHandler, t *handlers.TrashHandler, a *handlers.AuditHandler, pr *handlers.PricesHandler, st *handlers.StockHandler, w *handlers.WarehousesHandler, tr *handlers.TransfersHandler, al *handlers.AlertsHandler, su *handlers.SuppliersHandler, po *handlers.PurchaseOrdersHandler, cu *handlers.CustomersHandler, ca *handlers.CartsHandler, pm *handlers.PromotionsHandler, tx *handlers.TaxRatesHandler, pay *handlers.PaymentsHandler, rt *handlers.ReturnsHandler, l *zap.Logger, c *config.Config) *HttpService {
 	return &HttpService{
-		ordersHandler:  o,
-		productHandler: p,
-		logger:         l,
-		cfg:            c,
+		ordersHandler:     o,
+		productHandler:    p,
+		categoriesHandler: cat,
+		filesHandler:      f,
+		trashHandler:      t,
+		auditHandler:      a,
+		pricesHandler:     pr,
+		stockHandler:      st,
+		warehousesHandler: w,
+		transfersHandler:  tr,
+		alertsHandler:     al,
+		suppliersHandler:  su,
+		purchasingHandler: po,
+		customersHandler:  cu,
+		cartsHandler:      ca,
+		promotionsHandler: pm,
+		taxRatesHandler:   tx,
+		paymentsHandler:   pay,
+		returnsHandler:    rt,
+		logger:            l,
+		cfg:               c,
 	}
 }
 
@@ -46,27 +80,170 @@ func NewHttpService(o *handlers.OrdersHandler, p *handlers.ProductsHandler, l *z
 func (h *HttpService) Run() error {
 	router := gin.Default()
 
+	router.Use(withRequestID(), withActor())
 	router.GET("swagger/*any", ginSwagger.WrapHandler(files.Handler))
 
 	product := router.Group("/products")
 	{
 		product.POST("", h.productHandler.CreateProduct)
 		product.GET("", h.productHandler.GetAllProducts)
+		product.GET("search", h.productHandler.SearchProducts)
+		product.GET("suggest", h.productHandler.SuggestProducts)
 		product.GET(":id", h.productHandler.GetProductByID)
 		product.PUT(":id", h.productHandler.UpdateProduct)
+		product.PATCH(":id", h.productHandler.PatchProduct)
 		product.DELETE(":id", h.productHandler.DeleteProduct)
+		product.POST(":id/restore", h.trashHandler.RestoreProduct)
+		product.GET(":id/prices", h.pricesHandler.GetPriceHistory)
+		product.GET(":id/prices/at", h.pricesHandler.GetPriceAt)
+		product.POST(":id/stock/adjust", h.stockHandler.AdjustStock)
+		product.GET(":id/stock/movements", h.stockHandler.GetStockMovements)
+		product.GET(":id/availability", h.stockHandler.GetAvailability)
+		product.PUT(":id/options", h.productHandler.SetProductOptions)
+		product.GET(":id/variants", h.productHandler.GetProductVariants)
+		product.POST(":id/variants/generate", h.productHandler.GenerateProductVariants)
+		product.PATCH(":id/variants/:variant_id", h.productHandler.PatchProductVariant)
+		product.DELETE(":id/variants/:variant_id", h.productHandler.DeleteProductVariant)
+		product.POST(":id/images", h.productHandler.UploadProductImage)
+		product.PUT(":id/images/order", h.productHandler.ReorderProductImages)
+		product.DELETE(":id/images/:image_id", h.productHandler.DeleteProductImage)
 	}
 
+	categories := router.Group("/categories")
+	{
+		categories.POST("", h.categoriesHandler.CreateCategory)
+		categories.GET("", h.categoriesHandler.GetAllCategories)
+		categories.GET(":id", h.categoriesHandler.GetCategoryByID)
+		categories.PUT(":id", h.categoriesHandler.UpdateCategory)
+		categories.DELETE(":id", h.categoriesHandler.DeleteCategory)
+		categories.GET(":id/products", h.categoriesHandler.GetCategoryProducts)
+		categories.GET(":id/schema", h.categoriesHandler.GetCategorySchema)
+	}
+
+	warehouses := router.Group("/warehouses")
+	{
+		warehouses.POST("", h.warehousesHandler.CreateWarehouse)
+		warehouses.GET("", h.warehousesHandler.GetAllWarehouses)
+		warehouses.GET(":id", h.warehousesHandler.GetWarehouseByID)
+		warehouses.PUT(":id", h.warehousesHandler.UpdateWarehouse)
+		warehouses.DELETE(":id", h.warehousesHandler.DeleteWarehouse)
+	}
+
+	transfers := router.Group("/transfers")
+	{
+		transfers.POST("", h.transfersHandler.CreateTransfer)
+		transfers.GET("", h.transfersHandler.GetAllTransfers)
+		transfers.GET(":id", h.transfersHandler.GetTransferByID)
+		transfers.POST(":id/ship", h.transfersHandler.ShipTransfer)
+		transfers.POST(":id/receive", h.transfersHandler.ReceiveTransfer)
+		transfers.POST(":id/cancel", h.transfersHandler.CancelTransfer)
+	}
+
+	alerts := router.Group("/alerts")
+	{
+		alerts.GET("", h.alertsHandler.GetAllAlerts)
+		alerts.GET(":id", h.alertsHandler.GetAlertByID)
+		alerts.POST(":id/acknowledge", h.alertsHandler.AcknowledgeAlert)
+		alerts.POST(":id/resolve", h.alertsHandler.ResolveAlert)
+	}
+
+	suppliers := router.Group("/suppliers")
+	{
+		suppliers.POST("", h.suppliersHandler.CreateSupplier)
+		suppliers.GET("", h.suppliersHandler.GetAllSuppliers)
+		suppliers.GET(":id", h.suppliersHandler.GetSupplierByID)
+		suppliers.PUT(":id", h.suppliersHandler.UpdateSupplier)
+		suppliers.DELETE(":id", h.suppliersHandler.DeleteSupplier)
+	}
+
+	purchaseOrders := router.Group("/purchase-orders")
+	{
+		purchaseOrders.POST("", h.purchasingHandler.CreatePurchaseOrder)
+		purchaseOrders.GET("", h.purchasingHandler.GetAllPurchaseOrders)
+		purchaseOrders.GET(":id", h.purchasingHandler.GetPurchaseOrderByID)
+		purchaseOrders.PUT(":id", h.purchasingHandler.UpdatePurchaseOrder)
+		purchaseOrders.POST(":id/send", h.purchasingHandler.SendPurchaseOrder)
+		purchaseOrders.POST(":id/receive", h.purchasingHandler.ReceivePurchaseOrder)
+		purchaseOrders.POST(":id/cancel", h.purchasingHandler.CancelPurchaseOrder)
+	}
+
+	customers := router.Group("/customers")
+	{
+		customers.POST("", h.customersHandler.CreateCustomer)
+		customers.GET("", h.customersHandler.GetAllCustomers)
+		customers.GET(":id", h.customersHandler.GetCustomerByID)
+		customers.PUT(":id", h.customersHandler.UpdateCustomer)
+		customers.DELETE(":id", h.customersHandler.DeleteCustomer)
+		customers.GET(":id/orders", h.customersHandler.GetCustomerOrders)
+	}
+
+	carts := router.Group("/carts")
+	{
+		carts.POST("", h.cartsHandler.CreateCart)
+		carts.GET(":id", h.cartsHandler.GetCartByID)
+		carts.DELETE(":id", h.cartsHandler.DeleteCart)
+		carts.POST(":id/lines", h.cartsHandler.AddCartLine)
+		carts.PUT(":id/lines/:lineId", h.cartsHandler.UpdateCartLine)
+		carts.DELETE(":id/lines/:lineId", h.cartsHandler.RemoveCartLine)
+		carts.POST(":id/coupons", h.cartsHandler.AddCartCoupon)
+		carts.DELETE(":id/coupons/:code", h.cartsHandler.RemoveCartCoupon)
+		carts.POST(":id/checkout", h.cartsHandler.CheckoutCart)
+	}
+
+	promotions := router.Group("/promotions")
+	{
+		promotions.POST("", h.promotionsHandler.CreatePromotion)
+		promotions.GET("", h.promotionsHandler.GetAllPromotions)
+		promotions.GET(":id", h.promotionsHandler.GetPromotionByID)
+		promotions.PUT(":id", h.promotionsHandler.UpdatePromotion)
+		promotions.DELETE(":id", h.promotionsHandler.DeletePromotion)
+	}
+
+	taxRates := router.Group("/tax-rates")
+	{
+		taxRates.POST("", h.taxRatesHandler.CreateTaxRate)
+		taxRates.GET("", h.taxRatesHandler.GetAllTaxRates)
+		taxRates.GET(":id", h.taxRatesHandler.GetTaxRateByID)
+		taxRates.PUT(":id", h.taxRatesHandler.UpdateTaxRate)
+		taxRates.DELETE(":id", h.taxRatesHandler.DeleteTaxRate)
+	}
+
+	router.GET("files/*key", h.filesHandler.GetFile)
+	router.GET("trash", h.trashHandler.GetTrash)
+	router.GET("audit", h.auditHandler.GetAuditLog)
+	router.GET("stock/reconciliation", h.stockHandler.GetReconciliation)
+
 	orders := router.Group("/orders")
 	{
 		orders.POST("", h.ordersHandler.CreateOrder)
 		orders.GET("", h.ordersHandler.GetAllOrders)
 		orders.GET(":id", h.ordersHandler.GetOrderByID)
 		orders.PUT(":id", h.ordersHandler.UpdateOrder)
+		orders.PATCH(":id", h.ordersHandler.PatchOrder)
 		orders.DELETE(":id", h.ordersHandler.DeleteOrder)
+		orders.POST(":id/restore", h.trashHandler.RestoreOrder)
+		orders.GET(":id/recompute", h.ordersHandler.RecomputeOrder)
+		orders.POST(":id/payments", h.paymentsHandler.CreatePayment)
+		orders.GET(":id/payments", h.paymentsHandler.GetOrderPayments)
+		orders.POST(":id/payments/:payment_id/capture", h.paymentsHandler.CapturePayment)
+		orders.POST(":id/payments/:payment_id/refund", h.paymentsHandler.RefundPayment)
+		orders.POST(":id/payments/:payment_id/void", h.paymentsHandler.VoidPayment)
 		orders.GET("/report", h.ordersHandler.GenerateReport)
 	}
 
+	returns := router.Group("/returns")
+	{
+		returns.POST("", h.returnsHandler.CreateReturn)
+		returns.GET("", h.returnsHandler.GetAllReturns)
+		returns.GET(":id", h.returnsHandler.GetReturnByID)
+		returns.POST(":id/approve", h.returnsHandler.ApproveReturn)
+		returns.POST(":id/reject", h.returnsHandler.RejectReturn)
+		returns.POST(":id/receive", h.returnsHandler.ReceiveReturn)
+		returns.POST(":id/refund", h.returnsHandler.RefundReturn)
+	}
+
+	router.POST("webhooks/payments/:provider", h.paymentsHandler.HandleWebhook)
+
 	h.logger.Info("Starting server", zap.String("address", h.cfg.Server.Host))
 	err := http.ListenAndServe(h.cfg.Server.Host+":"+h.cfg.Server.Port, router)
 	if err != nil {
@@ -0,0 +1,64 @@
+// Command consistency scans the products and orders for references to
+// products, variants and categories that do not exist, and the stock
+// ledger for stock taken by orders that were never stored, and prints
+// them. It exits with status 1 when it finds any.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/udevs/lesson3/config"
+	"github.com/udevs/lesson3/mongo"
+	"github.com/udevs/lesson3/pkg/logger"
+	"github.com/udevs/lesson3/storage"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger.Initialize()
+	log := logger.GetLogger()
+
+	cfg, err := config.New()
+	if err != nil {
+		log.Fatal("failed to get config", zap.Error(err))
+	}
+	mongoDB, err := mongo.Connect(&cfg.MongoDB)
+	if err != nil {
+		log.Fatal("Failed to connect to database", zap.Error(err))
+	}
+	testDB := mongoDB.Database("test_db")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	dangling, err := storage.FindDanglingReferences(ctx,
+		storage.NewProductStorage(testDB.Collection("products"), nil, nil, nil),
+		storage.NewOrdersStorage(testDB.Collection("orders")),
+		storage.NewCategoryStorage(testDB.Collection("categories")),
+	)
+	if err != nil {
+		log.Fatal("Failed to check consistency", zap.Error(err))
+	}
+
+	leaked, err := storage.FindLeakedStock(ctx,
+		storage.NewStockLedgerStorage(testDB.Collection("stock_movements"), testDB.Collection("products")),
+		storage.NewOrdersStorage(testDB.Collection("orders")),
+		storage.NewAuditStorage(testDB.Collection("audit")),
+	)
+	if err != nil {
+		log.Fatal("Failed to check stock", zap.Error(err))
+	}
+
+	for _, ref := range dangling {
+		fmt.Println(ref)
+	}
+	for _, l := range leaked {
+		fmt.Println(l)
+	}
+	fmt.Printf("%d dangling references, %d leaked stock\n", len(dangling), len(leaked))
+	if len(dangling) > 0 || len(leaked) > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
 	app "github.com/udevs/lesson3/api"
 	"github.com/udevs/lesson3/api/handlers"
 	"github.com/udevs/lesson3/config"
 	"github.com/udevs/lesson3/mongo"
+	"github.com/udevs/lesson3/pkg/blob"
+	"github.com/udevs/lesson3/pkg/imaging"
 	"github.com/udevs/lesson3/pkg/logger"
+	"github.com/udevs/lesson3/pkg/notify"
+	"github.com/udevs/lesson3/pkg/suggest"
+	"github.com/udevs/lesson3/service"
 	"github.com/udevs/lesson3/storage"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 )
 
@@ -26,14 +37,204 @@ func main() {
 
 	productsCollection := testDB.Collection("products")
 	ordersCollection := testDB.Collection("orders")
+	categoriesCollection := testDB.Collection("categories")
+	auditCollection := testDB.Collection("audit")
+	pricesCollection := testDB.Collection("price_history")
+	stockCollection := testDB.Collection("stock_movements")
+	warehousesCollection := testDB.Collection("warehouses")
+	transfersCollection := testDB.Collection("transfers")
+	alertsCollection := testDB.Collection("stock_alerts")
+	suppliersCollection := testDB.Collection("suppliers")
+	purchaseOrdersCollection := testDB.Collection("purchase_orders")
+	customersCollection := testDB.Collection("customers")
+	cartsCollection := testDB.Collection("carts")
+	promotionsCollection := testDB.Collection("promotions")
+	redemptionsCollection := testDB.Collection("promotion_redemptions")
+	taxRatesCollection := testDB.Collection("tax_rates")
+	paymentsCollection := testDB.Collection("payments")
+	returnsCollection := testDB.Collection("returns")
 
-	productStorage := storage.NewProductStorage(productsCollection)
+	priceStorage := storage.NewPriceHistoryStorage(pricesCollection)
+	stockStorage := storage.NewStockLedgerStorage(stockCollection, productsCollection)
+	warehouseStorage := storage.NewWarehouseStorage(warehousesCollection)
+	transferStorage := storage.NewTransferStorage(transfersCollection)
+	productStorage := storage.NewProductStorage(productsCollection, priceStorage, stockStorage, warehouseStorage)
 	orderStorage := storage.NewOrdersStorage(ordersCollection)
+	categoryStorage := storage.NewCategoryStorage(categoriesCollection)
+	auditStorage := storage.NewAuditStorage(auditCollection)
+	alertStorage := storage.NewStockAlertStorage(alertsCollection)
+	supplierStorage := storage.NewSupplierStorage(suppliersCollection)
+	purchaseOrderStorage := storage.NewPurchaseOrderStorage(purchaseOrdersCollection)
+	customerStorage := storage.NewCustomerStorage(customersCollection)
+	cartStorage := storage.NewCartStorage(cartsCollection)
+	promotionStorage := storage.NewPromotionStorage(promotionsCollection, redemptionsCollection)
+	taxRateStorage := storage.NewTaxRateStorage(taxRatesCollection)
+	paymentStorage := storage.NewPaymentStorage(paymentsCollection)
+	returnStorage := storage.NewReturnStorage(returnsCollection)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	if err := storage.EnsureIndexes(ctx, productStorage, orderStorage, categoryStorage, auditStorage, priceStorage, stockStorage, warehouseStorage, transferStorage, alertStorage, supplierStorage, purchaseOrderStorage, customerStorage, cartStorage, promotionStorage, taxRateStorage, paymentStorage, returnStorage); err != nil {
+		log.Fatal("Failed to create indexes", zap.Error(err))
+	}
+	if err := storage.Migrate(ctx, testDB, storage.Migrations); err != nil {
+		log.Fatal("Failed to migrate database", zap.Error(err))
+	}
+	transactor, err := storage.NewTransactor(ctx, mongoDB)
+	if err != nil {
+		log.Fatal("Failed to query database", zap.Error(err))
+	}
+	if !transactor.Atomic() {
+		log.Warn("Database has no transactions; stock of orders that fail midway is put back by hand")
+	}
+	if err := productStorage.BackfillSearchFields(ctx); err != nil {
+		log.Fatal("Failed to backfill product search fields", zap.Error(err))
+	}
+
+	suggestions := suggest.NewIndex()
+	if err := storage.LoadSuggestIndex(ctx, suggestions, productStorage, orderStorage); err != nil {
+		log.Fatal("Failed to build product suggestion index", zap.Error(err))
+	}
+	cancel()
 
-	proHandler := handlers.NewProductsHandler(productStorage, log)
-	ordHandler := handlers.NewOrdersHandler(orderStorage, log)
+	notifier, err := newNotifier(cfg, log)
+	if err != nil {
+		log.Fatal("Failed to set up notifications", zap.Error(err))
+	}
+	reorder := service.NewReorderChecker(productStorage, alertStorage, notifier, log)
 
-	httpservice := app.NewHttpService(ordHandler, proHandler, log, cfg)
+	products := storage.NewStockWatchingProducts(
+		storage.NewAuditedProducts(storage.NewSuggestingProducts(productStorage, suggestions), auditStorage, log),
+		reorder.Changed,
+	)
+	orders := storage.NewAuditedOrders(storage.NewPopularityTrackingOrders(orderStorage, suggestions), auditStorage, log)
+
+	taxService, err := service.NewTaxService(taxRateStorage, products, cfg.Tax.PricesIncludeTax, cfg.Tax.Rounding)
+	if err != nil {
+		log.Fatal("Invalid TAX_ROUNDING", zap.Error(err))
+	}
+	if cfg.Tax.RatesFile != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := taxService.LoadRates(ctx, cfg.Tax.RatesFile)
+		cancel()
+		if err != nil {
+			log.Fatal("Failed to load TAX_RATES_FILE", zap.Error(err))
+		}
+	}
+
+	blobs, err := newBlobStore(cfg, testDB)
+	if err != nil {
+		log.Fatal("Failed to set up blob storage", zap.Error(err))
+	}
+	thumbnailSizes, err := imaging.ParseSizes(cfg.Images.ThumbnailSizes)
+	if err != nil {
+		log.Fatal("Invalid IMAGE_THUMBNAIL_SIZES", zap.Error(err))
+	}
+	images := service.NewImageService(blobs, thumbnailSizes, cfg.Images.MaxBytes, cfg.Blob.PublicURL)
+
+	proService := service.NewProductService(products, orders)
+	proHandler := handlers.NewProductsHandler(products, categoryStorage, suggestions, images, proService, log)
+	strategy, err := service.NewFulfillmentStrategy(cfg.Fulfillment.Strategy)
+	if err != nil {
+		log.Fatal("Invalid FULFILLMENT_STRATEGY", zap.Error(err))
+	}
+	promoService := service.NewPromotionService(promotionStorage, products, categoryStorage)
+	ordService := service.NewOrderService(products, orders, priceStorage, warehouseStorage, customerStorage, promoService, taxService, strategy, transactor, cfg.Shipping.FlatFee)
+	ordHandler := handlers.NewOrdersHandler(orders, ordService, log)
+	catHandler := handlers.NewCategoriesHandler(categoryStorage, products, log)
+	filesHandler := handlers.NewFilesHandler(blobs, log)
+	trashHandler := handlers.NewTrashHandler(products, orders, log)
+	auditHandler := handlers.NewAuditHandler(auditStorage, log)
+	pricesHandler := handlers.NewPricesHandler(priceStorage, log)
+	invService := service.NewInventoryService(products, orders, warehouseStorage)
+	stockHandler := handlers.NewStockHandler(invService, stockStorage, log)
+	warehousesHandler := handlers.NewWarehousesHandler(warehouseStorage, products, transferStorage, log)
+	transferService := service.NewTransferService(products, warehouseStorage, transferStorage)
+	transfersHandler := handlers.NewTransfersHandler(transferService, transferStorage, log)
+	alertsHandler := handlers.NewAlertsHandler(alertStorage, log)
+	suppliersHandler := handlers.NewSuppliersHandler(supplierStorage, purchaseOrderStorage, log)
+	purchasing := service.NewPurchasingService(products, warehouseStorage, supplierStorage, purchaseOrderStorage)
+	purchaseOrdersHandler := handlers.NewPurchaseOrdersHandler(purchasing, purchaseOrderStorage, log)
+	customersHandler := handlers.NewCustomersHandler(customerStorage, orders, log)
+	cartService := service.NewCartService(products, customerStorage, cartStorage, promotionStorage, ordService, cfg.Cart.TTL, log)
+	cartsHandler := handlers.NewCartsHandler(cartService, cartStorage, log)
+	promotionsHandler := handlers.NewPromotionsHandler(promotionStorage, log)
+	taxRatesHandler := handlers.NewTaxRatesHandler(taxRateStorage, log)
+	provider, err := service.NewPaymentProvider(cfg.Payment.Provider, service.PaymentProviderConfig{
+		APIKey:        cfg.Payment.APIKey,
+		WebhookSecret: cfg.Payment.WebhookSecret,
+	})
+	if err != nil {
+		log.Fatal("Invalid PAYMENT_PROVIDER", zap.Error(err))
+	}
+	paymentService := service.NewPaymentService(paymentStorage, orders, provider, cfg.Payment.Currency)
+	paymentsHandler := handlers.NewPaymentsHandler(paymentService, log)
+	returnService := service.NewReturnService(returnStorage, orders, products, warehouseStorage, paymentService)
+	returnsHandler := handlers.NewReturnsHandler(returnService, returnStorage, log)
+
+	if cfg.Trash.Retention > 0 {
+		purger := service.NewTrashPurger(products, orders, images, cfg.Trash.Retention, log)
+		go purger.Run(context.Background(), cfg.Trash.PurgeInterval)
+	}
+	go reorder.Run(context.Background(), cfg.Reorder.CheckInterval)
+
+	httpservice := app.NewHttpService(ordHandler, proHandler, catHandler, filesHandler, trashHandler, auditHandler, pricesHandler, stockHandler, warehousesHandler, transfersHandler, alertsHandler, suppliersHandler, purchaseOrdersHandler, customersHandler, cartsHandler, promotionsHandler, taxRatesHandler, paymentsHandler, returnsHandler, log, cfg)
 
 	httpservice.Run()
 }
+
+func newBlobStore(cfg *config.Config, db *mongodriver.Database) (blob.Store, error) {
+	switch cfg.Blob.Backend {
+	case "local":
+		return blob.NewLocalStore(cfg.Blob.LocalDir)
+	case "gridfs":
+		return blob.NewGridFSStore(db, cfg.Blob.GridFSBucket)
+	case "s3":
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:  cfg.Blob.S3.Endpoint,
+			Region:    cfg.Blob.S3.Region,
+			Bucket:    cfg.Blob.S3.Bucket,
+			AccessKey: cfg.Blob.S3.AccessKey,
+			SecretKey: cfg.Blob.S3.SecretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_BACKEND %q, want local, gridfs or s3", cfg.Blob.Backend)
+	}
+}
+
+func newNotifier(cfg *config.Config, log *zap.Logger) (notify.Notifier, error) {
+	var notifiers notify.Multi
+	for _, channel := range strings.Split(cfg.Notify.Channels, ",") {
+		switch strings.TrimSpace(channel) {
+		case "":
+		case "log":
+			notifiers = append(notifiers, notify.NewLogNotifier(log))
+		case "webhook":
+			n, err := notify.NewWebhookNotifier(cfg.Notify.WebhookURL)
+			if err != nil {
+				return nil, err
+			}
+			notifiers = append(notifiers, n)
+		case "smtp":
+			var to []string
+			for _, addr := range strings.Split(cfg.Notify.SMTP.To, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					to = append(to, addr)
+				}
+			}
+			n, err := notify.NewSMTPNotifier(notify.SMTPConfig{
+				Addr:     cfg.Notify.SMTP.Addr,
+				From:     cfg.Notify.SMTP.From,
+				To:       to,
+				Username: cfg.Notify.SMTP.Username,
+				Password: cfg.Notify.SMTP.Password,
+			})
+			if err != nil {
+				return nil, err
+			}
+			notifiers = append(notifiers, n)
+		default:
+			return nil, fmt.Errorf("unknown notification channel %q, want log, webhook or smtp", channel)
+		}
+	}
+	return notifiers, nil
+}
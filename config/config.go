@@ -11,14 +11,26 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type (
 	Config struct {
-		Server  ServerConfig
-		MongoDB MongoDBConfig
+		Server      ServerConfig
+		MongoDB     MongoDBConfig
+		Blob        BlobConfig
+		Images      ImagesConfig
+		Trash       TrashConfig
+		Fulfillment FulfillmentConfig
+		Reorder     ReorderConfig
+		Notify      NotifyConfig
+		Cart        CartConfig
+		Shipping    ShippingConfig
+		Tax         TaxConfig
+		Payment     PaymentConfig
 	}
 	ServerConfig struct {
 		Host string
@@ -28,6 +40,92 @@ type (
 	MongoDBConfig struct {
 		URI string
 	}
+
+	BlobConfig struct {
+		Backend      string // local, gridfs or s3
+		LocalDir     string
+		GridFSBucket string
+		// PublicURL is the base of the URLs blobs are served from; by
+		// default they are served by this API under /files.
+		PublicURL string
+		S3        S3Config
+	}
+
+	S3Config struct {
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
+	}
+
+	ImagesConfig struct {
+		MaxBytes       int64
+		ThumbnailSizes string // e.g. "150x150,600x600"
+	}
+
+	TrashConfig struct {
+		// Retention is how long deleted products and orders can be
+		// restored before they are purged; zero keeps them forever.
+		Retention     time.Duration
+		PurgeInterval time.Duration
+	}
+
+	FulfillmentConfig struct {
+		// Strategy picks the warehouses orders ship from: priority,
+		// most_stock or nearest.
+		Strategy string
+	}
+
+	ReorderConfig struct {
+		// CheckInterval is how often every product is scanned for low
+		// stock; stock changes made through the API are checked at once.
+		CheckInterval time.Duration
+	}
+
+	CartConfig struct {
+		// TTL is how long a cart is kept after its last change.
+		TTL time.Duration
+	}
+
+	ShippingConfig struct {
+		// FlatFee is charged on every order, unless a free shipping
+		// promotion waives it.
+		FlatFee float64
+	}
+
+	TaxConfig struct {
+		// RatesFile is a JSON list of tax rates stored at startup, in place
+		// of those covering the same region and tax class.
+		RatesFile string
+		// PricesIncludeTax says catalog prices include tax rather than
+		// having it added on top.
+		PricesIncludeTax bool
+		Rounding         string // line or order
+	}
+
+	PaymentConfig struct {
+		// Provider is the gateway payments go through: fake, or an adapter
+		// registered with service.RegisterPaymentProvider.
+		Provider      string
+		APIKey        string
+		WebhookSecret string
+		Currency      string
+	}
+
+	NotifyConfig struct {
+		Channels   string // comma-separated: log, webhook, smtp
+		WebhookURL string
+		SMTP       SMTPConfig
+	}
+
+	SMTPConfig struct {
+		Addr     string // host:port
+		From     string
+		To       string // comma-separated
+		Username string
+		Password string
+	}
 )
 
 func (c *Config) Load() error {
@@ -50,6 +148,98 @@ func (c *Config) Load() error {
 		*fieldPtr = value
 	}
 
+	optionalVars := map[string]struct {
+		field    *string
+		fallback string
+	}{
+		"BLOB_BACKEND":           {&c.Blob.Backend, "local"},
+		"BLOB_LOCAL_DIR":         {&c.Blob.LocalDir, "./data/blobs"},
+		"BLOB_GRIDFS_BUCKET":     {&c.Blob.GridFSBucket, "blobs"},
+		"BLOB_PUBLIC_URL":        {&c.Blob.PublicURL, "/files"},
+		"S3_ENDPOINT":            {&c.Blob.S3.Endpoint, ""},
+		"S3_REGION":              {&c.Blob.S3.Region, "us-east-1"},
+		"S3_BUCKET":              {&c.Blob.S3.Bucket, ""},
+		"S3_ACCESS_KEY":          {&c.Blob.S3.AccessKey, ""},
+		"S3_SECRET_KEY":          {&c.Blob.S3.SecretKey, ""},
+		"IMAGE_THUMBNAIL_SIZES":  {&c.Images.ThumbnailSizes, "150x150,600x600"},
+		"FULFILLMENT_STRATEGY":   {&c.Fulfillment.Strategy, "priority"},
+		"TAX_RATES_FILE":         {&c.Tax.RatesFile, ""},
+		"TAX_ROUNDING":           {&c.Tax.Rounding, "line"},
+		"PAYMENT_PROVIDER":       {&c.Payment.Provider, "fake"},
+		"PAYMENT_API_KEY":        {&c.Payment.APIKey, ""},
+		"PAYMENT_WEBHOOK_SECRET": {&c.Payment.WebhookSecret, ""},
+		"PAYMENT_CURRENCY":       {&c.Payment.Currency, "USD"},
+		"NOTIFY_CHANNELS":        {&c.Notify.Channels, "log"},
+		"NOTIFY_WEBHOOK_URL":     {&c.Notify.WebhookURL, ""},
+		"SMTP_ADDR":              {&c.Notify.SMTP.Addr, "localhost:1025"},
+		"SMTP_FROM":              {&c.Notify.SMTP.From, "alerts@localhost"},
+		"SMTP_TO":                {&c.Notify.SMTP.To, ""},
+		"SMTP_USERNAME":          {&c.Notify.SMTP.Username, ""},
+		"SMTP_PASSWORD":          {&c.Notify.SMTP.Password, ""},
+	}
+	for envVar, v := range optionalVars {
+		*v.field = os.Getenv(envVar)
+		if *v.field == "" {
+			*v.field = v.fallback
+		}
+	}
+
+	c.Images.MaxBytes = 10 << 20
+	if raw := os.Getenv("IMAGE_MAX_BYTES"); raw != "" {
+		maxBytes, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return fmt.Errorf("invalid IMAGE_MAX_BYTES: %q", raw)
+		}
+		c.Images.MaxBytes = maxBytes
+	}
+
+	if raw := os.Getenv("SHIPPING_FEE"); raw != "" {
+		fee, err := strconv.ParseFloat(raw, 64)
+		if err != nil || fee < 0 {
+			return fmt.Errorf("invalid SHIPPING_FEE: %q", raw)
+		}
+		c.Shipping.FlatFee = fee
+	}
+
+	if raw := os.Getenv("TAX_PRICES_INCLUDE_TAX"); raw != "" {
+		inclusive, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid TAX_PRICES_INCLUDE_TAX: %q", raw)
+		}
+		c.Tax.PricesIncludeTax = inclusive
+	}
+
+	durations := map[string]struct {
+		field    *time.Duration
+		fallback time.Duration
+	}{
+		"TRASH_RETENTION":        {&c.Trash.Retention, 30 * 24 * time.Hour},
+		"TRASH_PURGE_INTERVAL":   {&c.Trash.PurgeInterval, time.Hour},
+		"REORDER_CHECK_INTERVAL": {&c.Reorder.CheckInterval, 15 * time.Minute},
+		"CART_TTL":               {&c.Cart.TTL, 72 * time.Hour},
+	}
+	for envVar, v := range durations {
+		*v.field = v.fallback
+		raw := os.Getenv(envVar)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s: %q", envVar, raw)
+		}
+		*v.field = d
+	}
+	if c.Trash.PurgeInterval == 0 {
+		return fmt.Errorf("invalid TRASH_PURGE_INTERVAL: must be positive")
+	}
+	if c.Reorder.CheckInterval == 0 {
+		return fmt.Errorf("invalid REORDER_CHECK_INTERVAL: must be positive")
+	}
+	if c.Cart.TTL == 0 {
+		return fmt.Errorf("invalid CART_TTL: must be positive")
+	}
+
 	return nil
 }
 
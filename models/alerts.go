@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidReorder is wrapped by the errors of products with unusable
+// reorder settings.
+var ErrInvalidReorder = errors.New("invalid reorder settings")
+
+// ValidateReorder checks the reorder point and quantity of p. Bundles have
+// no stock of their own to reorder.
+func (p *Product) ValidateReorder() error {
+	if p.ReorderPoint < 0 || p.ReorderQuantity < 0 {
+		return fmt.Errorf("%w: reorder_point and reorder_quantity cannot be negative", ErrInvalidReorder)
+	}
+	if p.IsBundle() && (p.ReorderPoint > 0 || p.ReorderQuantity > 0) {
+		return fmt.Errorf("%w: bundles are reordered through their components", ErrInvalidReorder)
+	}
+	return nil
+}
+
+// Stock alert statuses. An alert is raised open; acknowledging it tells
+// others it is being handled, and resolving it closes it.
+const (
+	AlertOpen         = "open"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+)
+
+// StockAlert reports a product whose stock has fallen to its reorder point.
+type StockAlert struct {
+	ID        string `json:"id" bson:"_id,omitempty"`
+	ProductID string `json:"product_id" bson:"product_id"`
+	Name      string `json:"name" bson:"name"`
+	SKU       string `json:"sku,omitempty" bson:"sku,omitempty"`
+	// Stock, ReorderPoint and ReorderQuantity are those of the product when
+	// the alert was raised.
+	Stock           int    `json:"stock" bson:"stock"`
+	ReorderPoint    int    `json:"reorder_point" bson:"reorder_point"`
+	ReorderQuantity int    `json:"reorder_quantity" bson:"reorder_quantity"`
+	Status          string `json:"status" bson:"status"`
+	// Restocked is set once the stock is back above the reorder point, which
+	// resolves the alert if it is not yet. Until then no new alert is
+	// raised for the product, even when this one is resolved by hand.
+	Restocked      bool   `json:"restocked" bson:"restocked"`
+	Version        int64  `json:"version" bson:"version"`
+	RaisedAt       string `json:"raised_at" bson:"raised_at"`
+	AcknowledgedAt string `json:"acknowledged_at,omitempty" bson:"acknowledged_at,omitempty"`
+	AcknowledgedBy string `json:"acknowledged_by,omitempty" bson:"acknowledged_by,omitempty"`
+	ResolvedAt     string `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+	ResolvedBy     string `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+}
+
+type StockAlertList struct {
+	Items []*StockAlert `json:"items"`
+	Total int64         `json:"total"`
+	Links PageLinks     `json:"links"`
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Attribute types.
+const (
+	AttributeString = "string"
+	AttributeNumber = "number"
+	AttributeBool   = "bool"
+	AttributeEnum   = "enum"
+)
+
+// ErrInvalidAttributes is wrapped by every attribute schema or value error.
+var ErrInvalidAttributes = errors.New("invalid attributes")
+
+// AttributeKeyPattern is what attribute keys look like. Keys become part of
+// document paths and filter names, so they are kept to a safe alphabet.
+var AttributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// AttributeDef declares an attribute the products of a category carry,
+// such as the screen size of phones.
+type AttributeDef struct {
+	Key      string `json:"key" bson:"key"`
+	Label    string `json:"label,omitempty" bson:"label,omitempty"`
+	Type     string `json:"type" bson:"type"`
+	Required bool   `json:"required" bson:"required"`
+	// Values lists the allowed values of an enum attribute.
+	Values []string `json:"values,omitempty" bson:"values,omitempty"`
+}
+
+// ValidateAttributeDefs checks a category's attribute schema.
+func ValidateAttributeDefs(defs []AttributeDef) error {
+	seen := map[string]bool{}
+	for _, d := range defs {
+		if !AttributeKeyPattern.MatchString(d.Key) {
+			return fmt.Errorf("%w: key %q must match %s", ErrInvalidAttributes, d.Key, AttributeKeyPattern)
+		}
+		if seen[d.Key] {
+			return fmt.Errorf("%w: duplicate key %q", ErrInvalidAttributes, d.Key)
+		}
+		seen[d.Key] = true
+
+		switch d.Type {
+		case AttributeString, AttributeNumber, AttributeBool:
+			if len(d.Values) > 0 {
+				return fmt.Errorf("%w: only enum attributes list values, %q is %s", ErrInvalidAttributes, d.Key, d.Type)
+			}
+		case AttributeEnum:
+			if len(d.Values) == 0 {
+				return fmt.Errorf("%w: enum attribute %q needs values", ErrInvalidAttributes, d.Key)
+			}
+		default:
+			return fmt.Errorf("%w: attribute %q has unknown type %q", ErrInvalidAttributes, d.Key, d.Type)
+		}
+	}
+	return nil
+}
+
+// MergeAttributeDefs combines the schemas of a category and its ancestors,
+// given root first. A category may redefine an attribute it inherits.
+func MergeAttributeDefs(chain []*Category) []AttributeDef {
+	byKey := map[string]AttributeDef{}
+	for _, c := range chain {
+		for _, d := range c.Attributes {
+			byKey[d.Key] = d
+		}
+	}
+	defs := make([]AttributeDef, 0, len(byKey))
+	for _, d := range byKey {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Key < defs[j].Key })
+	return defs
+}
+
+// ValidateAttributes checks attribute values against a schema and returns
+// them in their stored form. Unknown keys and missing required attributes
+// are rejected.
+func ValidateAttributes(defs []AttributeDef, values map[string]interface{}) (map[string]interface{}, error) {
+	byKey := make(map[string]AttributeDef, len(defs))
+	for _, d := range defs {
+		byKey[d.Key] = d
+	}
+
+	valid := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		d, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: the category has no attribute %q", ErrInvalidAttributes, key)
+		}
+		if value == nil {
+			continue
+		}
+		v, err := d.check(value)
+		if err != nil {
+			return nil, err
+		}
+		valid[key] = v
+	}
+
+	var missing []string
+	for _, d := range defs {
+		if _, ok := valid[d.Key]; d.Required && !ok {
+			missing = append(missing, d.Key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: missing required %s", ErrInvalidAttributes, strings.Join(missing, ", "))
+	}
+	if len(valid) == 0 {
+		return nil, nil
+	}
+	return valid, nil
+}
+
+func (d AttributeDef) check(value interface{}) (interface{}, error) {
+	switch d.Type {
+	case AttributeNumber:
+		switch n := value.(type) {
+		case float64:
+			return n, nil
+		case int:
+			return float64(n), nil
+		case int32:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		}
+	case AttributeBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case AttributeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case AttributeEnum:
+		if s, ok := value.(string); ok {
+			if !slices.Contains(d.Values, s) {
+				return nil, fmt.Errorf("%w: %q must be one of %s", ErrInvalidAttributes, d.Key, strings.Join(d.Values, ", "))
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q must be a %s", ErrInvalidAttributes, d.Key, d.Type)
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	defs := []AttributeDef{
+		{Key: "screen", Type: AttributeNumber, Required: true},
+		{Key: "color", Type: AttributeEnum, Values: []string{"black", "white"}},
+		{Key: "model", Type: AttributeString},
+		{Key: "refurbished", Type: AttributeBool},
+	}
+
+	tests := []struct {
+		name   string
+		defs   []AttributeDef
+		values map[string]interface{}
+		want   map[string]interface{}
+		isErr  bool
+	}{
+		{
+			name:   "no schema, no values",
+			values: nil,
+			want:   nil,
+		},
+		{
+			name:   "every type",
+			defs:   defs,
+			values: map[string]interface{}{"screen": 6.1, "color": "black", "model": "X1", "refurbished": false},
+			want:   map[string]interface{}{"screen": 6.1, "color": "black", "model": "X1", "refurbished": false},
+		},
+		{
+			name:   "integers stored as numbers",
+			defs:   defs,
+			values: map[string]interface{}{"screen": int64(6)},
+			want:   map[string]interface{}{"screen": float64(6)},
+		},
+		{
+			name:   "int32 stored as number",
+			defs:   defs,
+			values: map[string]interface{}{"screen": int32(7)},
+			want:   map[string]interface{}{"screen": float64(7)},
+		},
+		{
+			name:   "null values dropped",
+			defs:   defs,
+			values: map[string]interface{}{"screen": 6, "model": nil},
+			want:   map[string]interface{}{"screen": float64(6)},
+		},
+		{
+			name:   "nothing left",
+			defs:   []AttributeDef{{Key: "model", Type: AttributeString}},
+			values: map[string]interface{}{"model": nil},
+			want:   nil,
+		},
+		{name: "unknown key", defs: defs, values: map[string]interface{}{"screen": 6, "weight": 1}, isErr: true},
+		{name: "missing required", defs: defs, values: map[string]interface{}{"model": "X1"}, isErr: true},
+		{name: "required set to null", defs: defs, values: map[string]interface{}{"screen": nil}, isErr: true},
+		{name: "number as string", defs: defs, values: map[string]interface{}{"screen": "6"}, isErr: true},
+		{name: "bool as string", defs: defs, values: map[string]interface{}{"screen": 6, "refurbished": "no"}, isErr: true},
+		{name: "string as number", defs: defs, values: map[string]interface{}{"screen": 6, "model": 1}, isErr: true},
+		{name: "enum value not allowed", defs: defs, values: map[string]interface{}{"screen": 6, "color": "red"}, isErr: true},
+		{name: "enum as number", defs: defs, values: map[string]interface{}{"screen": 6, "color": 1}, isErr: true},
+		{name: "values without schema", values: map[string]interface{}{"screen": 6}, isErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateAttributes(tt.defs, tt.values)
+			if tt.isErr {
+				if !errors.Is(err, ErrInvalidAttributes) {
+					t.Errorf("error = %v, want ErrInvalidAttributes", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateAttributes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAttributeDefs(t *testing.T) {
+	tests := []struct {
+		name  string
+		defs  []AttributeDef
+		isErr bool
+	}{
+		{name: "empty"},
+		{name: "valid", defs: []AttributeDef{
+			{Key: "screen_size", Type: AttributeNumber},
+			{Key: "color", Type: AttributeEnum, Values: []string{"black"}},
+			{Key: "wifi", Type: AttributeBool},
+			{Key: "model", Type: AttributeString},
+		}},
+		{name: "uppercase key", defs: []AttributeDef{{Key: "Color", Type: AttributeString}}, isErr: true},
+		{name: "key with dot", defs: []AttributeDef{{Key: "a.b", Type: AttributeString}}, isErr: true},
+		{name: "leading digit", defs: []AttributeDef{{Key: "4g", Type: AttributeBool}}, isErr: true},
+		{name: "empty key", defs: []AttributeDef{{Key: "", Type: AttributeString}}, isErr: true},
+		{name: "duplicate key", defs: []AttributeDef{
+			{Key: "color", Type: AttributeString},
+			{Key: "color", Type: AttributeString},
+		}, isErr: true},
+		{name: "unknown type", defs: []AttributeDef{{Key: "size", Type: "date"}}, isErr: true},
+		{name: "enum without values", defs: []AttributeDef{{Key: "color", Type: AttributeEnum}}, isErr: true},
+		{name: "values on a string", defs: []AttributeDef{{Key: "color", Type: AttributeString, Values: []string{"red"}}}, isErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAttributeDefs(tt.defs)
+			if tt.isErr && !errors.Is(err, ErrInvalidAttributes) {
+				t.Errorf("error = %v, want ErrInvalidAttributes", err)
+			}
+			if !tt.isErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMergeAttributeDefs(t *testing.T) {
+	root := &Category{Attributes: []AttributeDef{
+		{Key: "brand", Type: AttributeString},
+		{Key: "color", Type: AttributeString},
+	}}
+	child := &Category{Attributes: []AttributeDef{
+		{Key: "color", Type: AttributeEnum, Values: []string{"black"}, Required: true},
+		{Key: "screen", Type: AttributeNumber},
+	}}
+
+	tests := []struct {
+		name  string
+		chain []*Category
+		want  []AttributeDef
+	}{
+		{name: "empty", want: []AttributeDef{}},
+		{name: "root only", chain: []*Category{root}, want: root.Attributes},
+		{name: "child redefines", chain: []*Category{root, child}, want: []AttributeDef{
+			{Key: "brand", Type: AttributeString},
+			{Key: "color", Type: AttributeEnum, Values: []string{"black"}, Required: true},
+			{Key: "screen", Type: AttributeNumber},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeAttributeDefs(tt.chain); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
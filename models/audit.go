@@ -0,0 +1,40 @@
+package models
+
+// Audit operations.
+const (
+	AuditCreate      = "create"
+	AuditUpdate      = "update"
+	AuditDelete      = "delete"
+	AuditRestore     = "restore"
+	AuditAdjustStock = "adjust_stock"
+	AuditPurge       = "purge"
+)
+
+// AuditEntry records one change to a product or an order.
+type AuditEntry struct {
+	ID         string `json:"id" bson:"_id,omitempty"`
+	Timestamp  string `json:"timestamp" bson:"timestamp"`
+	Actor      string `json:"actor" bson:"actor"`
+	RequestID  string `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	EntityType string `json:"entity_type" bson:"entity_type"` // product or order
+	EntityID   string `json:"entity_id" bson:"entity_id"`
+	Operation  string `json:"operation" bson:"operation"`
+	// Changes lists the fields that differ between the entity before and
+	// after the operation. Created and restored entities have no before,
+	// deleted ones no after; purges record no changes, as the deletion
+	// already did.
+	Changes []FieldChange `json:"changes" bson:"changes"`
+}
+
+// FieldChange is the before and after value of one top-level field.
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+type AuditList struct {
+	Items []*AuditEntry `json:"items"`
+	Total int64         `json:"total"`
+	Links PageLinks     `json:"links"`
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Product types.
+const (
+	ProductTypeSimple = "simple"
+	ProductTypeBundle = "bundle"
+)
+
+// Bundle pricing modes.
+const (
+	// BundlePricingFixed sells the bundle at the product price.
+	BundlePricingFixed = "fixed"
+	// BundlePricingDiscount sells the bundle at the sum of its component
+	// prices minus Discount.
+	BundlePricingDiscount = "discount"
+)
+
+// ErrInvalidBundle is wrapped by every bundle validation error.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// Bundle describes the contents of a bundle product such as a gift box.
+// A bundle has no stock of its own: it is in stock as far as all of its
+// components are.
+type Bundle struct {
+	Components []BundleComponent `json:"components" bson:"components"`
+	Pricing    string            `json:"pricing" bson:"pricing"`
+	Discount   float64           `json:"discount,omitempty" bson:"discount,omitempty"`
+}
+
+// BundleComponent is a quantity of one catalog product, or one variant of
+// it, contained in every unit of a bundle.
+type BundleComponent struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	VariantID string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+}
+
+// IsBundle reports whether p is a bundle of other products.
+func (p *Product) IsBundle() bool {
+	return p.Type == ProductTypeBundle
+}
+
+// Validate checks the variant, reorder and bundle rules of p.
+func (p *Product) Validate() error {
+	if err := p.ValidateVariants(); err != nil {
+		return err
+	}
+	if err := p.ValidateReorder(); err != nil {
+		return err
+	}
+	return p.ValidateBundle()
+}
+
+// ValidateBundle checks that bundles, and only bundles, list their
+// components. Whether the components exist is checked by storage.
+func (p *Product) ValidateBundle() error {
+	switch p.Type {
+	case "", ProductTypeSimple:
+		if p.Bundle != nil {
+			return fmt.Errorf("%w: only products of type %q have components", ErrInvalidBundle, ProductTypeBundle)
+		}
+		return nil
+	case ProductTypeBundle:
+	default:
+		return fmt.Errorf("%w: unknown product type %q", ErrInvalidBundle, p.Type)
+	}
+
+	if p.Bundle == nil || len(p.Bundle.Components) == 0 {
+		return fmt.Errorf("%w: a bundle needs at least one component", ErrInvalidBundle)
+	}
+	if len(p.Variants) > 0 {
+		return fmt.Errorf("%w: a bundle cannot have variants", ErrInvalidBundle)
+	}
+	switch p.Bundle.Pricing {
+	case BundlePricingFixed, BundlePricingDiscount:
+	default:
+		return fmt.Errorf("%w: pricing must be %q or %q", ErrInvalidBundle, BundlePricingFixed, BundlePricingDiscount)
+	}
+	if p.Bundle.Discount < 0 {
+		return fmt.Errorf("%w: discount cannot be negative", ErrInvalidBundle)
+	}
+
+	seen := map[BundleComponent]bool{}
+	for _, c := range p.Bundle.Components {
+		if c.ProductID == "" || c.Quantity <= 0 {
+			return fmt.Errorf("%w: every component needs a product_id and a positive quantity", ErrInvalidBundle)
+		}
+		if p.ID != "" && c.ProductID == p.ID {
+			return fmt.Errorf("%w: a bundle cannot contain itself", ErrInvalidBundle)
+		}
+		key := BundleComponent{ProductID: c.ProductID, VariantID: c.VariantID}
+		if seen[key] {
+			return fmt.Errorf("%w: component %s is listed twice", ErrInvalidBundle, c.ProductID)
+		}
+		seen[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import "time"
+
+// Cart statuses. A cart is open until it is checked out; while checkout
+// places its order the cart is locked against changes.
+const (
+	CartOpen        = "open"
+	CartCheckingOut = "checking_out"
+	CartCheckedOut  = "checked_out"
+)
+
+// Cart problems, found when the lines of a cart are checked against the
+// catalog.
+const (
+	// CartPriceChanged means the catalog price differs from the price the
+	// line was quoted at.
+	CartPriceChanged = "price_changed"
+	// CartOutOfStock means less stock is available than the line asks for.
+	CartOutOfStock = "insufficient_stock"
+	// CartUnavailable means the product, or variant, no longer exists.
+	CartUnavailable = "unavailable"
+)
+
+// Cart collects the products a shopper intends to order. Carts without a
+// customer are anonymous; the customer is then given at checkout. Carts
+// that are not changed expire.
+type Cart struct {
+	ID         string     `json:"id" bson:"_id,omitempty"`
+	CustomerID string     `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Lines      []CartLine `json:"lines" bson:"lines"`
+	// Subtotal is the sum of the lines at the prices they were quoted at.
+	Subtotal float64 `json:"subtotal" bson:"subtotal"`
+	// CouponCodes are the codes entered for the cart.
+	CouponCodes []string `json:"coupon_codes" bson:"coupon_codes"`
+	// Discounts, Discount, Shipping, Tax and Total price the cart as an
+	// order would be, with the promotions that apply now, and
+	// RejectedCoupons lists the codes that do not. They are worked out
+	// whenever the cart is read, not stored. Tax is taken at the default
+	// addresses of the customer, and is zero for anonymous carts.
+	Discounts       []AppliedDiscount `json:"discounts,omitempty" bson:"-"`
+	Discount        float64           `json:"discount" bson:"-"`
+	Shipping        float64           `json:"shipping" bson:"-"`
+	Tax             float64           `json:"tax" bson:"-"`
+	Total           float64           `json:"total" bson:"-"`
+	RejectedCoupons []RejectedCoupon  `json:"rejected_coupons,omitempty" bson:"-"`
+	Status          string            `json:"status" bson:"status"`
+	// OrderID is the order the cart was checked out as.
+	OrderID string `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	// Issues lists what changed in the catalog since the lines were
+	// quoted. It is worked out whenever the cart is read, not stored.
+	Issues    []CartIssue `json:"issues,omitempty" bson:"-"`
+	Version   int64       `json:"version" bson:"version"`
+	CreatedAt string      `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt string      `json:"updated_at" bson:"updated_at,omitempty"`
+	// ExpiresAt is when the cart is removed unless changed before. It is
+	// a date, not a string, so the database can expire carts by itself.
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+type CartLine struct {
+	// ID is assigned when the line is added.
+	ID        string `json:"id" bson:"id"`
+	ProductID string `json:"product_id" bson:"product_id"`
+	VariantID string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Name      string `json:"name" bson:"name"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+	// Price is the unit price the line was quoted at, when it was added or
+	// its quantity last changed, or when checkout found the catalog price
+	// changed.
+	Price float64 `json:"price" bson:"price"`
+}
+
+// CartIssue is a line of a cart that cannot be checked out as quoted.
+type CartIssue struct {
+	LineID    string `json:"line_id"`
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id,omitempty"`
+	Problem   string `json:"problem"`
+	// Price is the catalog price, for price_changed.
+	Price float64 `json:"price,omitempty"`
+	// Available is the stock that can be ordered, for insufficient_stock.
+	Available int `json:"available,omitempty"`
+}
+
+// Line returns the line with the given ID, or nil.
+func (c *Cart) Line(id string) *CartLine {
+	for i := range c.Lines {
+		if c.Lines[i].ID == id {
+			return &c.Lines[i]
+		}
+	}
+	return nil
+}
+
+// Checkout is what a cart is checked out with.
+type Checkout struct {
+	// CustomerID is required for anonymous carts and must match the
+	// customer of customer-bound ones.
+	CustomerID        string    `json:"customer_id"`
+	ShippingAddressID string    `json:"shipping_address_id"`
+	BillingAddressID  string    `json:"billing_address_id"`
+	ShipTo            *GeoPoint `json:"ship_to"`
+}
@@ -0,0 +1,21 @@
+package models
+
+type Category struct {
+	ID   string `json:"id" bson:"_id,omitempty"`
+	Name string `json:"name" bson:"name"`
+	Slug string `json:"slug" bson:"slug"`
+	// Aliases are other slugs the category is found by, such as those of
+	// the spellings merged into it when it was created from the free-text
+	// categories of existing products.
+	Aliases  []string `json:"aliases,omitempty" bson:"aliases,omitempty"`
+	ParentID string   `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	// Path is the materialised path of the category: the IDs of its
+	// ancestors and itself, e.g. "/<root id>/<parent id>/<id>/".
+	Path string `json:"path" bson:"path"`
+	// Attributes is the schema of the attributes the products of this
+	// category carry, in addition to those inherited from its ancestors.
+	Attributes []AttributeDef `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	Version    int64          `json:"version" bson:"version"`
+	CreatedAt  string         `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt  string         `json:"updated_at" bson:"updated_at,omitempty"`
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCustomer is wrapped by the errors for customers that cannot be
+// stored as given.
+var ErrInvalidCustomer = errors.New("invalid customer")
+
+// Address types. A customer can have several addresses of each type, one
+// of which is the default for orders that do not pick one.
+const (
+	AddressShipping = "shipping"
+	AddressBilling  = "billing"
+)
+
+// Customer is someone orders are placed for.
+type Customer struct {
+	ID        string    `json:"id" bson:"_id,omitempty"`
+	Name      string    `json:"name" bson:"name"`
+	Email     string    `json:"email,omitempty" bson:"email,omitempty"`
+	Phone     string    `json:"phone,omitempty" bson:"phone,omitempty"`
+	Addresses []Address `json:"addresses" bson:"addresses"`
+	Note      string    `json:"note,omitempty" bson:"note,omitempty"`
+	Version   int64     `json:"version" bson:"version"`
+	CreatedAt string    `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt string    `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+// Address is a postal address of a customer. Orders keep a copy of the
+// addresses they were placed with, so later edits leave them as they were.
+type Address struct {
+	// ID is assigned when the customer is stored, unless given.
+	ID         string `json:"id" bson:"id"`
+	Type       string `json:"type" bson:"type"`
+	Name       string `json:"name,omitempty" bson:"name,omitempty"`
+	Line1      string `json:"line1" bson:"line1"`
+	Line2      string `json:"line2,omitempty" bson:"line2,omitempty"`
+	City       string `json:"city" bson:"city"`
+	Region     string `json:"region,omitempty" bson:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+	Country    string `json:"country" bson:"country"`
+	Phone      string `json:"phone,omitempty" bson:"phone,omitempty"`
+	// Location is where the address is, used as the ship_to of orders
+	// shipped there.
+	Location *GeoPoint `json:"location,omitempty" bson:"location,omitempty"`
+	// Default marks the address used for orders that do not name one.
+	Default bool `json:"default,omitempty" bson:"default,omitempty"`
+}
+
+type CustomerList struct {
+	Items []*Customer `json:"items"`
+	Total int64       `json:"total"`
+	Links PageLinks   `json:"links"`
+}
+
+// Validate checks the addresses of the customer: each needs a known type,
+// the street, city and country, and an ID used by no other address, and
+// there is at most one default address per type.
+func (c *Customer) Validate() error {
+	ids := map[string]bool{}
+	defaults := map[string]bool{}
+	for i, a := range c.Addresses {
+		if a.Type != AddressShipping && a.Type != AddressBilling {
+			return fmt.Errorf("%w: address %d needs a type of %s or %s", ErrInvalidCustomer, i+1, AddressShipping, AddressBilling)
+		}
+		if a.Line1 == "" || a.City == "" || a.Country == "" {
+			return fmt.Errorf("%w: address %d needs line1, city and country", ErrInvalidCustomer, i+1)
+		}
+		if a.ID != "" {
+			if ids[a.ID] {
+				return fmt.Errorf("%w: address ID %q is used more than once", ErrInvalidCustomer, a.ID)
+			}
+			ids[a.ID] = true
+		}
+		if a.Default {
+			if defaults[a.Type] {
+				return fmt.Errorf("%w: only one %s address can be the default", ErrInvalidCustomer, a.Type)
+			}
+			defaults[a.Type] = true
+		}
+	}
+	return nil
+}
+
+// Address returns the address with the given ID, or nil.
+func (c *Customer) Address(id string) *Address {
+	for i := range c.Addresses {
+		if c.Addresses[i].ID == id {
+			return &c.Addresses[i]
+		}
+	}
+	return nil
+}
+
+// DefaultAddress returns the default address of the given type: the one
+// marked as default, else the only one of that type, else nil.
+func (c *Customer) DefaultAddress(typ string) *Address {
+	var only *Address
+	count := 0
+	for i := range c.Addresses {
+		a := &c.Addresses[i]
+		if a.Type != typ {
+			continue
+		}
+		if a.Default {
+			return a
+		}
+		only = a
+		count++
+	}
+	if count == 1 {
+		return only
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package models
+
+// Image is a picture attached to a product. Product.Images is kept in
+// display order, the first image being the main one.
+type Image struct {
+	ID          string `json:"id" bson:"id"`
+	URL         string `json:"url" bson:"url"`
+	ContentType string `json:"content_type" bson:"content_type"`
+	Size        int64  `json:"size" bson:"size"`
+	Width       int    `json:"width" bson:"width"`
+	Height      int    `json:"height" bson:"height"`
+	Alt         string `json:"alt,omitempty" bson:"alt,omitempty"`
+	// Thumbnails maps a size such as "150x150" to the URL of the image
+	// scaled to fit in it.
+	Thumbnails map[string]string `json:"thumbnails,omitempty" bson:"thumbnails,omitempty"`
+	// Keys are the blob store keys of the original and every thumbnail.
+	Keys      []string `json:"-" bson:"keys"`
+	CreatedAt string   `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// PageLinks holds ready-to-follow URLs for the neighbouring pages of a listing.
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+type ProductList struct {
+	Items []*Product `json:"items"`
+	Total int64      `json:"total"`
+	Links PageLinks  `json:"links"`
+}
+
+type OrderList struct {
+	Items []*Order  `json:"items"`
+	Total int64     `json:"total"`
+	Links PageLinks `json:"links"`
+}
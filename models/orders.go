@@ -1,18 +1,157 @@
 package models
 
+// Order statuses. Status is free text, but orders in one of the terminal
+// statuses are finished: nothing about them is expected to change, so they
+// no longer hold on to the products they reference. Orders move to
+// partially_refunded and refunded through returns.
+const (
+	OrderStatusPending           = "pending"
+	OrderStatusProcessing        = "processing"
+	OrderStatusShipped           = "shipped"
+	OrderStatusDelivered         = "delivered"
+	OrderStatusCompleted         = "completed"
+	OrderStatusCancelled         = "cancelled"
+	OrderStatusPartiallyRefunded = "partially_refunded"
+	OrderStatusRefunded          = "refunded"
+)
+
+// TerminalOrderStatuses are the statuses of finished orders.
+var TerminalOrderStatuses = []string{
+	OrderStatusDelivered, OrderStatusCompleted, OrderStatusCancelled,
+	OrderStatusPartiallyRefunded, OrderStatusRefunded,
+}
+
 type Order struct {
 	ID         string           `json:"id" bson:"_id,omitempty"`
 	CustomerID string           `json:"customer_id" bson:"customer_id"`
 	Products   []ProductInOrder `json:"products" bson:"products"`
-	TotalPrice float64          `json:"total_price" bson:"total_price"`
-	OrderDate  string           `json:"order_date" bson:"order_date"`
-	Status     string           `json:"status" bson:"status"`
-	CreatedAt  string           `json:"created_at" bson:"created_at,omitempty"`
-	UpdatedAt  string           `json:"updated_at" bson:"updated_at,omitempty"`
+	// CouponCodes are the codes entered for the order.
+	CouponCodes []string `json:"coupon_codes,omitempty" bson:"coupon_codes,omitempty"`
+	// Subtotal is the sum of the lines before discounts and Shipping the
+	// shipping fee; Discount is what Discounts took off both, so that
+	// TotalPrice is Subtotal less Discount plus Shipping, plus Tax unless
+	// TaxInclusive says the prices already include it.
+	Subtotal     float64           `json:"subtotal" bson:"subtotal"`
+	Discount     float64           `json:"discount" bson:"discount"`
+	Discounts    []AppliedDiscount `json:"discounts,omitempty" bson:"discounts,omitempty"`
+	Shipping     float64           `json:"shipping" bson:"shipping"`
+	TaxInclusive bool              `json:"tax_inclusive" bson:"tax_inclusive"`
+	// Tax is the sum of Taxes, the tax charged at each rate.
+	Tax        float64    `json:"tax" bson:"tax"`
+	Taxes      []OrderTax `json:"taxes,omitempty" bson:"taxes,omitempty"`
+	TotalPrice float64    `json:"total_price" bson:"total_price"`
+	OrderDate  string     `json:"order_date" bson:"order_date"`
+	Status     string     `json:"status" bson:"status"`
+	// PaymentStatus summarises the payments of the order and Paid is what
+	// they captured less what was refunded. Both change only through
+	// payments.
+	PaymentStatus string  `json:"payment_status,omitempty" bson:"payment_status,omitempty"`
+	Paid          float64 `json:"paid,omitempty" bson:"paid,omitempty"`
+	// Held is what the payments of the order hold of its total, the sum of
+	// Payment.Holds. A payment reserves its amount here before it goes to
+	// the gateway, so payments made at the same time cannot exceed the
+	// total. It changes only through payments.
+	Held float64 `json:"held,omitempty" bson:"held,omitempty"`
+	// Refunded is what returns refunded, tax included, and RefundedTax
+	// the tax in it.
+	Refunded    float64 `json:"refunded,omitempty" bson:"refunded,omitempty"`
+	RefundedTax float64 `json:"refunded_tax,omitempty" bson:"refunded_tax,omitempty"`
+	// ShippingAddressID and BillingAddressID pick addresses of the
+	// customer when the order is placed, the default ones when empty.
+	// ShippingAddress and BillingAddress are copies of the addresses
+	// picked, kept as they were when the order was placed.
+	ShippingAddressID string   `json:"shipping_address_id,omitempty" bson:"shipping_address_id,omitempty"`
+	BillingAddressID  string   `json:"billing_address_id,omitempty" bson:"billing_address_id,omitempty"`
+	ShippingAddress   *Address `json:"shipping_address,omitempty" bson:"shipping_address,omitempty"`
+	BillingAddress    *Address `json:"billing_address,omitempty" bson:"billing_address,omitempty"`
+	// ShipTo is where the order is delivered, for the nearest fulfillment
+	// strategy. It defaults to the location of the shipping address.
+	ShipTo *GeoPoint `json:"ship_to,omitempty" bson:"ship_to,omitempty"`
+	// Allocations records which warehouses the ordered stock was taken
+	// from, filled in when the order is placed.
+	Allocations []StockAllocation `json:"allocations,omitempty" bson:"allocations,omitempty"`
+	Version     int64             `json:"version" bson:"version"`
+	CreatedAt   string            `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt   string            `json:"updated_at" bson:"updated_at,omitempty"`
+	// DeletedAt and DeletedBy are set while the order is in the trash.
+	DeletedAt string `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy string `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
 }
 
 type ProductInOrder struct {
-	ProductID string  `json:"product_id" bson:"product_id"`
+	ProductID string `json:"product_id" bson:"product_id"`
+	// VariantID names the variant ordered, for products that have variants.
+	VariantID string  `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
 	Quantity  int     `json:"quantity" bson:"quantity"`
 	Price     float64 `json:"price" bson:"price"`
+	// Discount is what promotions took off the line as a whole.
+	Discount float64 `json:"discount,omitempty" bson:"discount,omitempty"`
+	// TaxClass is that of the product, and TaxRate the percentage the line
+	// was taxed at; Tax is the tax on the line after its discount.
+	TaxClass string  `json:"tax_class,omitempty" bson:"tax_class,omitempty"`
+	TaxRate  float64 `json:"tax_rate,omitempty" bson:"tax_rate,omitempty"`
+	Tax      float64 `json:"tax,omitempty" bson:"tax,omitempty"`
+	// Cost is the unit cost of the line when the order was placed, the sum
+	// of the component costs for bundles.
+	Cost float64 `json:"cost,omitempty" bson:"cost,omitempty"`
+	// Components lists what a bundle line ships, filled in when the order
+	// is placed.
+	Components []OrderComponent `json:"components,omitempty" bson:"components,omitempty"`
+	// Returned is how much of the line was returned and refunded, and
+	// Restocked how much of what came back went into stock. Refunded is
+	// what the returns refunded for the line, tax included, and
+	// RefundedTax the tax in it.
+	Returned    int     `json:"returned,omitempty" bson:"returned,omitempty"`
+	Restocked   int     `json:"restocked,omitempty" bson:"restocked,omitempty"`
+	Refunded    float64 `json:"refunded,omitempty" bson:"refunded,omitempty"`
+	RefundedTax float64 `json:"refunded_tax,omitempty" bson:"refunded_tax,omitempty"`
+}
+
+// OrderComponent is a product shipped as part of a bundle line, with the
+// share of the line revenue, after discounts and without tax, attributed to
+// it and what it cost.
+type OrderComponent struct {
+	ProductID string  `json:"product_id" bson:"product_id"`
+	VariantID string  `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Name      string  `json:"name" bson:"name"`
+	Quantity  int     `json:"quantity" bson:"quantity"`
+	Revenue   float64 `json:"revenue" bson:"revenue"`
+	Cost      float64 `json:"cost" bson:"cost"`
+}
+
+// StockAllocation is stock of a product, or variant, taken from one
+// warehouse for an order.
+type StockAllocation struct {
+	ProductID   string `json:"product_id" bson:"product_id"`
+	VariantID   string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	WarehouseID string `json:"warehouse_id" bson:"warehouse_id"`
+	Quantity    int    `json:"quantity" bson:"quantity"`
+}
+
+// OrderPatch holds the fields of a partial order update; nil fields are left unchanged.
+type OrderPatch struct {
+	CustomerID *string          `json:"customer_id"`
+	Products   []ProductInOrder `json:"products"`
+	TotalPrice *float64         `json:"total_price"`
+	OrderDate  *string          `json:"order_date"`
+	Status     *string          `json:"status"`
+}
+
+// Apply copies the set fields of the patch onto o.
+func (op *OrderPatch) Apply(o *Order) {
+	if op.CustomerID != nil {
+		o.CustomerID = *op.CustomerID
+	}
+	if op.Products != nil {
+		o.Products = op.Products
+	}
+	if op.TotalPrice != nil {
+		o.TotalPrice = *op.TotalPrice
+	}
+	if op.OrderDate != nil {
+		o.OrderDate = *op.OrderDate
+	}
+	if op.Status != nil {
+		o.Status = *op.Status
+	}
 }
@@ -0,0 +1,93 @@
+package models
+
+// Payment statuses. A payment is authorized first, or pending until the
+// gateway confirms the authorization; an authorization is then captured,
+// taking the money, or voided, releasing it. Captured money can be
+// refunded, in part or in full.
+const (
+	PaymentPending           = "pending"
+	PaymentAuthorized        = "authorized"
+	PaymentCaptured          = "captured"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentRefunded          = "refunded"
+	PaymentVoided            = "voided"
+	PaymentFailed            = "failed"
+)
+
+// Order payment statuses, summarising the payments of an order.
+const (
+	OrderUnpaid            = "unpaid"
+	OrderAuthorized        = "authorized"
+	OrderPartiallyPaid     = "partially_paid"
+	OrderPaid              = "paid"
+	OrderPartiallyRefunded = "partially_refunded"
+	OrderRefunded          = "refunded"
+)
+
+// Payment event types, for the changes made through the API and those
+// reported by the gateway through webhooks.
+const (
+	PaymentEventAuthorized = "authorized"
+	PaymentEventCaptured   = "captured"
+	PaymentEventRefunded   = "refunded"
+	PaymentEventVoided     = "voided"
+	PaymentEventFailed     = "failed"
+)
+
+// Payment is money taken, or to be taken, for an order through a payment
+// gateway.
+type Payment struct {
+	ID      string `json:"id" bson:"_id,omitempty"`
+	OrderID string `json:"order_id" bson:"order_id"`
+	// Provider is the gateway the payment went through and TransactionID
+	// its reference for it.
+	Provider      string `json:"provider" bson:"provider"`
+	TransactionID string `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	Currency      string `json:"currency" bson:"currency"`
+	// Amount is what was authorized; Captured and Refunded are what has
+	// been taken and given back of it.
+	Amount   float64 `json:"amount" bson:"amount"`
+	Captured float64 `json:"captured" bson:"captured"`
+	Refunded float64 `json:"refunded" bson:"refunded"`
+	Status   string  `json:"status" bson:"status"`
+	// FailureReason tells why the gateway declined the payment.
+	FailureReason string `json:"failure_reason,omitempty" bson:"failure_reason,omitempty"`
+	// Events is the history of the payment, oldest first.
+	Events    []PaymentEvent `json:"events" bson:"events"`
+	Version   int64          `json:"version" bson:"version"`
+	CreatedAt string         `json:"created_at" bson:"created_at"`
+	UpdatedAt string         `json:"updated_at" bson:"updated_at"`
+}
+
+// PaymentEvent is a change to a payment. Events received through webhooks
+// keep the ID the gateway gave them, so a delivery repeated by the gateway
+// is applied once.
+type PaymentEvent struct {
+	ID     string  `json:"id,omitempty" bson:"id,omitempty"`
+	Type   string  `json:"type" bson:"type"`
+	Amount float64 `json:"amount,omitempty" bson:"amount,omitempty"`
+	// Source is api or webhook.
+	Source string `json:"source" bson:"source"`
+	At     string `json:"at" bson:"at"`
+}
+
+// HasEvent reports whether the payment has received the event id.
+func (p *Payment) HasEvent(id string) bool {
+	for _, e := range p.Events {
+		if id != "" && e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Holds is what the payment takes, or may take, of the total of its order.
+func (p *Payment) Holds() float64 {
+	switch p.Status {
+	case PaymentPending, PaymentAuthorized:
+		return p.Amount
+	case PaymentCaptured, PaymentPartiallyRefunded, PaymentRefunded:
+		return p.Captured - p.Refunded
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package models
+
+// PricePoint records the price a product, or one of its variants, had from
+// EffectiveAt until the next point of the same product and variant.
+type PricePoint struct {
+	ID        string `json:"id" bson:"_id,omitempty"`
+	ProductID string `json:"product_id" bson:"product_id"`
+	// VariantID is empty for the price of the product itself. Variant
+	// points hold the price the variant sold at, its own or the product's.
+	VariantID     string   `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Price         float64  `json:"price" bson:"price"`
+	PreviousPrice *float64 `json:"previous_price,omitempty" bson:"previous_price,omitempty"`
+	EffectiveAt   string   `json:"effective_at" bson:"effective_at"`
+	Actor         string   `json:"actor" bson:"actor"`
+	RequestID     string   `json:"request_id,omitempty" bson:"request_id,omitempty"`
+}
+
+type PriceHistory struct {
+	Items []*PricePoint `json:"items"`
+	Total int64         `json:"total"`
+	Links PageLinks     `json:"links"`
+}
+
+// PriceChangeStats summarises the price changes made in a date range.
+type PriceChangeStats struct {
+	Changes   int64 `json:"changes"`
+	Products  int64 `json:"products"`
+	Increases int64 `json:"increases"`
+	Decreases int64 `json:"decreases"`
+	// AverageChangePercent averages the relative change of every change
+	// from a non-zero price.
+	AverageChangePercent float64 `json:"average_change_percent"`
+}
+
+// OrderRecomputation compares what an order charged with the catalog
+// prices in effect when it was placed.
+type OrderRecomputation struct {
+	OrderID      string           `json:"order_id"`
+	At           string           `json:"at"`
+	Lines        []RecomputedLine `json:"lines"`
+	ChargedTotal float64          `json:"charged_total"`
+	CatalogTotal float64          `json:"catalog_total"`
+	Difference   float64          `json:"difference"`
+}
+
+type RecomputedLine struct {
+	ProductID    string  `json:"product_id"`
+	VariantID    string  `json:"variant_id,omitempty"`
+	Quantity     int     `json:"quantity"`
+	ChargedPrice float64 `json:"charged_price"`
+	// CatalogPrice is nil when no price was recorded for the product by
+	// then; such lines count at their charged price in CatalogTotal.
+	CatalogPrice *float64 `json:"catalog_price"`
+	Difference   float64  `json:"difference"`
+}
@@ -1,11 +1,154 @@
 package models
 
 type Product struct {
-	ID        string  `json:"id" bson:"_id,omitempty"`
-	Name      string  `json:"name" bson:"name"`
-	Category  string  `json:"category" bson:"category"`
-	Price     float64 `json:"price" bson:"price"`
-	Stock     int     `json:"stock" bson:"stock"`
+	ID          string  `json:"id" bson:"_id,omitempty"`
+	Name        string  `json:"name" bson:"name"`
+	SKU         string  `json:"sku" bson:"sku"`
+	Type        string  `json:"type,omitempty" bson:"type,omitempty"`
+	CategoryID  string  `json:"category_id" bson:"category_id,omitempty"`
+	Category    string  `json:"category" bson:"category"`
+	Description string  `json:"description" bson:"description"`
+	Price       float64 `json:"price" bson:"price"`
+	// TaxClass picks the tax rates the product is taxed at, standard when
+	// empty.
+	TaxClass string `json:"tax_class,omitempty" bson:"tax_class,omitempty"`
+	// Cost is the average cost price of the stock. It is set when the
+	// product is created and averaged with the unit cost of the goods
+	// received on purchase orders.
+	Cost float64 `json:"cost,omitempty" bson:"cost,omitempty"`
+	// Stock is the sum of the variant stocks for products with variants,
+	// and the number of complete bundles the components allow for bundles.
+	// The stock of products, and of variants, is the sum of their Locations.
+	Stock int `json:"stock" bson:"stock"`
+	// Locations holds the stock per warehouse; bundles have none.
+	Locations []StockLevel `json:"locations,omitempty" bson:"locations,omitempty"`
+	// ReorderPoint raises a low-stock alert once the stock falls to it or
+	// below; zero raises none. ReorderQuantity is how much to reorder then.
+	ReorderPoint    int             `json:"reorder_point,omitempty" bson:"reorder_point,omitempty"`
+	ReorderQuantity int             `json:"reorder_quantity,omitempty" bson:"reorder_quantity,omitempty"`
+	Options         []ProductOption `json:"options,omitempty" bson:"options,omitempty"`
+	Variants        []Variant       `json:"variants,omitempty" bson:"variants,omitempty"`
+	Bundle          *Bundle         `json:"bundle,omitempty" bson:"bundle,omitempty"`
+	// Attributes holds the values of the attributes defined by the
+	// product's category, validated against its schema.
+	Attributes map[string]interface{} `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	// Images are managed through the image endpoints only.
+	Images    []Image `json:"images,omitempty" bson:"images,omitempty"`
+	Version   int64   `json:"version" bson:"version"`
 	CreatedAt string  `json:"created_at" bson:"created_at,omitempty"`
 	UpdatedAt string  `json:"updated_at" bson:"updated_at,omitempty"`
+	// DeletedAt and DeletedBy are set while the product is in the trash.
+	DeletedAt string `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy string `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+}
+
+// ProductPatch holds the fields of a partial product update; nil fields are left unchanged.
+// Stock is not among them: it changes through stock adjustments.
+type ProductPatch struct {
+	Name        *string  `json:"name"`
+	SKU         *string  `json:"sku"`
+	CategoryID  *string  `json:"category_id"`
+	Category    *string  `json:"category"`
+	Description *string  `json:"description"`
+	Price       *float64 `json:"price"`
+	TaxClass    *string  `json:"tax_class"`
+	Bundle      *Bundle  `json:"bundle"`
+
+	ReorderPoint    *int `json:"reorder_point"`
+	ReorderQuantity *int `json:"reorder_quantity"`
+	// Attributes are merged into the current ones; a null value removes one.
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// Apply copies the set fields of the patch onto p.
+func (pp *ProductPatch) Apply(p *Product) {
+	if pp.Name != nil {
+		p.Name = *pp.Name
+	}
+	if pp.SKU != nil {
+		p.SKU = *pp.SKU
+	}
+	if pp.CategoryID != nil {
+		p.CategoryID = *pp.CategoryID
+	}
+	if pp.Category != nil {
+		p.Category = *pp.Category
+	}
+	if pp.Description != nil {
+		p.Description = *pp.Description
+	}
+	if pp.Price != nil {
+		p.Price = *pp.Price
+	}
+	if pp.TaxClass != nil {
+		p.TaxClass = *pp.TaxClass
+	}
+	if pp.Bundle != nil {
+		p.Bundle = pp.Bundle
+	}
+	if pp.ReorderPoint != nil {
+		p.ReorderPoint = *pp.ReorderPoint
+	}
+	if pp.ReorderQuantity != nil {
+		p.ReorderQuantity = *pp.ReorderQuantity
+	}
+	if pp.Attributes != nil && p.Attributes == nil {
+		p.Attributes = map[string]interface{}{}
+	}
+	for key, value := range pp.Attributes {
+		if value == nil {
+			delete(p.Attributes, key)
+		} else {
+			p.Attributes[key] = value
+		}
+	}
+}
+
+// ProductHit is a single search result together with its relevance and the
+// matching fragments of its text fields, as HTML-escaped text with the
+// matches in <em></em>.
+type ProductHit struct {
+	Product    *Product          `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+type ProductSearchResult struct {
+	Hits []*ProductHit `json:"hits"`
+	// Fuzzy is set when no exact match was found and the hits come from
+	// typo-tolerant matching instead.
+	Fuzzy  bool           `json:"fuzzy"`
+	Facets *ProductFacets `json:"facets"`
+}
+
+// ProductFacets summarises a set of matching products for filter sidebars.
+type ProductFacets struct {
+	Categories   []FacetCount       `json:"categories"`
+	PriceRanges  []PriceRangeFacet  `json:"price_ranges"`
+	Availability AvailabilityFacets `json:"availability"`
+	// Attributes counts the values of text, enum and bool attributes.
+	Attributes []AttributeFacet `json:"attributes"`
+}
+
+// AttributeFacet counts the products per value of one attribute.
+type AttributeFacet struct {
+	Key    string       `json:"key"`
+	Values []FacetCount `json:"values"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// PriceRangeFacet counts products priced in [Min, Max); the last range has no Max.
+type PriceRangeFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max,omitempty"`
+	Count int64    `json:"count"`
+}
+
+type AvailabilityFacets struct {
+	InStock    int64 `json:"in_stock"`
+	OutOfStock int64 `json:"out_of_stock"`
 }
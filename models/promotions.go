@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidPromotion is wrapped by the errors for promotions that cannot
+// be stored as given.
+var ErrInvalidPromotion = errors.New("invalid promotion")
+
+// Promotion types.
+const (
+	// PromotionPercentage takes Value percent off the lines it applies to.
+	PromotionPercentage = "percentage"
+	// PromotionFixedAmount takes Value off the lines it applies to, shared
+	// between them in proportion to their amounts.
+	PromotionFixedAmount = "fixed_amount"
+	// PromotionFreeShipping waives the shipping fee.
+	PromotionFreeShipping = "free_shipping"
+	// PromotionBuyXGetY makes GetQuantity units free for every BuyQuantity
+	// units bought, line by line.
+	PromotionBuyXGetY = "buy_x_get_y"
+)
+
+// Promotion is a discount on orders. Promotions with a code are coupons,
+// applied to the orders and carts the code is entered for; promotions
+// without one apply automatically to every order they fit.
+type Promotion struct {
+	ID   string `json:"id" bson:"_id,omitempty"`
+	Name string `json:"name" bson:"name"`
+	// Code is kept in upper case and matched regardless of case.
+	Code  string  `json:"code,omitempty" bson:"code,omitempty"`
+	Type  string  `json:"type" bson:"type"`
+	Value float64 `json:"value,omitempty" bson:"value,omitempty"`
+	// BuyQuantity and GetQuantity are the terms of buy_x_get_y promotions.
+	BuyQuantity int `json:"buy_quantity,omitempty" bson:"buy_quantity,omitempty"`
+	GetQuantity int `json:"get_quantity,omitempty" bson:"get_quantity,omitempty"`
+	// ProductIDs and CategoryIDs limit the lines the promotion applies to;
+	// a category covers its subcategories. When both are empty it applies
+	// to every line.
+	ProductIDs  []string `json:"product_ids,omitempty" bson:"product_ids,omitempty"`
+	CategoryIDs []string `json:"category_ids,omitempty" bson:"category_ids,omitempty"`
+	// MinOrderValue is the subtotal an order needs for the promotion.
+	MinOrderValue float64 `json:"min_order_value,omitempty" bson:"min_order_value,omitempty"`
+	// StartsAt and EndsAt bound when the promotion can be used, as RFC 3339
+	// times; either can be left open.
+	StartsAt string `json:"starts_at,omitempty" bson:"starts_at,omitempty"`
+	EndsAt   string `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	// UsageLimit caps the orders the promotion is used on, PerCustomerLimit
+	// the orders of each customer; zero is unlimited. Uses counts the
+	// orders so far.
+	UsageLimit       int `json:"usage_limit,omitempty" bson:"usage_limit,omitempty"`
+	PerCustomerLimit int `json:"per_customer_limit,omitempty" bson:"per_customer_limit,omitempty"`
+	Uses             int `json:"uses" bson:"uses"`
+	// Exclusive promotions are not combined with others: an order gets
+	// either its best exclusive promotion or all the others, whichever
+	// saves more.
+	Exclusive bool `json:"exclusive" bson:"exclusive"`
+	// Active switches the promotion on and off.
+	Active    bool   `json:"active" bson:"active"`
+	Version   int64  `json:"version" bson:"version"`
+	CreatedAt string `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+type PromotionList struct {
+	Items []*Promotion `json:"items"`
+	Total int64        `json:"total"`
+	Links PageLinks    `json:"links"`
+}
+
+// Validate checks that the promotion has a name and the terms its type
+// needs, and that its validity window and limits make sense.
+func (p *Promotion) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPromotion)
+	}
+	switch p.Type {
+	case PromotionPercentage:
+		if p.Value <= 0 || p.Value > 100 {
+			return fmt.Errorf("%w: a percentage needs a value above 0 and at most 100", ErrInvalidPromotion)
+		}
+	case PromotionFixedAmount:
+		if p.Value <= 0 {
+			return fmt.Errorf("%w: a fixed amount needs a positive value", ErrInvalidPromotion)
+		}
+	case PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return fmt.Errorf("%w: buy_x_get_y needs a positive buy_quantity and get_quantity", ErrInvalidPromotion)
+		}
+	case PromotionFreeShipping:
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidPromotion, p.Type)
+	}
+	if p.MinOrderValue < 0 || p.UsageLimit < 0 || p.PerCustomerLimit < 0 {
+		return fmt.Errorf("%w: min_order_value and limits cannot be negative", ErrInvalidPromotion)
+	}
+	var starts, ends time.Time
+	for _, t := range []struct {
+		raw string
+		at  *time.Time
+	}{{p.StartsAt, &starts}, {p.EndsAt, &ends}} {
+		if t.raw == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, t.raw)
+		if err != nil {
+			return fmt.Errorf("%w: starts_at and ends_at must be RFC 3339 times", ErrInvalidPromotion)
+		}
+		*t.at = at
+	}
+	if !starts.IsZero() && !ends.IsZero() && !ends.After(starts) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPromotion)
+	}
+	return nil
+}
+
+// Live reports whether the promotion is active and within its validity
+// window at the given time.
+func (p *Promotion) Live(at time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if starts, err := time.Parse(time.RFC3339, p.StartsAt); err == nil && at.Before(starts) {
+		return false
+	}
+	if ends, err := time.Parse(time.RFC3339, p.EndsAt); err == nil && !at.Before(ends) {
+		return false
+	}
+	return true
+}
+
+// UsedUp reports whether the promotion has reached its usage limit.
+func (p *Promotion) UsedUp() bool {
+	return p.UsageLimit > 0 && p.Uses >= p.UsageLimit
+}
+
+// AppliedDiscount is a promotion applied to an order or cart, and what it
+// took off.
+type AppliedDiscount struct {
+	PromotionID string  `json:"promotion_id" bson:"promotion_id"`
+	Name        string  `json:"name" bson:"name"`
+	Code        string  `json:"code,omitempty" bson:"code,omitempty"`
+	Type        string  `json:"type" bson:"type"`
+	Amount      float64 `json:"amount" bson:"amount"`
+}
+
+// RejectedCoupon is a coupon code that was not applied, and why.
+type RejectedCoupon struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+	// Outranked is set for usable coupons left out because promotions
+	// they cannot be combined with save more.
+	Outranked bool `json:"outranked,omitempty"`
+}
@@ -0,0 +1,87 @@
+package models
+
+// Supplier is a company products are bought from.
+type Supplier struct {
+	ID      string `json:"id" bson:"_id,omitempty"`
+	Name    string `json:"name" bson:"name"`
+	Email   string `json:"email,omitempty" bson:"email,omitempty"`
+	Phone   string `json:"phone,omitempty" bson:"phone,omitempty"`
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+	// LeadTimeDays is how long the supplier usually takes to deliver.
+	LeadTimeDays int    `json:"lead_time_days,omitempty" bson:"lead_time_days,omitempty"`
+	Note         string `json:"note,omitempty" bson:"note,omitempty"`
+	Version      int64  `json:"version" bson:"version"`
+	CreatedAt    string `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt    string `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+type SupplierList struct {
+	Items []*Supplier `json:"items"`
+	Total int64       `json:"total"`
+	Links PageLinks   `json:"links"`
+}
+
+// Purchase order statuses. A draft can still be edited; once sent to the
+// supplier, goods are received against it, possibly in several deliveries.
+// Drafts and sent orders that have received nothing can be cancelled.
+const (
+	PurchaseOrderDraft             = "draft"
+	PurchaseOrderSent              = "sent"
+	PurchaseOrderPartiallyReceived = "partially_received"
+	PurchaseOrderReceived          = "received"
+	PurchaseOrderCancelled         = "cancelled"
+)
+
+// PurchaseOrder orders products from a supplier to restock a warehouse.
+type PurchaseOrder struct {
+	ID         string `json:"id" bson:"_id,omitempty"`
+	SupplierID string `json:"supplier_id" bson:"supplier_id"`
+	// WarehouseID is where the goods are received; it defaults to the
+	// default warehouse when the purchase order is created.
+	WarehouseID string              `json:"warehouse_id" bson:"warehouse_id"`
+	Lines       []PurchaseOrderLine `json:"lines" bson:"lines"`
+	// ExpectedDate is when the goods are expected, YYYY-MM-DD.
+	ExpectedDate string `json:"expected_date,omitempty" bson:"expected_date,omitempty"`
+	Note         string `json:"note,omitempty" bson:"note,omitempty"`
+	// TotalCost is the cost of every line, received or not.
+	TotalCost  float64 `json:"total_cost" bson:"total_cost"`
+	Status     string  `json:"status" bson:"status"`
+	Version    int64   `json:"version" bson:"version"`
+	CreatedAt  string  `json:"created_at" bson:"created_at"`
+	UpdatedAt  string  `json:"updated_at" bson:"updated_at"`
+	SentAt     string  `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	ReceivedAt string  `json:"received_at,omitempty" bson:"received_at,omitempty"`
+}
+
+type PurchaseOrderLine struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	VariantID string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+	// Received is how much of Quantity has been received so far.
+	Received int     `json:"received" bson:"received"`
+	UnitCost float64 `json:"unit_cost" bson:"unit_cost"`
+}
+
+// Remaining is how much of the line is still to be received.
+func (l PurchaseOrderLine) Remaining() int {
+	return l.Quantity - l.Received
+}
+
+type PurchaseOrderList struct {
+	Items []*PurchaseOrder `json:"items"`
+	Total int64            `json:"total"`
+	Links PageLinks        `json:"links"`
+}
+
+// GoodsReceipt lists the goods delivered against a purchase order.
+type GoodsReceipt struct {
+	// Lines lists what was delivered; empty receives everything still
+	// outstanding.
+	Lines []ReceiptLine `json:"lines"`
+}
+
+type ReceiptLine struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id,omitempty"`
+	Quantity  int    `json:"quantity"`
+}
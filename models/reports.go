@@ -0,0 +1,55 @@
+package models
+
+// SalesReport summarises the orders created in a date range.
+type SalesReport struct {
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Orders int64  `json:"orders"`
+	// Revenue is what the orders were charged, shipping included and tax
+	// left out, less what returns refunded. Tax is the tax charged less
+	// the tax refunded, broken down by rate in Taxes. Refunded is what
+	// returns refunded, tax included.
+	Revenue  float64    `json:"revenue"`
+	Tax      float64    `json:"tax"`
+	Taxes    []TaxSales `json:"taxes"`
+	Refunded float64    `json:"refunded"`
+	// Cost is what the ordered goods cost, at the average cost of each
+	// product when the order was placed, less the cost of goods returned
+	// to stock; Margin is Revenue less Cost.
+	Cost   float64 `json:"cost"`
+	Margin float64 `json:"margin"`
+	// Customers is ordered by revenue, highest first.
+	Customers []CustomerSales `json:"customers"`
+	// Products attributes bundle revenue to the bundle components, so it
+	// shows what was actually shipped, and leaves out returned goods.
+	// Ordered by revenue, highest first.
+	Products []ProductSales `json:"products"`
+	// PriceChanges covers the catalog price changes made in the range.
+	PriceChanges PriceChangeStats `json:"price_changes"`
+}
+
+type CustomerSales struct {
+	CustomerID string  `json:"customer_id" bson:"customer_id"`
+	Orders     int64   `json:"orders" bson:"orders"`
+	Revenue    float64 `json:"revenue" bson:"revenue"`
+}
+
+type ProductSales struct {
+	ProductID string  `json:"product_id" bson:"product_id"`
+	VariantID string  `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity  int64   `json:"quantity" bson:"quantity"`
+	Revenue   float64 `json:"revenue" bson:"revenue"`
+	Cost      float64 `json:"cost" bson:"cost"`
+	Margin    float64 `json:"margin" bson:"-"`
+}
+
+// TaxSales is the tax charged at one rate, ordered by region and class.
+type TaxSales struct {
+	Name     string  `json:"name" bson:"name"`
+	Country  string  `json:"country" bson:"country"`
+	Region   string  `json:"region,omitempty" bson:"region,omitempty"`
+	TaxClass string  `json:"tax_class" bson:"tax_class"`
+	Rate     float64 `json:"rate" bson:"rate"`
+	Taxable  float64 `json:"taxable" bson:"taxable"`
+	Amount   float64 `json:"amount" bson:"amount"`
+}
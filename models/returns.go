@@ -0,0 +1,77 @@
+package models
+
+// Return statuses. A return is requested, then approved or rejected; the
+// goods of an approved return are received back, and restocked unless
+// damaged, and the return is then refunded.
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnRefunded  = "refunded"
+)
+
+// ReturnableOrderStatuses are the statuses of orders whose goods can be
+// returned: those that have been shipped.
+var ReturnableOrderStatuses = []string{
+	OrderStatusShipped, OrderStatusDelivered, OrderStatusCompleted, OrderStatusPartiallyRefunded,
+}
+
+// Return is a request to send back goods of an order, an RMA.
+type Return struct {
+	ID         string       `json:"id" bson:"_id,omitempty"`
+	OrderID    string       `json:"order_id" bson:"order_id"`
+	CustomerID string       `json:"customer_id" bson:"customer_id"`
+	Lines      []ReturnLine `json:"lines" bson:"lines"`
+	Status     string       `json:"status" bson:"status"`
+	// Note is left by staff approving or rejecting the return.
+	Note string `json:"note,omitempty" bson:"note,omitempty"`
+	// WarehouseID is where the goods were restocked, when they were all
+	// restocked at one warehouse.
+	WarehouseID string `json:"warehouse_id,omitempty" bson:"warehouse_id,omitempty"`
+	// Refund is what the return refunded, tax included, and Tax the tax
+	// in it. PaymentRefunded is the part given back through the payments
+	// of the order; the rest was refunded outside them.
+	Refund          float64 `json:"refund" bson:"refund"`
+	Tax             float64 `json:"tax" bson:"tax"`
+	PaymentRefunded float64 `json:"payment_refunded" bson:"payment_refunded"`
+	Version         int64   `json:"version" bson:"version"`
+	CreatedAt       string  `json:"created_at" bson:"created_at"`
+	UpdatedAt       string  `json:"updated_at" bson:"updated_at"`
+	ReceivedAt      string  `json:"received_at,omitempty" bson:"received_at,omitempty"`
+	RefundedAt      string  `json:"refunded_at,omitempty" bson:"refunded_at,omitempty"`
+}
+
+// ReturnLine is a quantity of an order line sent back.
+type ReturnLine struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	VariantID string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	// Line is the index of the order line returned, filled in when the
+	// return is requested.
+	Line     int    `json:"line" bson:"line"`
+	Quantity int    `json:"quantity" bson:"quantity"`
+	Reason   string `json:"reason,omitempty" bson:"reason,omitempty"`
+	// Restocked is how much of Quantity went back into stock when the
+	// goods were received.
+	Restocked int `json:"restocked" bson:"restocked"`
+	// Refund and Tax are the line's share of the refund of the return.
+	Refund float64 `json:"refund" bson:"refund"`
+	Tax    float64 `json:"tax" bson:"tax"`
+}
+
+type ReturnList struct {
+	Items []*Return `json:"items"`
+	Total int64     `json:"total"`
+	Links PageLinks `json:"links"`
+}
+
+// ReturnReceipt records the goods of a return received back.
+type ReturnReceipt struct {
+	// WarehouseID names where the goods are restocked; when empty, each
+	// goes back to the warehouse it was shipped from, or else to the
+	// default warehouse.
+	WarehouseID string `json:"warehouse_id"`
+	// Damaged lists goods that came back unfit for sale and are not
+	// restocked.
+	Damaged []ReceiptLine `json:"damaged"`
+}
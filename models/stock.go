@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Stock movement types.
+const (
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementReturn     = "return"
+	MovementAdjustment = "adjustment"
+	MovementTransfer   = "transfer"
+)
+
+// StockMovement is an entry of the append-only stock ledger. The stock of a
+// product, or of one of its variants, is the sum of its movements.
+type StockMovement struct {
+	ID        string `json:"id" bson:"_id,omitempty"`
+	ProductID string `json:"product_id" bson:"product_id"`
+	// VariantID is empty for products without variants.
+	VariantID   string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	WarehouseID string `json:"warehouse_id" bson:"warehouse_id"`
+	Type        string `json:"type" bson:"type"`
+	// Quantity is added to the stock, so it is negative for stock going out.
+	Quantity   int    `json:"quantity" bson:"quantity"`
+	Reason     string `json:"reason,omitempty" bson:"reason,omitempty"`
+	OrderID    string `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	TransferID string `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
+	// PurchaseOrderID and UnitCost are set on goods received on a purchase
+	// order; the unit cost is averaged into the cost of the product.
+	PurchaseOrderID string  `json:"purchase_order_id,omitempty" bson:"purchase_order_id,omitempty"`
+	UnitCost        float64 `json:"unit_cost,omitempty" bson:"unit_cost,omitempty"`
+	Actor           string  `json:"actor" bson:"actor"`
+	RequestID       string  `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	CreatedAt       string  `json:"created_at" bson:"created_at"`
+}
+
+type StockMovementList struct {
+	Items []*StockMovement `json:"items"`
+	Total int64            `json:"total"`
+	Links PageLinks        `json:"links"`
+}
+
+// StockAdjustment is a manual change of stock: goods received, goods
+// returned by a customer, or a correction such as a stock count.
+type StockAdjustment struct {
+	VariantID string `json:"variant_id"`
+	// WarehouseID names where the stock changes; the default warehouse
+	// when empty.
+	WarehouseID string `json:"warehouse_id"`
+	// Type is receipt, return or adjustment; adjustment when empty.
+	Type     string `json:"type"`
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+	// OrderID names the order a return came from.
+	OrderID string `json:"order_id"`
+}
+
+var ErrInvalidAdjustment = errors.New("invalid stock adjustment")
+
+// Validate checks the adjustment, defaulting its type.
+func (a *StockAdjustment) Validate() error {
+	if a.Type == "" {
+		a.Type = MovementAdjustment
+	}
+	switch {
+	case a.Type != MovementReceipt && a.Type != MovementReturn && a.Type != MovementAdjustment:
+		return fmt.Errorf("%w: type must be receipt, return or adjustment", ErrInvalidAdjustment)
+	case a.Quantity == 0:
+		return fmt.Errorf("%w: quantity must not be zero", ErrInvalidAdjustment)
+	case a.Type != MovementAdjustment && a.Quantity < 0:
+		return fmt.Errorf("%w: a %s adds stock, so its quantity must be positive", ErrInvalidAdjustment, a.Type)
+	case a.Type == MovementAdjustment && a.Reason == "":
+		return fmt.Errorf("%w: an adjustment needs a reason", ErrInvalidAdjustment)
+	case a.OrderID != "" && a.Type != MovementReturn:
+		return fmt.Errorf("%w: only returns name an order", ErrInvalidAdjustment)
+	}
+	return nil
+}
+
+// StockReconciliation compares the stock cached on products with the
+// stock their ledger adds up to.
+type StockReconciliation struct {
+	CheckedAt string `json:"checked_at"`
+	// Checked counts the stock levels compared: one per product without
+	// variants and one per variant, plus one for each of their locations.
+	// Bundles keep no stock of their own.
+	Checked    int64           `json:"checked"`
+	Mismatches []StockMismatch `json:"mismatches"`
+}
+
+type StockMismatch struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id,omitempty"`
+	// WarehouseID is set for the stock at one warehouse, and empty for
+	// the total over all of them.
+	WarehouseID string `json:"warehouse_id,omitempty"`
+	Name        string `json:"name"`
+	Cached      int    `json:"cached"`
+	Ledger      int    `json:"ledger"`
+	// Difference is Cached minus Ledger.
+	Difference int `json:"difference"`
+}
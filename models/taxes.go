@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidTaxRate is wrapped by the errors for tax rates that cannot be
+// stored as given.
+var ErrInvalidTaxRate = errors.New("invalid tax rate")
+
+// TaxClassStandard is the tax class of products that name none.
+const TaxClassStandard = "standard"
+
+// Tax rounding modes.
+const (
+	// TaxRoundPerLine rounds the tax of every line to the cent and adds the
+	// rounded amounts up.
+	TaxRoundPerLine = "line"
+	// TaxRoundPerOrder adds up the exact tax of the lines taxed at each rate
+	// and rounds the sum, so the order total carries no accumulated
+	// rounding; the line amounts are still shown rounded.
+	TaxRoundPerOrder = "order"
+)
+
+// TaxRate is the rate a tax class is taxed at in a region. A rate without
+// a region covers the whole country; a rate for the region of an address
+// takes precedence over it. Classes without a rate in a region are not
+// taxed there.
+type TaxRate struct {
+	ID   string `json:"id" bson:"_id,omitempty"`
+	Name string `json:"name" bson:"name"`
+	// Country is an ISO 3166-1 code and Region a state or province code
+	// within it, both kept in upper case and matched against the shipping
+	// address of orders.
+	Country  string `json:"country" bson:"country"`
+	Region   string `json:"region,omitempty" bson:"region"`
+	TaxClass string `json:"tax_class" bson:"tax_class"`
+	// Rate is a percentage.
+	Rate      float64 `json:"rate" bson:"rate"`
+	Version   int64   `json:"version" bson:"version"`
+	CreatedAt string  `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt string  `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+type TaxRateList struct {
+	Items []*TaxRate `json:"items"`
+	Total int64      `json:"total"`
+	Links PageLinks  `json:"links"`
+}
+
+// Validate checks that the rate has a name, a country and a percentage
+// between 0 and 100.
+func (r *TaxRate) Validate() error {
+	if r.Name == "" || r.Country == "" {
+		return fmt.Errorf("%w: name and country are required", ErrInvalidTaxRate)
+	}
+	if r.Rate < 0 || r.Rate > 100 {
+		return fmt.Errorf("%w: rate must be a percentage between 0 and 100", ErrInvalidTaxRate)
+	}
+	return nil
+}
+
+// OrderTax is the tax of an order at one rate: what it was charged on and
+// how much.
+type OrderTax struct {
+	RateID   string  `json:"rate_id" bson:"rate_id"`
+	Name     string  `json:"name" bson:"name"`
+	Country  string  `json:"country" bson:"country"`
+	Region   string  `json:"region,omitempty" bson:"region,omitempty"`
+	TaxClass string  `json:"tax_class" bson:"tax_class"`
+	Rate     float64 `json:"rate" bson:"rate"`
+	Taxable  float64 `json:"taxable" bson:"taxable"`
+	Amount   float64 `json:"amount" bson:"amount"`
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MaxVariants bounds the size of a product's option matrix.
+const MaxVariants = 100
+
+// ErrInvalidVariants is wrapped by every variant validation error.
+var ErrInvalidVariants = errors.New("invalid variants")
+
+// ProductOption is one axis of a product's option matrix, e.g. "size" with
+// the values S, M and L.
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name"`
+	Values []string `json:"values" bson:"values"`
+}
+
+// Variant is one sellable combination of option values, such as a red
+// t-shirt in size M, with its own SKU and stock.
+type Variant struct {
+	ID      string            `json:"id" bson:"id"`
+	SKU     string            `json:"sku" bson:"sku"`
+	Options map[string]string `json:"options" bson:"options"`
+	// Price overrides the product price when set.
+	Price *float64 `json:"price,omitempty" bson:"price,omitempty"`
+	Stock int      `json:"stock" bson:"stock"`
+}
+
+// VariantPatch holds the fields of a partial variant update; nil fields are left unchanged.
+type VariantPatch struct {
+	SKU   *string  `json:"sku"`
+	Price *float64 `json:"price"`
+	// ClearPrice drops the price override so the product price applies again.
+	ClearPrice bool `json:"clear_price"`
+}
+
+// Apply copies the set fields of the patch onto v.
+func (vp *VariantPatch) Apply(v *Variant) {
+	if vp.SKU != nil {
+		v.SKU = *vp.SKU
+	}
+	if vp.Price != nil {
+		v.Price = vp.Price
+	}
+	if vp.ClearPrice {
+		v.Price = nil
+	}
+}
+
+// Variant returns the variant with the given ID, or nil.
+func (p *Product) Variant(id string) *Variant {
+	for i := range p.Variants {
+		if p.Variants[i].ID == id {
+			return &p.Variants[i]
+		}
+	}
+	return nil
+}
+
+// PriceOf returns the unit price of a variant, falling back to the product
+// price when the variant has no override or variantID is empty.
+func (p *Product) PriceOf(variantID string) float64 {
+	if v := p.Variant(variantID); v != nil && v.Price != nil {
+		return *v.Price
+	}
+	return p.Price
+}
+
+// ValidateVariants checks that every variant picks exactly one known value
+// for each option, and that no two variants share a combination or SKU.
+func (p *Product) ValidateVariants() error {
+	names := map[string]bool{}
+	for _, o := range p.Options {
+		if o.Name == "" || len(o.Values) == 0 {
+			return fmt.Errorf("%w: option %q needs a name and at least one value", ErrInvalidVariants, o.Name)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("%w: duplicate option %q", ErrInvalidVariants, o.Name)
+		}
+		names[o.Name] = true
+	}
+	if len(p.Variants) > MaxVariants {
+		return fmt.Errorf("%w: at most %d variants are allowed", ErrInvalidVariants, MaxVariants)
+	}
+
+	combinations := map[string]bool{}
+	skus := map[string]bool{}
+	for _, v := range p.Variants {
+		if !p.inMatrix(v.Options) {
+			return fmt.Errorf("%w: variant %q does not match the product options", ErrInvalidVariants, v.SKU)
+		}
+		key := p.combinationKey(v.Options)
+		if combinations[key] {
+			return fmt.Errorf("%w: more than one variant for %s", ErrInvalidVariants, key)
+		}
+		combinations[key] = true
+		if v.SKU == "" || skus[v.SKU] {
+			return fmt.Errorf("%w: variant SKUs must be set and unique, got %q", ErrInvalidVariants, v.SKU)
+		}
+		skus[v.SKU] = true
+		if v.Stock < 0 {
+			return fmt.Errorf("%w: variant %q has negative stock", ErrInvalidVariants, v.SKU)
+		}
+	}
+	return nil
+}
+
+// PruneVariants drops the variants that no longer fit the option matrix,
+// e.g. after an option value was removed, and returns how many it dropped.
+func (p *Product) PruneVariants() int {
+	kept := p.Variants[:0]
+	for _, v := range p.Variants {
+		if p.inMatrix(v.Options) {
+			kept = append(kept, v)
+		}
+	}
+	dropped := len(p.Variants) - len(kept)
+	p.Variants = kept
+	return dropped
+}
+
+// GenerateVariants adds a variant for every combination of option values
+// that does not have one yet. Existing variants keep their ID, SKU, price
+// and stock. New variants have no ID; storage assigns one on save.
+func (p *Product) GenerateVariants() (int, error) {
+	total := 1
+	for _, o := range p.Options {
+		total *= len(o.Values)
+		if total > MaxVariants {
+			return 0, fmt.Errorf("%w: the options describe more than %d variants", ErrInvalidVariants, MaxVariants)
+		}
+	}
+	if len(p.Options) == 0 {
+		return 0, nil
+	}
+
+	existing := map[string]bool{}
+	for _, v := range p.Variants {
+		existing[p.combinationKey(v.Options)] = true
+	}
+
+	added := 0
+	for _, combination := range p.combinations() {
+		if existing[p.combinationKey(combination)] {
+			continue
+		}
+		p.Variants = append(p.Variants, Variant{
+			SKU:     p.variantSKU(combination),
+			Options: combination,
+		})
+		added++
+	}
+	return added, nil
+}
+
+// combinations lists every combination of option values in option order.
+func (p *Product) combinations() []map[string]string {
+	result := []map[string]string{{}}
+	for _, o := range p.Options {
+		next := make([]map[string]string, 0, len(result)*len(o.Values))
+		for _, partial := range result {
+			for _, value := range o.Values {
+				c := make(map[string]string, len(partial)+1)
+				for k, v := range partial {
+					c[k] = v
+				}
+				c[o.Name] = value
+				next = append(next, c)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+func (p *Product) inMatrix(values map[string]string) bool {
+	if len(values) != len(p.Options) {
+		return false
+	}
+	for _, o := range p.Options {
+		value, ok := values[o.Name]
+		if !ok || !slices.Contains(o.Values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// combinationKey renders values in option order, e.g. "color=red,size=M".
+func (p *Product) combinationKey(values map[string]string) string {
+	parts := make([]string, len(p.Options))
+	for i, o := range p.Options {
+		parts[i] = o.Name + "=" + values[o.Name]
+	}
+	return strings.Join(parts, ",")
+}
+
+// variantSKU derives a SKU such as "TS-RED-M" from the product SKU and the
+// option values.
+func (p *Product) variantSKU(values map[string]string) string {
+	parts := []string{p.SKU}
+	if p.SKU == "" {
+		parts[0] = skuPart(p.Name)
+	}
+	for _, o := range p.Options {
+		parts = append(parts, skuPart(values[o.Name]))
+	}
+	return strings.Join(parts, "-")
+}
+
+func skuPart(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), ""))
+}
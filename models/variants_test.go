@@ -0,0 +1,183 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func shirt(variants ...Variant) *Product {
+	return &Product{
+		Name:  "T-Shirt",
+		SKU:   "TS",
+		Price: 20,
+		Options: []ProductOption{
+			{Name: "color", Values: []string{"red", "blue"}},
+			{Name: "size", Values: []string{"M", "L"}},
+		},
+		Variants: variants,
+	}
+}
+
+func TestPriceOf(t *testing.T) {
+	override := 25.0
+	p := shirt(
+		Variant{ID: "a", Options: map[string]string{"color": "red", "size": "M"}},
+		Variant{ID: "b", Options: map[string]string{"color": "red", "size": "L"}, Price: &override},
+	)
+
+	tests := []struct {
+		variantID string
+		want      float64
+	}{
+		{"", 20},
+		{"a", 20},
+		{"b", 25},
+		{"unknown", 20},
+	}
+	for _, tt := range tests {
+		if got := p.PriceOf(tt.variantID); got != tt.want {
+			t.Errorf("PriceOf(%q) = %v, want %v", tt.variantID, got, tt.want)
+		}
+	}
+}
+
+func TestValidateVariants(t *testing.T) {
+	redM := map[string]string{"color": "red", "size": "M"}
+	redL := map[string]string{"color": "red", "size": "L"}
+
+	tests := []struct {
+		name    string
+		product *Product
+		isErr   bool
+	}{
+		{name: "no options", product: &Product{}},
+		{name: "valid", product: shirt(
+			Variant{SKU: "TS-RED-M", Options: redM, Stock: 3},
+			Variant{SKU: "TS-RED-L", Options: redL},
+		)},
+		{name: "option without values", product: &Product{Options: []ProductOption{{Name: "size"}}}, isErr: true},
+		{name: "option without name", product: &Product{Options: []ProductOption{{Values: []string{"M"}}}}, isErr: true},
+		{name: "duplicate option", product: &Product{Options: []ProductOption{
+			{Name: "size", Values: []string{"M"}},
+			{Name: "size", Values: []string{"L"}},
+		}}, isErr: true},
+		{name: "unknown value", product: shirt(
+			Variant{SKU: "TS-GREEN-M", Options: map[string]string{"color": "green", "size": "M"}},
+		), isErr: true},
+		{name: "missing option", product: shirt(
+			Variant{SKU: "TS-RED", Options: map[string]string{"color": "red"}},
+		), isErr: true},
+		{name: "duplicate combination", product: shirt(
+			Variant{SKU: "A", Options: redM},
+			Variant{SKU: "B", Options: redM},
+		), isErr: true},
+		{name: "duplicate sku", product: shirt(
+			Variant{SKU: "A", Options: redM},
+			Variant{SKU: "A", Options: redL},
+		), isErr: true},
+		{name: "empty sku", product: shirt(Variant{Options: redM}), isErr: true},
+		{name: "negative stock", product: shirt(Variant{SKU: "A", Options: redM, Stock: -1}), isErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.product.ValidateVariants()
+			if tt.isErr && !errors.Is(err, ErrInvalidVariants) {
+				t.Errorf("error = %v, want ErrInvalidVariants", err)
+			}
+			if !tt.isErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	tests := []struct {
+		name      string
+		product   *Product
+		wantAdded int
+		wantSKUs  []string
+		isErr     bool
+	}{
+		{
+			name:      "no options",
+			product:   &Product{SKU: "TS"},
+			wantAdded: 0,
+			wantSKUs:  nil,
+		},
+		{
+			name:      "full matrix",
+			product:   shirt(),
+			wantAdded: 4,
+			wantSKUs:  []string{"TS-RED-M", "TS-RED-L", "TS-BLUE-M", "TS-BLUE-L"},
+		},
+		{
+			name: "existing variants kept",
+			product: shirt(Variant{
+				ID: "a", SKU: "CUSTOM", Options: map[string]string{"color": "blue", "size": "L"}, Stock: 5,
+			}),
+			wantAdded: 3,
+			wantSKUs:  []string{"CUSTOM", "TS-RED-M", "TS-RED-L", "TS-BLUE-M"},
+		},
+		{
+			name: "sku from the name",
+			product: &Product{Name: "Cotton tee", Options: []ProductOption{
+				{Name: "size", Values: []string{"x l"}},
+			}},
+			wantAdded: 1,
+			wantSKUs:  []string{"COTTONTEE-XL"},
+		},
+		{
+			name: "too many combinations",
+			product: &Product{Options: []ProductOption{
+				{Name: "a", Values: make([]string, 11)},
+				{Name: "b", Values: make([]string, 10)},
+			}},
+			isErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, err := tt.product.GenerateVariants()
+			if tt.isErr {
+				if !errors.Is(err, ErrInvalidVariants) {
+					t.Errorf("error = %v, want ErrInvalidVariants", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateVariants: %v", err)
+			}
+			var skus []string
+			for _, v := range tt.product.Variants {
+				skus = append(skus, v.SKU)
+			}
+			if added != tt.wantAdded || !reflect.DeepEqual(skus, tt.wantSKUs) {
+				t.Errorf("got %d %v, want %d %v", added, skus, tt.wantAdded, tt.wantSKUs)
+			}
+		})
+	}
+}
+
+func TestPruneVariants(t *testing.T) {
+	p := shirt(
+		Variant{SKU: "TS-RED-M", Options: map[string]string{"color": "red", "size": "M"}},
+		Variant{SKU: "TS-GREEN-M", Options: map[string]string{"color": "green", "size": "M"}},
+		Variant{SKU: "TS-RED", Options: map[string]string{"color": "red"}},
+		Variant{SKU: "TS-BLUE-L", Options: map[string]string{"color": "blue", "size": "L"}},
+	)
+
+	if dropped := p.PruneVariants(); dropped != 2 {
+		t.Errorf("dropped %d, want 2", dropped)
+	}
+	var skus []string
+	for _, v := range p.Variants {
+		skus = append(skus, v.SKU)
+	}
+	if want := []string{"TS-RED-M", "TS-BLUE-L"}; !reflect.DeepEqual(skus, want) {
+		t.Errorf("kept %v, want %v", skus, want)
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import "math"
+
+// Warehouse is a location stock is kept at and orders ship from.
+type Warehouse struct {
+	ID      string `json:"id" bson:"_id,omitempty"`
+	Code    string `json:"code" bson:"code"`
+	Name    string `json:"name" bson:"name"`
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+	// Location is where the warehouse is, for the nearest fulfillment
+	// strategy.
+	Location *GeoPoint `json:"location,omitempty" bson:"location,omitempty"`
+	// Priority orders warehouses for the priority fulfillment strategy,
+	// lowest first. The first warehouse by priority is the default one,
+	// which new products keep their opening stock in.
+	Priority  int    `json:"priority" bson:"priority"`
+	Version   int64  `json:"version" bson:"version"`
+	CreatedAt string `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at" bson:"updated_at,omitempty"`
+}
+
+type GeoPoint struct {
+	Lat float64 `json:"lat" bson:"lat"`
+	Lng float64 `json:"lng" bson:"lng"`
+}
+
+// DistanceKm returns the great-circle distance between p and q.
+func (p GeoPoint) DistanceKm(q GeoPoint) float64 {
+	const earthRadiusKm = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := rad(q.Lat-p.Lat), rad(q.Lng-p.Lng)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(p.Lat))*math.Cos(rad(q.Lat))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// StockLevel is the stock of a product, or of one of its variants, at one
+// warehouse.
+type StockLevel struct {
+	WarehouseID string `json:"warehouse_id" bson:"warehouse_id"`
+	VariantID   string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Stock       int    `json:"stock" bson:"stock"`
+}
+
+// WarehouseStock is what a product has in stock at one warehouse.
+type WarehouseStock struct {
+	WarehouseID string `json:"warehouse_id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Stock       int    `json:"stock"`
+	// Variants holds the stock of each variant, by variant ID.
+	Variants map[string]int `json:"variants,omitempty"`
+}
+
+// Transfer statuses. A transfer takes its stock out of the source
+// warehouse when it ships and puts it into the destination when it is
+// received; in between the stock is in neither.
+const (
+	TransferPending   = "pending"
+	TransferInTransit = "in_transit"
+	TransferReceived  = "received"
+	TransferCancelled = "cancelled"
+)
+
+// Transfer moves stock from one warehouse to another.
+type Transfer struct {
+	ID              string         `json:"id" bson:"_id,omitempty"`
+	FromWarehouseID string         `json:"from_warehouse_id" bson:"from_warehouse_id"`
+	ToWarehouseID   string         `json:"to_warehouse_id" bson:"to_warehouse_id"`
+	Lines           []TransferLine `json:"lines" bson:"lines"`
+	Note            string         `json:"note,omitempty" bson:"note,omitempty"`
+	Status          string         `json:"status" bson:"status"`
+	Version         int64          `json:"version" bson:"version"`
+	CreatedAt       string         `json:"created_at" bson:"created_at"`
+	UpdatedAt       string         `json:"updated_at" bson:"updated_at"`
+	ShippedAt       string         `json:"shipped_at,omitempty" bson:"shipped_at,omitempty"`
+	ReceivedAt      string         `json:"received_at,omitempty" bson:"received_at,omitempty"`
+}
+
+type TransferLine struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	VariantID string `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+}
+
+type TransferList struct {
+	Items []*Transfer `json:"items"`
+	Total int64       `json:"total"`
+	Links PageLinks   `json:"links"`
+}
@@ -0,0 +1,52 @@
+// Package blob stores opaque files such as product images behind a small
+// interface, so the backend (local disk, GridFS or S3) is a deployment
+// choice.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned when no blob is stored under a key.
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored blob.
+type Info struct {
+	ContentType string
+	Size        int64
+}
+
+// Store keeps blobs under slash-separated keys such as
+// "products/<id>/<image id>/original".
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, info Info) error
+	// Get opens a blob; the caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether key is safe to use with every backend: relative,
+// slash-separated, with no empty, "." or ".." segments.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func checkKey(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSStore keeps blobs in a GridFS bucket of the application database,
+// using the key as the file name.
+type GridFSStore struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFSStore(db *mongo.Database, bucketName string) (*GridFSStore, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSStore{bucket: bucket}, nil
+}
+
+type gridfsMetadata struct {
+	ContentType string `bson:"content_type"`
+}
+
+func (s *GridFSStore) Put(ctx context.Context, key string, r io.Reader, info Info) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	// Replacing a blob uploads the new revision first and only then drops
+	// the older ones, so a reader always finds one.
+	old, err := s.revisions(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	opts := options.GridFSUpload().SetMetadata(gridfsMetadata{ContentType: info.ContentType})
+	if _, err := s.bucket.UploadFromStream(key, r, opts); err != nil {
+		return err
+	}
+	for _, id := range old {
+		if err := s.bucket.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GridFSStore) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	if err := checkKey(key); err != nil {
+		return nil, Info{}, err
+	}
+
+	var file struct {
+		ID       interface{}    `bson:"_id"`
+		Length   int64          `bson:"length"`
+		Metadata gridfsMetadata `bson:"metadata"`
+	}
+	opts := options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetLimit(1)
+	cursor, err := s.bucket.FindContext(ctx, bson.M{"filename": key}, opts)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, Info{}, err
+		}
+		return nil, Info{}, ErrNotFound
+	}
+	if err := cursor.Decode(&file); err != nil {
+		return nil, Info{}, err
+	}
+
+	stream, err := s.bucket.OpenDownloadStream(file.ID)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return stream, Info{ContentType: file.Metadata.ContentType, Size: file.Length}, nil
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	ids, err := s.revisions(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.bucket.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GridFSStore) revisions(ctx context.Context, key string) ([]interface{}, error) {
+	cursor, err := s.bucket.FindContext(ctx, bson.M{"filename": key})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var ids []interface{}
+	for cursor.Next(ctx) {
+		var file struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&file); err != nil {
+			return nil, err
+		}
+		ids = append(ids, file.ID)
+	}
+	return ids, cursor.Err()
+}
@@ -0,0 +1,146 @@
+// Package imaging validates uploaded images and renders thumbnails using
+// only the standard library decoders (JPEG, PNG and GIF).
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxPixels bounds the decoded size of an image, so a small file cannot
+// expand into gigabytes of memory.
+const MaxPixels = 40_000_000
+
+var (
+	// ErrUnsupportedType is returned for content that is not a JPEG, PNG or GIF.
+	ErrUnsupportedType = errors.New("unsupported image type")
+	// ErrInvalidImage is returned for content that cannot be decoded.
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// ContentTypes are the accepted image types, by the type sniffed from the
+// content rather than the one the client claims.
+var ContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Size is the box a thumbnail must fit in.
+type Size struct {
+	Width  int
+	Height int
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+// ParseSizes parses a comma-separated list such as "150x150,600x600".
+func ParseSizes(s string) ([]Size, error) {
+	var sizes []Size
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		w, h, ok := strings.Cut(part, "x")
+		width, werr := strconv.Atoi(w)
+		height, herr := strconv.Atoi(h)
+		if !ok || werr != nil || herr != nil || width <= 0 || height <= 0 || width > 4096 || height > 4096 {
+			return nil, fmt.Errorf("invalid thumbnail size %q, want WIDTHxHEIGHT", part)
+		}
+		sizes = append(sizes, Size{Width: width, Height: height})
+	}
+	return sizes, nil
+}
+
+// Decode checks the content type and dimensions of data before decoding it.
+func Decode(data []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	if !ContentTypes[contentType] {
+		return nil, contentType, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, contentType, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, contentType, fmt.Errorf("%w: %dx%d pixels is too large", ErrInvalidImage, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, contentType, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, contentType, nil
+}
+
+// Thumbnail scales img down to fit in size, keeping its aspect ratio.
+// Images that already fit are returned unscaled.
+func Thumbnail(img image.Image, size Size) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size.Width && h <= size.Height {
+		return img
+	}
+	scale := min(float64(size.Width)/float64(w), float64(size.Height)/float64(h))
+	tw := max(1, int(float64(w)*scale+0.5))
+	th := max(1, int(float64(h)*scale+0.5))
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// Box filter: every target pixel averages the source pixels it covers,
+	// which avoids the aliasing of nearest-neighbour sampling when shrinking.
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// Encode renders img as JPEG, or as PNG when the source type may carry
+// transparency. It returns the encoded bytes and their content type.
+func Encode(img image.Image, sourceType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch sourceType {
+	case "image/png", "image/gif":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	default:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+}
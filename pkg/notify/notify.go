@@ -0,0 +1,49 @@
+// Package notify delivers notifications, such as low-stock alerts, through
+// a small interface, so the channels (log, webhook, SMTP) are a deployment
+// choice.
+package notify
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// Message is a notification. Text is the human-readable body; Data is
+// passed on as it is to the channels that carry structured payloads.
+type Message struct {
+	Subject string
+	Text    string
+	Data    interface{}
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Multi delivers every message through all of its notifiers, even when
+// some of them fail.
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.Notify(ctx, msg))
+	}
+	return errors.Join(errs...)
+}
+
+// LogNotifier writes messages to a log.
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+func NewLogNotifier(logger *zap.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, msg Message) error {
+	n.logger.Warn(msg.Subject, zap.String("text", msg.Text), zap.Any("data", msg.Data))
+	return nil
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPConfig addresses a mail server. Username and Password are optional,
+// so a local test server without authentication can be used.
+type SMTPConfig struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// SMTPNotifier mails messages as plain text.
+type SMTPNotifier struct {
+	cfg  SMTPConfig
+	auth smtp.Auth
+	now  func() time.Time
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) (*SMTPNotifier, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q", cfg.Addr)
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("SMTP sender and recipients are required")
+	}
+	n := &SMTPNotifier{cfg: cfg, now: time.Now}
+	if cfg.Username != "" {
+		n.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return n, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", n.now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	// net/smtp takes no context, so a cancelled ctx only stops messages
+	// that have not started sending.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(n.cfg.Addr, n.auth, n.cfg.From, n.cfg.To, buf.Bytes())
+}
@@ -0,0 +1,55 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a keyset-paginated listing. Clients only ever
+// see it in its encoded, opaque form.
+type Cursor struct {
+	// ID is the _id of the last (or, when Backward, the first) item of the
+	// page the cursor was issued for.
+	ID string `json:"id"`
+	// Values are the sort-key values of that item, in the order of the sort
+	// keys of the listing, so the position survives the item being removed.
+	Values []interface{} `json:"v,omitempty"`
+	// Backward asks for the page preceding ID instead of the one after it.
+	Backward bool `json:"b,omitempty"`
+	// Sort is the sort parameter of the listing, as in "-price,name", so
+	// that the cursor is not used with another sort, whose keys Values
+	// would not match.
+	Sort string `json:"s,omitempty"`
+}
+
+// Positions holds the sort-key values of the items of a page by ID, as
+// recorded by the listing that fetched them.
+type Positions map[string][]interface{}
+
+// Cursor returns the cursor pointing at the item with the given ID.
+func (p Positions) Cursor(id string, backward bool) Cursor {
+	return Cursor{ID: id, Values: p[id], Backward: backward}
+}
+
+// Encode serialises the cursor into a URL-safe token.
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Decode parses a token produced by Encode.
+func Decode(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
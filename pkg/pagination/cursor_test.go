@@ -0,0 +1,80 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"id only", Cursor{ID: "64b7f0c2e4b0a1a2b3c4d5e6"}},
+		{"backward", Cursor{ID: "64b7f0c2e4b0a1a2b3c4d5e6", Backward: true}},
+		{"string value", Cursor{ID: "a", Values: []interface{}{"Widget"}}},
+		{"mixed values", Cursor{ID: "a", Values: []interface{}{9.5, "2024-01-02T15:04:05Z", nil}, Backward: true}},
+		{"sorted", Cursor{ID: "a", Values: []interface{}{9.5, "Widget"}, Sort: "-price,name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.cursor.Encode())
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.cursor) {
+				t.Errorf("got %+v, want %+v", *got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"not json", encode("cursor")},
+		{"no id", encode(`{"v":[1]}`)},
+		{"empty id", encode(`{"id":""}`)},
+		{"wrong type", encode(`{"id":1}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Decode(%q) = %v, want ErrInvalidCursor", tt.token, err)
+			}
+		})
+	}
+}
+
+func TestPositionsCursor(t *testing.T) {
+	positions := Positions{"a": {"Widget", 9.5}}
+
+	tests := []struct {
+		name     string
+		id       string
+		backward bool
+		want     Cursor
+	}{
+		{"recorded", "a", false, Cursor{ID: "a", Values: []interface{}{"Widget", 9.5}}},
+		{"recorded backward", "a", true, Cursor{ID: "a", Values: []interface{}{"Widget", 9.5}, Backward: true}},
+		{"unrecorded", "b", false, Cursor{ID: "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := positions.Cursor(tt.id, tt.backward); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Package query parses the filter and sort syntax accepted by listing
+// endpoints, e.g.
+//
+//	?filter[price][gte]=10&filter[category][in]=a,b&sort=-created_at,name
+//
+// Parsing is purely syntactic: which fields exist, and what their values
+// mean, is decided by the storage that translates a Query into a database
+// query.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalid is wrapped by every error caused by a malformed or disallowed
+// filter or sort, so callers can answer with 400.
+var ErrInvalid = errors.New("invalid query")
+
+type Op string
+
+const (
+	Eq       Op = "eq"
+	Ne       Op = "ne"
+	Gt       Op = "gt"
+	Gte      Op = "gte"
+	Lt       Op = "lt"
+	Lte      Op = "lte"
+	In       Op = "in"
+	Nin      Op = "nin"
+	Contains Op = "contains"
+)
+
+var knownOps = map[Op]bool{Eq: true, Ne: true, Gt: true, Gte: true, Lt: true, Lte: true, In: true, Nin: true, Contains: true}
+
+// Multi reports whether the operator takes a comma-separated list of values.
+func (o Op) Multi() bool {
+	return o == In || o == Nin
+}
+
+// Condition is a single filter[field][op]=value term.
+type Condition struct {
+	Field  string
+	Op     Op
+	Values []string
+}
+
+// Value returns the single operand of a non-list operator.
+func (c Condition) Value() string {
+	if len(c.Values) == 0 {
+		return ""
+	}
+	return c.Values[0]
+}
+
+// Sort is one key of a sort=... list; a leading "-" makes it descending.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+type Query struct {
+	Conditions []Condition
+	Sort       []Sort
+}
+
+var (
+	filterParam = regexp.MustCompile(`^filter\[([a-zA-Z0-9_.]+)\](?:\[([a-z]+)\])?$`)
+	fieldName   = regexp.MustCompile(`^[a-zA-Z0-9_.]+$`)
+)
+
+// Parse extracts the filter[...] and sort parameters from a query string;
+// other parameters are ignored.
+func Parse(values url.Values) (Query, error) {
+	var q Query
+
+	for key, vals := range values {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+		m := filterParam.FindStringSubmatch(key)
+		if m == nil {
+			return q, fmt.Errorf("%w: malformed parameter %q", ErrInvalid, key)
+		}
+
+		op := Eq
+		if m[2] != "" {
+			op = Op(m[2])
+		}
+		if !knownOps[op] {
+			return q, fmt.Errorf("%w: unknown operator %q", ErrInvalid, op)
+		}
+
+		for _, v := range vals {
+			cond := Condition{Field: m[1], Op: op, Values: []string{v}}
+			if op.Multi() {
+				cond.Values = splitList(v)
+			}
+			if len(cond.Values) == 0 {
+				return q, fmt.Errorf("%w: %s requires a value", ErrInvalid, key)
+			}
+			q.Conditions = append(q.Conditions, cond)
+		}
+	}
+
+	if raw := values.Get("sort"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.TrimSpace(part)
+			s := Sort{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+			if !fieldName.MatchString(s.Field) {
+				return q, fmt.Errorf("%w: malformed sort key %q", ErrInvalid, part)
+			}
+			q.Sort = append(q.Sort, s)
+		}
+	}
+
+	return q, nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,133 @@
+package query
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		want  Query
+		isErr bool
+	}{
+		{
+			name: "empty",
+			raw:  "",
+			want: Query{},
+		},
+		{
+			name: "other parameters ignored",
+			raw:  "limit=10&cursor=abc",
+			want: Query{},
+		},
+		{
+			name: "implicit eq",
+			raw:  "filter[status]=active",
+			want: Query{Conditions: []Condition{{Field: "status", Op: Eq, Values: []string{"active"}}}},
+		},
+		{
+			name: "explicit operator",
+			raw:  "filter[price][gte]=10",
+			want: Query{Conditions: []Condition{{Field: "price", Op: Gte, Values: []string{"10"}}}},
+		},
+		{
+			name: "nested field",
+			raw:  "filter[attributes.color]=red",
+			want: Query{Conditions: []Condition{{Field: "attributes.color", Op: Eq, Values: []string{"red"}}}},
+		},
+		{
+			name: "list operator splits and trims",
+			raw:  "filter[category][in]=a,%20b,,c",
+			want: Query{Conditions: []Condition{{Field: "category", Op: In, Values: []string{"a", "b", "c"}}}},
+		},
+		{
+			name: "single operator keeps commas",
+			raw:  "filter[name][contains]=a,b",
+			want: Query{Conditions: []Condition{{Field: "name", Op: Contains, Values: []string{"a,b"}}}},
+		},
+		{
+			name: "repeated parameter",
+			raw:  "filter[price][gt]=1&filter[price][gt]=2",
+			want: Query{Conditions: []Condition{
+				{Field: "price", Op: Gt, Values: []string{"1"}},
+				{Field: "price", Op: Gt, Values: []string{"2"}},
+			}},
+		},
+		{
+			name: "sort keys",
+			raw:  "sort=-created_at,%20name",
+			want: Query{Sort: []Sort{{Field: "created_at", Desc: true}, {Field: "name"}}},
+		},
+		{name: "unknown operator", raw: "filter[price][between]=1", isErr: true},
+		{name: "malformed parameter", raw: "filter[price", isErr: true},
+		{name: "malformed field", raw: "filter[pri ce]=1", isErr: true},
+		{name: "empty list", raw: "filter[category][in]=,", isErr: true},
+		{name: "malformed sort key", raw: "sort=name,", isErr: true},
+		{name: "sort key with operator", raw: "sort=$where", isErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+
+			got, err := Parse(values)
+			if tt.isErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Parse(%q) error = %v, want ErrInvalid", tt.raw, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.raw, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMultipleFields(t *testing.T) {
+	values := url.Values{
+		"filter[price][lte]": {"20"},
+		"filter[status]":     {"active"},
+	}
+
+	got, err := Parse(values)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Parameters come from a map, so conditions have no set order.
+	sort.Slice(got.Conditions, func(i, j int) bool { return got.Conditions[i].Field < got.Conditions[j].Field })
+
+	want := []Condition{
+		{Field: "price", Op: Lte, Values: []string{"20"}},
+		{Field: "status", Op: Eq, Values: []string{"active"}},
+	}
+	if !reflect.DeepEqual(got.Conditions, want) {
+		t.Errorf("got %+v, want %+v", got.Conditions, want)
+	}
+}
+
+func TestConditionValue(t *testing.T) {
+	tests := []struct {
+		cond Condition
+		want string
+	}{
+		{Condition{Values: []string{"a", "b"}}, "a"},
+		{Condition{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.Value(); got != tt.want {
+			t.Errorf("%+v.Value() = %q, want %q", tt.cond, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package reqctx carries request-scoped values, such as who is acting and
+// the request ID, from the HTTP layer down to the storage.
+package reqctx
+
+import "context"
+
+// ActorHeader names the request header identifying who makes a request.
+const ActorHeader = "X-Actor"
+
+// Anonymous is the actor of requests that do not identify one.
+const Anonymous = "anonymous"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor carried by ctx, or Anonymous.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return Anonymous
+}
+
+// RequestIDHeader names the header carrying the ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
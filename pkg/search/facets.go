@@ -0,0 +1,105 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// PriceBoundaries are the lower bounds of the price range facets; the last
+// range is open-ended.
+var PriceBoundaries = []float64{0, 10, 50, 100, 500, 1000}
+
+// MaxAttributeFacetValues caps the values listed per attribute facet.
+const MaxAttributeFacetValues = 20
+
+// Facets computes in memory what the Mongo storage computes with $facet, for
+// backends (or result sets) that are already held in memory.
+func Facets(products []*models.Product) *models.ProductFacets {
+	facets := NewFacets()
+	categories := map[string]int64{}
+	attributes := map[string]map[string]int64{}
+
+	for _, p := range products {
+		categories[p.Category]++
+		for key, value := range p.Attributes {
+			switch value.(type) {
+			case string, bool:
+			default:
+				continue
+			}
+			if attributes[key] == nil {
+				attributes[key] = map[string]int64{}
+			}
+			attributes[key][fmt.Sprint(value)]++
+		}
+		if i := priceRange(p.Price); i >= 0 {
+			facets.PriceRanges[i].Count++
+		}
+		if p.Stock > 0 {
+			facets.Availability.InStock++
+		} else {
+			facets.Availability.OutOfStock++
+		}
+	}
+
+	for value, count := range categories {
+		facets.Categories = append(facets.Categories, models.FacetCount{Value: value, Count: count})
+	}
+	SortFacetCounts(facets.Categories)
+
+	counts := make(map[string][]models.FacetCount, len(attributes))
+	for key, values := range attributes {
+		for value, count := range values {
+			counts[key] = append(counts[key], models.FacetCount{Value: value, Count: count})
+		}
+	}
+	facets.Attributes = AttributeFacets(counts)
+	return facets
+}
+
+// AttributeFacets orders per-attribute value counts by key, keeping the
+// most common values of each.
+func AttributeFacets(counts map[string][]models.FacetCount) []models.AttributeFacet {
+	facets := make([]models.AttributeFacet, 0, len(counts))
+	for key, values := range counts {
+		SortFacetCounts(values)
+		if len(values) > MaxAttributeFacetValues {
+			values = values[:MaxAttributeFacetValues]
+		}
+		facets = append(facets, models.AttributeFacet{Key: key, Values: values})
+	}
+	sort.Slice(facets, func(i, j int) bool { return facets[i].Key < facets[j].Key })
+	return facets
+}
+
+// NewFacets returns empty facets with every price range present.
+func NewFacets() *models.ProductFacets {
+	facets := &models.ProductFacets{Categories: []models.FacetCount{}, Attributes: []models.AttributeFacet{}}
+	for i, min := range PriceBoundaries {
+		r := models.PriceRangeFacet{Min: min}
+		if i+1 < len(PriceBoundaries) {
+			max := PriceBoundaries[i+1]
+			r.Max = &max
+		}
+		facets.PriceRanges = append(facets.PriceRanges, r)
+	}
+	return facets
+}
+
+// SortFacetCounts orders facet values by descending count, then by value.
+func SortFacetCounts(counts []models.FacetCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+}
+
+// priceRange returns the index of the range containing price, or -1 for
+// prices below the first boundary.
+func priceRange(price float64) int {
+	return sort.Search(len(PriceBoundaries), func(i int) bool { return PriceBoundaries[i] > price }) - 1
+}
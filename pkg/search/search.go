@@ -0,0 +1,212 @@
+// Package search holds the text-processing half of product search:
+// normalisation, trigram generation for typo-tolerant matching, similarity
+// scoring and highlight snippets. It knows nothing about storage.
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaxQueryLength bounds user-supplied search strings, in characters.
+const MaxQueryLength = 100
+
+// FuzzyThreshold is the minimum trigram similarity for a word to count as a
+// misspelling of a query term.
+const FuzzyThreshold = 0.4
+
+// Normalize lowercases s and collapses every run of non-alphanumerics into a
+// single space.
+func Normalize(s string) string {
+	return strings.Join(Terms(s), " ")
+}
+
+// Terms splits s into lowercase alphanumeric words.
+func Terms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Trigrams returns the distinct trigrams of the words in s. Each word is
+// padded with boundary markers so that short words and word starts carry
+// weight.
+func Trigrams(s string) []string {
+	seen := map[string]bool{}
+	var grams []string
+	for _, term := range Terms(s) {
+		for _, g := range wordGrams(term) {
+			if !seen[g] {
+				seen[g] = true
+				grams = append(grams, g)
+			}
+		}
+	}
+	sort.Strings(grams)
+	return grams
+}
+
+func wordGrams(word string) []string {
+	runes := []rune("$" + word + "$")
+	if len(runes) < 3 {
+		return []string{string(runes)}
+	}
+	grams := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+3]))
+	}
+	return grams
+}
+
+// Similarity is the Dice coefficient of the trigram sets of two words.
+func Similarity(a, b string) float64 {
+	ga, gb := wordGrams(a), wordGrams(b)
+	set := make(map[string]int, len(ga))
+	for _, g := range ga {
+		set[g]++
+	}
+	common := 0
+	for _, g := range gb {
+		if set[g] > 0 {
+			set[g]--
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(ga)+len(gb))
+}
+
+// Matcher decides which words of a document match a query.
+type Matcher struct {
+	terms []string
+	fuzzy bool
+}
+
+// NewMatcher builds a matcher for query. A fuzzy matcher also accepts words
+// within FuzzyThreshold of a query term; otherwise a word matches when it
+// starts with a query term.
+func NewMatcher(query string, fuzzy bool) *Matcher {
+	return &Matcher{terms: Terms(query), fuzzy: fuzzy}
+}
+
+// Match reports whether word matches one of the query terms.
+func (m *Matcher) Match(word string) bool {
+	return m.score(strings.ToLower(word)) > 0
+}
+
+func (m *Matcher) score(word string) float64 {
+	best := 0.0
+	for _, term := range m.terms {
+		if strings.HasPrefix(word, term) {
+			return 1
+		}
+		if m.fuzzy {
+			if s := Similarity(term, word); s >= FuzzyThreshold && s > best {
+				best = s
+			}
+		}
+	}
+	return best
+}
+
+// Score rates text against the query as the mean, over query terms, of the
+// best similarity to any word of text.
+func (m *Matcher) Score(text string) float64 {
+	if len(m.terms) == 0 {
+		return 0
+	}
+	words := Terms(text)
+	total := 0.0
+	for _, term := range m.terms {
+		best := 0.0
+		for _, w := range words {
+			s := 0.0
+			if strings.HasPrefix(w, term) {
+				s = 1
+			} else if m.fuzzy {
+				s = Similarity(term, w)
+			}
+			if s > best {
+				best = s
+			}
+		}
+		total += best
+	}
+	return total / float64(len(m.terms))
+}
+
+// snippetRadius is how many characters of context Highlight keeps on each
+// side of the first match in long texts.
+const snippetRadius = 60
+
+// Highlight wraps every matching word of text in <em></em>, escaping the
+// rest as HTML. Texts longer than a snippet are cut down to the
+// neighbourhood of the first match. The empty string is returned when
+// nothing matches.
+func Highlight(text string, m *Matcher) string {
+	runes := []rune(text)
+	marked, first := mark(runes, m)
+	if first < 0 {
+		return ""
+	}
+	if len(runes) <= 2*snippetRadius {
+		return marked
+	}
+
+	start, end := first-snippetRadius, first+snippetRadius
+	prefix, suffix := "…", "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= len(runes) {
+		end, suffix = len(runes), ""
+	}
+	// Don't cut words in half at either edge.
+	for start > 0 && isWordRune(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && isWordRune(runes[end]) {
+		end++
+	}
+	marked, _ = mark(runes[start:end], m)
+	return prefix + marked + suffix
+}
+
+// mark wraps matching words in <em></em>, HTML-escaping the text around
+// them, and reports the offset of the first match, or -1.
+func mark(runes []rune, m *Matcher) (string, int) {
+	var b strings.Builder
+	first := -1
+
+	for i := 0; i < len(runes); {
+		j := i
+		if !isWordRune(runes[i]) {
+			for j < len(runes) && !isWordRune(runes[j]) {
+				j++
+			}
+			b.WriteString(html.EscapeString(string(runes[i:j])))
+			i = j
+			continue
+		}
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+		// Words are letters and digits, which need no escaping.
+		word := string(runes[i:j])
+		if m.Match(word) {
+			if first < 0 {
+				first = i
+			}
+			b.WriteString("<em>" + word + "</em>")
+		} else {
+			b.WriteString(word)
+		}
+		i = j
+	}
+	return b.String(), first
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,180 @@
+package search
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"Red T-Shirt", []string{"red", "t", "shirt"}},
+		{"  USB-C  cable, 2m!", []string{"usb", "c", "cable", "2m"}},
+		{"Größe XL", []string{"größe", "xl"}},
+	}
+	for _, tt := range tests {
+		if got := Terms(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Terms(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"$a$"}},
+		{"Hi hi", []string{"$hi", "hi$"}},
+		{"cat", []string{"$ca", "at$", "cat"}},
+		{"ab ba ab", []string{"$ab", "$ba", "ab$", "ba$"}},
+	}
+
+	for _, tt := range tests {
+		if got := Trigrams(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Trigrams(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"phone", "phone", 1},
+		{"a", "b", 0},
+		// $ip iph pho hon one ne$ against $ip iph pho hon on$: 4 in common.
+		{"iphone", "iphon", 8.0 / 11},
+		// $ph pho hon one ne$ against $ph phn hno noe oe$: 1 in common.
+		{"phone", "phnoe", 2.0 / 10},
+	}
+	for _, tt := range tests {
+		if got := Similarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := Similarity(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Similarity(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		fuzzy bool
+		word  string
+		want  bool
+	}{
+		{"shirt", false, "Shirts", true},
+		{"shirt", false, "tshirt", false},
+		{"iphone", false, "iphon", false},
+		{"iphone", true, "iphon", true},
+		{"phone", true, "phnoe", false},
+		{"", true, "anything", false},
+	}
+	for _, tt := range tests {
+		if got := NewMatcher(tt.query, tt.fuzzy).Match(tt.word); got != tt.want {
+			t.Errorf("NewMatcher(%q, %v).Match(%q) = %v, want %v", tt.query, tt.fuzzy, tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherScore(t *testing.T) {
+	tests := []struct {
+		query string
+		fuzzy bool
+		text  string
+		want  float64
+	}{
+		{"red shirt", false, "Red T-Shirt", 1},
+		{"red shirt", false, "Red cap", 0.5},
+		{"red shirt", false, "Blue cap", 0},
+		{"iphone", false, "Apple iPhon 15", 0},
+		{"iphone", true, "Apple iPhon 15", 8.0 / 11},
+		{"iphone case", true, "Apple iPhon case", (8.0/11 + 1) / 2},
+		{"", true, "anything", 0},
+	}
+	for _, tt := range tests {
+		got := NewMatcher(tt.query, tt.fuzzy).Score(tt.text)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("NewMatcher(%q, %v).Score(%q) = %v, want %v", tt.query, tt.fuzzy, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("filler ", 20) + "target" + strings.Repeat(" filler", 20)
+
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		fuzzy bool
+		want  string
+	}{
+		{
+			name:  "no match",
+			text:  "Red cap",
+			query: "shirt",
+			want:  "",
+		},
+		{
+			name:  "prefix match",
+			text:  "Shirts & ties",
+			query: "shirt",
+			want:  "<em>Shirts</em> &amp; ties",
+		},
+		{
+			name:  "every match marked",
+			text:  "shirt, shirt",
+			query: "shirt",
+			want:  "<em>shirt</em>, <em>shirt</em>",
+		},
+		{
+			name:  "markup escaped",
+			text:  `Red <b>shirt</b> "new"`,
+			query: "shirt",
+			want:  "Red &lt;b&gt;<em>shirt</em>&lt;/b&gt; &#34;new&#34;",
+		},
+		{
+			name:  "fuzzy match",
+			text:  "Apple iPhon",
+			query: "iphone",
+			fuzzy: true,
+			want:  "Apple <em>iPhon</em>",
+		},
+		{
+			name:  "multibyte text",
+			text:  "Größe <XL>",
+			query: "grö",
+			want:  "<em>Größe</em> &lt;XL&gt;",
+		},
+		{
+			name:  "snippet cut at word edges",
+			text:  long,
+			query: "target",
+			want:  "…" + strings.Repeat("filler ", 9) + "<em>target</em>" + strings.Repeat(" filler", 8) + "…",
+		},
+		{
+			name:  "snippet at start",
+			text:  "target" + strings.Repeat(" filler", 30),
+			query: "target",
+			want:  "<em>target</em>" + strings.Repeat(" filler", 8) + "…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highlight(tt.text, NewMatcher(tt.query, tt.fuzzy)); got != tt.want {
+				t.Errorf("Highlight(%q, %q) =\n%q\nwant\n%q", tt.text, tt.query, got, tt.want)
+			}
+		})
+	}
+}
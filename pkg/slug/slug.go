@@ -0,0 +1,13 @@
+package slug
+
+import (
+	"strings"
+
+	"github.com/udevs/lesson3/pkg/search"
+)
+
+// Make turns a display name into a URL-safe slug: "Phones & Tablets"
+// becomes "phones-tablets".
+func Make(name string) string {
+	return strings.Join(search.Terms(name), "-")
+}
@@ -0,0 +1,261 @@
+// Package suggest implements the in-process prefix index behind product
+// autocomplete. It is small enough to rebuild from the database on start
+// and is kept current by the repository decorators that feed it writes.
+package suggest
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/udevs/lesson3/pkg/search"
+)
+
+// maxDepth bounds how many runes of each key are stored in the trie; longer
+// prefixes are verified against the key itself.
+const maxDepth = 16
+
+// Item is what the index knows about one product.
+type Item struct {
+	ID   string
+	Name string
+	SKU  string
+	// VariantSKUs makes a product findable by the SKU of any of its variants.
+	VariantSKUs []string
+}
+
+// Suggestion is a ranked match for a typed prefix.
+type Suggestion struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	SKU   string  `json:"sku,omitempty"`
+	Score float64 `json:"score"`
+}
+
+type entry struct {
+	Item
+	keys       []string
+	popularity float64
+}
+
+// node is a trie node. ids holds every entry with a key passing through the
+// node, so a lookup never has to walk the subtree below the prefix.
+type node struct {
+	children map[rune]*node
+	ids      map[string]int
+}
+
+func newNode() *node {
+	return &node{children: map[rune]*node{}, ids: map[string]int{}}
+}
+
+type Index struct {
+	mu      sync.RWMutex
+	root    *node
+	entries map[string]*entry
+}
+
+func NewIndex() *Index {
+	return &Index{root: newNode(), entries: map[string]*entry{}}
+}
+
+// Put adds or replaces an item, keeping any popularity it already had.
+func (idx *Index) Put(item Item) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	popularity := 0.0
+	if old, ok := idx.entries[item.ID]; ok {
+		popularity = old.popularity
+		idx.remove(old)
+	}
+
+	e := &entry{Item: item, keys: keysOf(item), popularity: popularity}
+	idx.entries[item.ID] = e
+	for _, key := range e.keys {
+		n := idx.root
+		for i, r := range []rune(key) {
+			if i == maxDepth {
+				break
+			}
+			child, ok := n.children[r]
+			if !ok {
+				child = newNode()
+				n.children[r] = child
+			}
+			child.ids[item.ID]++
+			n = child
+		}
+	}
+}
+
+// Remove drops an item from the index.
+func (idx *Index) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if e, ok := idx.entries[id]; ok {
+		idx.remove(e)
+		delete(idx.entries, id)
+	}
+}
+
+func (idx *Index) remove(e *entry) {
+	for _, key := range e.keys {
+		n := idx.root
+		for i, r := range []rune(key) {
+			if i == maxDepth {
+				break
+			}
+			child := n.children[r]
+			if child.ids[e.ID]--; child.ids[e.ID] == 0 {
+				delete(child.ids, e.ID)
+			}
+			if len(child.ids) == 0 {
+				delete(n.children, r)
+				break
+			}
+			n = child
+		}
+	}
+}
+
+// AddPopularity boosts an item, typically by the quantity just ordered.
+func (idx *Index) AddPopularity(id string, amount float64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if e, ok := idx.entries[id]; ok {
+		e.popularity += amount
+	}
+}
+
+// Lookup returns up to limit items matching every word of q, where a word
+// matches when it is a prefix of a word of the name or SKU.
+func (idx *Index) Lookup(q string, limit int) []Suggestion {
+	terms := search.Terms(q)
+	if len(terms) == 0 || limit <= 0 {
+		return []Suggestion{}
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var candidates map[string]int
+	for _, term := range terms {
+		ids := idx.prefixIDs(term)
+		if candidates == nil {
+			candidates = ids
+			continue
+		}
+		for id := range candidates {
+			if _, ok := ids[id]; !ok {
+				delete(candidates, id)
+			}
+		}
+	}
+
+	suggestions := make([]Suggestion, 0, len(candidates))
+	for id := range candidates {
+		e := idx.entries[id]
+		if !e.matches(terms) {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			ID:    e.ID,
+			Name:  e.Name,
+			SKU:   e.SKU,
+			Score: e.score(terms),
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Name < suggestions[j].Name
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+func (idx *Index) prefixIDs(prefix string) map[string]int {
+	n := idx.root
+	for i, r := range []rune(prefix) {
+		if i == maxDepth {
+			break
+		}
+		if n = n.children[r]; n == nil {
+			return map[string]int{}
+		}
+	}
+	ids := make(map[string]int, len(n.ids))
+	for id, c := range n.ids {
+		ids[id] = c
+	}
+	return ids
+}
+
+// matches re-checks terms longer than the trie depth against the full keys.
+func (e *entry) matches(terms []string) bool {
+	for _, term := range terms {
+		if len([]rune(term)) <= maxDepth {
+			continue
+		}
+		found := false
+		for _, key := range e.keys {
+			if strings.HasPrefix(key, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// score favours whole-word and leading matches, then popular items.
+func (e *entry) score(terms []string) float64 {
+	score := math.Log1p(e.popularity)
+	name := search.Normalize(e.Name)
+	if strings.HasPrefix(name, terms[0]) {
+		score += 2
+	}
+	for _, term := range terms {
+		for _, key := range e.keys {
+			if key == term {
+				score++
+				break
+			}
+		}
+	}
+	return score
+}
+
+func keysOf(item Item) []string {
+	seen := map[string]bool{}
+	var keys []string
+	add := func(k string) {
+		if k != "" && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for _, w := range search.Terms(item.Name) {
+		add(w)
+	}
+	for _, sku := range append([]string{item.SKU}, item.VariantSKUs...) {
+		skuTerms := search.Terms(sku)
+		for _, w := range skuTerms {
+			add(w)
+		}
+		// "TS-RED-M" is also findable by typing it without separators.
+		add(strings.Join(skuTerms, ""))
+	}
+	return keys
+}
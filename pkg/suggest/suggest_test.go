@@ -0,0 +1,145 @@
+package suggest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ids(suggestions []Suggestion) []string {
+	out := []string{}
+	for _, s := range suggestions {
+		out = append(out, s.ID)
+	}
+	return out
+}
+
+func TestLookup(t *testing.T) {
+	long := "Supercalifragilisticexpialidocious"
+
+	idx := NewIndex()
+	idx.Put(Item{ID: "1", Name: "Red T-Shirt", SKU: "TS-RED", VariantSKUs: []string{"TS-RED-M", "TS-RED-L"}})
+	idx.Put(Item{ID: "2", Name: "Red Cap", SKU: "CAP-RED"})
+	idx.Put(Item{ID: "3", Name: "Blue Shirt", SKU: "SH-BLUE"})
+	idx.Put(Item{ID: "4", Name: long + " Umbrella"})
+	idx.Put(Item{ID: "5", Name: "Navy Blue Scarf"})
+
+	tests := []struct {
+		name  string
+		q     string
+		limit int
+		want  []string
+	}{
+		{"empty query", "", 10, []string{}},
+		{"no limit", "red", 0, []string{}},
+		{"no match", "green", 10, []string{}},
+		// Equal scores fall back to the name.
+		{"word prefix", "shi", 10, []string{"3", "1"}},
+		{"every term must match", "red shirt", 10, []string{"1"}},
+		{"leading match first", "blue", 10, []string{"3", "5"}},
+		{"ties by name", "red", 10, []string{"2", "1"}},
+		{"limit", "red", 1, []string{"2"}},
+		{"sku", "cap-red", 10, []string{"2"}},
+		{"variant sku", "ts-red-l", 10, []string{"1"}},
+		{"sku without separators", "tsredm", 10, []string{"1"}},
+		{"case insensitive", "SCARF", 10, []string{"5"}},
+		{"prefix deeper than the trie", strings.ToLower(long[:20]), 10, []string{"4"}},
+		{"longer than the key", strings.ToLower(long) + "x", 10, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(idx.Lookup(tt.q, tt.limit)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookup(%q, %d) = %v, want %v", tt.q, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPopularity(t *testing.T) {
+	idx := NewIndex()
+	idx.Put(Item{ID: "a", Name: "Shirt Alpha"})
+	idx.Put(Item{ID: "b", Name: "Shirt Beta"})
+
+	if got := ids(idx.Lookup("shirt", 10)); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("before: got %v", got)
+	}
+
+	idx.AddPopularity("b", 5)
+	if got := ids(idx.Lookup("shirt", 10)); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("after AddPopularity: got %v", got)
+	}
+
+	// Replacing an item keeps what it earned.
+	idx.Put(Item{ID: "b", Name: "Shirt Gamma"})
+	if got := ids(idx.Lookup("shirt", 10)); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("after Put: got %v", got)
+	}
+}
+
+func TestPutAndRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(idx *Index)
+		q    string
+		want []string
+	}{
+		{
+			name: "replaced name is forgotten",
+			ops: func(idx *Index) {
+				idx.Put(Item{ID: "1", Name: "Old Lamp"})
+				idx.Put(Item{ID: "1", Name: "New Lamp"})
+			},
+			q:    "old",
+			want: []string{},
+		},
+		{
+			name: "replaced name is found",
+			ops: func(idx *Index) {
+				idx.Put(Item{ID: "1", Name: "Old Lamp"})
+				idx.Put(Item{ID: "1", Name: "New Lamp"})
+			},
+			q:    "new",
+			want: []string{"1"},
+		},
+		{
+			name: "removed item",
+			ops: func(idx *Index) {
+				idx.Put(Item{ID: "1", Name: "Lamp"})
+				idx.Put(Item{ID: "2", Name: "Lampshade"})
+				idx.Remove("1")
+			},
+			q:    "lamp",
+			want: []string{"2"},
+		},
+		{
+			name: "shared prefix survives removal",
+			ops: func(idx *Index) {
+				idx.Put(Item{ID: "1", Name: "Lamp"})
+				idx.Put(Item{ID: "2", Name: "Lampshade"})
+				idx.Remove("2")
+			},
+			q:    "lam",
+			want: []string{"1"},
+		},
+		{
+			name: "removing an unknown item",
+			ops: func(idx *Index) {
+				idx.Put(Item{ID: "1", Name: "Lamp"})
+				idx.Remove("2")
+			},
+			q:    "lamp",
+			want: []string{"1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := NewIndex()
+			tt.ops(idx)
+			if got := ids(idx.Lookup(tt.q, 10)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookup(%q) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
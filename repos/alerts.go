@@ -0,0 +1,39 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// StockAlertFilter narrows a stock alert listing.
+type StockAlertFilter struct {
+	Status    string
+	ProductID string
+}
+
+type StockAlertRepository interface {
+	// Raise stores a new open alert unless the product has one that is not
+	// restocked yet, and reports whether it did.
+	Raise(ctx context.Context, alert *models.StockAlert) (bool, error)
+
+	FindByID(ctx context.Context, id string) (*models.StockAlert, error)
+
+	// FindAll lists alerts, most recently raised first.
+	FindAll(ctx context.Context, filter StockAlertFilter, opts ListOptions) ([]*models.StockAlert, error)
+
+	Count(ctx context.Context, filter StockAlertFilter) (int64, error)
+
+	// Acknowledge moves an open alert to acknowledged, and Resolve an open
+	// or acknowledged one to resolved, on behalf of the actor of ctx. Both
+	// fail with ErrVersionConflict when the alert is not in such a status.
+	Acknowledge(ctx context.Context, id string) (*models.StockAlert, error)
+	Resolve(ctx context.Context, id string) (*models.StockAlert, error)
+
+	// Restocked marks the alerts of a product restocked, resolving those
+	// that are not yet, and returns how many it resolved.
+	Restocked(ctx context.Context, productID string) (int64, error)
+
+	// Pending lists the IDs of the products with alerts not restocked yet.
+	Pending(ctx context.Context) ([]string, error)
+}
@@ -0,0 +1,22 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/query"
+)
+
+// AuditFilter narrows audit listings and counts.
+type AuditFilter struct {
+	Conditions []query.Condition
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, entry *models.AuditEntry) error
+
+	// FindAll lists entries, newest first unless opts asks for another order.
+	FindAll(ctx context.Context, filter AuditFilter, opts ListOptions) ([]*models.AuditEntry, error)
+
+	Count(ctx context.Context, filter AuditFilter) (int64, error)
+}
@@ -0,0 +1,28 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+type CartRepository interface {
+	// Create stores a new open cart, giving it an ID.
+	Create(ctx context.Context, cart *models.Cart) (*models.Cart, error)
+
+	// FindByID returns the cart, failing with ErrNotFound once it has
+	// expired.
+	FindByID(ctx context.Context, id string) (*models.Cart, error)
+
+	// Update replaces the customer, lines and coupon codes of an open cart and moves its
+	// expiry to cart.ExpiresAt, if its stored version equals cart.Version.
+	// It fails with ErrVersionConflict when the version differs or the
+	// cart is no longer open.
+	Update(ctx context.Context, cart *models.Cart) (*models.Cart, error)
+
+	// SetStatus moves the cart to status, recording orderID, if its stored
+	// version equals version.
+	SetStatus(ctx context.Context, id string, version int64, status, orderID string) (*models.Cart, error)
+
+	Delete(ctx context.Context, id string) error
+}
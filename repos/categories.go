@@ -0,0 +1,35 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+type CategoryRepository interface {
+	Create(ctx context.Context, category *models.Category) (*models.Category, error)
+
+	FindByID(ctx context.Context, id string) (*models.Category, error)
+
+	// FindBySlug finds the category with the slug, or else one that has it
+	// among its aliases.
+	FindBySlug(ctx context.Context, slug string) (*models.Category, error)
+
+	// FindAll lists the children of parentID, or every category when
+	// parentID is empty, ordered by path.
+	FindAll(ctx context.Context, parentID string) ([]*models.Category, error)
+
+	// Ancestors returns the chain from the root category down to and
+	// including the category itself.
+	Ancestors(ctx context.Context, id string) ([]*models.Category, error)
+
+	// Descendants returns the category and everything below it.
+	Descendants(ctx context.Context, id string) ([]*models.Category, error)
+
+	// Update renames and/or moves the category, rewriting the paths of its
+	// descendants when it moves. The version check follows ProductRepository.Update.
+	Update(ctx context.Context, id string, category *models.Category) (*models.Category, error)
+
+	// Delete removes a category that has no children; ErrInUse otherwise.
+	Delete(ctx context.Context, id string, version int64) error
+}
@@ -0,0 +1,32 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// CustomerFilter narrows customer listings and counts.
+type CustomerFilter struct {
+	// Email selects the customer with the email address.
+	Email string
+}
+
+type CustomerRepository interface {
+	// Create stores a new customer, giving IDs to the addresses that have
+	// none. It fails with ErrDuplicate when another customer has the email.
+	Create(ctx context.Context, customer *models.Customer) (*models.Customer, error)
+
+	FindByID(ctx context.Context, id string) (*models.Customer, error)
+
+	// FindAll lists customers by name.
+	FindAll(ctx context.Context, filter CustomerFilter, opts ListOptions) ([]*models.Customer, error)
+
+	Count(ctx context.Context, filter CustomerFilter) (int64, error)
+
+	// Update replaces the customer, addresses included; the version check
+	// follows ProductRepository.Update and the email rule Create.
+	Update(ctx context.Context, id string, customer *models.Customer) (*models.Customer, error)
+
+	Delete(ctx context.Context, id string, version int64) error
+}
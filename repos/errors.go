@@ -0,0 +1,36 @@
+package repos
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the requested document does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrVersionConflict is returned when a conditional write was made against
+	// a version that is no longer current.
+	ErrVersionConflict = errors.New("version conflict")
+
+	// ErrDuplicate is returned when a write would break a uniqueness rule.
+	ErrDuplicate = errors.New("duplicate")
+
+	// ErrInUse is returned when a document cannot be removed because other
+	// documents still depend on it.
+	ErrInUse = errors.New("in use")
+
+	// ErrInvalidReference is returned when a document points at another one
+	// that does not exist or cannot be referenced.
+	ErrInvalidReference = errors.New("invalid reference")
+
+	// ErrInsufficientStock is returned when a stock deduction would take
+	// stock below zero.
+	ErrInsufficientStock = errors.New("insufficient stock")
+
+	// ErrNoWarehouse is returned when stock is added but there is no
+	// warehouse to keep it in.
+	ErrNoWarehouse = errors.New("no warehouse")
+
+	// ErrLimitReached is returned when a promotion has been used as often
+	// as it, or the customer, may use it, and when payments would hold more
+	// than the total of an order.
+	ErrLimitReached = errors.New("limit reached")
+)
@@ -0,0 +1,47 @@
+package repos
+
+import (
+	"strings"
+
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+)
+
+// ListOptions selects one page of a listing. When Page is set the listing is
+// paginated by offset (the legacy mode); otherwise it is paginated by _id,
+// starting after Cursor or from the beginning when Cursor is nil. Either way
+// items are ordered by Sort, then by _id.
+type ListOptions struct {
+	Page   int
+	Limit  int
+	Cursor *pagination.Cursor
+	Sort   []query.Sort
+	// Positions, when not nil, receives the sort-key values of the items
+	// listed in keyset mode, for the cursors pointing at them.
+	Positions pagination.Positions
+}
+
+// Keyset reports whether the options ask for keyset pagination.
+func (o ListOptions) Keyset() bool {
+	return o.Page == 0
+}
+
+// SortSpec returns Sort as a sort parameter, such as "-price,name".
+func (o ListOptions) SortSpec() string {
+	keys := make([]string, len(o.Sort))
+	for i, s := range o.Sort {
+		keys[i] = s.Field
+		if s.Desc {
+			keys[i] = "-" + s.Field
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// CursorAt returns the cursor pointing at the listed item with the given
+// ID, recording the sort it belongs to.
+func (o ListOptions) CursorAt(id string, backward bool) pagination.Cursor {
+	c := o.Positions.Cursor(id, backward)
+	c.Sort = o.SortSpec()
+	return c
+}
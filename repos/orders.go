@@ -2,22 +2,69 @@ package repos
 
 import (
 	"context"
+	"time"
 
 	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/query"
 )
 
+// OrderFilter narrows order listings and counts.
+type OrderFilter struct {
+	Conditions []query.Condition
+}
+
 type OrderRepository interface {
+	// Create stores a new order, under order.ID when that is set.
 	Create(ctx context.Context, order *models.Order) (*models.Order, error)
 
 	FindByID(ctx context.Context, id string) (*models.Order, error)
 
-	FindAll(ctx context.Context, page, limit int, status string) ([]*models.Order, error)
+	FindAll(ctx context.Context, filter OrderFilter, opts ListOptions) ([]*models.Order, error)
 
+	// Update replaces the order if its stored version equals order.Version;
+	// a zero version skips the check.
 	Update(ctx context.Context, id string, order *models.Order) (*models.Order, error)
 
-	Delete(ctx context.Context, id string) error
+	// SetPaymentStatus records the payment status of the order and what was
+	// paid. When from is set, an order in status from moves to status to.
+	SetPaymentStatus(ctx context.Context, id, paymentStatus string, paid float64, from, to string) (*models.Order, error)
+
+	// Hold adds amount to what the payments of the order hold. A positive
+	// amount is added only if the order then holds no more than its total
+	// price, failing with ErrLimitReached otherwise; a negative amount
+	// releases what was held.
+	Hold(ctx context.Context, id string, amount float64) (*models.Order, error)
+
+	// SetReturns stores the lines of order, with what was returned of
+	// them, its refund totals and its status if its stored version still
+	// equals order.Version.
+	SetReturns(ctx context.Context, order *models.Order) (*models.Order, error)
+
+	// Delete moves the order to the trash if its stored version equals
+	// version; a zero version skips the check. Orders in the trash are left
+	// out of every lookup but the trash ones, and out of reports.
+	Delete(ctx context.Context, id string, version int64) error
+
+	// Restore takes an order out of the trash, failing with ErrNotFound
+	// when it is not there.
+	Restore(ctx context.Context, id string) (*models.Order, error)
+
+	// FindDeleted lists the orders in the trash, most recently deleted first.
+	FindDeleted(ctx context.Context, opts ListOptions) ([]*models.Order, error)
+
+	CountDeleted(ctx context.Context) (int64, error)
+
+	// Purge permanently removes the orders deleted before cutoff and
+	// returns them.
+	Purge(ctx context.Context, cutoff time.Time) ([]*models.Order, error)
+
+	// FindOpenByProduct lists up to limit orders that are not in a terminal
+	// status and reference productID, directly or as a bundle component.
+	FindOpenByProduct(ctx context.Context, productID string, limit int) ([]*models.Order, error)
 
-	GenerateReport(ctx context.Context, startDate, endDate string) ([]*models.Order, error)
+	// GenerateReport summarises the orders created between startDate and
+	// endDate, both inclusive.
+	GenerateReport(ctx context.Context, startDate, endDate string) (*models.SalesReport, error)
 
-	Count(ctx context.Context, status string) (int64, error)
+	Count(ctx context.Context, filter OrderFilter) (int64, error)
 }
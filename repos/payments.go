@@ -0,0 +1,25 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+type PaymentRepository interface {
+	Create(ctx context.Context, payment *models.Payment) (*models.Payment, error)
+
+	FindByID(ctx context.Context, id string) (*models.Payment, error)
+
+	// FindByTransaction returns the payment a gateway knows by
+	// transactionID.
+	FindByTransaction(ctx context.Context, provider, transactionID string) (*models.Payment, error)
+
+	// FindByOrder lists the payments of an order, oldest first.
+	FindByOrder(ctx context.Context, orderID string) ([]*models.Payment, error)
+
+	// Update stores the state and events of the payment if its stored
+	// version equals payment.Version, failing with ErrVersionConflict
+	// otherwise.
+	Update(ctx context.Context, payment *models.Payment) (*models.Payment, error)
+}
@@ -0,0 +1,34 @@
+package repos
+
+import (
+	"context"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// PriceHistoryFilter narrows a price timeline.
+type PriceHistoryFilter struct {
+	// VariantID selects the points of one variant; empty selects those of
+	// the product itself.
+	VariantID string
+	// From and To bound EffectiveAt, both inclusive; empty means unbounded.
+	From, To string
+}
+
+// PriceHistoryRepository reads the price history recorded as products are
+// written.
+type PriceHistoryRepository interface {
+	// FindByProduct lists the price points of a product, oldest first.
+	FindByProduct(ctx context.Context, productID string, filter PriceHistoryFilter, opts ListOptions) ([]*models.PricePoint, error)
+
+	CountByProduct(ctx context.Context, productID string, filter PriceHistoryFilter) (int64, error)
+
+	// PriceAt returns the point in effect at the given time, failing with
+	// ErrNotFound when no price had been recorded by then.
+	PriceAt(ctx context.Context, productID, variantID string, at time.Time) (*models.PricePoint, error)
+
+	// ChangeStats summarises the price changes between startDate and
+	// endDate, both inclusive.
+	ChangeStats(ctx context.Context, startDate, endDate string) (*models.PriceChangeStats, error)
+}
@@ -2,20 +2,76 @@ package repos
 
 import (
 	"context"
+	"time"
 
 	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/query"
 )
 
+// ProductFilter narrows product listings and counts.
+type ProductFilter struct {
+	// Search matches products whose name contains it, or starts with it
+	// when SearchPrefix is set.
+	Search       string
+	SearchPrefix bool
+	Conditions   []query.Condition
+	// WarehouseID selects the products in stock at the warehouse.
+	WarehouseID string
+}
+
 type ProductRepository interface {
+	// Create stores a new product. Its opening stock is kept in the default
+	// warehouse, failing with ErrNoWarehouse when there is none.
 	Create(ctx context.Context, product *models.Product) (*models.Product, error)
 
 	FindByID(ctx context.Context, id string) (*models.Product, error)
 
-	FindAll(ctx context.Context, page, limit int, search string) ([]*models.Product, error)
+	FindAll(ctx context.Context, filter ProductFilter, opts ListOptions) ([]*models.Product, error)
 
+	// Update replaces the product if its stored version equals product.Version;
+	// a zero version skips the check. Images are left as they are when
+	// product.Images is nil. Stock and cost are never replaced: they keep
+	// the stored values and change through AdjustStock only.
 	Update(ctx context.Context, id string, product *models.Product) (*models.Product, error)
 
-	Delete(ctx context.Context, id string) error
+	// Delete moves the product to the trash if its stored version equals
+	// version; a zero version skips the check. Products in the trash are
+	// left out of every lookup but the trash ones.
+	Delete(ctx context.Context, id string, version int64) error
+
+	// Restore takes a product out of the trash, failing with ErrNotFound
+	// when it is not there.
+	Restore(ctx context.Context, id string) (*models.Product, error)
+
+	// FindDeleted lists the products in the trash, most recently deleted first.
+	FindDeleted(ctx context.Context, opts ListOptions) ([]*models.Product, error)
+
+	CountDeleted(ctx context.Context) (int64, error)
+
+	// Purge permanently removes the products deleted before cutoff and
+	// returns them.
+	Purge(ctx context.Context, cutoff time.Time) ([]*models.Product, error)
+
+	Count(ctx context.Context, filter ProductFilter) (int64, error)
+
+	// AdjustStock adds the quantity of movement to the stock of a product,
+	// or of one of its variants, at the warehouse of the movement and
+	// records the movement in the stock ledger. It fails with
+	// ErrInsufficientStock instead of going below zero. The unit cost of
+	// goods received is averaged into the cost of the product. Bundles
+	// containing the product are updated to match.
+	AdjustStock(ctx context.Context, movement *models.StockMovement) error
+
+	// LowStock lists the products whose stock is at or below their reorder
+	// point.
+	LowStock(ctx context.Context) ([]*models.Product, error)
+
+	// SetCategoryName refreshes the category name copied onto the products
+	// of categoryID and returns the products it renamed, as they were
+	// before.
+	SetCategoryName(ctx context.Context, categoryID, name string) ([]*models.Product, error)
 
-	Count(ctx context.Context, search string) (int64, error)
+	// Search ranks the products matching filter by relevance to q, falling
+	// back to typo-tolerant matching when nothing matches exactly.
+	Search(ctx context.Context, q string, filter ProductFilter, limit int) (*models.ProductSearchResult, error)
 }
@@ -0,0 +1,42 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+type PromotionRepository interface {
+	// Create stores a new promotion. It fails with ErrDuplicate when
+	// another promotion has the code.
+	Create(ctx context.Context, promotion *models.Promotion) (*models.Promotion, error)
+
+	FindByID(ctx context.Context, id string) (*models.Promotion, error)
+
+	// FindByCode returns the promotion with the code, in any case.
+	FindByCode(ctx context.Context, code string) (*models.Promotion, error)
+
+	// FindAll lists promotions, newest first.
+	FindAll(ctx context.Context, opts ListOptions) ([]*models.Promotion, error)
+
+	Count(ctx context.Context) (int64, error)
+
+	// Automatic lists the active promotions that have no code, whatever
+	// their validity window.
+	Automatic(ctx context.Context) ([]*models.Promotion, error)
+
+	// Update replaces the terms of the promotion, leaving its uses; the
+	// version check follows ProductRepository.Update and the code rule
+	// Create.
+	Update(ctx context.Context, id string, promotion *models.Promotion) (*models.Promotion, error)
+
+	Delete(ctx context.Context, id string, version int64) error
+
+	// Redeem counts a use of the promotion by the customer, failing with
+	// ErrLimitReached, and counting nothing, when the promotion or the
+	// customer has reached its limit.
+	Redeem(ctx context.Context, promotion *models.Promotion, customerID string) error
+
+	// Release takes back a use counted by Redeem.
+	Release(ctx context.Context, promotion *models.Promotion, customerID string) error
+}
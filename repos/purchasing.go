@@ -0,0 +1,60 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+type SupplierRepository interface {
+	Create(ctx context.Context, supplier *models.Supplier) (*models.Supplier, error)
+
+	FindByID(ctx context.Context, id string) (*models.Supplier, error)
+
+	// FindAll lists suppliers by name.
+	FindAll(ctx context.Context, opts ListOptions) ([]*models.Supplier, error)
+
+	Count(ctx context.Context) (int64, error)
+
+	// Update replaces the supplier; the version check follows
+	// ProductRepository.Update.
+	Update(ctx context.Context, id string, supplier *models.Supplier) (*models.Supplier, error)
+
+	Delete(ctx context.Context, id string, version int64) error
+}
+
+// PurchaseOrderFilter narrows purchase order listings and counts.
+type PurchaseOrderFilter struct {
+	// Statuses selects purchase orders in any of the statuses; empty
+	// selects all.
+	Statuses   []string
+	SupplierID string
+	// ProductID selects the purchase orders with a line of the product.
+	ProductID string
+}
+
+type PurchaseOrderRepository interface {
+	// Create stores a new draft.
+	Create(ctx context.Context, po *models.PurchaseOrder) (*models.PurchaseOrder, error)
+
+	FindByID(ctx context.Context, id string) (*models.PurchaseOrder, error)
+
+	// FindAll lists purchase orders, newest first.
+	FindAll(ctx context.Context, filter PurchaseOrderFilter, opts ListOptions) ([]*models.PurchaseOrder, error)
+
+	Count(ctx context.Context, filter PurchaseOrderFilter) (int64, error)
+
+	// Update replaces the supplier, warehouse, lines, expected date and note
+	// of a draft. The version check follows ProductRepository.Update;
+	// purchase orders that are no longer drafts fail with
+	// ErrVersionConflict too.
+	Update(ctx context.Context, id string, po *models.PurchaseOrder) (*models.PurchaseOrder, error)
+
+	// SetStatus moves a purchase order from any of the statuses from to
+	// status to, failing with ErrVersionConflict when it is in none of them.
+	SetStatus(ctx context.Context, id string, from []string, to string) (*models.PurchaseOrder, error)
+
+	// SetReceived stores the received quantities of the lines of po, and
+	// its status, if its stored version still equals po.Version.
+	SetReceived(ctx context.Context, po *models.PurchaseOrder) (*models.PurchaseOrder, error)
+}
@@ -0,0 +1,33 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// ReturnFilter narrows return listings and counts.
+type ReturnFilter struct {
+	OrderID string
+	// Statuses selects returns in any of the statuses; empty selects all.
+	Statuses []string
+}
+
+type ReturnRepository interface {
+	// Create stores a new return request.
+	Create(ctx context.Context, ret *models.Return) (*models.Return, error)
+
+	FindByID(ctx context.Context, id string) (*models.Return, error)
+
+	// FindAll lists returns, newest first.
+	FindAll(ctx context.Context, filter ReturnFilter, opts ListOptions) ([]*models.Return, error)
+
+	Count(ctx context.Context, filter ReturnFilter) (int64, error)
+
+	// FindByOrder lists every return of an order, oldest first.
+	FindByOrder(ctx context.Context, orderID string) ([]*models.Return, error)
+
+	// Update stores the lines, status, note, warehouse and refund of ret
+	// if its stored version still equals ret.Version.
+	Update(ctx context.Context, ret *models.Return) (*models.Return, error)
+}
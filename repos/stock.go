@@ -0,0 +1,29 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// StockMovementFilter narrows a stock ledger listing.
+type StockMovementFilter struct {
+	// VariantID selects the movements of one variant; empty selects all of
+	// the product's.
+	VariantID   string
+	WarehouseID string
+	Type        string
+}
+
+// StockLedgerRepository reads the stock movements recorded as stock is
+// adjusted.
+type StockLedgerRepository interface {
+	// FindByProduct lists the movements of a product, newest first.
+	FindByProduct(ctx context.Context, productID string, filter StockMovementFilter, opts ListOptions) ([]*models.StockMovement, error)
+
+	CountByProduct(ctx context.Context, productID string, filter StockMovementFilter) (int64, error)
+
+	// Reconcile compares the stock of every product with the sum of its
+	// movements.
+	Reconcile(ctx context.Context) (*models.StockReconciliation, error)
+}
@@ -0,0 +1,40 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// TaxRateFilter narrows tax rate listings and counts.
+type TaxRateFilter struct {
+	// Country selects the rates of a country, in any case.
+	Country string
+}
+
+type TaxRateRepository interface {
+	// Create stores a new tax rate. It fails with ErrDuplicate when another
+	// rate covers the same country, region and tax class.
+	Create(ctx context.Context, rate *models.TaxRate) (*models.TaxRate, error)
+
+	FindByID(ctx context.Context, id string) (*models.TaxRate, error)
+
+	// FindAll lists tax rates by country, region and tax class.
+	FindAll(ctx context.Context, filter TaxRateFilter, opts ListOptions) ([]*models.TaxRate, error)
+
+	Count(ctx context.Context, filter TaxRateFilter) (int64, error)
+
+	// ForCountry returns every rate of the country, those of its regions
+	// included.
+	ForCountry(ctx context.Context, country string) ([]*models.TaxRate, error)
+
+	// Update replaces the rate; the version check follows
+	// ProductRepository.Update and the uniqueness rule Create.
+	Update(ctx context.Context, id string, rate *models.TaxRate) (*models.TaxRate, error)
+
+	// Upsert stores the rate in place of the one covering the same
+	// country, region and tax class, if any.
+	Upsert(ctx context.Context, rate *models.TaxRate) (*models.TaxRate, error)
+
+	Delete(ctx context.Context, id string, version int64) error
+}
@@ -0,0 +1,19 @@
+package repos
+
+import "context"
+
+// Transactor runs writes to several repositories as one.
+type Transactor interface {
+	// InTransaction runs fn in a transaction, which commits when fn
+	// succeeds. The repository calls of the transaction must be made with
+	// the context fn is given. fn may run more than once, when the
+	// transaction is retried after a conflict. What the repositories do
+	// outside the database, such as updating search indexes or checking
+	// stock alerts, happens once, after the transaction commits.
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Atomic reports whether InTransaction undoes the writes of an fn
+	// that fails. When it does not, fn runs once, without a transaction,
+	// and must undo its writes itself.
+	Atomic() bool
+}
@@ -0,0 +1,49 @@
+package repos
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+)
+
+type WarehouseRepository interface {
+	Create(ctx context.Context, warehouse *models.Warehouse) (*models.Warehouse, error)
+
+	FindByID(ctx context.Context, id string) (*models.Warehouse, error)
+
+	// FindAll lists every warehouse by priority, then code.
+	FindAll(ctx context.Context) ([]*models.Warehouse, error)
+
+	// Default returns the first warehouse by priority, failing with
+	// ErrNotFound when there is none.
+	Default(ctx context.Context) (*models.Warehouse, error)
+
+	// Update replaces the warehouse; the version check follows
+	// ProductRepository.Update.
+	Update(ctx context.Context, id string, warehouse *models.Warehouse) (*models.Warehouse, error)
+
+	Delete(ctx context.Context, id string, version int64) error
+}
+
+// TransferFilter narrows transfer listings and counts.
+type TransferFilter struct {
+	// Statuses selects transfers in any of the statuses; empty selects all.
+	Statuses []string
+	// WarehouseID selects transfers from or to the warehouse.
+	WarehouseID string
+}
+
+type TransferRepository interface {
+	Create(ctx context.Context, transfer *models.Transfer) (*models.Transfer, error)
+
+	FindByID(ctx context.Context, id string) (*models.Transfer, error)
+
+	// FindAll lists transfers, newest first.
+	FindAll(ctx context.Context, filter TransferFilter, opts ListOptions) ([]*models.Transfer, error)
+
+	Count(ctx context.Context, filter TransferFilter) (int64, error)
+
+	// SetStatus moves a transfer from status from to status to, failing
+	// with ErrVersionConflict when it is no longer in status from.
+	SetStatus(ctx context.Context, id, from, to string) (*models.Transfer, error)
+}
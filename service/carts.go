@@ -0,0 +1,422 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrInvalidCart is wrapped by the errors for cart changes, and
+	// checkouts, that cannot be made as given.
+	ErrInvalidCart = errors.New("invalid cart")
+
+	// ErrCartClosed is returned for changes to a cart that has been, or is
+	// being, checked out.
+	ErrCartClosed = errors.New("cart is checked out")
+)
+
+// CheckoutError is returned when a cart cannot be checked out as quoted
+// because prices or stock have changed since its lines were added. The
+// lines whose price changed are quoted again at the catalog price.
+type CheckoutError struct {
+	Cart *models.Cart
+}
+
+func (e *CheckoutError) Error() string {
+	return fmt.Sprintf("cart has %d issue(s) to review before checkout", len(e.Cart.Issues))
+}
+
+type CartService struct {
+	products   repos.ProductRepository
+	customers  repos.CustomerRepository
+	carts      repos.CartRepository
+	promotions repos.PromotionRepository
+	orders     *OrderService
+	ttl        time.Duration
+	logger     *zap.Logger
+}
+
+// NewCartService returns a CartService whose carts expire when they have
+// not been changed for ttl.
+func NewCartService(products repos.ProductRepository, customers repos.CustomerRepository, carts repos.CartRepository, promotions repos.PromotionRepository, orders *OrderService, ttl time.Duration, logger *zap.Logger) *CartService {
+	return &CartService{
+		products:   products,
+		customers:  customers,
+		carts:      carts,
+		promotions: promotions,
+		orders:     orders,
+		ttl:        ttl,
+		logger:     logger,
+	}
+}
+
+// Create opens an empty cart, bound to the customer if customerID is given.
+func (s *CartService) Create(ctx context.Context, customerID string) (*models.Cart, error) {
+	if customerID != "" {
+		if err := s.checkCustomer(ctx, customerID); err != nil {
+			return nil, err
+		}
+	}
+	return s.carts.Create(ctx, &models.Cart{
+		CustomerID: customerID,
+		ExpiresAt:  time.Now().Add(s.ttl),
+	})
+}
+
+// Get returns the cart with its issues, the lines whose price or stock has
+// changed since they were quoted, and priced with the promotions that apply.
+func (s *CartService) Get(ctx context.Context, id string) (*models.Cart, error) {
+	cart, err := s.carts.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cart.Status == models.CartOpen {
+		if err := s.review(ctx, cart); err != nil {
+			return nil, err
+		}
+	}
+	return cart, nil
+}
+
+// AddLine adds quantity of a product, or variant, to the cart at its
+// catalog price. A product already in the cart has its line raised and
+// quoted again. There must be enough stock for the whole line.
+func (s *CartService) AddLine(ctx context.Context, id string, version int64, line models.CartLine) (*models.Cart, error) {
+	if line.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: quantity must be positive", ErrInvalidCart)
+	}
+	return s.change(ctx, id, version, func(cart *models.Cart) error {
+		for i := range cart.Lines {
+			existing := &cart.Lines[i]
+			if existing.ProductID == line.ProductID && existing.VariantID == line.VariantID {
+				return s.quote(ctx, existing, existing.Quantity+line.Quantity)
+			}
+		}
+		line.ID = primitive.NewObjectID().Hex()
+		if err := s.quote(ctx, &line, line.Quantity); err != nil {
+			return err
+		}
+		cart.Lines = append(cart.Lines, line)
+		return nil
+	})
+}
+
+// UpdateLine sets the quantity of a line and quotes it again.
+func (s *CartService) UpdateLine(ctx context.Context, id string, version int64, lineID string, quantity int) (*models.Cart, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("%w: quantity must be positive; remove the line instead", ErrInvalidCart)
+	}
+	return s.change(ctx, id, version, func(cart *models.Cart) error {
+		line := cart.Line(lineID)
+		if line == nil {
+			return repos.ErrNotFound
+		}
+		return s.quote(ctx, line, quantity)
+	})
+}
+
+// RemoveLine takes a line out of the cart.
+func (s *CartService) RemoveLine(ctx context.Context, id string, version int64, lineID string) (*models.Cart, error) {
+	return s.change(ctx, id, version, func(cart *models.Cart) error {
+		for i := range cart.Lines {
+			if cart.Lines[i].ID == lineID {
+				cart.Lines = append(cart.Lines[:i], cart.Lines[i+1:]...)
+				return nil
+			}
+		}
+		return repos.ErrNotFound
+	})
+}
+
+// AddCoupon enters a coupon code for the cart. Unknown codes are refused;
+// codes that do not apply to the cart as it is are kept and listed in its
+// rejected coupons, as they may apply once the cart changes.
+func (s *CartService) AddCoupon(ctx context.Context, id string, version int64, code string) (*models.Cart, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, err := s.promotions.FindByCode(ctx, code); err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown coupon code %q", ErrInvalidCart, code)
+		}
+		return nil, err
+	}
+	return s.change(ctx, id, version, func(cart *models.Cart) error {
+		cart.CouponCodes = normalizeCodes(append(cart.CouponCodes, code))
+		return nil
+	})
+}
+
+// RemoveCoupon takes a coupon code off the cart.
+func (s *CartService) RemoveCoupon(ctx context.Context, id string, version int64, code string) (*models.Cart, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return s.change(ctx, id, version, func(cart *models.Cart) error {
+		for i, c := range cart.CouponCodes {
+			if c == code {
+				cart.CouponCodes = append(cart.CouponCodes[:i], cart.CouponCodes[i+1:]...)
+				return nil
+			}
+		}
+		return repos.ErrNotFound
+	})
+}
+
+// Checkout places the cart as an order through OrderService.Place and
+// closes it. The cart is first checked against the catalog: if prices
+// changed, or stock fell short, since its lines were quoted, nothing is
+// ordered and a CheckoutError lists the issues, with the changed prices
+// quoted again so the shopper can review and check out once more.
+//
+// The cart is locked for the checkout, conditional on its version, so it
+// is never ordered twice; PlaceQuoted takes all the stock or none of it,
+// and only at the prices confirmed. If the order cannot be placed, the
+// cart is unlocked; if stock or a price changed again meanwhile, it is
+// reviewed once more.
+func (s *CartService) Checkout(ctx context.Context, id string, version int64, checkout *models.Checkout) (*models.Order, error) {
+	cart, err := s.open(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(cart.Lines) == 0 {
+		return nil, fmt.Errorf("%w: the cart is empty", ErrInvalidCart)
+	}
+	customerID := cart.CustomerID
+	switch {
+	case customerID == "" && checkout.CustomerID == "":
+		return nil, fmt.Errorf("%w: an anonymous cart needs a customer_id at checkout", ErrInvalidCart)
+	case customerID == "":
+		customerID = checkout.CustomerID
+	case checkout.CustomerID != "" && checkout.CustomerID != customerID:
+		return nil, fmt.Errorf("%w: the cart belongs to another customer", ErrInvalidCart)
+	}
+
+	if err := s.confirm(ctx, cart); err != nil {
+		return nil, err
+	}
+	locked, err := s.carts.SetStatus(ctx, id, cart.Version, models.CartCheckingOut, "")
+	if err != nil {
+		return nil, err
+	}
+
+	order := &models.Order{
+		CustomerID:        customerID,
+		CouponCodes:       cart.CouponCodes,
+		ShippingAddressID: checkout.ShippingAddressID,
+		BillingAddressID:  checkout.BillingAddressID,
+		ShipTo:            checkout.ShipTo,
+		Status:            models.OrderStatusPending,
+		OrderDate:         time.Now().Format(time.DateOnly),
+	}
+	quoted := make([]float64, 0, len(cart.Lines))
+	for _, line := range cart.Lines {
+		order.Products = append(order.Products, models.ProductInOrder{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Quantity:  line.Quantity,
+		})
+		quoted = append(quoted, line.Price)
+	}
+	placed, err := s.orders.PlaceQuoted(ctx, order, quoted)
+	if err != nil {
+		// Unlocking must not be cut short by a cancelled request, or the
+		// cart would stay locked until it expires.
+		unlocked, unlockErr := s.carts.SetStatus(context.WithoutCancel(ctx), id, locked.Version, models.CartOpen, "")
+		if unlockErr == nil && (errors.Is(err, repos.ErrInsufficientStock) || errors.Is(err, ErrPriceChanged)) {
+			// Stock, or a price, changed again since the review.
+			if reviewErr := s.confirm(ctx, unlocked); reviewErr != nil {
+				return nil, reviewErr
+			}
+		}
+		return nil, errors.Join(err, unlockErr)
+	}
+
+	if _, err := s.carts.SetStatus(context.WithoutCancel(ctx), id, locked.Version, models.CartCheckedOut, placed.ID); err != nil {
+		// The order stands; the cart stays locked until it expires.
+		s.logger.Error("Failed to close checked out cart", zap.String("cart_id", id), zap.String("order_id", placed.ID), zap.Error(err))
+	}
+	return placed, nil
+}
+
+// confirm fails with a CheckoutError when the cart has issues, after
+// quoting again the lines whose price changed.
+func (s *CartService) confirm(ctx context.Context, cart *models.Cart) error {
+	if err := s.review(ctx, cart); err != nil || len(cart.Issues) == 0 {
+		return err
+	}
+	issues := cart.Issues
+	requoted := false
+	for _, issue := range issues {
+		if issue.Problem == models.CartPriceChanged {
+			cart.Line(issue.LineID).Price = issue.Price
+			requoted = true
+		}
+	}
+	if requoted {
+		cart.Subtotal = subtotal(cart)
+		cart.ExpiresAt = time.Now().Add(s.ttl)
+		updated, err := s.carts.Update(ctx, cart)
+		if err != nil {
+			return err
+		}
+		if err := s.review(ctx, updated); err != nil {
+			return err
+		}
+		// The issues are what was found, before the prices were quoted
+		// again.
+		updated.Issues = issues
+		return &CheckoutError{Cart: updated}
+	}
+	return &CheckoutError{Cart: cart}
+}
+
+// review fills in the issues of the cart and prices it as an order, at the
+// prices its lines were quoted at, leaving out the unavailable lines.
+func (s *CartService) review(ctx context.Context, cart *models.Cart) error {
+	issues, err := s.issues(ctx, cart)
+	if err != nil {
+		return err
+	}
+	cart.Issues = issues
+	unavailable := map[string]bool{}
+	for _, issue := range issues {
+		if issue.Problem == models.CartUnavailable {
+			unavailable[issue.LineID] = true
+		}
+	}
+
+	order := &models.Order{CustomerID: cart.CustomerID, CouponCodes: cart.CouponCodes}
+	for _, line := range cart.Lines {
+		if !unavailable[line.ID] {
+			order.Products = append(order.Products, models.ProductInOrder{
+				ProductID: line.ProductID,
+				VariantID: line.VariantID,
+				Quantity:  line.Quantity,
+				Price:     line.Price,
+			})
+		}
+	}
+	if cart.RejectedCoupons, err = s.orders.Quote(ctx, order); err != nil {
+		return err
+	}
+	cart.Discounts, cart.Discount = order.Discounts, order.Discount
+	cart.Shipping, cart.Tax, cart.Total = order.Shipping, order.Tax, order.TotalPrice
+	return nil
+}
+
+// change applies fn to the open cart id, if its version is version or
+// version is zero, and stores it with a fresh expiry.
+func (s *CartService) change(ctx context.Context, id string, version int64, fn func(*models.Cart) error) (*models.Cart, error) {
+	cart, err := s.open(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(cart); err != nil {
+		return nil, err
+	}
+	cart.Subtotal = subtotal(cart)
+	cart.ExpiresAt = time.Now().Add(s.ttl)
+	updated, err := s.carts.Update(ctx, cart)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.review(ctx, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// open returns the cart id if it is open and, unless version is zero, at
+// version.
+func (s *CartService) open(ctx context.Context, id string, version int64) (*models.Cart, error) {
+	cart, err := s.carts.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cart.Status != models.CartOpen {
+		return nil, ErrCartClosed
+	}
+	if version > 0 && version != cart.Version {
+		return nil, repos.ErrVersionConflict
+	}
+	return cart, nil
+}
+
+// quote sets the quantity of line and its price from the catalog, after
+// checking that the product, or variant, exists and has the stock.
+func (s *CartService) quote(ctx context.Context, line *models.CartLine, quantity int) error {
+	product, err := s.products.FindByID(ctx, line.ProductID)
+	if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(line.ProductID) {
+		return fmt.Errorf("%w: unknown product %q", ErrInvalidCart, line.ProductID)
+	}
+	if err != nil {
+		return err
+	}
+	if problem := variantProblem(product, line.VariantID); problem != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidCart, problem)
+	}
+	if available := stockOf(product, line.VariantID); available < quantity {
+		return fmt.Errorf("%w: only %d of product %s available", repos.ErrInsufficientStock, available, product.ID)
+	}
+	line.Name = product.Name
+	line.Quantity = quantity
+	line.Price = product.PriceOf(line.VariantID)
+	return nil
+}
+
+// issues checks every line of the cart against the catalog.
+func (s *CartService) issues(ctx context.Context, cart *models.Cart) ([]models.CartIssue, error) {
+	var issues []models.CartIssue
+	for _, line := range cart.Lines {
+		issue := models.CartIssue{LineID: line.ID, ProductID: line.ProductID, VariantID: line.VariantID}
+		product, err := s.products.FindByID(ctx, line.ProductID)
+		if err != nil && !errors.Is(err, repos.ErrNotFound) {
+			return nil, err
+		}
+		if product == nil || variantProblem(product, line.VariantID) != "" {
+			issue.Problem = models.CartUnavailable
+			issues = append(issues, issue)
+			continue
+		}
+		if price := product.PriceOf(line.VariantID); price != line.Price {
+			issue.Problem, issue.Price = models.CartPriceChanged, price
+			issues = append(issues, issue)
+			issue.Price = 0
+		}
+		if available := stockOf(product, line.VariantID); available < line.Quantity {
+			issue.Problem, issue.Available = models.CartOutOfStock, available
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+func (s *CartService) checkCustomer(ctx context.Context, id string) error {
+	_, err := s.customers.FindByID(ctx, id)
+	if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(id) {
+		return fmt.Errorf("%w: unknown customer %q", ErrInvalidCart, id)
+	}
+	return err
+}
+
+// stockOf returns the stock of a product, or of one of its variants; the
+// stock of a bundle is what its components make up.
+func stockOf(product *models.Product, variantID string) int {
+	if v := product.Variant(variantID); v != nil {
+		return v.Stock
+	}
+	return product.Stock
+}
+
+func subtotal(cart *models.Cart) float64 {
+	total := 0.0
+	for _, line := range cart.Lines {
+		total += line.Price * float64(line.Quantity)
+	}
+	return roundCents(total)
+}
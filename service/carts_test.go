@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.uber.org/zap"
+)
+
+// stubCarts keeps one cart in memory and lists the statuses it was moved
+// to. onLock runs when the cart is locked for checkout; other methods are
+// not used.
+type stubCarts struct {
+	repos.CartRepository
+	cart     *models.Cart
+	statuses []string
+	onLock   func()
+}
+
+func (s *stubCarts) FindByID(_ context.Context, id string) (*models.Cart, error) {
+	if id != s.cart.ID || s.cart.ExpiresAt.Before(time.Now()) {
+		return nil, repos.ErrNotFound
+	}
+	found := *s.cart
+	found.Lines = append([]models.CartLine(nil), s.cart.Lines...)
+	return &found, nil
+}
+
+func (s *stubCarts) Update(_ context.Context, cart *models.Cart) (*models.Cart, error) {
+	if cart.Version != s.cart.Version || s.cart.Status != models.CartOpen {
+		return nil, repos.ErrVersionConflict
+	}
+	cart.Version++
+	stored := *cart
+	s.cart = &stored
+	return cart, nil
+}
+
+func (s *stubCarts) SetStatus(_ context.Context, id string, version int64, status, orderID string) (*models.Cart, error) {
+	if id != s.cart.ID || version != s.cart.Version {
+		return nil, repos.ErrVersionConflict
+	}
+	s.cart.Status, s.cart.OrderID = status, orderID
+	s.cart.Version++
+	s.statuses = append(s.statuses, status)
+	if status == models.CartCheckingOut && s.onLock != nil {
+		s.onLock()
+	}
+	found := *s.cart
+	return &found, nil
+}
+
+// stockedProducts serves products stocked at one warehouse and takes stock
+// from them; other methods are not used.
+type stockedProducts struct {
+	repos.ProductRepository
+	byID map[string]*models.Product
+}
+
+func (s stockedProducts) FindByID(_ context.Context, id string) (*models.Product, error) {
+	if p, ok := s.byID[id]; ok {
+		found := *p
+		found.Locations = append([]models.StockLevel(nil), p.Locations...)
+		return &found, nil
+	}
+	return nil, repos.ErrNotFound
+}
+
+func (s stockedProducts) AdjustStock(_ context.Context, m *models.StockMovement) error {
+	p, ok := s.byID[m.ProductID]
+	if !ok {
+		return repos.ErrNotFound
+	}
+	for i := range p.Locations {
+		l := &p.Locations[i]
+		if l.WarehouseID == m.WarehouseID {
+			if l.Stock+m.Quantity < 0 {
+				return repos.ErrInsufficientStock
+			}
+			l.Stock += m.Quantity
+			p.Stock += m.Quantity
+			return nil
+		}
+	}
+	return repos.ErrInsufficientStock
+}
+
+type stubWarehouses struct {
+	repos.WarehouseRepository
+}
+
+func (stubWarehouses) FindAll(context.Context) ([]*models.Warehouse, error) {
+	return []*models.Warehouse{{ID: "w1"}}, nil
+}
+
+type stubCustomers struct {
+	repos.CustomerRepository
+}
+
+func (stubCustomers) FindByID(_ context.Context, id string) (*models.Customer, error) {
+	return &models.Customer{ID: id}, nil
+}
+
+// noPromotions has no automatic promotions; other methods are not used.
+type noPromotions struct {
+	repos.PromotionRepository
+}
+
+func (noPromotions) Automatic(context.Context) ([]*models.Promotion, error) {
+	return nil, nil
+}
+
+// stubOrderStore stores orders, or fails with err; other methods are not
+// used.
+type stubOrderStore struct {
+	repos.OrderRepository
+	created []*models.Order
+	err     error
+}
+
+func (s *stubOrderStore) Create(_ context.Context, order *models.Order) (*models.Order, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	order.Version = 1
+	s.created = append(s.created, order)
+	return order, nil
+}
+
+// noTransactions runs the work as it is, as a standalone server does.
+type noTransactions struct{}
+
+func (noTransactions) InTransaction(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+func (noTransactions) Atomic() bool { return false }
+
+func TestCheckout(t *testing.T) {
+	const productID = "64b7f0c2e4b0a1a2b3c4d5e6"
+	errStore := errors.New("store down")
+
+	tests := []struct {
+		name         string
+		status       string
+		expired      bool
+		version      int64
+		catalogPrice float64
+		// onLock changes the product once the cart is locked.
+		onLock       func(p *models.Product)
+		storeErr     error
+		wantErr      error
+		wantIssues   []string
+		wantStatuses []string
+		wantStock    int
+		wantPrice    float64
+	}{
+		{
+			name:         "placed at the quoted price",
+			version:      3,
+			catalogPrice: 10,
+			wantStatuses: []string{models.CartCheckingOut, models.CartCheckedOut},
+			wantStock:    3,
+			wantPrice:    10,
+		},
+		{
+			name:         "stale version",
+			version:      2,
+			catalogPrice: 10,
+			wantErr:      repos.ErrVersionConflict,
+			wantStock:    5,
+			wantPrice:    10,
+		},
+		{
+			name:         "locked by another checkout",
+			status:       models.CartCheckingOut,
+			catalogPrice: 10,
+			wantErr:      ErrCartClosed,
+			wantStock:    5,
+			wantPrice:    10,
+		},
+		{
+			name:         "expired",
+			expired:      true,
+			catalogPrice: 10,
+			wantErr:      repos.ErrNotFound,
+			wantStock:    5,
+			wantPrice:    10,
+		},
+		{
+			name:         "price changed before checkout",
+			catalogPrice: 12,
+			wantIssues:   []string{models.CartPriceChanged},
+			wantStock:    5,
+			wantPrice:    12,
+		},
+		{
+			name:         "price changed while locked",
+			catalogPrice: 10,
+			onLock:       func(p *models.Product) { p.Price = 12 },
+			wantIssues:   []string{models.CartPriceChanged},
+			wantStatuses: []string{models.CartCheckingOut, models.CartOpen},
+			wantStock:    5,
+			wantPrice:    12,
+		},
+		{
+			name:         "stock taken while locked",
+			catalogPrice: 10,
+			onLock: func(p *models.Product) {
+				p.Stock, p.Locations[0].Stock = 1, 1
+			},
+			wantIssues:   []string{models.CartOutOfStock},
+			wantStatuses: []string{models.CartCheckingOut, models.CartOpen},
+			wantStock:    1,
+			wantPrice:    10,
+		},
+		{
+			name:         "unlocked when the order is not stored",
+			catalogPrice: 10,
+			storeErr:     errStore,
+			wantErr:      errStore,
+			wantStatuses: []string{models.CartCheckingOut, models.CartOpen},
+			wantStock:    5,
+			wantPrice:    10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := &models.Product{
+				ID:        productID,
+				Name:      "Pen",
+				Price:     tt.catalogPrice,
+				Stock:     5,
+				Locations: []models.StockLevel{{WarehouseID: "w1", Stock: 5}},
+			}
+			products := stockedProducts{byID: map[string]*models.Product{productID: product}}
+			status := models.CartOpen
+			if tt.status != "" {
+				status = tt.status
+			}
+			expires := time.Now().Add(time.Hour)
+			if tt.expired {
+				expires = time.Now().Add(-time.Minute)
+			}
+			carts := &stubCarts{cart: &models.Cart{
+				ID:         "cart",
+				CustomerID: "c1",
+				Lines:      []models.CartLine{{ID: "l1", ProductID: productID, Quantity: 2, Price: 10}},
+				Status:     status,
+				Version:    3,
+				ExpiresAt:  expires,
+			}}
+			if tt.onLock != nil {
+				carts.onLock = func() { tt.onLock(product) }
+			}
+			store := &stubOrderStore{err: tt.storeErr}
+
+			taxes, err := NewTaxService(stubRates{}, products, false, models.TaxRoundPerLine)
+			if err != nil {
+				t.Fatalf("NewTaxService: %v", err)
+			}
+			promotions := NewPromotionService(noPromotions{}, products, nil)
+			orders := NewOrderService(products, store, nil, stubWarehouses{}, stubCustomers{}, promotions, taxes, PriorityStrategy{}, noTransactions{}, 0)
+			s := NewCartService(products, stubCustomers{}, carts, noPromotions{}, orders, time.Hour, zap.NewNop())
+
+			placed, err := s.Checkout(context.Background(), "cart", tt.version, &models.Checkout{})
+
+			var checkoutErr *CheckoutError
+			switch {
+			case tt.wantIssues != nil:
+				if !errors.As(err, &checkoutErr) {
+					t.Fatalf("error = %v, want a CheckoutError", err)
+				}
+				var problems []string
+				for _, issue := range checkoutErr.Cart.Issues {
+					problems = append(problems, issue.Problem)
+				}
+				if !reflect.DeepEqual(problems, tt.wantIssues) {
+					t.Errorf("issues = %v, want %v", problems, tt.wantIssues)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("Checkout: %v", err)
+			default:
+				if placed.Products[0].Price != tt.wantPrice || carts.cart.OrderID != placed.ID {
+					t.Errorf("placed at %v as %q, cart records %q", placed.Products[0].Price, placed.ID, carts.cart.OrderID)
+				}
+			}
+			if err != nil && len(store.created) != 0 {
+				t.Errorf("stored %d orders for a failed checkout", len(store.created))
+			}
+
+			if !reflect.DeepEqual(carts.statuses, tt.wantStatuses) {
+				t.Errorf("statuses = %v, want %v", carts.statuses, tt.wantStatuses)
+			}
+			if product.Stock != tt.wantStock || product.Locations[0].Stock != tt.wantStock {
+				t.Errorf("stock = %d at %d, want %d", product.Stock, product.Locations[0].Stock, tt.wantStock)
+			}
+			if price := carts.cart.Lines[0].Price; price != tt.wantPrice {
+				t.Errorf("cart line price = %v, want %v", price, tt.wantPrice)
+			}
+		})
+	}
+}
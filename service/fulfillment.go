@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/udevs/lesson3/models"
+)
+
+// Source is a warehouse that holds stock an order line can be filled from.
+type Source struct {
+	Warehouse *models.Warehouse
+	Stock     int
+}
+
+// FulfillmentStrategy decides which warehouses orders ship from. Stock of
+// a line is taken from the sources in the order Rank leaves them, moving to
+// the next one when a source runs out.
+type FulfillmentStrategy interface {
+	Rank(order *models.Order, sources []Source)
+}
+
+// NewFulfillmentStrategy returns the strategy called name: priority, most_stock
+// or nearest.
+func NewFulfillmentStrategy(name string) (FulfillmentStrategy, error) {
+	switch name {
+	case "priority":
+		return PriorityStrategy{}, nil
+	case "most_stock":
+		return MostStockStrategy{}, nil
+	case "nearest":
+		return NearestStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown fulfillment strategy %q, want priority, most_stock or nearest", name)
+	}
+}
+
+// byPriority orders warehouses by priority, then code.
+func byPriority(a, b *models.Warehouse) bool {
+	if a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	return a.Code < b.Code
+}
+
+// PriorityStrategy ships from the warehouses in their priority order.
+type PriorityStrategy struct{}
+
+func (PriorityStrategy) Rank(_ *models.Order, sources []Source) {
+	sort.SliceStable(sources, func(i, j int) bool {
+		return byPriority(sources[i].Warehouse, sources[j].Warehouse)
+	})
+}
+
+// MostStockStrategy ships from the warehouses holding the most stock of the
+// line first, which keeps orders from being split.
+type MostStockStrategy struct{}
+
+func (MostStockStrategy) Rank(_ *models.Order, sources []Source) {
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].Stock != sources[j].Stock {
+			return sources[i].Stock > sources[j].Stock
+		}
+		return byPriority(sources[i].Warehouse, sources[j].Warehouse)
+	})
+}
+
+// NearestStrategy ships from the warehouses closest to the order's ship_to
+// first. Warehouses without a location come last, and orders without
+// ship_to fall back to the priority order.
+type NearestStrategy struct{}
+
+func (NearestStrategy) Rank(order *models.Order, sources []Source) {
+	if order.ShipTo == nil {
+		PriorityStrategy{}.Rank(order, sources)
+		return
+	}
+	distance := func(w *models.Warehouse) float64 {
+		if w.Location == nil {
+			return math.Inf(1)
+		}
+		return order.ShipTo.DistanceKm(*w.Location)
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		di, dj := distance(sources[i].Warehouse), distance(sources[j].Warehouse)
+		if di != dj {
+			return di < dj
+		}
+		return byPriority(sources[i].Warehouse, sources[j].Warehouse)
+	})
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+)
+
+var (
+	// ErrPaymentDeclined is wrapped by the errors of gateways that refuse
+	// a payment, with the reason they give.
+	ErrPaymentDeclined = errors.New("payment declined")
+
+	// ErrInvalidSignature is returned for webhook requests whose signature
+	// does not verify.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// PaymentRequest asks a gateway to authorize an amount.
+type PaymentRequest struct {
+	// Reference identifies the payment to the gateway.
+	Reference string
+	Amount    float64
+	Currency  string
+	// Method is the gateway token for the means of payment, such as a
+	// tokenized card; card details never reach this service.
+	Method string
+}
+
+// PaymentAuthorization is the answer of a gateway to an authorization.
+type PaymentAuthorization struct {
+	TransactionID string
+	// Pending is set when the gateway confirms the authorization later,
+	// through a webhook.
+	Pending bool
+}
+
+// WebhookEvent is a change to a payment reported by a gateway.
+type WebhookEvent struct {
+	// ID identifies the event, so repeated deliveries are applied once.
+	ID            string
+	Type          string
+	TransactionID string
+	Amount        float64
+	// Reason is why a payment failed.
+	Reason string
+}
+
+// PaymentProvider is a payment gateway. Amounts are in the major unit of
+// the currency, such as dollars.
+type PaymentProvider interface {
+	// Name is the name the provider is configured by and payments record.
+	Name() string
+	// Authorize reserves an amount, failing with an error wrapping
+	// ErrPaymentDeclined when the gateway refuses it.
+	Authorize(ctx context.Context, req PaymentRequest) (*PaymentAuthorization, error)
+	// Capture takes up to the authorized amount.
+	Capture(ctx context.Context, transactionID string, amount float64) error
+	// Refund gives back up to the captured amount.
+	Refund(ctx context.Context, transactionID string, amount float64) error
+	// Void releases an authorization that was not captured.
+	Void(ctx context.Context, transactionID string) error
+	// ParseWebhook verifies the signature of a webhook request and returns
+	// the event it carries, failing with ErrInvalidSignature.
+	ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error)
+}
+
+// PaymentProviderConfig is what gateways are set up with.
+type PaymentProviderConfig struct {
+	APIKey        string
+	WebhookSecret string
+}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]func(PaymentProviderConfig) (PaymentProvider, error){
+		"fake": func(cfg PaymentProviderConfig) (PaymentProvider, error) {
+			return NewFakePaymentProvider(cfg.WebhookSecret), nil
+		},
+	}
+)
+
+// RegisterPaymentProvider makes a gateway adapter available under name, for
+// NewPaymentProvider.
+func RegisterPaymentProvider(name string, factory func(PaymentProviderConfig) (PaymentProvider, error)) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+// NewPaymentProvider returns the gateway registered under name; fake is
+// always registered.
+func NewPaymentProvider(name string, cfg PaymentProviderConfig) (PaymentProvider, error) {
+	providersMu.Lock()
+	factory, ok := providers[name]
+	names := make([]string, 0, len(providers))
+	for n := range providers {
+		names = append(names, n)
+	}
+	providersMu.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown payment provider %q, want %s", name, strings.Join(names, ", "))
+	}
+	return factory(cfg)
+}
+
+// Payment methods the fake provider treats specially; any other method is
+// authorized at once.
+const (
+	FakeMethodDeclined          = "fake_declined"
+	FakeMethodInsufficientFunds = "fake_insufficient_funds"
+	// FakeMethodAsync leaves the authorization pending until a webhook
+	// confirms it.
+	FakeMethodAsync = "fake_async"
+)
+
+// fakeSignatureTolerance is how old a webhook may be, so recorded requests
+// cannot be replayed later.
+const fakeSignatureTolerance = 5 * time.Minute
+
+// FakePaymentProvider is a gateway for local use and tests that moves no
+// money. Its answers depend only on the request: the transaction ID is
+// derived from the reference, and the method decides the outcome.
+//
+// Its webhooks are JSON objects {"id", "type", "transaction_id", "amount",
+// "reason"}, signed like Stripe's: the Fake-Signature header holds
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">", keyed by the
+// webhook secret.
+type FakePaymentProvider struct {
+	secret string
+	now    func() time.Time
+}
+
+func NewFakePaymentProvider(webhookSecret string) *FakePaymentProvider {
+	return &FakePaymentProvider{secret: webhookSecret, now: time.Now}
+}
+
+func (p *FakePaymentProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakePaymentProvider) Authorize(_ context.Context, req PaymentRequest) (*PaymentAuthorization, error) {
+	if req.Amount <= 0 || math.IsNaN(req.Amount) {
+		return nil, fmt.Errorf("%w: invalid amount", ErrPaymentDeclined)
+	}
+	switch req.Method {
+	case FakeMethodDeclined:
+		return nil, fmt.Errorf("%w: card declined", ErrPaymentDeclined)
+	case FakeMethodInsufficientFunds:
+		return nil, fmt.Errorf("%w: insufficient funds", ErrPaymentDeclined)
+	}
+	return &PaymentAuthorization{
+		TransactionID: "fake_" + req.Reference,
+		Pending:       req.Method == FakeMethodAsync,
+	}, nil
+}
+
+func (p *FakePaymentProvider) check(transactionID string) error {
+	if !strings.HasPrefix(transactionID, "fake_") {
+		return fmt.Errorf("fake provider: unknown transaction %q", transactionID)
+	}
+	return nil
+}
+
+func (p *FakePaymentProvider) Capture(_ context.Context, transactionID string, _ float64) error {
+	return p.check(transactionID)
+}
+
+func (p *FakePaymentProvider) Refund(_ context.Context, transactionID string, _ float64) error {
+	return p.check(transactionID)
+}
+
+func (p *FakePaymentProvider) Void(_ context.Context, transactionID string) error {
+	return p.check(transactionID)
+}
+
+// Sign returns the Fake-Signature header for a webhook body sent at the
+// given time, for tools that play the part of the gateway.
+func (p *FakePaymentProvider) Sign(body []byte, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + ts + ",v1=" + p.mac(ts, body)
+}
+
+func (p *FakePaymentProvider) mac(ts string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(p.secret))
+	m.Write([]byte(ts + "."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func (p *FakePaymentProvider) ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error) {
+	// Without a secret any request could pass as the gateway.
+	if p.secret == "" {
+		return nil, fmt.Errorf("%w: no webhook secret is configured", ErrInvalidSignature)
+	}
+	var ts, sig string
+	for _, part := range strings.Split(header.Get("Fake-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return nil, ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(p.mac(ts, body))) {
+		return nil, ErrInvalidSignature
+	}
+	if age := p.now().Sub(time.Unix(unix, 0)); age > fakeSignatureTolerance || age < -fakeSignatureTolerance {
+		return nil, fmt.Errorf("%w: timestamp out of tolerance", ErrInvalidSignature)
+	}
+
+	var event struct {
+		ID            string  `json:"id"`
+		Type          string  `json:"type"`
+		TransactionID string  `json:"transaction_id"`
+		Amount        float64 `json:"amount"`
+		Reason        string  `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayment, err)
+	}
+	switch event.Type {
+	case models.PaymentEventAuthorized, models.PaymentEventCaptured, models.PaymentEventRefunded,
+		models.PaymentEventVoided, models.PaymentEventFailed:
+	default:
+		return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidPayment, event.Type)
+	}
+	if event.ID == "" || event.TransactionID == "" {
+		return nil, fmt.Errorf("%w: events need an id and a transaction_id", ErrInvalidPayment)
+	}
+	return &WebhookEvent{
+		ID:            event.ID,
+		Type:          event.Type,
+		TransactionID: event.TransactionID,
+		Amount:        event.Amount,
+		Reason:        event.Reason,
+	}, nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseWebhook(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1","type":"captured","transaction_id":"fake_1","amount":12.5}`)
+	signed := func(secret string, body []byte, at time.Time) http.Header {
+		return http.Header{"Fake-Signature": {NewFakePaymentProvider(secret).Sign(body, at)}}
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  http.Header
+		body    []byte
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			secret: "whsec",
+			header: signed("whsec", body, now),
+			body:   body,
+		},
+		{
+			name:   "inside the tolerance",
+			secret: "whsec",
+			header: signed("whsec", body, now.Add(-fakeSignatureTolerance)),
+			body:   body,
+		},
+		{
+			name:    "no secret configured",
+			secret:  "",
+			header:  signed("", body, now),
+			body:    body,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "no signature",
+			secret:  "whsec",
+			header:  http.Header{},
+			body:    body,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "malformed signature",
+			secret:  "whsec",
+			header:  http.Header{"Fake-Signature": {"v1=abc"}},
+			body:    body,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "signed with another secret",
+			secret:  "whsec",
+			header:  signed("other", body, now),
+			body:    body,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "tampered body",
+			secret:  "whsec",
+			header:  signed("whsec", body, now),
+			body:    []byte(`{"id":"evt_1","type":"captured","transaction_id":"fake_1","amount":1250}`),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "timestamp too old",
+			secret:  "whsec",
+			header:  signed("whsec", body, now.Add(-fakeSignatureTolerance-time.Second)),
+			body:    body,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "timestamp in the future",
+			secret:  "whsec",
+			header:  signed("whsec", body, now.Add(fakeSignatureTolerance+time.Second)),
+			body:    body,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "not json",
+			secret:  "whsec",
+			header:  signed("whsec", []byte("captured"), now),
+			body:    []byte("captured"),
+			wantErr: ErrInvalidPayment,
+		},
+		{
+			name:    "unknown type",
+			secret:  "whsec",
+			header:  signed("whsec", []byte(`{"id":"evt_1","type":"disputed","transaction_id":"fake_1"}`), now),
+			body:    []byte(`{"id":"evt_1","type":"disputed","transaction_id":"fake_1"}`),
+			wantErr: ErrInvalidPayment,
+		},
+		{
+			name:    "no event id",
+			secret:  "whsec",
+			header:  signed("whsec", []byte(`{"type":"captured","transaction_id":"fake_1"}`), now),
+			body:    []byte(`{"type":"captured","transaction_id":"fake_1"}`),
+			wantErr: ErrInvalidPayment,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFakePaymentProvider(tt.secret)
+			p.now = func() time.Time { return now }
+			event, err := p.ParseWebhook(tt.header, tt.body)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWebhook: %v", err)
+			}
+			want := WebhookEvent{ID: "evt_1", Type: "captured", TransactionID: "fake_1", Amount: 12.5}
+			if *event != want {
+				t.Errorf("event = %+v, want %+v", *event, want)
+			}
+		})
+	}
+}
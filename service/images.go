@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/blob"
+	"github.com/udevs/lesson3/pkg/imaging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrImageTooLarge is returned for uploads over the configured size limit.
+var ErrImageTooLarge = errors.New("image too large")
+
+// ImageService turns uploads into stored images with thumbnails.
+type ImageService struct {
+	store     blob.Store
+	sizes     []imaging.Size
+	maxBytes  int64
+	publicURL string
+}
+
+func NewImageService(store blob.Store, sizes []imaging.Size, maxBytes int64, publicURL string) *ImageService {
+	return &ImageService{
+		store:     store,
+		sizes:     sizes,
+		maxBytes:  maxBytes,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}
+}
+
+// MaxBytes is the largest accepted upload.
+func (s *ImageService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Store validates an uploaded image of a product and stores it together
+// with a thumbnail for every configured size. Nothing is left behind in
+// the blob store when it fails.
+func (s *ImageService) Store(ctx context.Context, productID string, data []byte, alt string) (*models.Image, error) {
+	if int64(len(data)) > s.maxBytes {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrImageTooLarge, s.maxBytes)
+	}
+	img, contentType, err := imaging.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id := primitive.NewObjectID().Hex()
+	prefix := "products/" + productID + "/" + id + "/"
+	bounds := img.Bounds()
+	image := &models.Image{
+		ID:          id,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Alt:         alt,
+		Thumbnails:  map[string]string{},
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+
+	image.URL, err = s.put(ctx, image, prefix+"original", data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	for _, size := range s.sizes {
+		encoded, thumbType, err := imaging.Encode(imaging.Thumbnail(img, size), contentType)
+		if err == nil {
+			image.Thumbnails[size.String()], err = s.put(ctx, image, prefix+size.String(), encoded, thumbType)
+		}
+		if err != nil {
+			return nil, errors.Join(err, s.Discard(ctx, image))
+		}
+	}
+	return image, nil
+}
+
+// put stores one blob of image, recording its key, and returns its URL.
+func (s *ImageService) put(ctx context.Context, image *models.Image, key string, data []byte, contentType string) (string, error) {
+	err := s.store.Put(ctx, key, bytes.NewReader(data), blob.Info{ContentType: contentType, Size: int64(len(data))})
+	if err != nil {
+		return "", err
+	}
+	image.Keys = append(image.Keys, key)
+	return s.publicURL + "/" + key, nil
+}
+
+// Discard removes the blobs of an image. It keeps going past failures, so
+// as much as possible is removed, and reports them all.
+func (s *ImageService) Discard(ctx context.Context, image *models.Image) error {
+	ctx = context.WithoutCancel(ctx)
+	var errs []error
+	for _, key := range image.Keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
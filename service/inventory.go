@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type InventoryService struct {
+	products   repos.ProductRepository
+	orders     repos.OrderRepository
+	warehouses repos.WarehouseRepository
+}
+
+func NewInventoryService(products repos.ProductRepository, orders repos.OrderRepository, warehouses repos.WarehouseRepository) *InventoryService {
+	return &InventoryService{products: products, orders: orders, warehouses: warehouses}
+}
+
+// Adjust applies a manual stock change to a product at a warehouse, the
+// default one unless the adjustment names one, and records it in the stock
+// ledger. A return naming an order must be of something the order shipped.
+// Invalid adjustments fail with an error wrapping
+// models.ErrInvalidAdjustment.
+func (s *InventoryService) Adjust(ctx context.Context, productID string, adj *models.StockAdjustment) (*models.Product, error) {
+	if err := adj.Validate(); err != nil {
+		return nil, err
+	}
+	if adj.OrderID != "" {
+		if err := s.checkReturn(ctx, productID, adj); err != nil {
+			return nil, err
+		}
+	}
+	warehouse, err := s.warehouse(ctx, adj.WarehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.products.AdjustStock(ctx, &models.StockMovement{
+		ProductID:   productID,
+		VariantID:   adj.VariantID,
+		WarehouseID: warehouse.ID,
+		Type:        adj.Type,
+		Quantity:    adj.Quantity,
+		Reason:      adj.Reason,
+		OrderID:     adj.OrderID,
+	})
+	if errors.Is(err, repos.ErrInvalidReference) {
+		return nil, fmt.Errorf("%w: products with variants are adjusted by variant_id, others without it; bundles hold no stock of their own", models.ErrInvalidAdjustment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.products.FindByID(ctx, productID)
+}
+
+// warehouse returns the warehouse with the given ID, or the default one
+// for an empty ID.
+func (s *InventoryService) warehouse(ctx context.Context, id string) (*models.Warehouse, error) {
+	if id == "" {
+		warehouse, err := s.warehouses.Default(ctx)
+		if errors.Is(err, repos.ErrNotFound) {
+			return nil, fmt.Errorf("%w: there is no warehouse to keep stock in", models.ErrInvalidAdjustment)
+		}
+		return warehouse, err
+	}
+	warehouse, err := s.warehouses.FindByID(ctx, id)
+	if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(id) {
+		return nil, fmt.Errorf("%w: unknown warehouse %q", models.ErrInvalidAdjustment, id)
+	}
+	return warehouse, err
+}
+
+// Availability returns the stock of a product at each warehouse that holds
+// some, in the order of the warehouses' priority.
+func (s *InventoryService) Availability(ctx context.Context, productID string) ([]models.WarehouseStock, error) {
+	product, err := s.products.FindByID(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	warehouses, err := s.warehouses.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	availability := []models.WarehouseStock{}
+	for _, w := range warehouses {
+		stock := models.WarehouseStock{WarehouseID: w.ID, Code: w.Code, Name: w.Name}
+		held := false
+		for _, l := range product.Locations {
+			if l.WarehouseID != w.ID {
+				continue
+			}
+			held = true
+			stock.Stock += l.Stock
+			if l.VariantID != "" {
+				if stock.Variants == nil {
+					stock.Variants = map[string]int{}
+				}
+				stock.Variants[l.VariantID] = l.Stock
+			}
+		}
+		if held {
+			availability = append(availability, stock)
+		}
+	}
+	return availability, nil
+}
+
+func (s *InventoryService) checkReturn(ctx context.Context, productID string, adj *models.StockAdjustment) error {
+	order, err := s.orders.FindByID(ctx, adj.OrderID)
+	if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(adj.OrderID) {
+		return fmt.Errorf("%w: unknown order %q", models.ErrInvalidAdjustment, adj.OrderID)
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range order.Products {
+		if line.ProductID == productID && line.VariantID == adj.VariantID {
+			return nil
+		}
+		for _, c := range line.Components {
+			if c.ProductID == productID && c.VariantID == adj.VariantID {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: order %s did not ship this product", models.ErrInvalidAdjustment, order.ID)
+}
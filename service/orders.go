@@ -0,0 +1,603 @@
+// Package service holds the operations that span several repositories.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidOrder is wrapped by the errors for orders that cannot be placed
+// as given, e.g. because a line names an unknown product.
+var ErrInvalidOrder = errors.New("invalid order")
+
+// ErrPriceChanged is returned when the catalog price of a line differs from
+// the price it was quoted at.
+var ErrPriceChanged = errors.New("price changed")
+
+type OrderService struct {
+	products   repos.ProductRepository
+	orders     repos.OrderRepository
+	prices     repos.PriceHistoryRepository
+	warehouses repos.WarehouseRepository
+	customers  repos.CustomerRepository
+	promotions *PromotionService
+	taxes      *TaxService
+	strategy   FulfillmentStrategy
+	tx         repos.Transactor
+	// shippingFee is charged on every order, less free shipping
+	// promotions.
+	shippingFee float64
+}
+
+func NewOrderService(products repos.ProductRepository, orders repos.OrderRepository, prices repos.PriceHistoryRepository, warehouses repos.WarehouseRepository, customers repos.CustomerRepository, promotions *PromotionService, taxes *TaxService, strategy FulfillmentStrategy, tx repos.Transactor, shippingFee float64) *OrderService {
+	return &OrderService{
+		products:    products,
+		orders:      orders,
+		prices:      prices,
+		warehouses:  warehouses,
+		customers:   customers,
+		promotions:  promotions,
+		taxes:       taxes,
+		strategy:    strategy,
+		tx:          tx,
+		shippingFee: shippingFee,
+	}
+}
+
+// stockKey identifies the stock a deduction is taken from.
+type stockKey struct {
+	productID string
+	variantID string
+}
+
+// Place prices the order from the catalog, applies the promotions that fit
+// it and taxes it, takes the ordered stock and stores the order. The customer must
+// exist; copies of the shipping and billing addresses picked, or of the
+// default ones, are kept on the order. Coupon codes that cannot be used
+// fail the order, and the uses of the promotions applied count towards
+// their limits. Bundle lines take the stock of their components. Stock is
+// taken from the warehouses the fulfillment strategy prefers, as recorded
+// in the allocations of the order.
+//
+// Every deduction is a conditional update of one product, so stock never
+// goes negative. The deductions and the order are written in one
+// transaction where the database has them, so that a bundle takes all of
+// its components or none. A standalone server has no transactions: there,
+// if a deduction fails, the ones already made are put back and the order
+// is not stored, and stock left taken by a crash in between is reported as
+// leaked by the consistency check. Deductions are recorded in the stock
+// ledger as sales of the order, under the ID it is then stored with.
+func (s *OrderService) Place(ctx context.Context, order *models.Order) (*models.Order, error) {
+	return s.PlaceQuoted(ctx, order, nil)
+}
+
+// PlaceQuoted places the order like Place, at the prices its lines were
+// quoted at, in order: when the catalog price of a line differs, it fails
+// with ErrPriceChanged before anything is taken. Nil quotes take the
+// catalog prices.
+func (s *OrderService) PlaceQuoted(ctx context.Context, order *models.Order, quoted []float64) (*models.Order, error) {
+	if quoted != nil && len(quoted) != len(order.Products) {
+		return nil, fmt.Errorf("%w: %d quotes for %d lines", ErrInvalidOrder, len(quoted), len(order.Products))
+	}
+	if len(order.Products) == 0 {
+		return nil, fmt.Errorf("%w: an order needs at least one line", ErrInvalidOrder)
+	}
+	customer, err := s.customer(ctx, order.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pickAddresses(order, customer); err != nil {
+		return nil, err
+	}
+
+	products := make([]*models.Product, len(order.Products))
+	for i := range order.Products {
+		line := &order.Products[i]
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: line %d needs a positive quantity", ErrInvalidOrder, i+1)
+		}
+
+		product, err := s.product(ctx, line.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkVariant(product, line.VariantID); err != nil {
+			return nil, err
+		}
+		line.Price = product.PriceOf(line.VariantID)
+		if quoted != nil && roundCents(line.Price) != roundCents(quoted[i]) {
+			return nil, fmt.Errorf("%w: line %d costs %.2f, not the %.2f quoted", ErrPriceChanged, i+1, line.Price, quoted[i])
+		}
+		products[i] = product
+	}
+	promotions, err := s.discount(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.taxes.Apply(ctx, order); err != nil {
+		return nil, err
+	}
+
+	deductions := map[stockKey]int{}
+	for i := range order.Products {
+		line := &order.Products[i]
+		if !products[i].IsBundle() {
+			line.Cost = products[i].Cost
+			deductions[stockKey{line.ProductID, line.VariantID}] += line.Quantity
+			continue
+		}
+		components, err := s.components(ctx, products[i], line, order.TaxInclusive)
+		if err != nil {
+			return nil, err
+		}
+		line.Components = components
+		cost := 0.0
+		for _, c := range components {
+			deductions[stockKey{c.ProductID, c.VariantID}] += c.Quantity
+			cost += c.Cost
+		}
+		line.Cost = roundCents(cost / float64(line.Quantity))
+	}
+	order.ID = primitive.NewObjectID().Hex()
+	order.PaymentStatus = models.OrderUnpaid
+	order.Held = 0
+
+	if err := s.promotions.Redeem(ctx, promotions, order.CustomerID); err != nil {
+		return nil, err
+	}
+	// Putting stock and promotion uses back must not be cut short by a
+	// cancelled request.
+	undo := context.WithoutCancel(ctx)
+	var created *models.Order
+	err = s.tx.InTransaction(ctx, func(ctx context.Context) error {
+		allocations, err := s.deduct(ctx, order, deductions)
+		if err == nil {
+			order.Allocations = allocations
+			created, err = s.orders.Create(ctx, order)
+		}
+		if err != nil && !s.tx.Atomic() {
+			err = errors.Join(err, s.restore(ctx, order.ID, allocations))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, errors.Join(err, s.promotions.Release(undo, promotions, order.CustomerID))
+	}
+	return created, nil
+}
+
+// Quote prices an order whose lines are priced as it would be placed: the
+// shipping fee is added, promotions applied and tax added at the default
+// addresses of the customer, if any. It returns the coupon codes that were
+// not applied.
+func (s *OrderService) Quote(ctx context.Context, order *models.Order) ([]models.RejectedCoupon, error) {
+	if order.CustomerID != "" {
+		customer, err := s.customers.FindByID(ctx, order.CustomerID)
+		switch {
+		case err == nil:
+			if err := pickAddresses(order, customer); err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, repos.ErrNotFound):
+			return nil, err
+		}
+	}
+	order.Shipping = s.shippingFee
+	rejected, _, err := s.promotions.Apply(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	return rejected, s.taxes.Apply(ctx, order)
+}
+
+// discount quotes the order, failing when one of its coupon codes cannot
+// be used, and returns the promotions applied.
+func (s *OrderService) discount(ctx context.Context, order *models.Order) ([]*models.Promotion, error) {
+	order.Shipping = s.shippingFee
+	rejected, promotions, err := s.promotions.Apply(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rejected {
+		if !r.Outranked {
+			return nil, fmt.Errorf("%w: coupon %s: %s", ErrInvalidOrder, r.Code, r.Reason)
+		}
+	}
+	return promotions, nil
+}
+
+// Update replaces an order after checking its customer when that changes.
+// The lines of a placed order are fixed: they must name the same products,
+// variants and quantities as the stored ones, their prices, discounts, tax
+// and cost, and the order total, are kept or must match, and what returns
+// recorded on them is kept. The refund statuses are set by returns only. The
+// allocations and addresses recorded when the order was placed are kept.
+func (s *OrderService) Update(ctx context.Context, id string, order *models.Order) (*models.Order, error) {
+	current, err := s.orders.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	order.Allocations = current.Allocations
+	if order.CustomerID != current.CustomerID {
+		if _, err := s.customer(ctx, order.CustomerID); err != nil {
+			return nil, err
+		}
+	}
+	if order.Status != current.Status &&
+		(order.Status == models.OrderStatusRefunded || order.Status == models.OrderStatusPartiallyRefunded) {
+		return nil, fmt.Errorf("%w: orders move to %s through returns only", ErrInvalidOrder, order.Status)
+	}
+	if err := keepLines(order, current); err != nil {
+		return nil, err
+	}
+	if err := keepPrice("total_price", &order.TotalPrice, current.TotalPrice); err != nil {
+		return nil, err
+	}
+	return s.orders.Update(ctx, id, order)
+}
+
+// keepLines checks that the lines of order are those of the stored order
+// current and carries over what returns recorded on them.
+func keepLines(order, current *models.Order) error {
+	if len(order.Products) != len(current.Products) {
+		return fmt.Errorf("%w: the lines of a placed order cannot be added or removed", ErrInvalidOrder)
+	}
+	for i := range order.Products {
+		line, stored := &order.Products[i], current.Products[i]
+		if line.ProductID != stored.ProductID || line.VariantID != stored.VariantID || line.Quantity != stored.Quantity {
+			return fmt.Errorf("%w: line %d of a placed order cannot change its product or quantity", ErrInvalidOrder, i+1)
+		}
+		line.Returned, line.Restocked = stored.Returned, stored.Restocked
+		line.Refunded, line.RefundedTax = stored.Refunded, stored.RefundedTax
+
+		// What the line was charged and cost was worked out when the order
+		// was placed, and the order totals and reports rest on it.
+		prices := []struct {
+			field  string
+			given  *float64
+			stored float64
+		}{
+			{"price", &line.Price, stored.Price},
+			{"discount", &line.Discount, stored.Discount},
+			{"tax_rate", &line.TaxRate, stored.TaxRate},
+			{"tax", &line.Tax, stored.Tax},
+			{"cost", &line.Cost, stored.Cost},
+		}
+		for _, p := range prices {
+			if err := keepPrice(fmt.Sprintf("line %d %s", i+1, p.field), p.given, p.stored); err != nil {
+				return err
+			}
+		}
+		line.TaxClass, line.Components = stored.TaxClass, stored.Components
+	}
+	return nil
+}
+
+// keepPrice sets an amount of an update to the stored one, failing when
+// the update names a different one; zero stands for leaving it out.
+func keepPrice(field string, given *float64, stored float64) error {
+	if *given != 0 && roundCents(*given) != roundCents(stored) {
+		return fmt.Errorf("%w: %s is worked out when the order is placed and cannot be changed", ErrInvalidOrder, field)
+	}
+	*given = stored
+	return nil
+}
+
+// Recompute prices the lines of an order at the catalog prices in effect
+// when it was placed, as recorded in the price history.
+func (s *OrderService) Recompute(ctx context.Context, id string) (*models.OrderRecomputation, error) {
+	order, err := s.orders.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	at, err := time.Parse(time.RFC3339, order.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("order %s has no valid creation time: %w", id, err)
+	}
+
+	result := &models.OrderRecomputation{
+		OrderID: order.ID,
+		At:      order.CreatedAt,
+		Lines:   make([]models.RecomputedLine, 0, len(order.Products)),
+	}
+	charged, catalog := 0.0, 0.0
+	for _, line := range order.Products {
+		recomputed := models.RecomputedLine{
+			ProductID:    line.ProductID,
+			VariantID:    line.VariantID,
+			Quantity:     line.Quantity,
+			ChargedPrice: line.Price,
+		}
+		price := line.Price
+		point, err := s.prices.PriceAt(ctx, line.ProductID, line.VariantID, at)
+		switch {
+		case err == nil:
+			price = point.Price
+			recomputed.CatalogPrice = &point.Price
+			recomputed.Difference = roundCents((line.Price - point.Price) * float64(line.Quantity))
+		case !errors.Is(err, repos.ErrNotFound):
+			return nil, err
+		}
+		charged += line.Price * float64(line.Quantity)
+		catalog += price * float64(line.Quantity)
+		result.Lines = append(result.Lines, recomputed)
+	}
+	result.ChargedTotal = roundCents(charged)
+	result.CatalogTotal = roundCents(catalog)
+	result.Difference = roundCents(charged - catalog)
+	return result, nil
+}
+
+// Report summarises the orders, and the catalog price changes, between
+// startDate and endDate.
+func (s *OrderService) Report(ctx context.Context, startDate, endDate string) (*models.SalesReport, error) {
+	report, err := s.orders.GenerateReport(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.prices.ChangeStats(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	report.PriceChanges = *stats
+	return report, nil
+}
+
+func (s *OrderService) product(ctx context.Context, id string) (*models.Product, error) {
+	product, err := s.products.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(id) {
+			return nil, fmt.Errorf("%w: unknown product %q", ErrInvalidOrder, id)
+		}
+		return nil, err
+	}
+	return product, nil
+}
+
+func (s *OrderService) customer(ctx context.Context, id string) (*models.Customer, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: an order needs a customer_id", ErrInvalidOrder)
+	}
+	customer, err := s.customers.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(id) {
+			return nil, fmt.Errorf("%w: unknown customer %q", ErrInvalidOrder, id)
+		}
+		return nil, err
+	}
+	return customer, nil
+}
+
+// pickAddresses copies the shipping and billing addresses the order names,
+// or the default ones of the customer, onto the order. Without a ship_to,
+// the order is shipped to the location of the shipping address.
+func pickAddresses(order *models.Order, customer *models.Customer) error {
+	var err error
+	order.ShippingAddress, err = pickAddress(customer, order.ShippingAddressID, models.AddressShipping)
+	if err != nil {
+		return err
+	}
+	order.BillingAddress, err = pickAddress(customer, order.BillingAddressID, models.AddressBilling)
+	if err != nil {
+		return err
+	}
+	if order.ShipTo == nil && order.ShippingAddress != nil && order.ShippingAddress.Location != nil {
+		location := *order.ShippingAddress.Location
+		order.ShipTo = &location
+	}
+	return nil
+}
+
+func pickAddress(customer *models.Customer, id, typ string) (*models.Address, error) {
+	address := customer.DefaultAddress(typ)
+	if id != "" {
+		address = customer.Address(id)
+		if address == nil || address.Type != typ {
+			return nil, fmt.Errorf("%w: customer %q has no %s address %q", ErrInvalidOrder, customer.ID, typ, id)
+		}
+	}
+	if address == nil {
+		return nil, nil
+	}
+	picked := *address
+	picked.Default = false
+	return &picked, nil
+}
+
+// checkVariant requires a variant exactly for products that have variants.
+func checkVariant(product *models.Product, variantID string) error {
+	if problem := variantProblem(product, variantID); problem != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidOrder, problem)
+	}
+	return nil
+}
+
+// variantProblem explains why variantID cannot be used with product, or
+// returns "".
+func variantProblem(product *models.Product, variantID string) string {
+	switch {
+	case variantID == "" && len(product.Variants) > 0:
+		return fmt.Sprintf("product %q is sold in variants; choose one with variant_id", product.ID)
+	case variantID != "" && product.Variant(variantID) == nil:
+		return fmt.Sprintf("product %q has no variant %q", product.ID, variantID)
+	}
+	return ""
+}
+
+// components expands a bundle line into what it ships and splits the line
+// revenue between the components in proportion to their catalog prices.
+func (s *OrderService) components(ctx context.Context, bundle *models.Product, line *models.ProductInOrder, taxInclusive bool) ([]models.OrderComponent, error) {
+	components := make([]models.OrderComponent, 0, len(bundle.Bundle.Components))
+	weights := make([]float64, 0, len(bundle.Bundle.Components))
+	weightSum := 0.0
+	for _, c := range bundle.Bundle.Components {
+		product, err := s.product(ctx, c.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkVariant(product, c.VariantID); err != nil {
+			return nil, err
+		}
+		quantity := c.Quantity * line.Quantity
+		components = append(components, models.OrderComponent{
+			ProductID: c.ProductID,
+			VariantID: c.VariantID,
+			Name:      product.Name,
+			Quantity:  quantity,
+			Cost:      roundCents(product.Cost * float64(quantity)),
+		})
+		w := product.PriceOf(c.VariantID) * float64(quantity)
+		weights = append(weights, w)
+		weightSum += w
+	}
+
+	revenue := line.Price*float64(line.Quantity) - line.Discount
+	if taxInclusive {
+		revenue -= line.Tax
+	}
+	revenue = roundCents(revenue)
+	remaining := revenue
+	for i := range components {
+		share := float64(components[i].Quantity) / float64(totalQuantity(components))
+		if weightSum > 0 {
+			share = weights[i] / weightSum
+		}
+		if i == len(components)-1 {
+			// The last component takes the rounding remainder so the shares
+			// add up to the line revenue exactly.
+			components[i].Revenue = roundCents(remaining)
+			break
+		}
+		components[i].Revenue = roundCents(revenue * share)
+		remaining -= components[i].Revenue
+	}
+	return components, nil
+}
+
+func totalQuantity(components []models.OrderComponent) int {
+	n := 0
+	for _, c := range components {
+		n += c.Quantity
+	}
+	return n
+}
+
+// deduct takes the stock in a fixed order and returns where it took it
+// from, also when it fails.
+func (s *OrderService) deduct(ctx context.Context, order *models.Order, deductions map[stockKey]int) ([]models.StockAllocation, error) {
+	keys := make([]stockKey, 0, len(deductions))
+	for k := range deductions {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].productID != keys[j].productID {
+			return keys[i].productID < keys[j].productID
+		}
+		return keys[i].variantID < keys[j].variantID
+	})
+
+	list, err := s.warehouses.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	warehouses := make(map[string]*models.Warehouse, len(list))
+	for _, w := range list {
+		warehouses[w.ID] = w
+	}
+
+	var taken []models.StockAllocation
+	for _, k := range keys {
+		allocations, err := s.allocate(ctx, order, k, deductions[k], warehouses)
+		taken = append(taken, allocations...)
+		if err != nil {
+			return taken, err
+		}
+	}
+	return taken, nil
+}
+
+// allocate takes quantity of a product or variant from the warehouses
+// holding it, in the order the fulfillment strategy ranks them. It returns
+// what it took, also when it fails.
+func (s *OrderService) allocate(ctx context.Context, order *models.Order, k stockKey, quantity int, warehouses map[string]*models.Warehouse) ([]models.StockAllocation, error) {
+	product, err := s.products.FindByID(ctx, k.productID)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	for _, l := range product.Locations {
+		if w := warehouses[l.WarehouseID]; w != nil && l.VariantID == k.variantID && l.Stock > 0 {
+			sources = append(sources, Source{Warehouse: w, Stock: l.Stock})
+		}
+	}
+	s.strategy.Rank(order, sources)
+
+	var allocations []models.StockAllocation
+	for _, source := range sources {
+		if quantity == 0 {
+			break
+		}
+		n := min(quantity, source.Stock)
+		err := s.products.AdjustStock(ctx, &models.StockMovement{
+			ProductID:   k.productID,
+			VariantID:   k.variantID,
+			WarehouseID: source.Warehouse.ID,
+			Type:        models.MovementSale,
+			Quantity:    -n,
+			OrderID:     order.ID,
+		})
+		if errors.Is(err, repos.ErrInsufficientStock) {
+			// Another order took the stock since it was read.
+			continue
+		}
+		if err != nil {
+			return allocations, err
+		}
+		allocations = append(allocations, models.StockAllocation{
+			ProductID:   k.productID,
+			VariantID:   k.variantID,
+			WarehouseID: source.Warehouse.ID,
+			Quantity:    n,
+		})
+		quantity -= n
+	}
+	if quantity > 0 {
+		return allocations, fmt.Errorf("%w: product %s", repos.ErrInsufficientStock, k.productID)
+	}
+	return allocations, nil
+}
+
+// restore puts back stock taken by deduct, recording it as an adjustment
+// that cancels the sale. It runs even when ctx has been cancelled, since
+// giving up would leak the stock.
+func (s *OrderService) restore(ctx context.Context, orderID string, allocations []models.StockAllocation) error {
+	ctx = context.WithoutCancel(ctx)
+	var errs []error
+	for _, a := range allocations {
+		err := s.products.AdjustStock(ctx, &models.StockMovement{
+			ProductID:   a.ProductID,
+			VariantID:   a.VariantID,
+			WarehouseID: a.WarehouseID,
+			Type:        models.MovementAdjustment,
+			Quantity:    a.Quantity,
+			Reason:      "order not placed",
+			OrderID:     orderID,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring stock of %s: %w", a.ProductID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
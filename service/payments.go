@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidPayment is wrapped by the errors for payment requests, and
+// webhook events, that cannot be applied as given.
+var ErrInvalidPayment = errors.New("invalid payment")
+
+// PaymentService takes payments for orders through a PaymentProvider and
+// keeps the payment status of the orders up to date.
+type PaymentService struct {
+	payments repos.PaymentRepository
+	orders   repos.OrderRepository
+	provider PaymentProvider
+	currency string
+}
+
+func NewPaymentService(payments repos.PaymentRepository, orders repos.OrderRepository, provider PaymentProvider, currency string) *PaymentService {
+	return &PaymentService{
+		payments: payments,
+		orders:   orders,
+		provider: provider,
+		currency: currency,
+	}
+}
+
+// Provider returns the name of the gateway payments go through.
+func (s *PaymentService) Provider() string {
+	return s.provider.Name()
+}
+
+// Payments lists the payments of an order, oldest first.
+func (s *PaymentService) Payments(ctx context.Context, orderID string) ([]*models.Payment, error) {
+	if _, err := s.orders.FindByID(ctx, orderID); err != nil {
+		return nil, err
+	}
+	return s.payments.FindByOrder(ctx, orderID)
+}
+
+// Pay authorizes amount for an order, by default what is still due on it,
+// and captures it at once if capture is set and the gateway authorized it
+// at once. A declined payment is stored as failed and returned with an
+// error wrapping ErrPaymentDeclined.
+func (s *PaymentService) Pay(ctx context.Context, orderID string, amount float64, method string, capture bool) (*models.Payment, error) {
+	order, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status == models.OrderStatusCancelled {
+		return nil, fmt.Errorf("%w: the order is cancelled", ErrInvalidPayment)
+	}
+	due := roundCents(order.TotalPrice - order.Held)
+	if amount == 0 {
+		amount = due
+	}
+	amount = roundCents(amount)
+	switch {
+	case due <= 0:
+		return nil, fmt.Errorf("%w: nothing is due on the order", ErrInvalidPayment)
+	case amount <= 0 || amount > due:
+		return nil, fmt.Errorf("%w: the amount must be positive and at most the %.2f due", ErrInvalidPayment, due)
+	}
+	// Reserve the amount before the gateway sees it, so that a payment
+	// made at the same time finds it held.
+	if _, err := s.orders.Hold(ctx, orderID, amount); errors.Is(err, repos.ErrLimitReached) {
+		return nil, fmt.Errorf("%w: the amount is more than is due on the order", ErrInvalidPayment)
+	} else if err != nil {
+		return nil, err
+	}
+	// Releasing the reservation must not be cut short by a cancelled
+	// request.
+	release := func() error {
+		_, err := s.orders.Hold(context.WithoutCancel(ctx), orderID, -amount)
+		return err
+	}
+
+	payment := &models.Payment{
+		ID:       primitive.NewObjectID().Hex(),
+		OrderID:  orderID,
+		Provider: s.provider.Name(),
+		Currency: s.currency,
+		Amount:   amount,
+	}
+	auth, authErr := s.provider.Authorize(ctx, PaymentRequest{
+		Reference: payment.ID,
+		Amount:    amount,
+		Currency:  s.currency,
+		Method:    method,
+	})
+	switch {
+	case errors.Is(authErr, ErrPaymentDeclined):
+		if err := release(); err != nil {
+			return nil, err
+		}
+		payment.Status = models.PaymentFailed
+		payment.FailureReason = authErr.Error()
+		payment.Events = []models.PaymentEvent{apiEvent(models.PaymentEventFailed, amount)}
+		if _, err := s.payments.Create(ctx, payment); err != nil {
+			return nil, err
+		}
+		return payment, authErr
+	case authErr != nil:
+		return nil, errors.Join(authErr, release())
+	}
+
+	payment.TransactionID = auth.TransactionID
+	payment.Status = models.PaymentAuthorized
+	payment.Events = []models.PaymentEvent{apiEvent(models.PaymentEventAuthorized, amount)}
+	if auth.Pending {
+		payment.Status = models.PaymentPending
+		payment.Events = []models.PaymentEvent{}
+	}
+	created, err := s.payments.Create(ctx, payment)
+	if err != nil {
+		// Release the authorization nobody knows about, even if the
+		// request is cancelled.
+		return nil, errors.Join(err, s.provider.Void(context.WithoutCancel(ctx), auth.TransactionID), release())
+	}
+	if capture && created.Status == models.PaymentAuthorized {
+		return s.Capture(ctx, orderID, created.ID, created.Version, 0)
+	}
+	return created, s.refresh(ctx, orderID)
+}
+
+// Capture takes amount of an authorized payment, by default all of it;
+// the rest of the authorization is released.
+func (s *PaymentService) Capture(ctx context.Context, orderID, paymentID string, version int64, amount float64) (*models.Payment, error) {
+	return s.change(ctx, orderID, paymentID, version, func(p *models.Payment) error {
+		if p.Status != models.PaymentAuthorized {
+			return fmt.Errorf("%w: only authorized payments can be captured", ErrInvalidPayment)
+		}
+		if amount == 0 {
+			amount = p.Amount
+		}
+		amount = roundCents(amount)
+		if amount <= 0 || amount > p.Amount {
+			return fmt.Errorf("%w: the amount must be positive and at most the %.2f authorized", ErrInvalidPayment, p.Amount)
+		}
+		if err := s.provider.Capture(ctx, p.TransactionID, amount); err != nil {
+			return err
+		}
+		return apply(p, apiEvent(models.PaymentEventCaptured, amount), "")
+	})
+}
+
+// Refund gives back amount of a captured payment, by default all that has
+// not been refunded yet.
+func (s *PaymentService) Refund(ctx context.Context, orderID, paymentID string, version int64, amount float64) (*models.Payment, error) {
+	return s.change(ctx, orderID, paymentID, version, func(p *models.Payment) error {
+		if p.Status != models.PaymentCaptured && p.Status != models.PaymentPartiallyRefunded {
+			return fmt.Errorf("%w: only captured payments can be refunded", ErrInvalidPayment)
+		}
+		left := roundCents(p.Captured - p.Refunded)
+		if amount == 0 {
+			amount = left
+		}
+		amount = roundCents(amount)
+		if amount <= 0 || amount > left {
+			return fmt.Errorf("%w: the amount must be positive and at most the %.2f left to refund", ErrInvalidPayment, left)
+		}
+		if err := s.provider.Refund(ctx, p.TransactionID, amount); err != nil {
+			return err
+		}
+		return apply(p, apiEvent(models.PaymentEventRefunded, amount), "")
+	})
+}
+
+// RefundOrder gives back up to amount of what the payments of an order
+// captured, through the most recent payments first, and returns how much it
+// gave back; less than amount when the payments hold less. It stops at the
+// first payment the gateway fails to refund.
+func (s *PaymentService) RefundOrder(ctx context.Context, orderID string, amount float64) (float64, error) {
+	payments, err := s.payments.FindByOrder(ctx, orderID)
+	if err != nil {
+		return 0, err
+	}
+	refunded := 0.0
+	for i := len(payments) - 1; i >= 0 && roundCents(amount-refunded) > 0; i-- {
+		p := payments[i]
+		left := roundCents(p.Captured - p.Refunded)
+		if p.Status != models.PaymentCaptured && p.Status != models.PaymentPartiallyRefunded || left <= 0 {
+			continue
+		}
+		part := min(left, roundCents(amount-refunded))
+		if _, err := s.Refund(ctx, orderID, p.ID, p.Version, part); err != nil {
+			return refunded, err
+		}
+		refunded = roundCents(refunded + part)
+	}
+	return refunded, nil
+}
+
+// Void releases an authorized payment that was not captured.
+func (s *PaymentService) Void(ctx context.Context, orderID, paymentID string, version int64) (*models.Payment, error) {
+	return s.change(ctx, orderID, paymentID, version, func(p *models.Payment) error {
+		if p.Status != models.PaymentAuthorized && p.Status != models.PaymentPending {
+			return fmt.Errorf("%w: only authorized payments can be voided", ErrInvalidPayment)
+		}
+		if err := s.provider.Void(ctx, p.TransactionID); err != nil {
+			return err
+		}
+		return apply(p, apiEvent(models.PaymentEventVoided, 0), "")
+	})
+}
+
+// HandleWebhook verifies a webhook request of the gateway and applies the
+// event it carries to its payment. Events are applied once; those that do
+// not fit the state of the payment, such as the gateway confirming a
+// capture made through this service, are only recorded.
+func (s *PaymentService) HandleWebhook(ctx context.Context, header http.Header, body []byte) (*models.Payment, error) {
+	event, err := s.provider.ParseWebhook(header, body)
+	if err != nil {
+		return nil, err
+	}
+	payment, err := s.payments.FindByTransaction(ctx, s.provider.Name(), event.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.HasEvent(event.ID) {
+		return payment, nil
+	}
+
+	e := models.PaymentEvent{
+		ID:     event.ID,
+		Type:   event.Type,
+		Amount: roundCents(event.Amount),
+		Source: "webhook",
+		At:     time.Now().UTC().Format(time.RFC3339),
+	}
+	before := payment.Holds()
+	if err := apply(payment, e, event.Reason); err != nil {
+		payment.Events = append(payment.Events, e)
+	}
+	updated, err := s.payments.Update(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rehold(ctx, updated.OrderID, before, updated.Holds()); err != nil {
+		return nil, err
+	}
+	return updated, s.refresh(ctx, updated.OrderID)
+}
+
+// change applies fn to a payment of the order, at version unless it is
+// zero, stores it and refreshes the payment status of the order. If the
+// gateway has acted but the payment cannot be stored, the webhook of the
+// gateway brings it up to date later.
+func (s *PaymentService) change(ctx context.Context, orderID, paymentID string, version int64, fn func(*models.Payment) error) (*models.Payment, error) {
+	payment, err := s.payments.FindByID(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.OrderID != orderID {
+		return nil, repos.ErrNotFound
+	}
+	if version != 0 && payment.Version != version {
+		return nil, repos.ErrVersionConflict
+	}
+	before := payment.Holds()
+	if err := fn(payment); err != nil {
+		return nil, err
+	}
+	updated, err := s.payments.Update(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rehold(ctx, orderID, before, updated.Holds()); err != nil {
+		return nil, err
+	}
+	return updated, s.refresh(ctx, orderID)
+}
+
+// rehold moves what the order holds by the change in what one of its
+// payments holds, from before to after.
+func (s *PaymentService) rehold(ctx context.Context, orderID string, before, after float64) error {
+	delta := roundCents(after - before)
+	if delta == 0 {
+		return nil
+	}
+	_, err := s.orders.Hold(ctx, orderID, delta)
+	return err
+}
+
+// refresh works out the payment status of the order from its payments. An
+// order paid in full moves from pending to processing.
+func (s *PaymentService) refresh(ctx context.Context, orderID string) error {
+	order, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	payments, err := s.payments.FindByOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	captured, refunded, authorized := 0.0, 0.0, false
+	for _, p := range payments {
+		captured += p.Captured
+		refunded += p.Refunded
+		authorized = authorized || p.Status == models.PaymentAuthorized
+	}
+	paid := roundCents(captured - refunded)
+
+	status := models.OrderUnpaid
+	switch {
+	case captured > 0 && paid <= 0:
+		status = models.OrderRefunded
+	case captured > 0 && refunded > 0:
+		status = models.OrderPartiallyRefunded
+	case captured > 0 && paid >= order.TotalPrice:
+		status = models.OrderPaid
+	case captured > 0:
+		status = models.OrderPartiallyPaid
+	case authorized:
+		status = models.OrderAuthorized
+	}
+	from, to := "", ""
+	if status == models.OrderPaid {
+		from, to = models.OrderStatusPending, models.OrderStatusProcessing
+	}
+	_, err = s.orders.SetPaymentStatus(ctx, orderID, status, paid, from, to)
+	return err
+}
+
+// apply moves the payment on by the event and records it, failing with
+// ErrInvalidPayment when the event does not fit the state of the payment.
+// An event amount of zero means all of what it can apply to.
+func apply(p *models.Payment, e models.PaymentEvent, reason string) error {
+	invalid := fmt.Errorf("%w: a %s payment cannot be %s", ErrInvalidPayment, p.Status, e.Type)
+	switch e.Type {
+	case models.PaymentEventAuthorized:
+		if p.Status != models.PaymentPending {
+			return invalid
+		}
+		p.Status = models.PaymentAuthorized
+	case models.PaymentEventCaptured:
+		if p.Status != models.PaymentAuthorized {
+			return invalid
+		}
+		if e.Amount <= 0 || e.Amount > p.Amount {
+			e.Amount = p.Amount
+		}
+		p.Captured = e.Amount
+		p.Status = models.PaymentCaptured
+	case models.PaymentEventRefunded:
+		left := roundCents(p.Captured - p.Refunded)
+		if (p.Status != models.PaymentCaptured && p.Status != models.PaymentPartiallyRefunded) || left <= 0 {
+			return invalid
+		}
+		if e.Amount <= 0 || e.Amount > left {
+			e.Amount = left
+		}
+		p.Refunded = roundCents(p.Refunded + e.Amount)
+		p.Status = models.PaymentPartiallyRefunded
+		if p.Refunded >= p.Captured {
+			p.Status = models.PaymentRefunded
+		}
+	case models.PaymentEventVoided:
+		if p.Status != models.PaymentAuthorized && p.Status != models.PaymentPending {
+			return invalid
+		}
+		p.Status = models.PaymentVoided
+	case models.PaymentEventFailed:
+		if p.Status != models.PaymentAuthorized && p.Status != models.PaymentPending {
+			return invalid
+		}
+		p.Status = models.PaymentFailed
+		p.FailureReason = reason
+	default:
+		return fmt.Errorf("%w: unknown event type %q", ErrInvalidPayment, e.Type)
+	}
+	p.Events = append(p.Events, e)
+	return nil
+}
+
+func apiEvent(typ string, amount float64) models.PaymentEvent {
+	return models.PaymentEvent{
+		Type:   typ,
+		Amount: amount,
+		Source: "api",
+		At:     time.Now().UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+)
+
+// stubPayments keeps payments in memory and counts updates; other methods
+// are not used.
+type stubPayments struct {
+	repos.PaymentRepository
+	byID    map[string]*models.Payment
+	updates int
+}
+
+func (s *stubPayments) Create(_ context.Context, p *models.Payment) (*models.Payment, error) {
+	p.Version = 1
+	stored := *p
+	s.byID[p.ID] = &stored
+	return p, nil
+}
+
+func (s *stubPayments) FindByID(_ context.Context, id string) (*models.Payment, error) {
+	if p, ok := s.byID[id]; ok {
+		found := *p
+		return &found, nil
+	}
+	return nil, repos.ErrNotFound
+}
+
+func (s *stubPayments) FindByTransaction(_ context.Context, _, transactionID string) (*models.Payment, error) {
+	for _, p := range s.byID {
+		if p.TransactionID == transactionID {
+			found := *p
+			return &found, nil
+		}
+	}
+	return nil, repos.ErrNotFound
+}
+
+func (s *stubPayments) FindByOrder(_ context.Context, orderID string) ([]*models.Payment, error) {
+	var payments []*models.Payment
+	for _, p := range s.byID {
+		if p.OrderID == orderID {
+			found := *p
+			payments = append(payments, &found)
+		}
+	}
+	return payments, nil
+}
+
+func (s *stubPayments) Update(_ context.Context, p *models.Payment) (*models.Payment, error) {
+	s.updates++
+	p.Version++
+	stored := *p
+	s.byID[p.ID] = &stored
+	return p, nil
+}
+
+// stubOrders keeps one order in memory and holds amounts on it like the
+// storage does. FindByID returns read when it is set, as a read made before
+// another payment reserved its amount would; other methods are not used.
+type stubOrders struct {
+	repos.OrderRepository
+	order *models.Order
+	read  *models.Order
+}
+
+func (s *stubOrders) FindByID(_ context.Context, id string) (*models.Order, error) {
+	if id != s.order.ID {
+		return nil, repos.ErrNotFound
+	}
+	found := *s.order
+	if s.read != nil {
+		found = *s.read
+	}
+	return &found, nil
+}
+
+func (s *stubOrders) Hold(_ context.Context, id string, amount float64) (*models.Order, error) {
+	if id != s.order.ID {
+		return nil, repos.ErrNotFound
+	}
+	if amount > 0 && s.order.Held+amount > s.order.TotalPrice+0.005 {
+		return nil, repos.ErrLimitReached
+	}
+	s.order.Held += amount
+	found := *s.order
+	return &found, nil
+}
+
+func (s *stubOrders) SetPaymentStatus(_ context.Context, id, paymentStatus string, paid float64, _, _ string) (*models.Order, error) {
+	s.order.PaymentStatus = paymentStatus
+	s.order.Paid = paid
+	found := *s.order
+	return &found, nil
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		captured     float64
+		refunded     float64
+		event        string
+		amount       float64
+		wantStatus   string
+		wantCaptured float64
+		wantRefunded float64
+		isErr        bool
+	}{
+		{name: "pending authorized", status: models.PaymentPending, event: models.PaymentEventAuthorized, wantStatus: models.PaymentAuthorized},
+		{name: "authorized again", status: models.PaymentAuthorized, event: models.PaymentEventAuthorized, isErr: true},
+		{name: "captured in full", status: models.PaymentAuthorized, event: models.PaymentEventCaptured, wantStatus: models.PaymentCaptured, wantCaptured: 100},
+		{name: "captured in part", status: models.PaymentAuthorized, event: models.PaymentEventCaptured, amount: 40, wantStatus: models.PaymentCaptured, wantCaptured: 40},
+		{name: "capture above the authorization", status: models.PaymentAuthorized, event: models.PaymentEventCaptured, amount: 150, wantStatus: models.PaymentCaptured, wantCaptured: 100},
+		{name: "pending captured", status: models.PaymentPending, event: models.PaymentEventCaptured, isErr: true},
+		{name: "refunded in part", status: models.PaymentCaptured, captured: 100, event: models.PaymentEventRefunded, amount: 30, wantStatus: models.PaymentPartiallyRefunded, wantCaptured: 100, wantRefunded: 30},
+		{name: "refunded the rest", status: models.PaymentPartiallyRefunded, captured: 100, refunded: 30, event: models.PaymentEventRefunded, wantStatus: models.PaymentRefunded, wantCaptured: 100, wantRefunded: 100},
+		{name: "refund capped by what is left", status: models.PaymentPartiallyRefunded, captured: 100, refunded: 30, event: models.PaymentEventRefunded, amount: 90, wantStatus: models.PaymentRefunded, wantCaptured: 100, wantRefunded: 100},
+		{name: "refunded twice", status: models.PaymentRefunded, captured: 100, refunded: 100, event: models.PaymentEventRefunded, isErr: true},
+		{name: "authorized refunded", status: models.PaymentAuthorized, event: models.PaymentEventRefunded, isErr: true},
+		{name: "authorized voided", status: models.PaymentAuthorized, event: models.PaymentEventVoided, wantStatus: models.PaymentVoided},
+		{name: "pending voided", status: models.PaymentPending, event: models.PaymentEventVoided, wantStatus: models.PaymentVoided},
+		{name: "captured voided", status: models.PaymentCaptured, captured: 100, event: models.PaymentEventVoided, isErr: true},
+		{name: "pending failed", status: models.PaymentPending, event: models.PaymentEventFailed, wantStatus: models.PaymentFailed},
+		{name: "voided failed", status: models.PaymentVoided, event: models.PaymentEventFailed, isErr: true},
+		{name: "unknown event", status: models.PaymentAuthorized, event: "disputed", isErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &models.Payment{Amount: 100, Status: tt.status, Captured: tt.captured, Refunded: tt.refunded}
+			err := apply(p, models.PaymentEvent{Type: tt.event, Amount: tt.amount}, "card lost")
+			if tt.isErr {
+				if !errors.Is(err, ErrInvalidPayment) {
+					t.Errorf("error = %v, want ErrInvalidPayment", err)
+				}
+				if p.Status != tt.status || len(p.Events) != 0 {
+					t.Errorf("payment changed to %s with %d events", p.Status, len(p.Events))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if p.Status != tt.wantStatus || p.Captured != tt.wantCaptured || p.Refunded != tt.wantRefunded {
+				t.Errorf("got %s, captured %v, refunded %v; want %s, %v, %v",
+					p.Status, p.Captured, p.Refunded, tt.wantStatus, tt.wantCaptured, tt.wantRefunded)
+			}
+			if len(p.Events) != 1 {
+				t.Errorf("recorded %d events, want 1", len(p.Events))
+			}
+			if (p.Status == models.PaymentFailed) != (p.FailureReason != "") {
+				t.Errorf("failure reason %q for a %s payment", p.FailureReason, p.Status)
+			}
+		})
+	}
+}
+
+func TestPayHolds(t *testing.T) {
+	tests := []struct {
+		name     string
+		held     float64
+		readHeld float64
+		amount   float64
+		method   string
+		capture  bool
+		wantHeld float64
+		wantErr  error
+	}{
+		{name: "what is due by default", wantHeld: 100},
+		{name: "part of what is due", amount: 40, wantHeld: 40},
+		{name: "captured at once", amount: 40, capture: true, wantHeld: 40},
+		{name: "pending authorization", amount: 40, method: FakeMethodAsync, wantHeld: 40},
+		{name: "the rest", held: 60, readHeld: 60, wantHeld: 100},
+		{name: "more than is due", held: 60, readHeld: 60, amount: 50, wantHeld: 60, wantErr: ErrInvalidPayment},
+		{name: "nothing due", held: 100, readHeld: 100, wantHeld: 100, wantErr: ErrInvalidPayment},
+		// The order was read before another payment reserved 60 of it.
+		{name: "reserved meanwhile", held: 60, amount: 50, wantHeld: 60, wantErr: ErrInvalidPayment},
+		{name: "declined releases the hold", amount: 40, method: FakeMethodDeclined, wantErr: ErrPaymentDeclined},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &models.Order{ID: "o1", TotalPrice: 100, Held: tt.held, Status: models.OrderStatusPending}
+			read := *order
+			read.Held = tt.readHeld
+			orders := &stubOrders{order: order, read: &read}
+			payments := &stubPayments{byID: map[string]*models.Payment{}}
+			s := NewPaymentService(payments, orders, NewFakePaymentProvider("whsec"), "USD")
+
+			_, err := s.Pay(context.Background(), "o1", tt.amount, tt.method, tt.capture)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Pay: %v", err)
+			}
+			if math.Abs(order.Held-tt.wantHeld) > 1e-9 {
+				t.Errorf("held = %v, want %v", order.Held, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	now := time.Now()
+	provider := NewFakePaymentProvider("whsec")
+	deliver := func(s *PaymentService, body string) (*models.Payment, error) {
+		header := http.Header{"Fake-Signature": {provider.Sign([]byte(body), now)}}
+		return s.HandleWebhook(context.Background(), header, []byte(body))
+	}
+	const captured = `{"id":"evt_1","type":"captured","transaction_id":"fake_p1","amount":60}`
+
+	tests := []struct {
+		name        string
+		status      string
+		events      []string
+		wantStatus  string
+		wantHeld    float64
+		wantUpdates int
+		wantEvents  int
+	}{
+		{
+			name:        "applied once",
+			status:      models.PaymentAuthorized,
+			events:      []string{captured, captured},
+			wantStatus:  models.PaymentCaptured,
+			wantHeld:    60,
+			wantUpdates: 1,
+			wantEvents:  1,
+		},
+		{
+			name:   "pending confirmed then captured",
+			status: models.PaymentPending,
+			events: []string{
+				`{"id":"evt_0","type":"authorized","transaction_id":"fake_p1"}`,
+				captured,
+				`{"id":"evt_0","type":"authorized","transaction_id":"fake_p1"}`,
+			},
+			wantStatus:  models.PaymentCaptured,
+			wantHeld:    60,
+			wantUpdates: 2,
+			wantEvents:  2,
+		},
+		{
+			// A capture already made through the API is only recorded.
+			name:        "not fitting the state",
+			status:      models.PaymentVoided,
+			events:      []string{captured, captured},
+			wantStatus:  models.PaymentVoided,
+			wantHeld:    0,
+			wantUpdates: 1,
+			wantEvents:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := &models.Payment{ID: "p1", OrderID: "o1", TransactionID: "fake_p1", Amount: 100, Status: tt.status, Version: 1}
+			order := &models.Order{ID: "o1", TotalPrice: 100, Held: payment.Holds()}
+			orders := &stubOrders{order: order}
+			payments := &stubPayments{byID: map[string]*models.Payment{"p1": payment}}
+			s := NewPaymentService(payments, orders, provider, "USD")
+
+			for _, body := range tt.events {
+				if _, err := deliver(s, body); err != nil {
+					t.Fatalf("HandleWebhook: %v", err)
+				}
+			}
+			got := payments.byID["p1"]
+			if got.Status != tt.wantStatus || len(got.Events) != tt.wantEvents || payments.updates != tt.wantUpdates {
+				t.Errorf("got %s with %d events after %d updates; want %s, %d, %d",
+					got.Status, len(got.Events), payments.updates, tt.wantStatus, tt.wantEvents, tt.wantUpdates)
+			}
+			if math.Abs(order.Held-tt.wantHeld) > 1e-9 {
+				t.Errorf("held = %v, want %v", order.Held, tt.wantHeld)
+			}
+		})
+	}
+
+	t.Run("bad signature", func(t *testing.T) {
+		orders := &stubOrders{order: &models.Order{ID: "o1"}}
+		payments := &stubPayments{byID: map[string]*models.Payment{}}
+		s := NewPaymentService(payments, orders, provider, "USD")
+		header := http.Header{"Fake-Signature": {NewFakePaymentProvider("other").Sign([]byte(captured), now)}}
+		if _, err := s.HandleWebhook(context.Background(), header, []byte(captured)); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("error = %v, want ErrInvalidSignature", err)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/udevs/lesson3/repos"
+)
+
+// maxBlockingOrders caps how many blocking orders a ProductInUseError lists.
+const maxBlockingOrders = 20
+
+// ProductInUseError lists the open orders that keep a product from being
+// deleted. It wraps repos.ErrInUse.
+type ProductInUseError struct {
+	ProductID string
+	OrderIDs  []string
+}
+
+func (e *ProductInUseError) Error() string {
+	return fmt.Sprintf("product %s is referenced by open orders %s", e.ProductID, strings.Join(e.OrderIDs, ", "))
+}
+
+func (e *ProductInUseError) Unwrap() error {
+	return repos.ErrInUse
+}
+
+type ProductService struct {
+	products repos.ProductRepository
+	orders   repos.OrderRepository
+}
+
+func NewProductService(products repos.ProductRepository, orders repos.OrderRepository) *ProductService {
+	return &ProductService{products: products, orders: orders}
+}
+
+// Delete moves a product to the trash unless an order that is not yet
+// finished references it, in which case it fails with a
+// *ProductInUseError.
+func (s *ProductService) Delete(ctx context.Context, id string, version int64) error {
+	blocking, err := s.orders.FindOpenByProduct(ctx, id, maxBlockingOrders)
+	if err != nil {
+		return err
+	}
+	if len(blocking) > 0 {
+		inUse := &ProductInUseError{ProductID: id}
+		for _, order := range blocking {
+			inUse.OrderIDs = append(inUse.OrderIDs, order.ID)
+		}
+		return inUse
+	}
+	return s.products.Delete(ctx, id, version)
+}
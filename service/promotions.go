@@ -0,0 +1,356 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+)
+
+// PromotionService works out the discounts of orders and carts.
+type PromotionService struct {
+	promotions repos.PromotionRepository
+	products   repos.ProductRepository
+	categories repos.CategoryRepository
+}
+
+func NewPromotionService(promotions repos.PromotionRepository, products repos.ProductRepository, categories repos.CategoryRepository) *PromotionService {
+	return &PromotionService{
+		promotions: promotions,
+		products:   products,
+		categories: categories,
+	}
+}
+
+// discountOrder is the order promotions are applied in, so that
+// percentages are taken before fixed amounts and the shipping fee comes
+// last.
+var discountOrder = []string{
+	models.PromotionBuyXGetY,
+	models.PromotionPercentage,
+	models.PromotionFixedAmount,
+	models.PromotionFreeShipping,
+}
+
+// discounting is the outcome of applying a set of promotions to an order.
+type discounting struct {
+	lines    []float64
+	shipping float64
+	applied  []models.AppliedDiscount
+	used     []*models.Promotion
+}
+
+func (d *discounting) total() float64 {
+	total := d.shipping
+	for _, amount := range d.lines {
+		total += amount
+	}
+	return roundCents(total)
+}
+
+// Apply works out the discounts of an order whose lines are priced and
+// whose shipping fee is set: the automatic promotions that fit it and the
+// promotions of its coupon codes. It fills in the line discounts and the
+// subtotal, discount and total of the order, and returns the codes that
+// were not applied and the promotions that were.
+//
+// Promotions are applied one after the other to what is left of each line,
+// so together they never take a line below zero. Exclusive promotions are
+// weighed alone against the combination of all the others, and the
+// choice that saves more wins.
+func (s *PromotionService) Apply(ctx context.Context, order *models.Order) ([]models.RejectedCoupon, []*models.Promotion, error) {
+	subtotal := 0.0
+	for _, line := range order.Products {
+		subtotal += line.Price * float64(line.Quantity)
+	}
+	subtotal = roundCents(subtotal)
+	now := time.Now()
+
+	automatic, err := s.promotions.Automatic(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []*models.Promotion
+	for _, p := range automatic {
+		if p.Live(now) && !p.UsedUp() && subtotal >= p.MinOrderValue {
+			candidates = append(candidates, p)
+		}
+	}
+
+	var rejected []models.RejectedCoupon
+	coupons := map[string]string{}
+	order.CouponCodes = normalizeCodes(order.CouponCodes)
+	for _, code := range order.CouponCodes {
+		p, err := s.promotions.FindByCode(ctx, code)
+		reason := ""
+		switch {
+		case errors.Is(err, repos.ErrNotFound):
+			reason = "unknown code"
+		case err != nil:
+			return nil, nil, err
+		case !p.Live(now):
+			reason = "the code is not valid now"
+		case p.UsedUp():
+			reason = "the code has been used up"
+		case subtotal < p.MinOrderValue:
+			reason = fmt.Sprintf("the code needs an order of at least %.2f", p.MinOrderValue)
+		}
+		if reason != "" {
+			rejected = append(rejected, models.RejectedCoupon{Code: code, Reason: reason})
+			continue
+		}
+		coupons[p.ID] = code
+		candidates = append(candidates, p)
+	}
+
+	eligible, err := s.eligibility(ctx, order, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	var combined, exclusive []*models.Promotion
+	for _, p := range candidates {
+		if p.Type != models.PromotionFreeShipping && !anyTrue(eligible[p.ID]) {
+			if code, ok := coupons[p.ID]; ok {
+				rejected = append(rejected, models.RejectedCoupon{Code: code, Reason: "the code applies to none of the items"})
+			}
+			continue
+		}
+		if p.Exclusive {
+			exclusive = append(exclusive, p)
+		} else {
+			combined = append(combined, p)
+		}
+	}
+
+	chosen, best := combined, discount(order, combined, eligible)
+	for _, p := range exclusive {
+		if alone := discount(order, []*models.Promotion{p}, eligible); alone.total() > best.total() {
+			chosen, best = []*models.Promotion{p}, alone
+		}
+	}
+	for _, p := range append(combined, exclusive...) {
+		code, ok := coupons[p.ID]
+		switch {
+		case !ok:
+		case !contains(chosen, p):
+			rejected = append(rejected, models.RejectedCoupon{
+				Code:      code,
+				Reason:    "promotions it cannot be combined with save more",
+				Outranked: true,
+			})
+		case !contains(best.used, p) && p.Type != models.PromotionFreeShipping:
+			rejected = append(rejected, models.RejectedCoupon{Code: code, Reason: "the items do not qualify for the code"})
+		}
+	}
+
+	for i := range order.Products {
+		order.Products[i].Discount = roundCents(best.lines[i])
+	}
+	for i := range best.applied {
+		best.applied[i].Code = coupons[best.applied[i].PromotionID]
+	}
+	order.Subtotal = subtotal
+	order.Discount = best.total()
+	order.Discounts = best.applied
+	order.TotalPrice = roundCents(subtotal - order.Discount + order.Shipping)
+	return rejected, best.used, nil
+}
+
+// Redeem counts a use of each promotion by the customer. If one has
+// reached its limit, the uses already counted are released.
+func (s *PromotionService) Redeem(ctx context.Context, promotions []*models.Promotion, customerID string) error {
+	for i, p := range promotions {
+		err := s.promotions.Redeem(ctx, p, customerID)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, repos.ErrLimitReached) {
+			err = fmt.Errorf("%w: promotion %q", repos.ErrLimitReached, p.Name)
+		}
+		return errors.Join(err, s.Release(context.WithoutCancel(ctx), promotions[:i], customerID))
+	}
+	return nil
+}
+
+// Release takes back the uses counted by Redeem.
+func (s *PromotionService) Release(ctx context.Context, promotions []*models.Promotion, customerID string) error {
+	var errs []error
+	for _, p := range promotions {
+		errs = append(errs, s.promotions.Release(ctx, p, customerID))
+	}
+	return errors.Join(errs...)
+}
+
+// discount applies promotions to the order in discountOrder.
+func discount(order *models.Order, promotions []*models.Promotion, eligible map[string][]bool) *discounting {
+	d := &discounting{lines: make([]float64, len(order.Products))}
+	left := make([]float64, len(order.Products))
+	for i, line := range order.Products {
+		left[i] = line.Price * float64(line.Quantity)
+	}
+
+	for _, typ := range discountOrder {
+		for _, p := range promotions {
+			if p.Type != typ {
+				continue
+			}
+			amount := 0.0
+			if p.Type == models.PromotionFreeShipping {
+				amount = order.Shipping - d.shipping
+				d.shipping += amount
+			} else {
+				for i, off := range lineDiscounts(p, order.Products, left, eligible[p.ID]) {
+					left[i] -= off
+					d.lines[i] += off
+					amount += off
+				}
+			}
+			if amount = roundCents(amount); amount > 0 {
+				d.applied = append(d.applied, models.AppliedDiscount{
+					PromotionID: p.ID,
+					Name:        p.Name,
+					Type:        p.Type,
+					Amount:      amount,
+				})
+				d.used = append(d.used, p)
+			}
+		}
+	}
+	return d
+}
+
+// lineDiscounts works out what a line promotion takes off each line, given
+// what is left of the lines, in whole cents.
+func lineDiscounts(p *models.Promotion, lines []models.ProductInOrder, left []float64, eligible []bool) []float64 {
+	off := make([]float64, len(lines))
+	switch p.Type {
+	case models.PromotionPercentage:
+		for i := range lines {
+			if eligible[i] {
+				off[i] = roundCents(left[i] * p.Value / 100)
+			}
+		}
+	case models.PromotionBuyXGetY:
+		for i, line := range lines {
+			if eligible[i] {
+				free := line.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+				off[i] = roundCents(math.Min(float64(free)*line.Price, left[i]))
+			}
+		}
+	case models.PromotionFixedAmount:
+		base := 0.0
+		for i := range lines {
+			if eligible[i] {
+				base += left[i]
+			}
+		}
+		amount := roundCents(math.Min(p.Value, base))
+		if amount <= 0 {
+			break
+		}
+		// Share the amount in proportion to what is left of the lines; the
+		// last one takes the rounding difference.
+		remaining, last := amount, -1
+		for i := range lines {
+			if eligible[i] && left[i] > 0 {
+				off[i] = roundCents(amount * left[i] / base)
+				remaining -= off[i]
+				last = i
+			}
+		}
+		if last >= 0 {
+			off[last] = roundCents(math.Min(off[last]+remaining, left[last]))
+		}
+	}
+	return off
+}
+
+// eligibility tells, for each promotion, which lines of the order it
+// applies to.
+func (s *PromotionService) eligibility(ctx context.Context, order *models.Order, promotions []*models.Promotion) (map[string][]bool, error) {
+	paths := map[string]string{}
+	pathOf := func(productID string) (string, error) {
+		if path, ok := paths[productID]; ok {
+			return path, nil
+		}
+		path := ""
+		product, err := s.products.FindByID(ctx, productID)
+		if err != nil && !errors.Is(err, repos.ErrNotFound) {
+			return "", err
+		}
+		if product != nil && product.CategoryID != "" {
+			category, err := s.categories.FindByID(ctx, product.CategoryID)
+			if err != nil && !errors.Is(err, repos.ErrNotFound) {
+				return "", err
+			}
+			if category != nil {
+				path = category.Path
+			}
+		}
+		paths[productID] = path
+		return path, nil
+	}
+
+	eligible := map[string][]bool{}
+	for _, p := range promotions {
+		lines := make([]bool, len(order.Products))
+		for i, line := range order.Products {
+			if len(p.ProductIDs) == 0 && len(p.CategoryIDs) == 0 {
+				lines[i] = true
+				continue
+			}
+			for _, id := range p.ProductIDs {
+				lines[i] = lines[i] || id == line.ProductID
+			}
+			if lines[i] || len(p.CategoryIDs) == 0 {
+				continue
+			}
+			path, err := pathOf(line.ProductID)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range p.CategoryIDs {
+				lines[i] = lines[i] || strings.Contains(path, "/"+id+"/")
+			}
+		}
+		eligible[p.ID] = lines
+	}
+	return eligible, nil
+}
+
+// normalizeCodes upper-cases codes and drops blanks and repeats.
+func normalizeCodes(codes []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !seen[code] {
+			seen[code] = true
+			normalized = append(normalized, code)
+		}
+	}
+	return normalized
+}
+
+func anyTrue(values []bool) bool {
+	for _, v := range values {
+		if v {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(promotions []*models.Promotion, p *models.Promotion) bool {
+	for _, q := range promotions {
+		if q.ID == p.ID {
+			return true
+		}
+	}
+	return false
+}
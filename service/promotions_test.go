@@ -0,0 +1,206 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/udevs/lesson3/models"
+)
+
+func sameAmounts(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLineDiscounts(t *testing.T) {
+	lines := []models.ProductInOrder{
+		{ProductID: "a", Price: 10, Quantity: 3},
+		{ProductID: "b", Price: 4, Quantity: 7},
+		{ProductID: "c", Price: 5, Quantity: 2},
+	}
+	full := []float64{30, 28, 10}
+	all := []bool{true, true, true}
+
+	tests := []struct {
+		name     string
+		p        *models.Promotion
+		left     []float64
+		eligible []bool
+		want     []float64
+	}{
+		{
+			name:     "percentage",
+			p:        &models.Promotion{Type: models.PromotionPercentage, Value: 10},
+			left:     full,
+			eligible: all,
+			want:     []float64{3, 2.8, 1},
+		},
+		{
+			name:     "percentage of what is left",
+			p:        &models.Promotion{Type: models.PromotionPercentage, Value: 15},
+			left:     []float64{3.33, 0, 10},
+			eligible: all,
+			want:     []float64{0.5, 0, 1.5},
+		},
+		{
+			name:     "percentage on eligible lines",
+			p:        &models.Promotion{Type: models.PromotionPercentage, Value: 50},
+			left:     full,
+			eligible: []bool{false, true, false},
+			want:     []float64{0, 14, 0},
+		},
+		{
+			name:     "buy two get one",
+			p:        &models.Promotion{Type: models.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1},
+			left:     full,
+			eligible: all,
+			// 3 units give 1 free, 7 units 2, 2 units none.
+			want: []float64{10, 8, 0},
+		},
+		{
+			name:     "buy x get y capped by what is left",
+			p:        &models.Promotion{Type: models.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1},
+			left:     []float64{6, 28, 10},
+			eligible: []bool{true, false, true},
+			want:     []float64{6, 0, 0},
+		},
+		{
+			name:     "fixed amount shared in proportion",
+			p:        &models.Promotion{Type: models.PromotionFixedAmount, Value: 17},
+			left:     []float64{30, 0, 4},
+			eligible: all,
+			want:     []float64{15, 0, 2},
+		},
+		{
+			name:     "fixed amount rounding goes to the last line",
+			p:        &models.Promotion{Type: models.PromotionFixedAmount, Value: 10},
+			left:     []float64{10, 10, 10},
+			eligible: all,
+			want:     []float64{3.33, 3.33, 3.34},
+		},
+		{
+			name:     "fixed amount capped by the eligible lines",
+			p:        &models.Promotion{Type: models.PromotionFixedAmount, Value: 100},
+			left:     full,
+			eligible: []bool{true, false, true},
+			want:     []float64{30, 0, 10},
+		},
+		{
+			name:     "fixed amount with nothing left",
+			p:        &models.Promotion{Type: models.PromotionFixedAmount, Value: 5},
+			left:     []float64{0, 0, 0},
+			eligible: all,
+			want:     []float64{0, 0, 0},
+		},
+		{
+			name:     "free shipping takes nothing off lines",
+			p:        &models.Promotion{Type: models.PromotionFreeShipping},
+			left:     full,
+			eligible: all,
+			want:     []float64{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineDiscounts(tt.p, lines, tt.left, tt.eligible); !sameAmounts(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	order := &models.Order{
+		Products: []models.ProductInOrder{
+			{ProductID: "a", Price: 10, Quantity: 2},
+			{ProductID: "b", Price: 5, Quantity: 1},
+		},
+		Shipping: 4.99,
+	}
+	percent := &models.Promotion{ID: "p", Name: "10% off", Type: models.PromotionPercentage, Value: 10}
+	fixed := &models.Promotion{ID: "f", Name: "5 off", Type: models.PromotionFixedAmount, Value: 5}
+	shipping := &models.Promotion{ID: "s", Name: "Free shipping", Type: models.PromotionFreeShipping}
+	shipping2 := &models.Promotion{ID: "s2", Name: "Free shipping again", Type: models.PromotionFreeShipping}
+	onlyB := &models.Promotion{ID: "b", Name: "Half off b", Type: models.PromotionPercentage, Value: 50}
+	all := []bool{true, true}
+
+	tests := []struct {
+		name         string
+		promotions   []*models.Promotion
+		eligible     map[string][]bool
+		wantLines    []float64
+		wantShipping float64
+		wantTotal    float64
+		wantUsed     []string
+	}{
+		{
+			name:      "none",
+			wantLines: []float64{0, 0},
+			wantTotal: 0,
+			wantUsed:  nil,
+		},
+		{
+			// The percentage comes first whatever the order given, and the
+			// fixed amount is shared over what it left: 18 and 4.5.
+			name:         "percentages before fixed amounts",
+			promotions:   []*models.Promotion{shipping, fixed, percent},
+			eligible:     map[string][]bool{"p": all, "f": all, "s": all},
+			wantLines:    []float64{6, 1.5},
+			wantShipping: 4.99,
+			wantTotal:    12.49,
+			wantUsed:     []string{"p", "f", "s"},
+		},
+		{
+			name:         "shipping waived once",
+			promotions:   []*models.Promotion{shipping, shipping2},
+			eligible:     map[string][]bool{"s": all, "s2": all},
+			wantLines:    []float64{0, 0},
+			wantShipping: 4.99,
+			wantTotal:    4.99,
+			wantUsed:     []string{"s"},
+		},
+		{
+			name:       "promotions that take nothing are not used",
+			promotions: []*models.Promotion{percent, onlyB},
+			eligible:   map[string][]bool{"p": {false, false}, "b": {false, true}},
+			wantLines:  []float64{0, 2.5},
+			wantTotal:  2.5,
+			wantUsed:   []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := discount(order, tt.promotions, tt.eligible)
+
+			var used []string
+			for _, p := range d.used {
+				used = append(used, p.ID)
+			}
+			if !sameAmounts(d.lines, tt.wantLines) || d.shipping != tt.wantShipping || !reflect.DeepEqual(used, tt.wantUsed) {
+				t.Errorf("got lines %v, shipping %v, used %v; want %v, %v, %v",
+					d.lines, d.shipping, used, tt.wantLines, tt.wantShipping, tt.wantUsed)
+			}
+			if total := d.total(); total != tt.wantTotal {
+				t.Errorf("total = %v, want %v", total, tt.wantTotal)
+			}
+			if len(d.applied) != len(d.used) {
+				t.Fatalf("applied %d discounts for %d promotions", len(d.applied), len(d.used))
+			}
+			for i, applied := range d.applied {
+				if applied.PromotionID != d.used[i].ID || applied.Amount <= 0 {
+					t.Errorf("applied[%d] = %+v", i, applied)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidPurchaseOrder is wrapped by the errors for purchase orders, and
+// goods receipts, that cannot be made as given.
+var ErrInvalidPurchaseOrder = errors.New("invalid purchase order")
+
+type PurchasingService struct {
+	products       repos.ProductRepository
+	warehouses     repos.WarehouseRepository
+	suppliers      repos.SupplierRepository
+	purchaseOrders repos.PurchaseOrderRepository
+}
+
+func NewPurchasingService(products repos.ProductRepository, warehouses repos.WarehouseRepository, suppliers repos.SupplierRepository, purchaseOrders repos.PurchaseOrderRepository) *PurchasingService {
+	return &PurchasingService{
+		products:       products,
+		warehouses:     warehouses,
+		suppliers:      suppliers,
+		purchaseOrders: purchaseOrders,
+	}
+}
+
+// Create stores a draft purchase order after checking its supplier,
+// warehouse and lines. Without a warehouse, goods go to the default one.
+func (s *PurchasingService) Create(ctx context.Context, po *models.PurchaseOrder) (*models.PurchaseOrder, error) {
+	if err := s.check(ctx, po); err != nil {
+		return nil, err
+	}
+	return s.purchaseOrders.Create(ctx, po)
+}
+
+// Update replaces a draft purchase order, checked as by Create.
+func (s *PurchasingService) Update(ctx context.Context, id string, po *models.PurchaseOrder) (*models.PurchaseOrder, error) {
+	if err := s.check(ctx, po); err != nil {
+		return nil, err
+	}
+	return s.purchaseOrders.Update(ctx, id, po)
+}
+
+// Send marks a draft as sent to the supplier, after which goods can be
+// received against it and it can no longer be edited.
+func (s *PurchasingService) Send(ctx context.Context, id string) (*models.PurchaseOrder, error) {
+	return s.purchaseOrders.SetStatus(ctx, id, []string{models.PurchaseOrderDraft}, models.PurchaseOrderSent)
+}
+
+// Cancel drops a purchase order nothing has been received on.
+func (s *PurchasingService) Cancel(ctx context.Context, id string) (*models.PurchaseOrder, error) {
+	from := []string{models.PurchaseOrderDraft, models.PurchaseOrderSent}
+	return s.purchaseOrders.SetStatus(ctx, id, from, models.PurchaseOrderCancelled)
+}
+
+// Receive books goods delivered against a sent purchase order: the
+// received quantities of its lines go up, and so does the stock of the
+// products at the warehouse of the purchase order, through stock receipts
+// carrying the unit cost of the line. The purchase order is received once
+// every line is, and partially received until then.
+//
+// The purchase order is updated first, conditional on its version, so
+// the same delivery is never booked twice. If the stock of a line cannot
+// be raised, the lines already booked are taken back and the purchase
+// order is restored.
+func (s *PurchasingService) Receive(ctx context.Context, id string, receipt *models.GoodsReceipt) (*models.PurchaseOrder, error) {
+	po, err := s.purchaseOrders.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if po.Status != models.PurchaseOrderSent && po.Status != models.PurchaseOrderPartiallyReceived {
+		return nil, repos.ErrVersionConflict
+	}
+
+	before := append([]models.PurchaseOrderLine(nil), po.Lines...)
+	received, err := receiveLines(po, receipt)
+	if err != nil {
+		return nil, err
+	}
+	po.Status = models.PurchaseOrderReceived
+	for _, line := range po.Lines {
+		if line.Remaining() > 0 {
+			po.Status = models.PurchaseOrderPartiallyReceived
+		}
+	}
+	updated, err := s.purchaseOrders.SetReceived(ctx, po)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range received {
+		err := s.move(ctx, po, r, r.Quantity)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, repos.ErrNotFound) || errors.Is(err, repos.ErrInvalidReference) {
+			// The product, or its variant, was deleted after it was ordered.
+			err = fmt.Errorf("%w: product %s can no longer be received", ErrInvalidPurchaseOrder, r.ProductID)
+		}
+		// Taking the receipt back must not be cut short by a cancelled
+		// request, or stock would be left without a booked delivery.
+		ctx := context.WithoutCancel(ctx)
+		errs := []error{err}
+		for _, booked := range received[:i] {
+			errs = append(errs, s.move(ctx, po, booked, -booked.Quantity))
+		}
+		restored := *updated
+		restored.Lines = before
+		restored.Status = models.PurchaseOrderPartiallyReceived
+		if !anyReceived(before) {
+			restored.Status = models.PurchaseOrderSent
+		}
+		_, revertErr := s.purchaseOrders.SetReceived(ctx, &restored)
+		return nil, errors.Join(append(errs, revertErr)...)
+	}
+	return updated, nil
+}
+
+// receiveLines adds the quantities of receipt to the received quantities
+// of the lines of po and returns what was received per line. An empty
+// receipt receives everything outstanding.
+func receiveLines(po *models.PurchaseOrder, receipt *models.GoodsReceipt) ([]models.PurchaseOrderLine, error) {
+	po.Lines = append([]models.PurchaseOrderLine(nil), po.Lines...)
+
+	var received []models.PurchaseOrderLine
+	if len(receipt.Lines) == 0 {
+		for i, line := range po.Lines {
+			if line.Remaining() > 0 {
+				received = append(received, models.PurchaseOrderLine{
+					ProductID: line.ProductID,
+					VariantID: line.VariantID,
+					Quantity:  line.Remaining(),
+					UnitCost:  line.UnitCost,
+				})
+				po.Lines[i].Received = line.Quantity
+			}
+		}
+		return received, nil
+	}
+
+	for i, r := range receipt.Lines {
+		if r.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: receipt line %d needs a positive quantity", ErrInvalidPurchaseOrder, i+1)
+		}
+		j := lineOf(po, r.ProductID, r.VariantID)
+		if j < 0 {
+			return nil, fmt.Errorf("%w: product %q is not on the purchase order", ErrInvalidPurchaseOrder, r.ProductID)
+		}
+		if r.Quantity > po.Lines[j].Remaining() {
+			return nil, fmt.Errorf("%w: only %d of product %s remain to be received", ErrInvalidPurchaseOrder, po.Lines[j].Remaining(), r.ProductID)
+		}
+		po.Lines[j].Received += r.Quantity
+		received = append(received, models.PurchaseOrderLine{
+			ProductID: r.ProductID,
+			VariantID: r.VariantID,
+			Quantity:  r.Quantity,
+			UnitCost:  po.Lines[j].UnitCost,
+		})
+	}
+	return received, nil
+}
+
+// lineOf returns the index of the line of po for the product, or variant,
+// or -1.
+func lineOf(po *models.PurchaseOrder, productID, variantID string) int {
+	for i, line := range po.Lines {
+		if line.ProductID == productID && line.VariantID == variantID {
+			return i
+		}
+	}
+	return -1
+}
+
+func anyReceived(lines []models.PurchaseOrderLine) bool {
+	for _, line := range lines {
+		if line.Received > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PurchasingService) move(ctx context.Context, po *models.PurchaseOrder, line models.PurchaseOrderLine, quantity int) error {
+	movement := &models.StockMovement{
+		ProductID:       line.ProductID,
+		VariantID:       line.VariantID,
+		WarehouseID:     po.WarehouseID,
+		Type:            models.MovementReceipt,
+		Quantity:        quantity,
+		PurchaseOrderID: po.ID,
+		UnitCost:        line.UnitCost,
+	}
+	if quantity < 0 {
+		// A receipt taken back does not change what the stock cost.
+		movement.UnitCost = 0
+		movement.Reason = "receipt taken back"
+	}
+	return s.products.AdjustStock(ctx, movement)
+}
+
+// check validates the supplier, warehouse, expected date and lines of po,
+// filling in the default warehouse.
+func (s *PurchasingService) check(ctx context.Context, po *models.PurchaseOrder) error {
+	_, err := s.suppliers.FindByID(ctx, po.SupplierID)
+	if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(po.SupplierID) {
+		return fmt.Errorf("%w: unknown supplier %q", ErrInvalidPurchaseOrder, po.SupplierID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if po.WarehouseID == "" {
+		warehouse, err := s.warehouses.Default(ctx)
+		if errors.Is(err, repos.ErrNotFound) {
+			return fmt.Errorf("%w: there is no warehouse to receive the goods", ErrInvalidPurchaseOrder)
+		}
+		if err != nil {
+			return err
+		}
+		po.WarehouseID = warehouse.ID
+	} else {
+		_, err := s.warehouses.FindByID(ctx, po.WarehouseID)
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(po.WarehouseID) {
+			return fmt.Errorf("%w: unknown warehouse %q", ErrInvalidPurchaseOrder, po.WarehouseID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if po.ExpectedDate != "" {
+		if _, err := time.Parse(time.DateOnly, po.ExpectedDate); err != nil {
+			return fmt.Errorf("%w: expected_date must be YYYY-MM-DD", ErrInvalidPurchaseOrder)
+		}
+	}
+
+	if len(po.Lines) == 0 {
+		return fmt.Errorf("%w: a purchase order needs at least one line", ErrInvalidPurchaseOrder)
+	}
+	for i, line := range po.Lines {
+		if line.Quantity <= 0 || line.UnitCost < 0 {
+			return fmt.Errorf("%w: line %d needs a positive quantity and a unit_cost of at least zero", ErrInvalidPurchaseOrder, i+1)
+		}
+		if lineOf(po, line.ProductID, line.VariantID) != i {
+			return fmt.Errorf("%w: product %q is ordered on more than one line", ErrInvalidPurchaseOrder, line.ProductID)
+		}
+		product, err := s.products.FindByID(ctx, line.ProductID)
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(line.ProductID) {
+			return fmt.Errorf("%w: unknown product %q", ErrInvalidPurchaseOrder, line.ProductID)
+		}
+		if err != nil {
+			return err
+		}
+		if product.IsBundle() {
+			return fmt.Errorf("%w: bundle %q holds no stock of its own; order its components", ErrInvalidPurchaseOrder, product.ID)
+		}
+		if problem := variantProblem(product, line.VariantID); problem != "" {
+			return fmt.Errorf("%w: %s", ErrInvalidPurchaseOrder, problem)
+		}
+	}
+	return nil
+}
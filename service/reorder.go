@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/notify"
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"github.com/udevs/lesson3/repos"
+	"go.uber.org/zap"
+)
+
+// ReorderChecker raises a stock alert, and sends a notification, for every
+// product whose stock falls to its reorder point, and resolves the alert
+// once the product is restocked.
+type ReorderChecker struct {
+	products repos.ProductRepository
+	alerts   repos.StockAlertRepository
+	notifier notify.Notifier
+	logger   *zap.Logger
+	changed  chan string
+}
+
+func NewReorderChecker(products repos.ProductRepository, alerts repos.StockAlertRepository, notifier notify.Notifier, logger *zap.Logger) *ReorderChecker {
+	return &ReorderChecker{
+		products: products,
+		alerts:   alerts,
+		notifier: notifier,
+		logger:   logger,
+		changed:  make(chan string, 256),
+	}
+}
+
+// Changed queues a product whose stock may have changed to be checked by
+// Run. It never blocks; when the queue is full, the product is left to the
+// next scan.
+func (c *ReorderChecker) Changed(productID string) {
+	select {
+	case c.changed <- productID:
+	default:
+	}
+}
+
+// Check raises or resolves the alert of one product.
+func (c *ReorderChecker) Check(ctx context.Context, productID string) error {
+	product, err := c.products.FindByID(ctx, productID)
+	if err != nil && !errors.Is(err, repos.ErrNotFound) {
+		return err
+	}
+	if product != nil && lowOnStock(product) {
+		return c.raise(ctx, product)
+	}
+
+	resolved, err := c.alerts.Restocked(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if resolved > 0 {
+		c.logger.Info("Resolved stock alert", zap.String("product_id", productID))
+	}
+	return nil
+}
+
+// Scan checks every product at or below its reorder point, and every
+// product with an alert that is not restocked yet.
+func (c *ReorderChecker) Scan(ctx context.Context) error {
+	low, err := c.products.LowStock(ctx)
+	if err != nil {
+		return err
+	}
+	for _, product := range low {
+		if err := c.raise(ctx, product); err != nil {
+			return err
+		}
+	}
+
+	pending, err := c.alerts.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range pending {
+		if err := c.Check(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run scans every interval, and checks the products passed to Changed as
+// they come, until ctx is done.
+func (c *ReorderChecker) Run(ctx context.Context, interval time.Duration) {
+	ctx = reqctx.WithActor(ctx, "reorder-checker")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	c.scan(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-c.changed:
+			if err := c.Check(ctx, id); err != nil {
+				c.logger.Error("Failed to check stock", zap.String("product_id", id), zap.Error(err))
+			}
+		case <-ticker.C:
+			c.scan(ctx)
+		}
+	}
+}
+
+func (c *ReorderChecker) scan(ctx context.Context) {
+	if err := c.Scan(ctx); err != nil {
+		c.logger.Error("Failed to scan for low stock", zap.Error(err))
+	}
+}
+
+func lowOnStock(p *models.Product) bool {
+	return !p.IsBundle() && p.ReorderPoint > 0 && p.Stock <= p.ReorderPoint
+}
+
+func (c *ReorderChecker) raise(ctx context.Context, product *models.Product) error {
+	alert := &models.StockAlert{
+		ProductID:       product.ID,
+		Name:            product.Name,
+		SKU:             product.SKU,
+		Stock:           product.Stock,
+		ReorderPoint:    product.ReorderPoint,
+		ReorderQuantity: product.ReorderQuantity,
+	}
+	raised, err := c.alerts.Raise(ctx, alert)
+	if err != nil || !raised {
+		return err
+	}
+
+	text := fmt.Sprintf("%s (SKU %s) has %d in stock, at or below its reorder point of %d.",
+		product.Name, product.SKU, product.Stock, product.ReorderPoint)
+	if product.ReorderQuantity > 0 {
+		text += fmt.Sprintf(" Reorder %d.", product.ReorderQuantity)
+	}
+	// The alert stands even if no notification gets through.
+	err = c.notifier.Notify(ctx, notify.Message{Subject: "Low stock: " + product.Name, Text: text, Data: alert})
+	if err != nil {
+		c.logger.Error("Failed to send stock alert", zap.String("alert_id", alert.ID), zap.Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrInvalidReturn is wrapped by the errors for returns, and receipts
+	// and refunds of returns, that cannot be made as given.
+	ErrInvalidReturn = errors.New("invalid return")
+
+	// ErrRefundIncomplete is wrapped by the error of a refund that was
+	// recorded but that the payment gateway failed to give back in full;
+	// the rest is refunded through the payments of the order.
+	ErrRefundIncomplete = errors.New("refund incomplete")
+)
+
+// orderUpdateAttempts bounds the retries of order updates that lost to a
+// concurrent change, such as a payment.
+const orderUpdateAttempts = 3
+
+// ReturnService runs returns of ordered goods: requests, their approval,
+// restocking the goods received back and refunding them on the order.
+type ReturnService struct {
+	returns    repos.ReturnRepository
+	orders     repos.OrderRepository
+	products   repos.ProductRepository
+	warehouses repos.WarehouseRepository
+	payments   *PaymentService
+}
+
+func NewReturnService(returns repos.ReturnRepository, orders repos.OrderRepository, products repos.ProductRepository, warehouses repos.WarehouseRepository, payments *PaymentService) *ReturnService {
+	return &ReturnService{
+		returns:    returns,
+		orders:     orders,
+		products:   products,
+		warehouses: warehouses,
+		payments:   payments,
+	}
+}
+
+// Request stores a return of goods of a shipped order. Each line names an
+// ordered product, or variant, and how much of it comes back, at most what
+// was ordered less what other returns that were not rejected cover.
+func (s *ReturnService) Request(ctx context.Context, ret *models.Return) (*models.Return, error) {
+	order, err := s.orders.FindByID(ctx, ret.OrderID)
+	if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(ret.OrderID) {
+		return nil, fmt.Errorf("%w: unknown order %q", ErrInvalidReturn, ret.OrderID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(models.ReturnableOrderStatuses, order.Status) {
+		return nil, fmt.Errorf("%w: only shipped orders can be returned, the order is %s", ErrInvalidReturn, order.Status)
+	}
+	if len(ret.Lines) == 0 {
+		return nil, fmt.Errorf("%w: a return needs at least one line", ErrInvalidReturn)
+	}
+
+	existing, err := s.returns.FindByOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]int, len(order.Products))
+	for _, other := range existing {
+		if other.Status == models.ReturnRejected {
+			continue
+		}
+		for _, line := range other.Lines {
+			if line.Line < len(claimed) {
+				claimed[line.Line] += line.Quantity
+			}
+		}
+	}
+
+	for i := range ret.Lines {
+		line := &ret.Lines[i]
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: line %d needs a positive quantity", ErrInvalidReturn, i+1)
+		}
+		line.Line = -1
+		left := 0
+		for j, ordered := range order.Products {
+			if ordered.ProductID != line.ProductID || ordered.VariantID != line.VariantID {
+				continue
+			}
+			left += ordered.Quantity - claimed[j]
+			if line.Line < 0 && ordered.Quantity > claimed[j] {
+				line.Line = j
+			}
+		}
+		if line.Line < 0 || line.Quantity > order.Products[line.Line].Quantity-claimed[line.Line] {
+			return nil, fmt.Errorf("%w: %d of product %q can be returned on the order", ErrInvalidReturn, left, line.ProductID)
+		}
+		claimed[line.Line] += line.Quantity
+		line.Restocked, line.Refund, line.Tax = 0, 0, 0
+	}
+
+	ret.CustomerID = order.CustomerID
+	ret.Note, ret.WarehouseID = "", ""
+	ret.Refund, ret.Tax, ret.PaymentRefunded = 0, 0, 0
+	return s.returns.Create(ctx, ret)
+}
+
+// Approve accepts a requested return, so its goods can be sent back.
+func (s *ReturnService) Approve(ctx context.Context, id, note string) (*models.Return, error) {
+	return s.decide(ctx, id, []string{models.ReturnRequested}, models.ReturnApproved, note)
+}
+
+// Reject turns down a return whose goods have not been received.
+func (s *ReturnService) Reject(ctx context.Context, id, note string) (*models.Return, error) {
+	return s.decide(ctx, id, []string{models.ReturnRequested, models.ReturnApproved}, models.ReturnRejected, note)
+}
+
+func (s *ReturnService) decide(ctx context.Context, id string, from []string, to, note string) (*models.Return, error) {
+	ret, err := s.returns.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(from, ret.Status) {
+		return nil, repos.ErrVersionConflict
+	}
+	ret.Status, ret.Note = to, note
+	return s.returns.Update(ctx, ret)
+}
+
+// Receive books the goods of an approved return as back: those not listed
+// as damaged go back into stock, as returns in the stock ledger. Bundles
+// are restocked as their components.
+//
+// The return is updated first, conditional on its version, so the same
+// goods are never restocked twice. If the stock of a line cannot be
+// raised, or the order cannot record what was restocked, the stock
+// already restocked is taken back and the return is restored.
+func (s *ReturnService) Receive(ctx context.Context, id string, receipt *models.ReturnReceipt) (*models.Return, error) {
+	ret, err := s.returns.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Status != models.ReturnApproved {
+		return nil, repos.ErrVersionConflict
+	}
+	order, err := s.orders.FindByID(ctx, ret.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLines(order, ret); err != nil {
+		return nil, err
+	}
+	if receipt.WarehouseID != "" {
+		_, err := s.warehouses.FindByID(ctx, receipt.WarehouseID)
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(receipt.WarehouseID) {
+			return nil, fmt.Errorf("%w: unknown warehouse %q", ErrInvalidReturn, receipt.WarehouseID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	before := *ret
+	ret.Lines = append([]models.ReturnLine(nil), ret.Lines...)
+	for i := range ret.Lines {
+		ret.Lines[i].Restocked = ret.Lines[i].Quantity
+	}
+	for i, damaged := range receipt.Damaged {
+		left := damaged.Quantity
+		for j := range ret.Lines {
+			line := &ret.Lines[j]
+			if left > 0 && line.ProductID == damaged.ProductID && line.VariantID == damaged.VariantID {
+				n := min(left, line.Restocked)
+				line.Restocked -= n
+				left -= n
+			}
+		}
+		if damaged.Quantity <= 0 || left > 0 {
+			return nil, fmt.Errorf("%w: damaged line %d needs a positive quantity of a returned product", ErrInvalidReturn, i+1)
+		}
+	}
+	ret.Status = models.ReturnReceived
+	ret.WarehouseID = receipt.WarehouseID
+	ret.ReceivedAt = time.Now().UTC().Format(time.RFC3339)
+	updated, err := s.returns.Update(ctx, ret)
+	if err != nil {
+		return nil, err
+	}
+
+	// undo takes back the stock restocked and restores the return. It must
+	// not be cut short by a cancelled request, or stock would be left
+	// without a received return.
+	var done []*models.StockMovement
+	undo := func(err error) error {
+		ctx := context.WithoutCancel(ctx)
+		errs := []error{err}
+		for _, m := range done {
+			back := *m
+			back.Quantity = -m.Quantity
+			back.Type = models.MovementAdjustment
+			back.Reason = "return receipt taken back"
+			errs = append(errs, s.products.AdjustStock(ctx, &back))
+		}
+		before.Version = updated.Version
+		_, revertErr := s.returns.Update(ctx, &before)
+		return errors.Join(append(errs, revertErr)...)
+	}
+
+	movements, err := s.restock(ctx, order, ret, receipt.WarehouseID)
+	for _, m := range movements {
+		if err = s.products.AdjustStock(ctx, m); err != nil {
+			break
+		}
+		done = append(done, m)
+	}
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) || errors.Is(err, repos.ErrInvalidReference) {
+			// The product, or its variant, was deleted after it was ordered.
+			err = fmt.Errorf("%w: a returned product no longer exists; list it as damaged", ErrInvalidReturn)
+		}
+		return nil, undo(err)
+	}
+
+	err = s.updateOrder(ctx, order.ID, func(o *models.Order) error {
+		for _, line := range ret.Lines {
+			o.Products[line.Line].Restocked += line.Restocked
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, undo(err)
+	}
+	return updated, nil
+}
+
+// restock returns the stock movements putting the restocked goods of ret
+// back, at the warehouse given or else where the order took them from.
+func (s *ReturnService) restock(ctx context.Context, order *models.Order, ret *models.Return, warehouseID string) ([]*models.StockMovement, error) {
+	var movements []*models.StockMovement
+	add := func(productID, variantID string, quantity int) error {
+		if quantity <= 0 {
+			return nil
+		}
+		warehouse, err := s.returnWarehouse(ctx, order, productID, variantID, warehouseID)
+		if err != nil {
+			return err
+		}
+		movements = append(movements, &models.StockMovement{
+			ProductID:   productID,
+			VariantID:   variantID,
+			WarehouseID: warehouse,
+			Type:        models.MovementReturn,
+			Quantity:    quantity,
+			Reason:      "return " + ret.ID,
+			OrderID:     order.ID,
+		})
+		return nil
+	}
+
+	for _, line := range ret.Lines {
+		ordered := order.Products[line.Line]
+		if len(ordered.Components) == 0 {
+			if err := add(line.ProductID, line.VariantID, line.Restocked); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, c := range ordered.Components {
+			if err := add(c.ProductID, c.VariantID, c.Quantity*line.Restocked/ordered.Quantity); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return movements, nil
+}
+
+func (s *ReturnService) returnWarehouse(ctx context.Context, order *models.Order, productID, variantID, warehouseID string) (string, error) {
+	if warehouseID != "" {
+		return warehouseID, nil
+	}
+	for _, a := range order.Allocations {
+		if a.ProductID == productID && a.VariantID == variantID {
+			return a.WarehouseID, nil
+		}
+	}
+	warehouse, err := s.warehouses.Default(ctx)
+	if errors.Is(err, repos.ErrNotFound) {
+		return "", fmt.Errorf("%w: there is no warehouse to restock the goods", ErrInvalidReturn)
+	}
+	if err != nil {
+		return "", err
+	}
+	return warehouse.ID, nil
+}
+
+// Refund refunds a received return on its order: each line is refunded
+// what was paid for it, after discounts and with its tax, and shipping is
+// kept. A smaller amount, such as one less a restocking fee, is spread over
+// the lines. The order records the refund and moves to refunded once every
+// line has been returned in full, and to partially_refunded until then.
+//
+// Money is given back through the captured payments of the order, as far
+// as they go; the rest is taken as refunded outside them. When the gateway
+// fails, the refund stays recorded and the error wraps
+// ErrRefundIncomplete.
+func (s *ReturnService) Refund(ctx context.Context, id string, amount float64) (*models.Return, error) {
+	ret, err := s.returns.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Status != models.ReturnReceived {
+		return nil, repos.ErrVersionConflict
+	}
+	order, err := s.orders.FindByID(ctx, ret.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLines(order, ret); err != nil {
+		return nil, err
+	}
+
+	before := *ret
+	ret.Lines = append([]models.ReturnLine(nil), ret.Lines...)
+	if err := refundLines(order, ret, amount); err != nil {
+		return nil, err
+	}
+	ret.Status = models.ReturnRefunded
+	ret.RefundedAt = time.Now().UTC().Format(time.RFC3339)
+	updated, err := s.returns.Update(ctx, ret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.updateOrder(ctx, order.ID, func(o *models.Order) error {
+		for _, line := range ret.Lines {
+			ordered := &o.Products[line.Line]
+			ordered.Returned += line.Quantity
+			ordered.Refunded = roundCents(ordered.Refunded + line.Refund)
+			ordered.RefundedTax = roundCents(ordered.RefundedTax + line.Tax)
+		}
+		o.Refunded = roundCents(o.Refunded + ret.Refund)
+		o.RefundedTax = roundCents(o.RefundedTax + ret.Tax)
+		o.Status = models.OrderStatusRefunded
+		for _, ordered := range o.Products {
+			if ordered.Returned < ordered.Quantity {
+				o.Status = models.OrderStatusPartiallyRefunded
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		before.Version = updated.Version
+		_, revertErr := s.returns.Update(context.WithoutCancel(ctx), &before)
+		return nil, errors.Join(err, revertErr)
+	}
+
+	paid, payErr := s.payments.RefundOrder(ctx, order.ID, updated.Refund)
+	if paid > 0 {
+		updated.PaymentRefunded = paid
+		if updated, err = s.returns.Update(context.WithoutCancel(ctx), updated); err != nil {
+			return nil, errors.Join(err, payErr)
+		}
+	}
+	if payErr != nil {
+		return updated, fmt.Errorf("%w: %.2f of %.2f was given back: %w", ErrRefundIncomplete, paid, updated.Refund, payErr)
+	}
+	return updated, nil
+}
+
+// checkLines makes sure the lines of ret still name lines of the order,
+// which an update of the order may have replaced.
+func checkLines(order *models.Order, ret *models.Return) error {
+	for _, line := range ret.Lines {
+		if line.Line < 0 || line.Line >= len(order.Products) ||
+			order.Products[line.Line].ProductID != line.ProductID || order.Products[line.Line].VariantID != line.VariantID {
+			return fmt.Errorf("%w: the order lines changed since the return was requested", ErrInvalidReturn)
+		}
+	}
+	return nil
+}
+
+// refundLines fills in the refund of each line of ret, and of ret, from
+// what was paid for the order lines returned, scaled down to amount unless
+// that is zero.
+func refundLines(order *models.Order, ret *models.Return, amount float64) error {
+	full := 0.0
+	for i := range ret.Lines {
+		line := &ret.Lines[i]
+		ordered := order.Products[line.Line]
+		gross := ordered.Price*float64(ordered.Quantity) - ordered.Discount
+		if !order.TaxInclusive {
+			gross += ordered.Tax
+		}
+		share := float64(line.Quantity) / float64(ordered.Quantity)
+		line.Refund = min(roundCents(gross*share), roundCents(gross-ordered.Refunded))
+		line.Tax = min(roundCents(ordered.Tax*share), roundCents(ordered.Tax-ordered.RefundedTax))
+		full += line.Refund
+	}
+	full = roundCents(full)
+
+	amount = roundCents(amount)
+	switch {
+	case amount < 0 || amount > full:
+		return fmt.Errorf("%w: the amount must be positive and at most the %.2f paid for the goods", ErrInvalidReturn, full)
+	case amount == 0 || amount == full:
+		amount = full
+	default:
+		// Spread the smaller amount over the lines, the last one taking
+		// what rounding leaves.
+		factor, left := amount/full, amount
+		for i := range ret.Lines {
+			line := &ret.Lines[i]
+			line.Tax = roundCents(line.Tax * factor)
+			line.Refund = roundCents(line.Refund * factor)
+			if i == len(ret.Lines)-1 {
+				line.Refund = roundCents(left)
+			}
+			left -= line.Refund
+		}
+	}
+
+	ret.Refund, ret.Tax = amount, 0
+	for _, line := range ret.Lines {
+		ret.Tax += line.Tax
+	}
+	ret.Tax = roundCents(ret.Tax)
+	return nil
+}
+
+// updateOrder applies fn to the order and stores what returns changed,
+// starting over when the order changed meanwhile.
+func (s *ReturnService) updateOrder(ctx context.Context, id string, fn func(*models.Order) error) error {
+	for attempt := 1; ; attempt++ {
+		order, err := s.orders.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+		_, err = s.orders.SetReturns(ctx, order)
+		if !errors.Is(err, repos.ErrVersionConflict) || attempt == orderUpdateAttempts {
+			return err
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+)
+
+func TestRefundLines(t *testing.T) {
+	order := func(inclusive bool, lines ...models.ProductInOrder) *models.Order {
+		return &models.Order{TaxInclusive: inclusive, Products: lines}
+	}
+	// 3 at 10 with 3 off and 10% tax on the 27 left, and 2 at 5 with 10% tax.
+	exclusive := order(false,
+		models.ProductInOrder{ProductID: "a", Price: 10, Quantity: 3, Discount: 3, Tax: 2.7},
+		models.ProductInOrder{ProductID: "b", Price: 5, Quantity: 2, Tax: 1},
+	)
+	// returning lists return lines from pairs of order line and quantity.
+	returning := func(quantities ...int) []models.ReturnLine {
+		lines := make([]models.ReturnLine, 0, len(quantities)/2)
+		for i := 0; i < len(quantities); i += 2 {
+			lines = append(lines, models.ReturnLine{Line: quantities[i], Quantity: quantities[i+1]})
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name       string
+		order      *models.Order
+		lines      []models.ReturnLine
+		amount     float64
+		wantLines  []float64
+		wantTaxes  []float64
+		wantRefund float64
+		wantTax    float64
+		isErr      bool
+	}{
+		{
+			name:       "whole line",
+			order:      exclusive,
+			lines:      returning(0, 3),
+			wantLines:  []float64{29.7},
+			wantTaxes:  []float64{2.7},
+			wantRefund: 29.7,
+			wantTax:    2.7,
+		},
+		{
+			name:       "part of a line",
+			order:      exclusive,
+			lines:      returning(0, 1),
+			wantLines:  []float64{9.9},
+			wantTaxes:  []float64{0.9},
+			wantRefund: 9.9,
+			wantTax:    0.9,
+		},
+		{
+			name:       "several lines",
+			order:      exclusive,
+			lines:      returning(0, 1, 1, 2),
+			wantLines:  []float64{9.9, 11},
+			wantTaxes:  []float64{0.9, 1},
+			wantRefund: 20.9,
+			wantTax:    1.9,
+		},
+		{
+			name:       "amount equal to what was paid",
+			order:      exclusive,
+			lines:      returning(0, 1, 1, 2),
+			amount:     20.9,
+			wantLines:  []float64{9.9, 11},
+			wantTaxes:  []float64{0.9, 1},
+			wantRefund: 20.9,
+			wantTax:    1.9,
+		},
+		{
+			name:       "smaller amount spread over the lines",
+			order:      exclusive,
+			lines:      returning(0, 1, 1, 2),
+			amount:     10.45,
+			wantLines:  []float64{4.95, 5.5},
+			wantTaxes:  []float64{0.45, 0.5},
+			wantRefund: 10.45,
+			wantTax:    0.95,
+		},
+		{
+			name: "capped by what is left to refund",
+			order: order(false, models.ProductInOrder{
+				ProductID: "a", Price: 10, Quantity: 3, Returned: 2, Refunded: 20.01,
+			}),
+			lines:      returning(0, 1),
+			wantLines:  []float64{9.99},
+			wantTaxes:  []float64{0},
+			wantRefund: 9.99,
+			wantTax:    0,
+		},
+		{
+			name: "prices include tax",
+			order: order(true, models.ProductInOrder{
+				ProductID: "a", Price: 11.9, Quantity: 2, Tax: 3.8,
+			}),
+			lines:      returning(0, 1),
+			wantLines:  []float64{11.9},
+			wantTaxes:  []float64{1.9},
+			wantRefund: 11.9,
+			wantTax:    1.9,
+		},
+		{
+			name:   "amount above what was paid",
+			order:  exclusive,
+			lines:  returning(0, 1),
+			amount: 9.91,
+			isErr:  true,
+		},
+		{
+			name:   "negative amount",
+			order:  exclusive,
+			lines:  returning(0, 1),
+			amount: -1,
+			isErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ret := &models.Return{Lines: tt.lines}
+			err := refundLines(tt.order, ret, tt.amount)
+			if tt.isErr {
+				if !errors.Is(err, ErrInvalidReturn) {
+					t.Errorf("error = %v, want ErrInvalidReturn", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("refundLines: %v", err)
+			}
+
+			var refunds, taxes []float64
+			for _, line := range ret.Lines {
+				refunds = append(refunds, line.Refund)
+				taxes = append(taxes, line.Tax)
+			}
+			if !sameAmounts(refunds, tt.wantLines) || !sameAmounts(taxes, tt.wantTaxes) {
+				t.Errorf("lines refund %v, tax %v; want %v, %v", refunds, taxes, tt.wantLines, tt.wantTaxes)
+			}
+			if ret.Refund != tt.wantRefund || ret.Tax != tt.wantTax {
+				t.Errorf("refund %v, tax %v; want %v, %v", ret.Refund, ret.Tax, tt.wantRefund, tt.wantTax)
+			}
+		})
+	}
+}
+
+// stubReturns keeps one return in memory; other methods are not used.
+type stubReturns struct {
+	repos.ReturnRepository
+	ret *models.Return
+}
+
+func (s *stubReturns) FindByID(_ context.Context, id string) (*models.Return, error) {
+	if id != s.ret.ID {
+		return nil, repos.ErrNotFound
+	}
+	found := *s.ret
+	return &found, nil
+}
+
+func (s *stubReturns) Update(_ context.Context, ret *models.Return) (*models.Return, error) {
+	if ret.Version != s.ret.Version {
+		return nil, repos.ErrVersionConflict
+	}
+	ret.Version++
+	stored := *ret
+	s.ret = &stored
+	return ret, nil
+}
+
+// returnedOrders keeps one order in memory and records returns on it, or
+// fails with err; other methods are not used.
+type returnedOrders struct {
+	repos.OrderRepository
+	order *models.Order
+	err   error
+}
+
+func (s *returnedOrders) FindByID(_ context.Context, id string) (*models.Order, error) {
+	found := *s.order
+	found.Products = append([]models.ProductInOrder(nil), s.order.Products...)
+	return &found, nil
+}
+
+func (s *returnedOrders) SetReturns(_ context.Context, order *models.Order) (*models.Order, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.order = order
+	return order, nil
+}
+
+func TestReceive(t *testing.T) {
+	const pen, ink = "64b7f0c2e4b0a1a2b3c4d5e6", "64b7f0c2e4b0a1a2b3c4d5e7"
+	errStore := errors.New("store down")
+
+	tests := []struct {
+		name          string
+		products      []string
+		orderErr      error
+		wantErr       error
+		wantStatus    string
+		wantStock     int
+		wantRestocked []int
+	}{
+		{
+			name:          "restocked",
+			products:      []string{pen, ink},
+			wantStatus:    models.ReturnReceived,
+			wantStock:     7,
+			wantRestocked: []int{2, 1},
+		},
+		{
+			name:          "product gone",
+			products:      []string{pen},
+			wantErr:       ErrInvalidReturn,
+			wantStatus:    models.ReturnApproved,
+			wantStock:     5,
+			wantRestocked: []int{0, 0},
+		},
+		{
+			name:          "order not updated",
+			products:      []string{pen, ink},
+			orderErr:      errStore,
+			wantErr:       errStore,
+			wantStatus:    models.ReturnApproved,
+			wantStock:     5,
+			wantRestocked: []int{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products := stockedProducts{byID: map[string]*models.Product{}}
+			for _, id := range tt.products {
+				products.byID[id] = &models.Product{ID: id, Stock: 5, Locations: []models.StockLevel{{WarehouseID: "w1", Stock: 5}}}
+			}
+			orders := &returnedOrders{err: tt.orderErr, order: &models.Order{
+				ID: "o1",
+				Products: []models.ProductInOrder{
+					{ProductID: pen, Quantity: 2},
+					{ProductID: ink, Quantity: 1},
+				},
+				Allocations: []models.StockAllocation{
+					{ProductID: pen, WarehouseID: "w1", Quantity: 2},
+					{ProductID: ink, WarehouseID: "w1", Quantity: 1},
+				},
+			}}
+			returns := &stubReturns{ret: &models.Return{
+				ID:      "r1",
+				OrderID: "o1",
+				Status:  models.ReturnApproved,
+				Version: 1,
+				Lines: []models.ReturnLine{
+					{ProductID: pen, Line: 0, Quantity: 2},
+					{ProductID: ink, Line: 1, Quantity: 1},
+				},
+			}}
+			s := NewReturnService(returns, orders, products, stubWarehouses{}, nil)
+
+			_, err := s.Receive(context.Background(), "r1", &models.ReturnReceipt{})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Receive: %v", err)
+			}
+
+			if returns.ret.Status != tt.wantStatus {
+				t.Errorf("return status = %q, want %q", returns.ret.Status, tt.wantStatus)
+			}
+			if p := products.byID[pen]; p.Stock != tt.wantStock || p.Locations[0].Stock != tt.wantStock {
+				t.Errorf("stock = %d at %d, want %d", p.Stock, p.Locations[0].Stock, tt.wantStock)
+			}
+			for i, want := range tt.wantRestocked {
+				if got := orders.order.Products[i].Restocked; got != want {
+					t.Errorf("line %d restocked %d, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+)
+
+// TaxService works out the tax of orders from the rates of the region they
+// ship to and the tax classes of their products.
+type TaxService struct {
+	rates    repos.TaxRateRepository
+	products repos.ProductRepository
+	// inclusive tells whether catalog prices include tax.
+	inclusive bool
+	rounding  string
+}
+
+// NewTaxService returns a TaxService for prices that include tax or not,
+// rounding per line or per order.
+func NewTaxService(rates repos.TaxRateRepository, products repos.ProductRepository, inclusive bool, rounding string) (*TaxService, error) {
+	if rounding != models.TaxRoundPerLine && rounding != models.TaxRoundPerOrder {
+		return nil, fmt.Errorf("unknown tax rounding %q, want %s or %s", rounding, models.TaxRoundPerLine, models.TaxRoundPerOrder)
+	}
+	return &TaxService{
+		rates:     rates,
+		products:  products,
+		inclusive: inclusive,
+		rounding:  rounding,
+	}, nil
+}
+
+// LoadRates stores the tax rates of a JSON file, a list of rates, in place
+// of those covering the same country, region and tax class. Rates changed
+// through the API since are overwritten again.
+func (s *TaxService) LoadRates(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rates []*models.TaxRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rate := range rates {
+		if err := rate.Validate(); err != nil {
+			return fmt.Errorf("%s: rate %d: %w", path, i+1, err)
+		}
+	}
+	for _, rate := range rates {
+		if _, err := s.rates.Upsert(ctx, rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply taxes the lines of an order, priced and discounted, at the rates
+// of its shipping address, or of its billing address when it ships to none;
+// an order with neither is not taxed. Each line is taxed on its amount
+// after discounts, and the shipping fee is not taxed. Apply fills in the
+// tax of the lines and of the order and, unless prices include tax, adds
+// it to the total.
+func (s *TaxService) Apply(ctx context.Context, order *models.Order) error {
+	order.TaxInclusive = s.inclusive
+	order.Tax, order.Taxes = 0, nil
+	for i := range order.Products {
+		order.Products[i].TaxRate, order.Products[i].Tax = 0, 0
+	}
+	address := order.ShippingAddress
+	if address == nil {
+		address = order.BillingAddress
+	}
+	if address == nil {
+		return nil
+	}
+	rates, err := s.rates.ForCountry(ctx, address.Country)
+	if err != nil {
+		return err
+	}
+
+	// exact holds the unrounded tax at each rate, for rounding per order.
+	var exact []float64
+	index := map[string]int{}
+	for i := range order.Products {
+		line := &order.Products[i]
+		if line.TaxClass, err = s.taxClass(ctx, line.ProductID); err != nil {
+			return err
+		}
+		rate := regionRate(rates, address.Region, line.TaxClass)
+		if rate == nil {
+			continue
+		}
+
+		amount := line.Price*float64(line.Quantity) - line.Discount
+		tax := amount * rate.Rate / 100
+		if s.inclusive {
+			tax = amount * rate.Rate / (100 + rate.Rate)
+		}
+		line.TaxRate = rate.Rate
+		line.Tax = roundCents(tax)
+
+		n, ok := index[rate.ID]
+		if !ok {
+			n = len(order.Taxes)
+			index[rate.ID] = n
+			order.Taxes = append(order.Taxes, models.OrderTax{
+				RateID:   rate.ID,
+				Name:     rate.Name,
+				Country:  rate.Country,
+				Region:   rate.Region,
+				TaxClass: rate.TaxClass,
+				Rate:     rate.Rate,
+			})
+			exact = append(exact, 0)
+		}
+		order.Taxes[n].Taxable += amount
+		order.Taxes[n].Amount += line.Tax
+		exact[n] += tax
+	}
+
+	for i := range order.Taxes {
+		t := &order.Taxes[i]
+		if s.rounding == models.TaxRoundPerOrder {
+			t.Amount = exact[i]
+		}
+		t.Amount = roundCents(t.Amount)
+		if s.inclusive {
+			t.Taxable -= t.Amount
+		}
+		t.Taxable = roundCents(t.Taxable)
+		order.Tax += t.Amount
+	}
+	order.Tax = roundCents(order.Tax)
+	if !s.inclusive {
+		order.TotalPrice = roundCents(order.TotalPrice + order.Tax)
+	}
+	return nil
+}
+
+// taxClass returns the tax class of a product; products that are gone are
+// taxed as standard.
+func (s *TaxService) taxClass(ctx context.Context, productID string) (string, error) {
+	product, err := s.products.FindByID(ctx, productID)
+	if errors.Is(err, repos.ErrNotFound) {
+		return models.TaxClassStandard, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if product.TaxClass == "" {
+		return models.TaxClassStandard, nil
+	}
+	return product.TaxClass, nil
+}
+
+// regionRate picks the rate of the tax class for the region, falling back
+// to the rate for the whole country.
+func regionRate(rates []*models.TaxRate, region, class string) *models.TaxRate {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	var country *models.TaxRate
+	for _, rate := range rates {
+		if rate.TaxClass != class {
+			continue
+		}
+		switch rate.Region {
+		case "":
+			country = rate
+		case region:
+			return rate
+		}
+	}
+	return country
+}
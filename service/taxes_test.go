@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+)
+
+// stubRates serves tax rates by country; other methods are not used.
+type stubRates struct {
+	repos.TaxRateRepository
+	byCountry map[string][]*models.TaxRate
+}
+
+func (s stubRates) ForCountry(_ context.Context, country string) ([]*models.TaxRate, error) {
+	return s.byCountry[country], nil
+}
+
+// stubProducts serves products by ID; other methods are not used.
+type stubProducts struct {
+	repos.ProductRepository
+	byID map[string]*models.Product
+}
+
+func (s stubProducts) FindByID(_ context.Context, id string) (*models.Product, error) {
+	if p, ok := s.byID[id]; ok {
+		return p, nil
+	}
+	return nil, repos.ErrNotFound
+}
+
+func TestTaxApply(t *testing.T) {
+	rates := stubRates{byCountry: map[string][]*models.TaxRate{
+		"US": {
+			{ID: "us", Name: "US", Country: "US", TaxClass: models.TaxClassStandard, Rate: 5},
+			{ID: "us-ca", Name: "California", Country: "US", Region: "CA", TaxClass: models.TaxClassStandard, Rate: 7.25},
+			{ID: "us-food", Name: "US food", Country: "US", TaxClass: "food", Rate: 1},
+		},
+		"DE": {
+			{ID: "de", Name: "MwSt", Country: "DE", TaxClass: models.TaxClassStandard, Rate: 19},
+		},
+	}}
+	products := stubProducts{byID: map[string]*models.Product{
+		"pen":  {ID: "pen"},
+		"pen2": {ID: "pen2", TaxClass: models.TaxClassStandard},
+		"book": {ID: "book", TaxClass: "food"},
+	}}
+	at := func(country, region string) *models.Address {
+		return &models.Address{Country: country, Region: region}
+	}
+	line := func(productID string, price float64, quantity int, discount float64) models.ProductInOrder {
+		return models.ProductInOrder{ProductID: productID, Price: price, Quantity: quantity, Discount: discount}
+	}
+
+	type wantTax struct {
+		rateID          string
+		taxable, amount float64
+	}
+	tests := []struct {
+		name      string
+		inclusive bool
+		rounding  string
+		order     *models.Order
+		wantLines []float64
+		wantTaxes []wantTax
+		wantTax   float64
+		wantTotal float64
+	}{
+		{
+			name:      "no address",
+			rounding:  models.TaxRoundPerLine,
+			order:     &models.Order{Products: []models.ProductInOrder{line("pen", 10, 1, 0)}, TotalPrice: 10},
+			wantLines: []float64{0},
+			wantTotal: 10,
+		},
+		{
+			name:     "country without rates",
+			rounding: models.TaxRoundPerLine,
+			order: &models.Order{
+				ShippingAddress: at("FR", ""),
+				Products:        []models.ProductInOrder{line("pen", 10, 1, 0)},
+				TotalPrice:      10,
+			},
+			wantLines: []float64{0},
+			wantTotal: 10,
+		},
+		{
+			name:     "rate per tax class",
+			rounding: models.TaxRoundPerLine,
+			order: &models.Order{
+				ShippingAddress: at("US", "NY"),
+				Products:        []models.ProductInOrder{line("pen", 0.99, 3, 0), line("book", 10, 1, 0)},
+				TotalPrice:      12.97,
+			},
+			wantLines: []float64{0.15, 0.1},
+			wantTaxes: []wantTax{{"us", 2.97, 0.15}, {"us-food", 10, 0.1}},
+			wantTax:   0.25,
+			wantTotal: 13.22,
+		},
+		{
+			name:     "region rate before the country rate",
+			rounding: models.TaxRoundPerLine,
+			order: &models.Order{
+				ShippingAddress: at("US", " ca "),
+				Products:        []models.ProductInOrder{line("pen", 20, 1, 0), line("book", 10, 1, 0)},
+				TotalPrice:      30,
+			},
+			wantLines: []float64{1.45, 0.1},
+			wantTaxes: []wantTax{{"us-ca", 20, 1.45}, {"us-food", 10, 0.1}},
+			wantTax:   1.55,
+			wantTotal: 31.55,
+		},
+		{
+			name:     "taxed after discounts",
+			rounding: models.TaxRoundPerLine,
+			order: &models.Order{
+				ShippingAddress: at("US", ""),
+				Products:        []models.ProductInOrder{line("pen", 10, 2, 5)},
+				TotalPrice:      15,
+			},
+			wantLines: []float64{0.75},
+			wantTaxes: []wantTax{{"us", 15, 0.75}},
+			wantTax:   0.75,
+			wantTotal: 15.75,
+		},
+		{
+			name:     "rounded per line",
+			rounding: models.TaxRoundPerLine,
+			order: &models.Order{
+				ShippingAddress: at("US", ""),
+				Products:        []models.ProductInOrder{line("pen", 0.13, 1, 0), line("pen2", 0.13, 1, 0), line("gone", 0.13, 1, 0)},
+				TotalPrice:      0.39,
+			},
+			wantLines: []float64{0.01, 0.01, 0.01},
+			wantTaxes: []wantTax{{"us", 0.39, 0.03}},
+			wantTax:   0.03,
+			wantTotal: 0.42,
+		},
+		{
+			name:     "rounded per order",
+			rounding: models.TaxRoundPerOrder,
+			order: &models.Order{
+				ShippingAddress: at("US", ""),
+				Products:        []models.ProductInOrder{line("pen", 0.13, 1, 0), line("pen2", 0.13, 1, 0), line("gone", 0.13, 1, 0)},
+				TotalPrice:      0.39,
+			},
+			wantLines: []float64{0.01, 0.01, 0.01},
+			wantTaxes: []wantTax{{"us", 0.39, 0.02}},
+			wantTax:   0.02,
+			wantTotal: 0.41,
+		},
+		{
+			name:      "prices include tax",
+			inclusive: true,
+			rounding:  models.TaxRoundPerLine,
+			order: &models.Order{
+				BillingAddress: at("DE", ""),
+				Products:       []models.ProductInOrder{line("pen", 11.9, 1, 0)},
+				TotalPrice:     11.9,
+			},
+			wantLines: []float64{1.9},
+			wantTaxes: []wantTax{{"de", 10, 1.9}},
+			wantTax:   1.9,
+			wantTotal: 11.9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewTaxService(rates, products, tt.inclusive, tt.rounding)
+			if err != nil {
+				t.Fatalf("NewTaxService: %v", err)
+			}
+			if err := s.Apply(context.Background(), tt.order); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			var lines []float64
+			for _, l := range tt.order.Products {
+				lines = append(lines, l.Tax)
+			}
+			if !sameAmounts(lines, tt.wantLines) {
+				t.Errorf("line taxes = %v, want %v", lines, tt.wantLines)
+			}
+			if len(tt.order.Taxes) != len(tt.wantTaxes) {
+				t.Fatalf("taxes = %+v, want %+v", tt.order.Taxes, tt.wantTaxes)
+			}
+			for i, want := range tt.wantTaxes {
+				got := tt.order.Taxes[i]
+				if got.RateID != want.rateID || math.Abs(got.Taxable-want.taxable) > 1e-9 || math.Abs(got.Amount-want.amount) > 1e-9 {
+					t.Errorf("taxes[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+			if tt.order.Tax != tt.wantTax || tt.order.TotalPrice != tt.wantTotal || tt.order.TaxInclusive != tt.inclusive {
+				t.Errorf("tax %v, total %v, inclusive %v; want %v, %v, %v",
+					tt.order.Tax, tt.order.TotalPrice, tt.order.TaxInclusive, tt.wantTax, tt.wantTotal, tt.inclusive)
+			}
+		})
+	}
+}
+
+func TestNewTaxServiceRounding(t *testing.T) {
+	tests := []struct {
+		rounding string
+		isErr    bool
+	}{
+		{models.TaxRoundPerLine, false},
+		{models.TaxRoundPerOrder, false},
+		{"", true},
+		{"cent", true},
+	}
+	for _, tt := range tests {
+		if _, err := NewTaxService(nil, nil, false, tt.rounding); (err != nil) != tt.isErr {
+			t.Errorf("NewTaxService(%q) error = %v, want error %v", tt.rounding, err, tt.isErr)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidTransfer is wrapped by the errors for transfers that cannot be
+// made as given.
+var ErrInvalidTransfer = errors.New("invalid transfer")
+
+type TransferService struct {
+	products   repos.ProductRepository
+	warehouses repos.WarehouseRepository
+	transfers  repos.TransferRepository
+}
+
+func NewTransferService(products repos.ProductRepository, warehouses repos.WarehouseRepository, transfers repos.TransferRepository) *TransferService {
+	return &TransferService{products: products, warehouses: warehouses, transfers: transfers}
+}
+
+// Create stores a pending transfer after checking its warehouses and
+// lines. No stock moves until it ships.
+func (s *TransferService) Create(ctx context.Context, transfer *models.Transfer) (*models.Transfer, error) {
+	if transfer.FromWarehouseID == transfer.ToWarehouseID {
+		return nil, fmt.Errorf("%w: the source and destination warehouses must differ", ErrInvalidTransfer)
+	}
+	for _, id := range []string{transfer.FromWarehouseID, transfer.ToWarehouseID} {
+		_, err := s.warehouses.FindByID(ctx, id)
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(id) {
+			return nil, fmt.Errorf("%w: unknown warehouse %q", ErrInvalidTransfer, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(transfer.Lines) == 0 {
+		return nil, fmt.Errorf("%w: a transfer needs at least one line", ErrInvalidTransfer)
+	}
+	for i, line := range transfer.Lines {
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: line %d needs a positive quantity", ErrInvalidTransfer, i+1)
+		}
+		product, err := s.products.FindByID(ctx, line.ProductID)
+		if errors.Is(err, repos.ErrNotFound) || !primitive.IsValidObjectID(line.ProductID) {
+			return nil, fmt.Errorf("%w: unknown product %q", ErrInvalidTransfer, line.ProductID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if product.IsBundle() {
+			return nil, fmt.Errorf("%w: bundle %q holds no stock of its own; transfer its components", ErrInvalidTransfer, product.ID)
+		}
+		if problem := variantProblem(product, line.VariantID); problem != "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTransfer, problem)
+		}
+	}
+	return s.transfers.Create(ctx, transfer)
+}
+
+// Ship takes the stock of a pending transfer out of the source warehouse.
+// If any line lacks the stock, the lines already taken are put back and the
+// transfer stays pending.
+func (s *TransferService) Ship(ctx context.Context, id string) (*models.Transfer, error) {
+	transfer, err := s.transfers.SetStatus(ctx, id, models.TransferPending, models.TransferInTransit)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range transfer.Lines {
+		err := s.move(ctx, transfer, line, transfer.FromWarehouseID, -line.Quantity)
+		if err == nil {
+			continue
+		}
+		switch {
+		case errors.Is(err, repos.ErrInsufficientStock):
+			err = fmt.Errorf("%w: product %s", repos.ErrInsufficientStock, line.ProductID)
+		case errors.Is(err, repos.ErrNotFound), errors.Is(err, repos.ErrInvalidReference):
+			// The product, or its variant, was deleted after the transfer was made.
+			err = fmt.Errorf("%w: product %s can no longer be transferred", ErrInvalidTransfer, line.ProductID)
+		}
+		// Putting the stock back must not be cut short by a cancelled
+		// request, or it would be lost in transit.
+		ctx := context.WithoutCancel(ctx)
+		errs := []error{err}
+		for _, taken := range transfer.Lines[:i] {
+			errs = append(errs, s.move(ctx, transfer, taken, transfer.FromWarehouseID, taken.Quantity))
+		}
+		_, revertErr := s.transfers.SetStatus(ctx, id, models.TransferInTransit, models.TransferPending)
+		return nil, errors.Join(append(errs, revertErr)...)
+	}
+	return transfer, nil
+}
+
+// Receive puts the stock of a transfer in transit into the destination
+// warehouse.
+func (s *TransferService) Receive(ctx context.Context, id string) (*models.Transfer, error) {
+	transfer, err := s.transfers.SetStatus(ctx, id, models.TransferInTransit, models.TransferReceived)
+	if err != nil {
+		return nil, err
+	}
+
+	// The transfer is received once marked so; every line is attempted,
+	// even when ctx is cancelled, so none is left in transit.
+	ctx = context.WithoutCancel(ctx)
+	var errs []error
+	for _, line := range transfer.Lines {
+		if err := s.move(ctx, transfer, line, transfer.ToWarehouseID, line.Quantity); err != nil {
+			errs = append(errs, fmt.Errorf("receiving product %s: %w", line.ProductID, err))
+		}
+	}
+	return transfer, errors.Join(errs...)
+}
+
+// Cancel drops a transfer that has not shipped.
+func (s *TransferService) Cancel(ctx context.Context, id string) (*models.Transfer, error) {
+	return s.transfers.SetStatus(ctx, id, models.TransferPending, models.TransferCancelled)
+}
+
+func (s *TransferService) move(ctx context.Context, transfer *models.Transfer, line models.TransferLine, warehouseID string, quantity int) error {
+	return s.products.AdjustStock(ctx, &models.StockMovement{
+		ProductID:   line.ProductID,
+		VariantID:   line.VariantID,
+		WarehouseID: warehouseID,
+		Type:        models.MovementTransfer,
+		Quantity:    quantity,
+		TransferID:  transfer.ID,
+	})
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/udevs/lesson3/repos"
+	"go.uber.org/zap"
+)
+
+// TrashPurger permanently removes the products and orders that have been
+// in the trash for longer than the retention period, together with the
+// images of the products.
+type TrashPurger struct {
+	products  repos.ProductRepository
+	orders    repos.OrderRepository
+	images    *ImageService
+	retention time.Duration
+	logger    *zap.Logger
+}
+
+func NewTrashPurger(products repos.ProductRepository, orders repos.OrderRepository, images *ImageService, retention time.Duration, logger *zap.Logger) *TrashPurger {
+	return &TrashPurger{
+		products:  products,
+		orders:    orders,
+		images:    images,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Purge runs a single purge.
+func (p *TrashPurger) Purge(ctx context.Context) error {
+	cutoff := time.Now().Add(-p.retention)
+
+	products, err := p.products.Purge(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	// The images of a purged product can no longer be restored with it.
+	for _, product := range products {
+		for i := range product.Images {
+			if err := p.images.Discard(ctx, &product.Images[i]); err != nil {
+				p.logger.Error("Failed to discard image", zap.String("product_id", product.ID), zap.Error(err))
+			}
+		}
+	}
+
+	orders, err := p.orders.Purge(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(products) > 0 || len(orders) > 0 {
+		p.logger.Info("Purged trash", zap.Int("products", len(products)), zap.Int("orders", len(orders)))
+	}
+	return nil
+}
+
+// Run purges every interval until ctx is done.
+func (p *TrashPurger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Purge(ctx); err != nil {
+			p.logger.Error("Failed to purge trash", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type StockAlertStorage struct {
+	collection *mongo.Collection
+}
+
+func NewStockAlertStorage(coll *mongo.Collection) *StockAlertStorage {
+	return &StockAlertStorage{
+		collection: coll,
+	}
+}
+
+func (s *StockAlertStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// A product has at most one alert that is not restocked, which
+		// makes raising an alert idempotent.
+		{
+			Keys: bson.D{{Key: "product_id", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"restocked": false}),
+		},
+		{Keys: bson.D{{Key: "raised_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "raised_at", Value: -1}}},
+	})
+	return err
+}
+
+func (s *StockAlertStorage) Raise(ctx context.Context, alert *models.StockAlert) (bool, error) {
+	alert.ID = primitive.NewObjectID().Hex()
+	alert.Status = models.AlertOpen
+	alert.Restocked = false
+	alert.Version = 1
+	alert.RaisedAt = time.Now().UTC().Format(time.RFC3339)
+
+	_, err := s.collection.InsertOne(ctx, alert)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *StockAlertStorage) FindByID(ctx context.Context, id string) (*models.StockAlert, error) {
+	if !primitive.IsValidObjectID(id) {
+		return nil, repos.ErrNotFound
+	}
+	var alert models.StockAlert
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&alert); err != nil {
+		return nil, notFound(err)
+	}
+	return &alert, nil
+}
+
+func alertFilter(filter repos.StockAlertFilter) bson.M {
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.ProductID != "" {
+		query["product_id"] = filter.ProductID
+	}
+	return query
+}
+
+func (s *StockAlertStorage) FindAll(ctx context.Context, filter repos.StockAlertFilter, opts repos.ListOptions) ([]*models.StockAlert, error) {
+	list := listing{coll: s.collection, key: hexKey, sort: []sortKey{{key: "raised_at", desc: true}}}
+	return findPage[models.StockAlert](ctx, list, alertFilter(filter), opts)
+}
+
+func (s *StockAlertStorage) Count(ctx context.Context, filter repos.StockAlertFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, alertFilter(filter))
+}
+
+func (s *StockAlertStorage) Acknowledge(ctx context.Context, id string) (*models.StockAlert, error) {
+	return s.setStatus(ctx, id, []string{models.AlertOpen}, bson.M{
+		"status":          models.AlertAcknowledged,
+		"acknowledged_at": time.Now().UTC().Format(time.RFC3339),
+		"acknowledged_by": reqctx.Actor(ctx),
+	})
+}
+
+func (s *StockAlertStorage) Resolve(ctx context.Context, id string) (*models.StockAlert, error) {
+	return s.setStatus(ctx, id, []string{models.AlertOpen, models.AlertAcknowledged}, bson.M{
+		"status":      models.AlertResolved,
+		"resolved_at": time.Now().UTC().Format(time.RFC3339),
+		"resolved_by": reqctx.Actor(ctx),
+	})
+}
+
+// setStatus applies set to the alert if its status is one of from.
+func (s *StockAlertStorage) setStatus(ctx context.Context, id string, from []string, set bson.M) (*models.StockAlert, error) {
+	filter := bson.M{"_id": id, "status": bson.M{"$in": from}}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+
+	var updated models.StockAlert
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.FindByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, repos.ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *StockAlertStorage) Restocked(ctx context.Context, productID string) (int64, error) {
+	res, err := s.collection.UpdateMany(ctx,
+		bson.M{"product_id": productID, "restocked": false, "status": bson.M{"$ne": models.AlertResolved}},
+		bson.M{
+			"$set": bson.M{
+				"status":      models.AlertResolved,
+				"resolved_at": time.Now().UTC().Format(time.RFC3339),
+				"resolved_by": reqctx.Actor(ctx),
+			},
+			"$inc": bson.M{"version": 1},
+		})
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.collection.UpdateMany(ctx,
+		bson.M{"product_id": productID, "restocked": false},
+		bson.M{"$set": bson.M{"restocked": true}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (s *StockAlertStorage) Pending(ctx context.Context) ([]string, error) {
+	values, err := s.collection.Distinct(ctx, "product_id", bson.M{"restocked": false})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// StockWatchingProducts tells watch about the products whose stock, or
+// reorder point, may have changed through the wrapped repository.
+type StockWatchingProducts struct {
+	repos.ProductRepository
+	watch func(productID string)
+}
+
+func NewStockWatchingProducts(inner repos.ProductRepository, watch func(productID string)) *StockWatchingProducts {
+	return &StockWatchingProducts{ProductRepository: inner, watch: watch}
+}
+
+func (s *StockWatchingProducts) Create(ctx context.Context, product *models.Product) (*models.Product, error) {
+	created, err := s.ProductRepository.Create(ctx, product)
+	if err == nil {
+		afterCommit(ctx, func() { s.watch(created.ID) })
+	}
+	return created, err
+}
+
+func (s *StockWatchingProducts) Update(ctx context.Context, id string, product *models.Product) (*models.Product, error) {
+	updated, err := s.ProductRepository.Update(ctx, id, product)
+	if err == nil {
+		afterCommit(ctx, func() { s.watch(id) })
+	}
+	return updated, err
+}
+
+func (s *StockWatchingProducts) Delete(ctx context.Context, id string, version int64) error {
+	err := s.ProductRepository.Delete(ctx, id, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.watch(id) })
+	}
+	return err
+}
+
+func (s *StockWatchingProducts) Restore(ctx context.Context, id string) (*models.Product, error) {
+	restored, err := s.ProductRepository.Restore(ctx, id)
+	if err == nil {
+		afterCommit(ctx, func() { s.watch(id) })
+	}
+	return restored, err
+}
+
+func (s *StockWatchingProducts) AdjustStock(ctx context.Context, movement *models.StockMovement) error {
+	err := s.ProductRepository.AdjustStock(ctx, movement)
+	if err == nil {
+		afterCommit(ctx, func() { s.watch(movement.ProductID) })
+	}
+	return err
+}
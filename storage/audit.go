@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var auditQuerySchema = querySchema{
+	"entity_type": {key: "entity_type", kind: textField},
+	"entity_id":   {key: "entity_id", kind: textField},
+	"actor":       {key: "actor", kind: textField, sortable: true},
+	"operation":   {key: "operation", kind: textField},
+	"request_id":  {key: "request_id", kind: textField},
+	"field":       {key: "changes.field", kind: textField},
+	"timestamp":   {key: "timestamp", kind: dateField, sortable: true},
+}
+
+type AuditStorage struct {
+	collection *mongo.Collection
+}
+
+func NewAuditStorage(coll *mongo.Collection) *AuditStorage {
+	return &AuditStorage{
+		collection: coll,
+	}
+}
+
+func (a *AuditStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := a.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entity_type", Value: 1}, {Key: "entity_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}}},
+		{Keys: bson.D{{Key: "request_id", Value: 1}}},
+	})
+	return err
+}
+
+func (a *AuditStorage) Record(ctx context.Context, entry *models.AuditEntry) error {
+	entry.ID = primitive.NewObjectID().Hex()
+	if entry.Timestamp == "" {
+		entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	if entry.Changes == nil {
+		entry.Changes = []models.FieldChange{}
+	}
+	_, err := a.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (a *AuditStorage) FindAll(ctx context.Context, filter repos.AuditFilter, opts repos.ListOptions) ([]*models.AuditEntry, error) {
+	clauses, err := auditQuerySchema.filter(filter.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	sort, err := auditQuerySchema.sort(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+	if len(sort) == 0 {
+		sort = []sortKey{{key: "timestamp", desc: true}}
+	}
+
+	list := listing{coll: a.collection, key: hexKey, sort: sort}
+	return findPage[models.AuditEntry](ctx, list, and(clauses), opts)
+}
+
+func (a *AuditStorage) Count(ctx context.Context, filter repos.AuditFilter) (int64, error) {
+	clauses, err := auditQuerySchema.filter(filter.Conditions)
+	if err != nil {
+		return 0, err
+	}
+	return a.collection.CountDocuments(ctx, and(clauses))
+}
+
+// auditor records the changes made through a repository decorator. A change
+// that fails to be recorded is logged rather than failed, as the change
+// itself has already been made.
+type auditor struct {
+	audit      repos.AuditRepository
+	entityType string
+	logger     *zap.Logger
+}
+
+func (a auditor) record(ctx context.Context, operation, entityID string, before, after interface{}) {
+	changes, err := diff(before, after)
+	if err == nil {
+		err = a.audit.Record(context.WithoutCancel(ctx), &models.AuditEntry{
+			Actor:      reqctx.Actor(ctx),
+			RequestID:  reqctx.RequestID(ctx),
+			EntityType: a.entityType,
+			EntityID:   entityID,
+			Operation:  operation,
+			Changes:    changes,
+		})
+	}
+	if err != nil {
+		a.logger.Error("Failed to record audit entry",
+			zap.String("entity_type", a.entityType),
+			zap.String("entity_id", entityID),
+			zap.String("operation", operation),
+			zap.Error(err))
+	}
+}
+
+// unaudited fields change on every write and say nothing about it.
+var unaudited = map[string]bool{"version": true, "updated_at": true}
+
+// diff compares the JSON forms of before and after field by field; either
+// may be nil.
+func diff(before, after interface{}) ([]models.FieldChange, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for f := range b {
+		fields[f] = true
+	}
+	for f := range a {
+		fields[f] = true
+	}
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		if !unaudited[f] && !reflect.DeepEqual(b[f], a[f]) {
+			names = append(names, f)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]models.FieldChange, 0, len(names))
+	for _, f := range names {
+		changes = append(changes, models.FieldChange{Field: f, Before: b[f], After: a[f]})
+	}
+	return changes, nil
+}
+
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// AuditedProducts records every change made through the wrapped repository
+// in an audit log.
+type AuditedProducts struct {
+	repos.ProductRepository
+	auditor
+}
+
+func NewAuditedProducts(inner repos.ProductRepository, audit repos.AuditRepository, logger *zap.Logger) *AuditedProducts {
+	return &AuditedProducts{
+		ProductRepository: inner,
+		auditor:           auditor{audit: audit, entityType: "product", logger: logger},
+	}
+}
+
+func (a *AuditedProducts) Create(ctx context.Context, product *models.Product) (*models.Product, error) {
+	created, err := a.ProductRepository.Create(ctx, product)
+	if err == nil {
+		a.record(ctx, models.AuditCreate, created.ID, nil, created)
+	}
+	return created, err
+}
+
+func (a *AuditedProducts) Update(ctx context.Context, id string, product *models.Product) (*models.Product, error) {
+	before, _ := a.ProductRepository.FindByID(ctx, id)
+	updated, err := a.ProductRepository.Update(ctx, id, product)
+	if err == nil {
+		a.record(ctx, models.AuditUpdate, id, before, updated)
+	}
+	return updated, err
+}
+
+func (a *AuditedProducts) Delete(ctx context.Context, id string, version int64) error {
+	before, _ := a.ProductRepository.FindByID(ctx, id)
+	err := a.ProductRepository.Delete(ctx, id, version)
+	if err == nil {
+		a.record(ctx, models.AuditDelete, id, before, nil)
+	}
+	return err
+}
+
+func (a *AuditedProducts) Restore(ctx context.Context, id string) (*models.Product, error) {
+	restored, err := a.ProductRepository.Restore(ctx, id)
+	if err == nil {
+		a.record(ctx, models.AuditRestore, id, nil, restored)
+	}
+	return restored, err
+}
+
+func (a *AuditedProducts) AdjustStock(ctx context.Context, movement *models.StockMovement) error {
+	before, _ := a.ProductRepository.FindByID(ctx, movement.ProductID)
+	err := a.ProductRepository.AdjustStock(ctx, movement)
+	if err == nil {
+		after, _ := a.ProductRepository.FindByID(ctx, movement.ProductID)
+		a.record(ctx, models.AuditAdjustStock, movement.ProductID, before, after)
+	}
+	return err
+}
+
+func (a *AuditedProducts) SetCategoryName(ctx context.Context, categoryID, name string) ([]*models.Product, error) {
+	renamed, err := a.ProductRepository.SetCategoryName(ctx, categoryID, name)
+	for _, before := range renamed {
+		after := *before
+		after.Category = name
+		after.Version++
+		a.record(ctx, models.AuditUpdate, before.ID, before, &after)
+	}
+	return renamed, err
+}
+
+func (a *AuditedProducts) Purge(ctx context.Context, cutoff time.Time) ([]*models.Product, error) {
+	purged, err := a.ProductRepository.Purge(ctx, cutoff)
+	for _, product := range purged {
+		a.record(ctx, models.AuditPurge, product.ID, nil, nil)
+	}
+	return purged, err
+}
+
+// AuditedOrders records every change made through the wrapped repository
+// in an audit log.
+type AuditedOrders struct {
+	repos.OrderRepository
+	auditor
+}
+
+func NewAuditedOrders(inner repos.OrderRepository, audit repos.AuditRepository, logger *zap.Logger) *AuditedOrders {
+	return &AuditedOrders{
+		OrderRepository: inner,
+		auditor:         auditor{audit: audit, entityType: "order", logger: logger},
+	}
+}
+
+func (a *AuditedOrders) Create(ctx context.Context, order *models.Order) (*models.Order, error) {
+	created, err := a.OrderRepository.Create(ctx, order)
+	if err == nil {
+		a.record(ctx, models.AuditCreate, created.ID, nil, created)
+	}
+	return created, err
+}
+
+func (a *AuditedOrders) Update(ctx context.Context, id string, order *models.Order) (*models.Order, error) {
+	before, _ := a.OrderRepository.FindByID(ctx, id)
+	updated, err := a.OrderRepository.Update(ctx, id, order)
+	if err == nil {
+		a.record(ctx, models.AuditUpdate, id, before, updated)
+	}
+	return updated, err
+}
+
+func (a *AuditedOrders) SetPaymentStatus(ctx context.Context, id, paymentStatus string, paid float64, from, to string) (*models.Order, error) {
+	before, _ := a.OrderRepository.FindByID(ctx, id)
+	updated, err := a.OrderRepository.SetPaymentStatus(ctx, id, paymentStatus, paid, from, to)
+	if err == nil {
+		a.record(ctx, models.AuditUpdate, id, before, updated)
+	}
+	return updated, err
+}
+
+func (a *AuditedOrders) SetReturns(ctx context.Context, order *models.Order) (*models.Order, error) {
+	before, _ := a.OrderRepository.FindByID(ctx, order.ID)
+	updated, err := a.OrderRepository.SetReturns(ctx, order)
+	if err == nil {
+		a.record(ctx, models.AuditUpdate, order.ID, before, updated)
+	}
+	return updated, err
+}
+
+func (a *AuditedOrders) Delete(ctx context.Context, id string, version int64) error {
+	before, _ := a.OrderRepository.FindByID(ctx, id)
+	err := a.OrderRepository.Delete(ctx, id, version)
+	if err == nil {
+		a.record(ctx, models.AuditDelete, id, before, nil)
+	}
+	return err
+}
+
+func (a *AuditedOrders) Restore(ctx context.Context, id string) (*models.Order, error) {
+	restored, err := a.OrderRepository.Restore(ctx, id)
+	if err == nil {
+		a.record(ctx, models.AuditRestore, id, nil, restored)
+	}
+	return restored, err
+}
+
+func (a *AuditedOrders) Purge(ctx context.Context, cutoff time.Time) ([]*models.Order, error) {
+	purged, err := a.OrderRepository.Purge(ctx, cutoff)
+	for _, order := range purged {
+		a.record(ctx, models.AuditPurge, order.ID, nil, nil)
+	}
+	return purged, err
+}
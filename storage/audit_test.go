@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.uber.org/zap"
+)
+
+// stubAudit collects the entries recorded; other methods are not used.
+type stubAudit struct {
+	repos.AuditRepository
+	entries []*models.AuditEntry
+}
+
+func (s *stubAudit) Record(_ context.Context, entry *models.AuditEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// renamingProducts renames the products it holds; other methods are not
+// used.
+type renamingProducts struct {
+	repos.ProductRepository
+	products []*models.Product
+}
+
+func (r renamingProducts) SetCategoryName(_ context.Context, categoryID, name string) ([]*models.Product, error) {
+	var renamed []*models.Product
+	for _, p := range r.products {
+		if p.CategoryID == categoryID && p.Category != name {
+			renamed = append(renamed, p)
+		}
+	}
+	return renamed, nil
+}
+
+func TestAuditedProductsSetCategoryName(t *testing.T) {
+	inner := renamingProducts{products: []*models.Product{
+		{ID: "a", CategoryID: "c1", Category: "Shirts", Version: 2},
+		{ID: "b", CategoryID: "c1", Category: "Tees", Version: 5},
+		{ID: "c", CategoryID: "c1", Category: "Tops", Version: 1},
+		{ID: "d", CategoryID: "c2", Category: "Shoes", Version: 1},
+	}}
+	audit := &stubAudit{}
+	products := NewAuditedProducts(inner, audit, zap.NewNop())
+
+	if _, err := products.SetCategoryName(context.Background(), "c1", "Tops"); err != nil {
+		t.Fatalf("SetCategoryName: %v", err)
+	}
+
+	type entry struct {
+		id      string
+		changes []models.FieldChange
+	}
+	want := []entry{
+		{"a", []models.FieldChange{{Field: "category", Before: "Shirts", After: "Tops"}}},
+		{"b", []models.FieldChange{{Field: "category", Before: "Tees", After: "Tops"}}},
+	}
+	var got []entry
+	for _, e := range audit.entries {
+		if e.EntityType != "product" || e.Operation != models.AuditUpdate {
+			t.Errorf("entry %+v", e)
+		}
+		got = append(got, entry{e.EntityID, e.Changes})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
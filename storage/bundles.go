@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bundleTotals derives the stock and price of a bundle from its components.
+// It fails with ErrInvalidReference when a component does not exist, is a
+// bundle itself, or does not name a variant of a product that has them.
+// Components in the trash count as missing.
+func (p *ProductStorage) bundleTotals(ctx context.Context, bundle *models.Product) (int, float64, error) {
+	ids := make([]primitive.ObjectID, 0, len(bundle.Bundle.Components))
+	for _, c := range bundle.Bundle.Components {
+		objID, err := primitive.ObjectIDFromHex(c.ProductID)
+		if err != nil || c.ProductID == bundle.ID {
+			return 0, 0, repos.ErrInvalidReference
+		}
+		ids = append(ids, objID)
+	}
+
+	components := map[string]*models.Product{}
+	filter := live()
+	filter["_id"] = bson.M{"$in": ids}
+	err := forEach(ctx, p.collection, filter, func(c *models.Product) error {
+		components[c.ID] = c
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return totalsOf(bundle, components)
+}
+
+// totalsOf computes the stock and price of a bundle from its components,
+// given by ID.
+func totalsOf(bundle *models.Product, components map[string]*models.Product) (int, float64, error) {
+	stock := math.MaxInt
+	sum := 0.0
+	for _, c := range bundle.Bundle.Components {
+		component, ok := components[c.ProductID]
+		if !ok || component.IsBundle() {
+			return 0, 0, repos.ErrInvalidReference
+		}
+		available := component.Stock
+		if c.VariantID != "" {
+			variant := component.Variant(c.VariantID)
+			if variant == nil {
+				return 0, 0, repos.ErrInvalidReference
+			}
+			available = variant.Stock
+		} else if len(component.Variants) > 0 {
+			return 0, 0, repos.ErrInvalidReference
+		}
+		stock = min(stock, available/c.Quantity)
+		sum += component.PriceOf(c.VariantID) * float64(c.Quantity)
+	}
+
+	price := bundle.Price
+	if bundle.Bundle.Pricing == models.BundlePricingDiscount {
+		price = math.Max(0, math.Round((sum-bundle.Bundle.Discount)*100)/100)
+	}
+	return stock, price, nil
+}
+
+// refreshBundles recomputes the stock and price of the bundles containing
+// componentID after the component changed. A bundle whose component is gone
+// or no longer fits is shown as out of stock.
+func (p *ProductStorage) refreshBundles(ctx context.Context, componentID string) error {
+	filter := bson.M{"bundle.components.product_id": componentID}
+	return forEach(ctx, p.collection, filter, func(bundle *models.Product) error {
+		stock, price, err := p.bundleTotals(ctx, bundle)
+		if errors.Is(err, repos.ErrInvalidReference) {
+			stock, price, err = 0, bundle.Price, nil
+		}
+		if err != nil {
+			return err
+		}
+		if stock == bundle.Stock && price == bundle.Price {
+			return nil
+		}
+
+		objID, err := primitive.ObjectIDFromHex(bundle.ID)
+		if err != nil {
+			return err
+		}
+		_, err = p.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+			"$set": bson.M{"stock": stock, "price": price},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil || price == bundle.Price {
+			return err
+		}
+		bundle.Price = price
+		return p.recordPrices(ctx, bundle)
+	})
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+)
+
+func TestTotalsOf(t *testing.T) {
+	price := func(p float64) *float64 { return &p }
+	components := map[string]*models.Product{
+		"mug":    {ID: "mug", Price: 4.99, Stock: 10},
+		"tea":    {ID: "tea", Price: 3.5, Stock: 7},
+		"empty":  {ID: "empty", Price: 2, Stock: 0},
+		"bundle": {ID: "bundle", Type: models.ProductTypeBundle, Price: 9, Stock: 5},
+		"shirt": {ID: "shirt", Price: 20, Stock: 9, Variants: []models.Variant{
+			{ID: "s", Stock: 4},
+			{ID: "l", Stock: 5, Price: price(22)},
+		}},
+	}
+	bundle := func(pricing string, discount float64, parts ...models.BundleComponent) *models.Product {
+		return &models.Product{
+			Type:   models.ProductTypeBundle,
+			Price:  15,
+			Bundle: &models.Bundle{Components: parts, Pricing: pricing, Discount: discount},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		bundle    *models.Product
+		wantStock int
+		wantPrice float64
+		wantErr   error
+	}{
+		{
+			name:      "fixed price",
+			bundle:    bundle(models.BundlePricingFixed, 0, models.BundleComponent{ProductID: "mug", Quantity: 1}),
+			wantStock: 10,
+			wantPrice: 15,
+		},
+		{
+			name: "scarcest component limits stock",
+			bundle: bundle(models.BundlePricingFixed, 0,
+				models.BundleComponent{ProductID: "mug", Quantity: 2},
+				models.BundleComponent{ProductID: "tea", Quantity: 3},
+			),
+			// 10/2 mugs, 7/3 teas.
+			wantStock: 2,
+			wantPrice: 15,
+		},
+		{
+			name: "component out of stock",
+			bundle: bundle(models.BundlePricingFixed, 0,
+				models.BundleComponent{ProductID: "mug", Quantity: 1},
+				models.BundleComponent{ProductID: "empty", Quantity: 1},
+			),
+			wantStock: 0,
+			wantPrice: 15,
+		},
+		{
+			name: "discount on the sum",
+			bundle: bundle(models.BundlePricingDiscount, 1.5,
+				models.BundleComponent{ProductID: "mug", Quantity: 2},
+				models.BundleComponent{ProductID: "tea", Quantity: 1},
+			),
+			// 2*4.99 + 3.5 - 1.5, rounded to cents.
+			wantStock: 5,
+			wantPrice: 11.98,
+		},
+		{
+			name:      "discount larger than the sum",
+			bundle:    bundle(models.BundlePricingDiscount, 10, models.BundleComponent{ProductID: "tea", Quantity: 1}),
+			wantStock: 7,
+			wantPrice: 0,
+		},
+		{
+			name: "variant stock and price",
+			bundle: bundle(models.BundlePricingDiscount, 0,
+				models.BundleComponent{ProductID: "shirt", VariantID: "l", Quantity: 2},
+				models.BundleComponent{ProductID: "shirt", VariantID: "s", Quantity: 1},
+			),
+			wantStock: 2,
+			wantPrice: 64,
+		},
+		{
+			name:    "missing component",
+			bundle:  bundle(models.BundlePricingFixed, 0, models.BundleComponent{ProductID: "gone", Quantity: 1}),
+			wantErr: repos.ErrInvalidReference,
+		},
+		{
+			name:    "nested bundle",
+			bundle:  bundle(models.BundlePricingFixed, 0, models.BundleComponent{ProductID: "bundle", Quantity: 1}),
+			wantErr: repos.ErrInvalidReference,
+		},
+		{
+			name:    "unknown variant",
+			bundle:  bundle(models.BundlePricingFixed, 0, models.BundleComponent{ProductID: "shirt", VariantID: "xl", Quantity: 1}),
+			wantErr: repos.ErrInvalidReference,
+		},
+		{
+			name:    "variant not named",
+			bundle:  bundle(models.BundlePricingFixed, 0, models.BundleComponent{ProductID: "shirt", Quantity: 1}),
+			wantErr: repos.ErrInvalidReference,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stock, price, err := totalsOf(tt.bundle, components)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("totalsOf: %v", err)
+			}
+			if stock != tt.wantStock || price != tt.wantPrice {
+				t.Errorf("got stock %d, price %v; want stock %d, price %v", stock, price, tt.wantStock, tt.wantPrice)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CartStorage struct {
+	collection *mongo.Collection
+}
+
+func NewCartStorage(coll *mongo.Collection) *CartStorage {
+	return &CartStorage{
+		collection: coll,
+	}
+}
+
+func (s *CartStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// The database removes carts once expires_at has passed.
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+	})
+	return err
+}
+
+func (s *CartStorage) Create(ctx context.Context, cart *models.Cart) (*models.Cart, error) {
+	cart.ID = primitive.NewObjectID().Hex()
+	cart.Status = models.CartOpen
+	cart.Version = 1
+	cart.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	cart.UpdatedAt = cart.CreatedAt
+	if cart.Lines == nil {
+		cart.Lines = []models.CartLine{}
+	}
+	if cart.CouponCodes == nil {
+		cart.CouponCodes = []string{}
+	}
+
+	if _, err := s.collection.InsertOne(ctx, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// unexpired selects the cart id unless it has expired; the database only
+// removes expired carts periodically.
+func unexpired(id string) bson.M {
+	return bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now()}}
+}
+
+func (s *CartStorage) FindByID(ctx context.Context, id string) (*models.Cart, error) {
+	if !primitive.IsValidObjectID(id) {
+		return nil, repos.ErrNotFound
+	}
+	var cart models.Cart
+	if err := s.collection.FindOne(ctx, unexpired(id)).Decode(&cart); err != nil {
+		return nil, notFound(err)
+	}
+	return &cart, nil
+}
+
+func (s *CartStorage) Update(ctx context.Context, cart *models.Cart) (*models.Cart, error) {
+	filter := unexpired(cart.ID)
+	filter["status"] = models.CartOpen
+	filter["version"] = cart.Version
+	update := bson.M{
+		"$set": bson.M{
+			"customer_id":  cart.CustomerID,
+			"lines":        cart.Lines,
+			"subtotal":     cart.Subtotal,
+			"coupon_codes": cart.CouponCodes,
+			"expires_at":   cart.ExpiresAt,
+			"updated_at":   time.Now().UTC().Format(time.RFC3339),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	return s.findAndUpdate(ctx, cart.ID, filter, update)
+}
+
+func (s *CartStorage) SetStatus(ctx context.Context, id string, version int64, status, orderID string) (*models.Cart, error) {
+	filter := unexpired(id)
+	filter["version"] = version
+	update := bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"order_id":   orderID,
+			"updated_at": time.Now().UTC().Format(time.RFC3339),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	return s.findAndUpdate(ctx, id, filter, update)
+}
+
+// findAndUpdate applies a conditional update to the cart id and tells a
+// missing cart from one that did not match filter.
+func (s *CartStorage) findAndUpdate(ctx context.Context, id string, filter, update bson.M) (*models.Cart, error) {
+	var updated models.Cart
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.FindByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, repos.ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *CartStorage) Delete(ctx context.Context, id string) error {
+	if !primitive.IsValidObjectID(id) {
+		return repos.ErrNotFound
+	}
+	res, err := s.collection.DeleteOne(ctx, unexpired(id))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return repos.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CategoryStorage struct {
+	collection *mongo.Collection
+}
+
+func NewCategoryStorage(coll *mongo.Collection) *CategoryStorage {
+	return &CategoryStorage{
+		collection: coll,
+	}
+}
+
+func (s *CategoryStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "aliases", Value: 1}}},
+		{Keys: bson.D{{Key: "path", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+	})
+	return err
+}
+
+func (s *CategoryStorage) Create(ctx context.Context, category *models.Category) (*models.Category, error) {
+	objID := primitive.NewObjectID()
+	parentPath, err := s.parentPath(ctx, category.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	category.ID = objID.Hex()
+	category.Path = parentPath + category.ID + "/"
+	category.Version = 1
+	category.CreatedAt = timestamp(time.Now())
+	category.UpdatedAt = category.CreatedAt
+
+	_, err = s.collection.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "name", Value: category.Name},
+		{Key: "slug", Value: category.Slug},
+		{Key: "parent_id", Value: category.ParentID},
+		{Key: "path", Value: category.Path},
+		{Key: "attributes", Value: category.Attributes},
+		{Key: "version", Value: category.Version},
+		{Key: "created_at", Value: category.CreatedAt},
+		{Key: "updated_at", Value: category.UpdatedAt},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// parentPath returns the path new children of parentID start with.
+func (s *CategoryStorage) parentPath(ctx context.Context, parentID string) (string, error) {
+	if parentID == "" {
+		return "/", nil
+	}
+	if !primitive.IsValidObjectID(parentID) {
+		return "", repos.ErrInvalidReference
+	}
+	parent, err := s.FindByID(ctx, parentID)
+	if errors.Is(err, repos.ErrNotFound) {
+		return "", repos.ErrInvalidReference
+	}
+	if err != nil {
+		return "", err
+	}
+	return parent.Path, nil
+}
+
+func (s *CategoryStorage) FindByID(ctx context.Context, id string) (*models.Category, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.findOne(ctx, bson.M{"_id": objID})
+}
+
+func (s *CategoryStorage) FindBySlug(ctx context.Context, slug string) (*models.Category, error) {
+	category, err := s.findOne(ctx, bson.M{"slug": slug})
+	if errors.Is(err, repos.ErrNotFound) {
+		return s.findOne(ctx, bson.M{"aliases": slug})
+	}
+	return category, err
+}
+
+func (s *CategoryStorage) findOne(ctx context.Context, filter bson.M) (*models.Category, error) {
+	var category models.Category
+	if err := s.collection.FindOne(ctx, filter).Decode(&category); err != nil {
+		return nil, notFound(err)
+	}
+	return &category, nil
+}
+
+func (s *CategoryStorage) FindAll(ctx context.Context, parentID string) ([]*models.Category, error) {
+	filter := bson.M{}
+	if parentID != "" {
+		filter["parent_id"] = parentID
+	}
+	return s.find(ctx, filter)
+}
+
+func (s *CategoryStorage) Ancestors(ctx context.Context, id string) ([]*models.Category, error) {
+	category, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var ids []primitive.ObjectID
+	for _, part := range strings.Split(strings.Trim(category.Path, "/"), "/") {
+		objID, err := primitive.ObjectIDFromHex(part)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, objID)
+	}
+	// Ancestors' paths are prefixes of each other, so path order is root first.
+	return s.find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+}
+
+func (s *CategoryStorage) Descendants(ctx context.Context, id string) ([]*models.Category, error) {
+	category, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// An anchored prefix on the materialised path is served by the path index.
+	return s.find(ctx, bson.M{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(category.Path)}})
+}
+
+func (s *CategoryStorage) find(ctx context.Context, filter bson.M) ([]*models.Category, error) {
+	categories := []*models.Category{}
+	err := forEach(ctx, s.collection, filter, func(c *models.Category) error {
+		categories = append(categories, c)
+		return nil
+	}, options.Find().SetSort(bson.D{{Key: "path", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (s *CategoryStorage) Update(ctx context.Context, id string, category *models.Category) (*models.Category, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	newPath := current.Path
+	if category.ParentID != current.ParentID {
+		parentPath, err := s.parentPath(ctx, category.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		// A category cannot become its own descendant.
+		if len(parentPath) >= len(current.Path) && parentPath[:len(current.Path)] == current.Path {
+			return nil, repos.ErrInvalidReference
+		}
+		newPath = parentPath + id + "/"
+	}
+
+	// The new path was derived from the version just read, so the write is
+	// always conditioned on a version, even when the caller did not ask.
+	expected := category.Version
+	if expected == 0 {
+		expected = current.Version
+	}
+	filter := bson.M{"_id": objID, "version": expected}
+	update := bson.M{
+		"$set": bson.M{
+			"name":       category.Name,
+			"slug":       category.Slug,
+			"parent_id":  category.ParentID,
+			"path":       newPath,
+			"attributes": category.Attributes,
+			"updated_at": timestamp(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	var updated models.Category
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if newPath != current.Path {
+		if err := s.rewritePaths(ctx, current.Path, newPath); err != nil {
+			return nil, err
+		}
+	}
+	return &updated, nil
+}
+
+// rewritePaths moves every strict descendant of oldPath under newPath.
+func (s *CategoryStorage) rewritePaths(ctx context.Context, oldPath, newPath string) error {
+	filter := bson.M{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(oldPath) + "."}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"path": bson.M{"$concat": bson.A{
+				newPath,
+				bson.M{"$substrCP": bson.A{"$path", len(oldPath), bson.M{"$strLenCP": "$path"}}},
+			}},
+			"version": bson.M{"$add": bson.A{"$version", 1}},
+		}}},
+	}
+	_, err := s.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (s *CategoryStorage) Delete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	children, err := s.collection.CountDocuments(ctx, bson.M{"parent_id": id})
+	if err != nil {
+		return err
+	}
+	if children > 0 {
+		return repos.ErrInUse
+	}
+
+	filter := bson.M{"_id": objID}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return missOrConflict(ctx, s.collection, objID)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/udevs/lesson3/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Problems of a DanglingReference.
+const (
+	ProblemMissing        = "missing"
+	ProblemDeleted        = "in the trash"
+	ProblemUnknownVariant = "unknown variant"
+)
+
+// DanglingReference is a reference from one document to another that does
+// not exist, or no longer may be referenced.
+type DanglingReference struct {
+	Collection string // of the referencing document
+	ID         string
+	Field      string
+	Target     string
+	Problem    string
+}
+
+func (r DanglingReference) String() string {
+	return fmt.Sprintf("%s %s: %s -> %s: %s", r.Collection, r.ID, r.Field, r.Target, r.Problem)
+}
+
+type productRef struct {
+	deleted  bool
+	variants map[string]bool
+}
+
+// FindDanglingReferences scans the products and orders outside the trash
+// for references to products, variants and categories that do not exist.
+// Finished orders may keep referencing products in the trash; open orders
+// and bundles may not.
+func FindDanglingReferences(ctx context.Context, products *ProductStorage, orders *OrdersStorage, categories *CategoryStorage) ([]DanglingReference, error) {
+	catalog := map[string]productRef{}
+	err := forEach(ctx, products.collection, bson.M{}, func(p *models.Product) error {
+		ref := productRef{deleted: p.DeletedAt != "", variants: map[string]bool{}}
+		for _, v := range p.Variants {
+			ref.variants[v.ID] = true
+		}
+		catalog[p.ID] = ref
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	categoryIDs := map[string]bool{}
+	err = forEach(ctx, categories.collection, bson.M{}, func(c *models.Category) error {
+		categoryIDs[c.ID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var dangling []DanglingReference
+	check := func(collection, id, field, productID, variantID string, allowDeleted bool) {
+		ref, ok := catalog[productID]
+		problem := ""
+		switch {
+		case !ok:
+			problem = ProblemMissing
+		case ref.deleted && !allowDeleted:
+			problem = ProblemDeleted
+		case variantID != "" && !ref.variants[variantID]:
+			problem, productID = ProblemUnknownVariant, productID+"/"+variantID
+		}
+		if problem != "" {
+			dangling = append(dangling, DanglingReference{collection, id, field, productID, problem})
+		}
+	}
+
+	err = forEach(ctx, products.collection, live(), func(p *models.Product) error {
+		if p.CategoryID != "" && !categoryIDs[p.CategoryID] {
+			dangling = append(dangling, DanglingReference{"products", p.ID, "category_id", p.CategoryID, ProblemMissing})
+		}
+		if p.Bundle == nil {
+			return nil
+		}
+		for i, c := range p.Bundle.Components {
+			check("products", p.ID, fmt.Sprintf("bundle.components[%d]", i), c.ProductID, c.VariantID, false)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEach(ctx, orders.collection, live(), func(o *models.Order) error {
+		finished := slices.Contains(models.TerminalOrderStatuses, o.Status)
+		for i, line := range o.Products {
+			field := fmt.Sprintf("products[%d]", i)
+			check("orders", o.ID, field, line.ProductID, line.VariantID, finished)
+			for j, c := range line.Components {
+				check("orders", o.ID, fmt.Sprintf("%s.components[%d]", field, j), c.ProductID, c.VariantID, finished)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dangling, nil
+}
+
+// LeakedStock is stock taken for an order that was never stored. Without
+// transactions, a crash between taking the stock of an order and storing
+// it leaves the stock taken.
+type LeakedStock struct {
+	OrderID     string
+	ProductID   string
+	VariantID   string
+	WarehouseID string
+	Quantity    int // taken, so negative
+}
+
+func (l LeakedStock) String() string {
+	target := l.ProductID
+	if l.VariantID != "" {
+		target += "/" + l.VariantID
+	}
+	return fmt.Sprintf("order %s: %d of %s at %s taken but never stored", l.OrderID, -l.Quantity, target, l.WarehouseID)
+}
+
+// FindLeakedStock scans the stock ledger for sales that were not put back
+// and whose order was never stored. Orders purged from the trash are told
+// apart from those by their audit trail.
+func FindLeakedStock(ctx context.Context, ledger *StockLedgerStorage, orders *OrdersStorage, audit *AuditStorage) ([]LeakedStock, error) {
+	cursor, err := ledger.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"order_id": bson.M{"$nin": bson.A{nil, ""}},
+			"type":     bson.M{"$in": bson.A{models.MovementSale, models.MovementAdjustment}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"order_id":     "$order_id",
+				"product_id":   "$product_id",
+				"variant_id":   bson.M{"$ifNull": bson.A{"$variant_id", ""}},
+				"warehouse_id": "$warehouse_id",
+			},
+			"quantity": bson.M{"$sum": "$quantity"},
+		}}},
+		{{Key: "$match", Value: bson.M{"quantity": bson.M{"$lt": 0}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.order_id", Value: 1}, {Key: "_id.product_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var taken []LeakedStock
+	for cursor.Next(ctx) {
+		var row struct {
+			Key struct {
+				OrderID     string `bson:"order_id"`
+				ProductID   string `bson:"product_id"`
+				VariantID   string `bson:"variant_id"`
+				WarehouseID string `bson:"warehouse_id"`
+			} `bson:"_id"`
+			Quantity int `bson:"quantity"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		taken = append(taken, LeakedStock{row.Key.OrderID, row.Key.ProductID, row.Key.VariantID, row.Key.WarehouseID, row.Quantity})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := bson.A{}
+	for _, l := range taken {
+		ids = append(ids, l.OrderID)
+	}
+	stored := map[string]bool{}
+	// The trash holds stored orders too.
+	err = forEach(ctx, orders.collection, bson.M{"_id": bson.M{"$in": ids}}, func(o *models.Order) error {
+		stored[o.ID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = forEach(ctx, audit.collection, bson.M{"entity_type": "order", "entity_id": bson.M{"$in": ids}}, func(e *models.AuditEntry) error {
+		stored[e.EntityID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var leaked []LeakedStock
+	for _, l := range taken {
+		if !stored[l.OrderID] {
+			leaked = append(leaked, l)
+		}
+	}
+	return leaked, nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CustomerStorage struct {
+	collection *mongo.Collection
+}
+
+func NewCustomerStorage(coll *mongo.Collection) *CustomerStorage {
+	return &CustomerStorage{
+		collection: coll,
+	}
+}
+
+func (s *CustomerStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		// Customers without an email address have no email field, so the
+		// partial index leaves them out.
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+		},
+	})
+	return err
+}
+
+// addressIDs gives the addresses that have no ID one.
+func addressIDs(customer *models.Customer) {
+	for i := range customer.Addresses {
+		if customer.Addresses[i].ID == "" {
+			customer.Addresses[i].ID = primitive.NewObjectID().Hex()
+		}
+	}
+	if customer.Addresses == nil {
+		customer.Addresses = []models.Address{}
+	}
+}
+
+func (s *CustomerStorage) Create(ctx context.Context, customer *models.Customer) (*models.Customer, error) {
+	objID := primitive.NewObjectID()
+	customer.ID = objID.Hex()
+	customer.Version = 1
+	customer.CreatedAt = timestamp(time.Now())
+	customer.UpdatedAt = customer.CreatedAt
+	addressIDs(customer)
+
+	doc := bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "name", Value: customer.Name},
+		{Key: "phone", Value: customer.Phone},
+		{Key: "addresses", Value: customer.Addresses},
+		{Key: "note", Value: customer.Note},
+		{Key: "version", Value: customer.Version},
+		{Key: "created_at", Value: customer.CreatedAt},
+		{Key: "updated_at", Value: customer.UpdatedAt},
+	}
+	if customer.Email != "" {
+		doc = append(doc, bson.E{Key: "email", Value: customer.Email})
+	}
+	_, err := s.collection.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+func (s *CustomerStorage) FindByID(ctx context.Context, id string) (*models.Customer, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var customer models.Customer
+	if err := s.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&customer); err != nil {
+		return nil, notFound(err)
+	}
+	return &customer, nil
+}
+
+func customerFilter(filter repos.CustomerFilter) bson.M {
+	query := bson.M{}
+	if filter.Email != "" {
+		query["email"] = filter.Email
+	}
+	return query
+}
+
+func (s *CustomerStorage) FindAll(ctx context.Context, filter repos.CustomerFilter, opts repos.ListOptions) ([]*models.Customer, error) {
+	list := listing{coll: s.collection, key: objectIDKey, sort: []sortKey{{key: "name"}}}
+	return findPage[models.Customer](ctx, list, customerFilter(filter), opts)
+}
+
+func (s *CustomerStorage) Count(ctx context.Context, filter repos.CustomerFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, customerFilter(filter))
+}
+
+func (s *CustomerStorage) Update(ctx context.Context, id string, customer *models.Customer) (*models.Customer, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	addressIDs(customer)
+
+	filter := bson.M{"_id": objID}
+	if customer.Version > 0 {
+		filter["version"] = customer.Version
+	}
+	set := bson.M{
+		"name":       customer.Name,
+		"phone":      customer.Phone,
+		"addresses":  customer.Addresses,
+		"note":       customer.Note,
+		"updated_at": timestamp(time.Now()),
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if customer.Email != "" {
+		set["email"] = customer.Email
+	} else {
+		update["$unset"] = bson.M{"email": ""}
+	}
+
+	var updated models.Customer
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *CustomerStorage) Delete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objID}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return missOrConflict(ctx, s.collection, objID)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// notFound translates the driver's "no documents" error into repos.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return repos.ErrNotFound
+	}
+	return err
+}
+
+// missOrConflict explains why a version-conditioned write matched nothing:
+// either the document is gone, or in the trash, or somebody else has already
+// bumped its version.
+func missOrConflict(ctx context.Context, coll *mongo.Collection, id interface{}) error {
+	n, err := coll.CountDocuments(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repos.ErrNotFound
+	}
+	return repos.ErrVersionConflict
+}
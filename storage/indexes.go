@@ -0,0 +1,19 @@
+package storage
+
+import "context"
+
+// Indexer is implemented by storages that need indexes on their collection.
+type Indexer interface {
+	EnsureIndexes(ctx context.Context) error
+}
+
+// EnsureIndexes creates the indexes of every storage. Creating an index that
+// already exists is a no-op, so this is safe to run on every start.
+func EnsureIndexes(ctx context.Context, storages ...Indexer) error {
+	for _, s := range storages {
+		if err := s.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
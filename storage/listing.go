@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type sortKey struct {
+	key  string
+	desc bool
+}
+
+// listing pages through a collection ordered by a list of sort keys, with
+// _id appended as the final tie-breaker so the order is always total.
+type listing struct {
+	coll *mongo.Collection
+	// key converts a cursor ID into the _id value stored in the collection.
+	key  func(string) (interface{}, error)
+	sort []sortKey
+}
+
+// page narrows filter to the page requested by opts and returns the matching
+// find options.
+//
+// In keyset mode the cursor carries the sort-key values of the boundary
+// document along with its _id, so the page is found even when the boundary
+// has since been deleted or changed.
+func (l listing) page(filter bson.M, opts repos.ListOptions) (bson.M, *options.FindOptions, error) {
+	keys := append(slices.Clone(l.sort), sortKey{key: "_id"})
+	backward := opts.Keyset() && opts.Cursor != nil && opts.Cursor.Backward
+
+	order := bson.D{}
+	for _, k := range keys {
+		asc := k.desc == backward
+		order = append(order, bson.E{Key: k.key, Value: direction(asc)})
+	}
+
+	findOptions := options.Find().SetSort(order).SetLimit(int64(opts.Limit))
+	if !opts.Keyset() {
+		findOptions.SetSkip(int64((opts.Page - 1) * opts.Limit))
+		return filter, findOptions, nil
+	}
+	if opts.Cursor == nil {
+		return filter, findOptions, nil
+	}
+
+	id, err := l.key(opts.Cursor.ID)
+	if err != nil || len(opts.Cursor.Values) != len(l.sort) || opts.Cursor.Sort != opts.SortSpec() {
+		return nil, nil, pagination.ErrInvalidCursor
+	}
+	boundary := append(slices.Clone(opts.Cursor.Values), id)
+
+	// (k0 > v0) OR (k0 = v0 AND k1 > v1) OR ... with the comparison flipped
+	// for descending keys and for backward pages. Null, which missing keys
+	// sort as, comes before any value but compares with none of them, so
+	// it is spelled out for the sort keys; _id is never null.
+	var after []bson.M
+	for i, k := range keys {
+		clause := bson.M{}
+		for j, prev := range keys[:i] {
+			clause[prev.key] = boundary[j]
+		}
+		op := "$gt"
+		if k.desc != backward {
+			op = "$lt"
+		}
+		switch {
+		case boundary[i] == nil && op == "$lt":
+			// Nothing sorts before null; the ties are left to later keys.
+			continue
+		case boundary[i] == nil:
+			clause[k.key] = bson.M{"$ne": nil}
+		case op == "$lt" && i < len(l.sort):
+			clause["$or"] = bson.A{bson.M{k.key: bson.M{"$lt": boundary[i]}}, bson.M{k.key: nil}}
+		default:
+			clause[k.key] = bson.M{op: boundary[i]}
+		}
+		after = append(after, clause)
+	}
+
+	return bson.M{"$and": []bson.M{filter, {"$or": after}}}, findOptions, nil
+}
+
+// record notes the sort-key values of doc in opts.Positions.
+func (l listing) record(doc bson.Raw, opts repos.ListOptions) error {
+	if opts.Positions == nil || !opts.Keyset() {
+		return nil
+	}
+	var id string
+	switch v := doc.Lookup("_id"); v.Type {
+	case bson.TypeObjectID:
+		id = v.ObjectID().Hex()
+	case bson.TypeString:
+		id = v.StringValue()
+	default:
+		return nil
+	}
+
+	values := make([]interface{}, len(l.sort))
+	for i, k := range l.sort {
+		// Documents lacking a key sort as null.
+		v := doc.Lookup(strings.Split(k.key, ".")...)
+		if v.Type == 0 {
+			continue
+		}
+		if err := v.Unmarshal(&values[i]); err != nil {
+			return err
+		}
+	}
+	opts.Positions[id] = values
+	return nil
+}
+
+// findPage fetches the page of the listing that opts asks for, decoding
+// the documents into Ts and recording their positions.
+func findPage[T any](ctx context.Context, l listing, filter bson.M, opts repos.ListOptions) ([]*T, error) {
+	query, findOptions, err := l.page(filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*T
+	err = forEach(ctx, l.coll, query, func(doc *bson.Raw) error {
+		var item T
+		if err := bson.Unmarshal(*doc, &item); err != nil {
+			return err
+		}
+		items = append(items, &item)
+		return l.record(*doc, opts)
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	return inPageOrder(items, opts), nil
+}
+
+func direction(asc bool) int {
+	if asc {
+		return 1
+	}
+	return -1
+}
+
+// inPageOrder restores the requested order for a page that was fetched backwards.
+func inPageOrder[T any](items []T, opts repos.ListOptions) []T {
+	if opts.Keyset() && opts.Cursor != nil && opts.Cursor.Backward {
+		slices.Reverse(items)
+	}
+	return items
+}
+
+func objectIDKey(id string) (interface{}, error) {
+	return primitive.ObjectIDFromHex(id)
+}
+
+func hexKey(id string) (interface{}, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// forEach decodes every document of coll matching filter into a T and hands
+// it to fn.
+func forEach[T any](ctx context.Context, coll *mongo.Collection, filter interface{}, fn func(*T) error, opts ...*options.FindOptions) error {
+	cursor, err := coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(&doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
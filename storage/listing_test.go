@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/udevs/lesson3/pkg/pagination"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestListingPage(t *testing.T) {
+	const id = "64b7f0c2e4b0a1a2b3c4d5e6"
+	byName := listing{key: hexKey, sort: []sortKey{{key: "name"}}}
+	byNameDesc := listing{key: hexKey, sort: []sortKey{{key: "name", desc: true}}}
+	asc := []query.Sort{{Field: "name"}}
+	desc := []query.Sort{{Field: "name", Desc: true}}
+	cursor := func(value interface{}, backward bool, sort string) *pagination.Cursor {
+		return &pagination.Cursor{ID: id, Values: []interface{}{value}, Backward: backward, Sort: sort}
+	}
+	after := func(clauses ...bson.M) bson.M {
+		return bson.M{"$and": []bson.M{{}, {"$or": clauses}}}
+	}
+
+	tests := []struct {
+		name    string
+		list    listing
+		opts    repos.ListOptions
+		want    bson.M
+		wantErr bool
+	}{
+		{
+			name: "after a value",
+			list: byName,
+			opts: repos.ListOptions{Sort: asc, Cursor: cursor("b", false, "name")},
+			want: after(
+				bson.M{"name": bson.M{"$gt": "b"}},
+				bson.M{"name": "b", "_id": bson.M{"$gt": id}},
+			),
+		},
+		{
+			name: "after null",
+			list: byName,
+			opts: repos.ListOptions{Sort: asc, Cursor: cursor(nil, false, "name")},
+			want: after(
+				bson.M{"name": bson.M{"$ne": nil}},
+				bson.M{"name": nil, "_id": bson.M{"$gt": id}},
+			),
+		},
+		{
+			name: "descending after a value takes in nulls",
+			list: byNameDesc,
+			opts: repos.ListOptions{Sort: desc, Cursor: cursor("b", false, "-name")},
+			want: after(
+				bson.M{"$or": bson.A{bson.M{"name": bson.M{"$lt": "b"}}, bson.M{"name": nil}}},
+				bson.M{"name": "b", "_id": bson.M{"$gt": id}},
+			),
+		},
+		{
+			name: "descending after null",
+			list: byNameDesc,
+			opts: repos.ListOptions{Sort: desc, Cursor: cursor(nil, false, "-name")},
+			want: after(bson.M{"name": nil, "_id": bson.M{"$gt": id}}),
+		},
+		{
+			name: "before null",
+			list: byName,
+			opts: repos.ListOptions{Sort: asc, Cursor: cursor(nil, true, "name")},
+			want: after(bson.M{"name": nil, "_id": bson.M{"$lt": id}}),
+		},
+		{
+			name:    "cursor of another sort",
+			list:    byName,
+			opts:    repos.ListOptions{Sort: asc, Cursor: cursor("b", false, "price")},
+			wantErr: true,
+		},
+		{
+			name:    "cursor of the default sort",
+			list:    byName,
+			opts:    repos.ListOptions{Sort: asc, Cursor: cursor("b", false, "")},
+			wantErr: true,
+		},
+		{
+			name:    "values not matching the sort keys",
+			list:    byName,
+			opts:    repos.ListOptions{Sort: asc, Cursor: &pagination.Cursor{ID: id, Sort: "name"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Limit = 10
+			filter, _, err := tt.list.page(bson.M{}, tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, pagination.ErrInvalidCursor) {
+					t.Errorf("error = %v, want ErrInvalidCursor", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("page: %v", err)
+			}
+			if !reflect.DeepEqual(filter, tt.want) {
+				t.Errorf("got %v, want %v", filter, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/slug"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a one-off data change. Applied migrations are recorded by ID
+// in the schema_migrations collection and never run again.
+type Migration struct {
+	ID string
+	Up func(ctx context.Context, db *mongo.Database) error
+}
+
+// Migrations lists every migration in the order it must be applied.
+var Migrations = []Migration{
+	{ID: "0001_normalize_product_categories", Up: normalizeProductCategories},
+	{ID: "0002_seed_price_history", Up: seedPriceHistory},
+	{ID: "0003_seed_stock_ledger", Up: seedStockLedger},
+	{ID: "0004_seed_warehouses", Up: seedWarehouses},
+	{ID: "0005_utc_timestamps", Up: utcTimestamps},
+	{ID: "0006_seed_payment_holds", Up: seedPaymentHolds},
+}
+
+// Migrate applies the migrations that have not been applied to db yet.
+func Migrate(ctx context.Context, db *mongo.Database, migrations []Migration) error {
+	applied := db.Collection("schema_migrations")
+	for _, m := range migrations {
+		err := applied.FindOne(ctx, bson.M{"_id": m.ID}).Err()
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		if err := m.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+		_, err = applied.InsertOne(ctx, bson.M{"_id": m.ID, "applied_at": time.Now().Format(time.RFC3339)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// categorySingulars maps plural category slugs to their singular where
+// dropping a trailing "s" does not give it.
+var categorySingulars = map[string]string{
+	"accessories": "accessory",
+	"batteries":   "battery",
+	"boxes":       "box",
+	"watches":     "watch",
+}
+
+// normalizeProductCategories turns the free-text category strings of
+// existing products into category documents. Spellings that only differ in
+// case or punctuation ("Phones", "phones") collapse into one category named
+// after the most common spelling, as do plurals and singulars ("Phone") when
+// both are in use or the pair is listed in categorySingulars. The slugs of
+// the merged spellings are kept as aliases of the category.
+func normalizeProductCategories(ctx context.Context, db *mongo.Database) error {
+	products := db.Collection("products")
+	categories := db.Collection("categories")
+
+	cursor, err := products.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"category":    bson.M{"$nin": bson.A{"", nil}},
+			"category_id": bson.M{"$in": bson.A{"", nil}},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var spellings []struct {
+		Name  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &spellings); err != nil {
+		return err
+	}
+
+	inUse := map[string]bool{}
+	for _, s := range spellings {
+		inUse[slug.Make(s.Name)] = true
+	}
+
+	type group struct {
+		names   []string
+		slugs   []string
+		display string
+		count   int
+	}
+	groups := map[string]*group{}
+	for _, s := range spellings {
+		spelling := slug.Make(s.Name)
+		if spelling == "" {
+			continue
+		}
+		key := singularSlug(spelling, inUse)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{slugs: []string{key}}
+			groups[key] = g
+		}
+		g.names = append(g.names, s.Name)
+		if !slices.Contains(g.slugs, spelling) {
+			g.slugs = append(g.slugs, spelling)
+		}
+		if s.Count > g.count || (s.Count == g.count && s.Name < g.display) {
+			g.display, g.count = s.Name, s.Count
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		g := groups[key]
+		id, err := categoryForSlugs(ctx, categories, g.slugs, g.display)
+		if err != nil {
+			return err
+		}
+		_, err = products.UpdateMany(ctx,
+			bson.M{"category": bson.M{"$in": g.names}, "category_id": bson.M{"$in": bson.A{"", nil}}},
+			bson.M{
+				"$set":   bson.M{"category_id": id, "category": g.display},
+				"$unset": bson.M{"search_grams": ""},
+				"$inc":   bson.M{"version": 1},
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// singularSlug returns the slug a category spelling is grouped under: its
+// singular when that is listed or also in use, else the slug itself, so
+// that words such as "glass" or "news" are left alone.
+func singularSlug(spelling string, inUse map[string]bool) string {
+	if singular, ok := categorySingulars[spelling]; ok {
+		return singular
+	}
+	if singular := strings.TrimSuffix(spelling, "s"); singular != spelling && inUse[singular] {
+		return singular
+	}
+	return spelling
+}
+
+// categoryForSlugs returns the ID of the root category found by one of
+// slugs, creating it when there is none yet. The slugs other than that of
+// the category become its aliases.
+func categoryForSlugs(ctx context.Context, categories *mongo.Collection, slugs []string, name string) (string, error) {
+	var existing struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Slug string             `bson:"slug"`
+	}
+	err := categories.FindOne(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"slug": bson.M{"$in": slugs}},
+			bson.M{"aliases": bson.M{"$in": slugs}},
+		}},
+		options.FindOne().SetProjection(bson.M{"_id": 1, "slug": 1}),
+	).Decode(&existing)
+	if err == nil {
+		aliases := slices.DeleteFunc(slices.Clone(slugs), func(s string) bool { return s == existing.Slug })
+		if len(aliases) > 0 {
+			_, err = categories.UpdateByID(ctx, existing.ID, bson.M{
+				"$addToSet": bson.M{"aliases": bson.M{"$each": aliases}},
+			})
+		}
+		return existing.ID.Hex(), err
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+
+	categorySlug := slug.Make(name)
+	aliases := slices.DeleteFunc(slices.Clone(slugs), func(s string) bool { return s == categorySlug })
+	objID := primitive.NewObjectID()
+	now := timestamp(time.Now())
+	_, err = categories.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "name", Value: name},
+		{Key: "slug", Value: categorySlug},
+		{Key: "aliases", Value: aliases},
+		{Key: "parent_id", Value: ""},
+		{Key: "path", Value: "/" + objID.Hex() + "/"},
+		{Key: "version", Value: int64(1)},
+		{Key: "created_at", Value: now},
+		{Key: "updated_at", Value: now},
+	})
+	if err != nil {
+		return "", err
+	}
+	return objID.Hex(), nil
+}
+
+// utcTimestamps rewrites the creation and update times that were stored in
+// local time, or as bare days for products, as UTC timestamps, so that they
+// order chronologically as text.
+func utcTimestamps(ctx context.Context, db *mongo.Database) error {
+	keys := []string{"created_at", "updated_at"}
+	for _, name := range []string{"products", "orders", "categories", "customers", "warehouses", "suppliers"} {
+		coll := db.Collection(name)
+		opts := options.Find().SetProjection(bson.M{"created_at": 1, "updated_at": 1})
+		err := forEach(ctx, coll, bson.M{}, func(doc *bson.M) error {
+			set := bson.M{}
+			for _, key := range keys {
+				raw, _ := (*doc)[key].(string)
+				if utc := utcTimestamp(raw); utc != raw {
+					set[key] = utc
+				}
+			}
+			if len(set) == 0 {
+				return nil
+			}
+			_, err := coll.UpdateOne(ctx, bson.M{"_id": (*doc)["_id"]}, bson.M{"$set": set})
+			return err
+		}, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// utcTimestamp converts an RFC 3339 timestamp or a bare day to a UTC
+// timestamp, leaving anything else as it is.
+func utcTimestamp(raw string) string {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return timestamp(t)
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return timestamp(t)
+	}
+	return raw
+}
+
+// seedPaymentHolds sets what the payments of each order hold on the order,
+// which payments reserve there from now on.
+func seedPaymentHolds(ctx context.Context, db *mongo.Database) error {
+	held := map[string]float64{}
+	err := forEach(ctx, db.Collection("payments"), bson.M{}, func(p *models.Payment) error {
+		held[p.OrderID] += p.Holds()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	orders := db.Collection("orders")
+	for id, amount := range held {
+		if _, err := orders.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"held": amount}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"math"
 	"time"
 
 	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -21,10 +24,25 @@ func NewOrdersStorage(coll *mongo.Collection) *OrdersStorage {
 	}
 }
 
+func (o *OrdersStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := o.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+		{Keys: bson.D{{Key: "products.product_id", Value: 1}}},
+		{Keys: bson.D{{Key: "products.components.product_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	})
+	return err
+}
+
 func (o *OrdersStorage) Create(ctx context.Context, order *models.Order) (*models.Order, error) {
-	order.ID = primitive.NewObjectID().Hex()
-	order.CreatedAt = time.Now().Format(time.RFC3339)
+	if order.ID == "" {
+		order.ID = primitive.NewObjectID().Hex()
+	}
+	order.CreatedAt = timestamp(time.Now())
 	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
 
 	_, err := o.collection.InsertOne(ctx, order)
 	if err != nil {
@@ -35,137 +53,464 @@ func (o *OrdersStorage) Create(ctx context.Context, order *models.Order) (*model
 
 func (o *OrdersStorage) FindByID(ctx context.Context, id string) (*models.Order, error) {
 	var order models.Order
-	objID, err := primitive.ObjectIDFromHex(id)
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	filter := live()
+	filter["_id"] = id
+	err := o.collection.FindOne(ctx, filter).Decode(&order)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &order, nil
+}
+
+func (o *OrdersStorage) FindAll(ctx context.Context, filter repos.OrderFilter, opts repos.ListOptions) ([]*models.Order, error) {
+	clauses, err := orderQuerySchema.filter(filter.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	sort, err := orderQuerySchema.sort(opts.Sort)
 	if err != nil {
 		return nil, err
 	}
 
-	err = o.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&order)
+	list := listing{coll: o.collection, key: hexKey, sort: sort}
+	return findPage[models.Order](ctx, list, and(append(clauses, live())), opts)
+}
+
+func (o *OrdersStorage) Update(ctx context.Context, id string, order *models.Order) (*models.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	filter := live()
+	filter["_id"] = id
+	if order.Version > 0 {
+		filter["version"] = order.Version
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"customer_id": order.CustomerID,
+			"products":    order.Products,
+			"total_price": order.TotalPrice,
+			"order_date":  order.OrderDate,
+			"status":      order.Status,
+			"updated_at":  timestamp(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Order
+	err := o.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, o.collection, id)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &order, nil
+	return &updated, nil
 }
 
-func (o *OrdersStorage) FindAll(ctx context.Context, page, limit int, status string) ([]*models.Order, error) {
-	var orders []*models.Order
-	query := bson.M{}
-	if status != "" {
-		query["status"] = status
+func (o *OrdersStorage) SetPaymentStatus(ctx context.Context, id, paymentStatus string, paid float64, from, to string) (*models.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
 	}
 
-	findOptions := options.Find()
-	findOptions.SetSkip(int64((page - 1) * limit))
-	findOptions.SetLimit(int64(limit))
+	filter := live()
+	filter["_id"] = id
+	set := bson.M{
+		"payment_status": paymentStatus,
+		"paid":           paid,
+		"updated_at":     timestamp(time.Now()),
+		"version":        bson.M{"$add": bson.A{"$version", 1}},
+	}
+	if from != "" {
+		// The status moves only if it is still from, decided in the same
+		// write as the payment status.
+		set["status"] = bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", from}}, to, "$status"}}
+	}
 
-	cursor, err := o.collection.Find(ctx, query, findOptions)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Order
+	err := o.collection.FindOneAndUpdate(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: set}}}, opts).Decode(&updated)
 	if err != nil {
+		return nil, notFound(err)
+	}
+	return &updated, nil
+}
+
+func (o *OrdersStorage) Hold(ctx context.Context, id string, amount float64) (*models.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var order models.Order
-		if err := cursor.Decode(&order); err != nil {
+	filter := live()
+	filter["_id"] = id
+	if amount > 0 {
+		// The check and the increment are one write, so concurrent holds
+		// cannot both pass. Half a cent absorbs the float sums.
+		filter["$expr"] = bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$held", 0}}, amount}},
+			bson.M{"$add": bson.A{"$total_price", 0.005}},
+		}}
+	}
+	update := bson.M{
+		"$inc": bson.M{"held": amount, "version": 1},
+		"$set": bson.M{"updated_at": timestamp(time.Now())},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Order
+	err := o.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := o.FindByID(ctx, id); err != nil {
 			return nil, err
 		}
-		orders = append(orders, &order)
+		return nil, repos.ErrLimitReached
 	}
-
-	if err := cursor.Err(); err != nil {
+	if err != nil {
 		return nil, err
 	}
-
-	return orders, nil
+	return &updated, nil
 }
 
-func (o *OrdersStorage) Update(ctx context.Context, id string, order *models.Order) (*models.Order, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
+func (o *OrdersStorage) SetReturns(ctx context.Context, order *models.Order) (*models.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(order.ID); err != nil {
 		return nil, err
 	}
 
-	order.UpdatedAt = time.Now().Format(time.RFC3339)
+	filter := live()
+	filter["_id"] = order.ID
+	filter["version"] = order.Version
+	update := bson.M{
+		"$set": bson.M{
+			"products":     order.Products,
+			"refunded":     order.Refunded,
+			"refunded_tax": order.RefundedTax,
+			"status":       order.Status,
+			"updated_at":   timestamp(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	}
 
-	update := bson.M{"$set": order}
-	_, err = o.collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Order
+	err := o.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, o.collection, order.ID)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return order, nil
+	return &updated, nil
 }
 
-func (o *OrdersStorage) Delete(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
+func (o *OrdersStorage) Delete(ctx context.Context, id string, version int64) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
 		return err
 	}
+	return softDelete(ctx, o.collection, id, version)
+}
 
-	_, err = o.collection.DeleteOne(ctx, bson.M{"_id": objID})
-	return err
+func (o *OrdersStorage) Restore(ctx context.Context, id string) (*models.Order, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	var restored models.Order
+	if err := restore(ctx, o.collection, id, &restored); err != nil {
+		return nil, err
+	}
+	return &restored, nil
 }
 
-func (o *OrdersStorage) GenerateReport(ctx context.Context, startDate, endDate string) ([]*models.Order, error) {
-	var report []*models.Order
+func (o *OrdersStorage) FindDeleted(ctx context.Context, opts repos.ListOptions) ([]*models.Order, error) {
+	return findPage[models.Order](ctx, trashListing(o.collection, hexKey), trashed(), opts)
+}
 
-	matchStage := bson.D{
-		{
-			Key: "$match",
-			Value: bson.M{
-				"created_at": bson.M{
-					"$gte": startDate,
-					"$lte": endDate,
-				},
-			},
-		},
+func (o *OrdersStorage) CountDeleted(ctx context.Context) (int64, error) {
+	return o.collection.CountDocuments(ctx, trashed())
+}
+
+func (o *OrdersStorage) Purge(ctx context.Context, cutoff time.Time) ([]*models.Order, error) {
+	return purge[models.Order](ctx, o.collection, cutoff)
+}
+
+func (o *OrdersStorage) FindOpenByProduct(ctx context.Context, productID string, limit int) ([]*models.Order, error) {
+	filter := live()
+	filter["status"] = bson.M{"$nin": models.TerminalOrderStatuses}
+	filter["$or"] = bson.A{
+		bson.M{"products.product_id": productID},
+		bson.M{"products.components.product_id": productID},
 	}
 
-	groupStage := bson.D{
-		{
-			Key: "$group",
-			Value: bson.M{
-				"_id":    "$customer_id",
-				"total":  bson.M{"$sum": "$total_price"},
-				"orders": bson.M{"$push": "$$ROOT"},
-			},
-		},
+	var orders []*models.Order
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	err := forEach(ctx, o.collection, filter, func(order *models.Order) error {
+		orders = append(orders, order)
+		return nil
+	}, opts)
+	return orders, err
+}
+
+func (o *OrdersStorage) GenerateReport(ctx context.Context, startDate, endDate string) (*models.SalesReport, error) {
+	// created_at holds RFC 3339 timestamps, so a bare end date has to cover
+	// the whole of that day.
+	created := bson.M{"$gte": startDate, "$lte": endDate}
+	if day, err := time.Parse(time.DateOnly, endDate); err == nil {
+		created = bson.M{"$gte": startDate, "$lt": day.AddDate(0, 0, 1).Format(time.DateOnly)}
 	}
 
-	sortStage := bson.D{
-		{
-			Key: "$sort",
-			Value: bson.M{
-				"total": -1,
-			},
+	// Revenue leaves out tax, which orders placed before taxes have none
+	// of, and what returns refunded, net of the tax in it.
+	ifZero := func(field string) bson.M { return bson.M{"$ifNull": bson.A{field, 0}} }
+	orderTax := bson.M{"$subtract": bson.A{ifZero("$tax"), ifZero("$refunded_tax")}}
+	orderRevenue := bson.M{"$subtract": bson.A{
+		bson.M{"$subtract": bson.A{"$total_price", ifZero("$refunded")}},
+		orderTax,
+	}}
+	lineTax := bson.M{"$cond": bson.A{"$tax_inclusive", ifZero("$products.tax"), 0}}
+	lineRefund := bson.M{"$subtract": bson.A{ifZero("$products.refunded"), ifZero("$products.refunded_tax")}}
+
+	// Each line contributes either itself, net of its discount, of any tax
+	// included in its price and of what returns refunded, or, for bundles,
+	// its components with the revenue attributed to them when the order was
+	// placed, less their share of the refund. Goods returned leave the
+	// quantities, and goods restocked the cost.
+	lineRevenue := bson.M{"$subtract": bson.A{
+		bson.M{"$multiply": bson.A{"$products.price", "$products.quantity"}},
+		bson.M{"$add": bson.A{ifZero("$products.discount"), lineTax}},
+	}}
+	kept := bson.M{"$subtract": bson.A{"$products.quantity", ifZero("$products.returned")}}
+	unrestocked := bson.M{"$subtract": bson.A{"$products.quantity", ifZero("$products.restocked")}}
+	lineCost := bson.M{"$multiply": bson.A{ifZero("$products.cost"), unrestocked}}
+	refundShare := bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{lineRevenue, 0}},
+		bson.M{"$divide": bson.A{lineRefund, lineRevenue}},
+		0,
+	}}
+	components := bson.M{"$map": bson.M{
+		"input": "$products.components",
+		"as":    "c",
+		"in": bson.M{
+			"product_id": "$$c.product_id",
+			"variant_id": "$$c.variant_id",
+			"quantity": bson.M{"$toLong": bson.M{"$round": bson.A{
+				bson.M{"$divide": bson.A{bson.M{"$multiply": bson.A{"$$c.quantity", kept}}, "$products.quantity"}},
+			}}},
+			"revenue": bson.M{"$multiply": bson.A{"$$c.revenue", bson.M{"$subtract": bson.A{1, refundShare}}}},
+			"cost": bson.M{"$divide": bson.A{
+				bson.M{"$multiply": bson.A{ifZero("$$c.cost"), unrestocked}},
+				"$products.quantity",
+			}},
 		},
+	}}
+	lineItems := bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$products.components", bson.A{}}}}, 0}},
+		components,
+		bson.A{bson.M{
+			"product_id": "$products.product_id",
+			"variant_id": "$products.variant_id",
+			"quantity":   kept,
+			"revenue":    bson.M{"$subtract": bson.A{lineRevenue, lineRefund}},
+			"cost":       lineCost,
+		}},
+	}}
+
+	// The tax refunded at a rate is that of the lines taxed at it.
+	refundedAtRate := func(in interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{
+				"input": "$products",
+				"as":    "p",
+				"cond": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$$p.tax_class", "$taxes.tax_class"}},
+					bson.M{"$eq": bson.A{"$$p.tax_rate", "$taxes.rate"}},
+				}},
+			}},
+			"as": "p",
+			"in": in,
+		}}}
 	}
+	refundedTax := refundedAtRate("$$p.refunded_tax")
+	refundedTaxable := refundedAtRate(bson.M{"$subtract": bson.A{ifZero("$$p.refunded"), ifZero("$$p.refunded_tax")}})
 
-	cursor, err := o.collection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, sortStage})
+	match := live()
+	match["created_at"] = created
+	cursor, err := o.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":      nil,
+					"orders":   bson.M{"$sum": 1},
+					"revenue":  bson.M{"$sum": orderRevenue},
+					"tax":      bson.M{"$sum": orderTax},
+					"refunded": bson.M{"$sum": ifZero("$refunded")},
+				}},
+			},
+			"costs": bson.A{
+				bson.M{"$unwind": "$products"},
+				bson.M{"$group": bson.M{"_id": nil, "cost": bson.M{"$sum": lineCost}}},
+			},
+			"customers": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":     "$customer_id",
+					"orders":  bson.M{"$sum": 1},
+					"revenue": bson.M{"$sum": orderRevenue},
+				}},
+				bson.M{"$sort": bson.D{{Key: "revenue", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$project": bson.M{"_id": 0, "customer_id": "$_id", "orders": 1, "revenue": 1}},
+			},
+			"taxes": bson.A{
+				bson.M{"$unwind": "$taxes"},
+				bson.M{"$group": bson.M{
+					"_id": bson.M{
+						"name":      "$taxes.name",
+						"country":   "$taxes.country",
+						"region":    "$taxes.region",
+						"tax_class": "$taxes.tax_class",
+						"rate":      "$taxes.rate",
+					},
+					"taxable": bson.M{"$sum": bson.M{"$subtract": bson.A{"$taxes.taxable", refundedTaxable}}},
+					"amount":  bson.M{"$sum": bson.M{"$subtract": bson.A{"$taxes.amount", refundedTax}}},
+				}},
+				bson.M{"$sort": bson.D{
+					{Key: "_id.country", Value: 1}, {Key: "_id.region", Value: 1},
+					{Key: "_id.tax_class", Value: 1}, {Key: "_id.rate", Value: 1}, {Key: "_id.name", Value: 1},
+				}},
+				bson.M{"$project": bson.M{
+					"_id":       0,
+					"name":      "$_id.name",
+					"country":   "$_id.country",
+					"region":    "$_id.region",
+					"tax_class": "$_id.tax_class",
+					"rate":      "$_id.rate",
+					"taxable":   1,
+					"amount":    1,
+				}},
+			},
+			"products": bson.A{
+				bson.M{"$unwind": "$products"},
+				bson.M{"$project": bson.M{"item": lineItems}},
+				bson.M{"$unwind": "$item"},
+				bson.M{"$group": bson.M{
+					"_id":      bson.M{"product_id": "$item.product_id", "variant_id": "$item.variant_id"},
+					"quantity": bson.M{"$sum": "$item.quantity"},
+					"revenue":  bson.M{"$sum": "$item.revenue"},
+					"cost":     bson.M{"$sum": bson.M{"$ifNull": bson.A{"$item.cost", 0}}},
+				}},
+				bson.M{"$sort": bson.D{{Key: "revenue", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$project": bson.M{
+					"_id":        0,
+					"product_id": "$_id.product_id",
+					"variant_id": "$_id.variant_id",
+					"quantity":   1,
+					"revenue":    1,
+					"cost":       1,
+				}},
+			},
+		}}},
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var result models.Order
+	var result struct {
+		Totals []struct {
+			Orders   int64   `bson:"orders"`
+			Revenue  float64 `bson:"revenue"`
+			Tax      float64 `bson:"tax"`
+			Refunded float64 `bson:"refunded"`
+		} `bson:"totals"`
+		Costs []struct {
+			Cost float64 `bson:"cost"`
+		} `bson:"costs"`
+		Customers []models.CustomerSales `bson:"customers"`
+		Taxes     []models.TaxSales      `bson:"taxes"`
+		Products  []models.ProductSales  `bson:"products"`
+	}
+	if cursor.Next(ctx) {
 		if err := cursor.Decode(&result); err != nil {
 			return nil, err
 		}
-		report = append(report, &result)
 	}
-
 	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
 
+	report := &models.SalesReport{
+		Start:     startDate,
+		End:       endDate,
+		Customers: result.Customers,
+		Taxes:     result.Taxes,
+		Products:  result.Products,
+	}
+	if len(result.Totals) > 0 {
+		report.Orders = result.Totals[0].Orders
+		report.Revenue = math.Round(result.Totals[0].Revenue*100) / 100
+		report.Tax = math.Round(result.Totals[0].Tax*100) / 100
+		report.Refunded = math.Round(result.Totals[0].Refunded*100) / 100
+	}
+	if len(result.Costs) > 0 {
+		report.Cost = result.Costs[0].Cost
+	}
+	report.Margin = math.Round((report.Revenue-report.Cost)*100) / 100
+	for i := range report.Products {
+		sales := &report.Products[i]
+		sales.Margin = math.Round((sales.Revenue-sales.Cost)*100) / 100
+	}
+	if report.Customers == nil {
+		report.Customers = []models.CustomerSales{}
+	}
+	if report.Taxes == nil {
+		report.Taxes = []models.TaxSales{}
+	}
+	if report.Products == nil {
+		report.Products = []models.ProductSales{}
+	}
 	return report, nil
 }
 
-func (o *OrdersStorage) Count(ctx context.Context, status string) (int64, error) {
-	query := bson.M{}
-	if status != "" {
-		query["status"] = status
+func (o *OrdersStorage) Count(ctx context.Context, filter repos.OrderFilter) (int64, error) {
+	clauses, err := orderQuerySchema.filter(filter.Conditions)
+	if err != nil {
+		return 0, err
 	}
-	count, err := o.collection.CountDocuments(ctx, query)
+	count, err := o.collection.CountDocuments(ctx, and(append(clauses, live())))
 	return count, err
 }
+
+// ProductPopularity sums the ordered quantity of every product.
+func (o *OrdersStorage) ProductPopularity(ctx context.Context) (map[string]int64, error) {
+	cursor, err := o.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: live()}},
+		{{Key: "$unwind", Value: "$products"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$products.product_id",
+			"quantity": bson.M{"$sum": "$products.quantity"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	popularity := map[string]int64{}
+	for cursor.Next(ctx) {
+		var row struct {
+			ID       string `bson:"_id"`
+			Quantity int64  `bson:"quantity"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		popularity[row.ID] = row.Quantity
+	}
+	return popularity, cursor.Err()
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PaymentStorage struct {
+	collection *mongo.Collection
+}
+
+func NewPaymentStorage(coll *mongo.Collection) *PaymentStorage {
+	return &PaymentStorage{
+		collection: coll,
+	}
+}
+
+func (s *PaymentStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "_id", Value: 1}}},
+		// Payments declined before the gateway gave them a transaction
+		// have no transaction_id, so the partial index leaves them out.
+		{
+			Keys: bson.D{{Key: "provider", Value: 1}, {Key: "transaction_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"transaction_id": bson.M{"$type": "string"}}),
+		},
+	})
+	return err
+}
+
+func (s *PaymentStorage) Create(ctx context.Context, payment *models.Payment) (*models.Payment, error) {
+	objID := primitive.NewObjectID()
+	if payment.ID != "" {
+		var err error
+		if objID, err = primitive.ObjectIDFromHex(payment.ID); err != nil {
+			return nil, err
+		}
+	}
+	payment.ID = objID.Hex()
+	payment.Version = 1
+	payment.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	payment.UpdatedAt = payment.CreatedAt
+	if payment.Events == nil {
+		payment.Events = []models.PaymentEvent{}
+	}
+
+	doc := bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "order_id", Value: payment.OrderID},
+		{Key: "provider", Value: payment.Provider},
+		{Key: "currency", Value: payment.Currency},
+		{Key: "amount", Value: payment.Amount},
+		{Key: "captured", Value: payment.Captured},
+		{Key: "refunded", Value: payment.Refunded},
+		{Key: "status", Value: payment.Status},
+		{Key: "events", Value: payment.Events},
+		{Key: "version", Value: payment.Version},
+		{Key: "created_at", Value: payment.CreatedAt},
+		{Key: "updated_at", Value: payment.UpdatedAt},
+	}
+	if payment.TransactionID != "" {
+		doc = append(doc, bson.E{Key: "transaction_id", Value: payment.TransactionID})
+	}
+	if payment.FailureReason != "" {
+		doc = append(doc, bson.E{Key: "failure_reason", Value: payment.FailureReason})
+	}
+	_, err := s.collection.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func (s *PaymentStorage) FindByID(ctx context.Context, id string) (*models.Payment, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var payment models.Payment
+	if err := s.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&payment); err != nil {
+		return nil, notFound(err)
+	}
+	return &payment, nil
+}
+
+func (s *PaymentStorage) FindByTransaction(ctx context.Context, provider, transactionID string) (*models.Payment, error) {
+	if transactionID == "" {
+		return nil, repos.ErrNotFound
+	}
+	var payment models.Payment
+	filter := bson.M{"provider": provider, "transaction_id": transactionID}
+	if err := s.collection.FindOne(ctx, filter).Decode(&payment); err != nil {
+		return nil, notFound(err)
+	}
+	return &payment, nil
+}
+
+func (s *PaymentStorage) FindByOrder(ctx context.Context, orderID string) ([]*models.Payment, error) {
+	payments := []*models.Payment{}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	err := forEach(ctx, s.collection, bson.M{"order_id": orderID}, func(payment *models.Payment) error {
+		payments = append(payments, payment)
+		return nil
+	}, opts)
+	return payments, err
+}
+
+func (s *PaymentStorage) Update(ctx context.Context, payment *models.Payment) (*models.Payment, error) {
+	objID, err := primitive.ObjectIDFromHex(payment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": objID, "version": payment.Version}
+	set := bson.M{
+		"captured":   payment.Captured,
+		"refunded":   payment.Refunded,
+		"status":     payment.Status,
+		"events":     payment.Events,
+		"updated_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if payment.FailureReason != "" {
+		set["failure_reason"] = payment.FailureReason
+	}
+
+	var updated models.Payment
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PriceHistoryStorage keeps a point for every price a product or variant
+// has had. Points are written by ProductStorage whenever a write changes a
+// price, including the derived prices of bundles.
+type PriceHistoryStorage struct {
+	collection *mongo.Collection
+}
+
+func NewPriceHistoryStorage(coll *mongo.Collection) *PriceHistoryStorage {
+	return &PriceHistoryStorage{
+		collection: coll,
+	}
+}
+
+func (s *PriceHistoryStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "variant_id", Value: 1}, {Key: "effective_at", Value: -1}}},
+		{Keys: bson.D{{Key: "effective_at", Value: 1}}},
+	})
+	return err
+}
+
+// effectiveAt formats t for effective_at. Timestamps are kept in UTC so that
+// comparing them as strings orders them in time.
+func effectiveAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Record adds a point for every price of product that differs from the
+// latest one recorded: the product's own and the one each variant sells at.
+func (s *PriceHistoryStorage) Record(ctx context.Context, product *models.Product) error {
+	latest, err := s.latest(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	prices := map[string]float64{"": product.Price}
+	for _, v := range product.Variants {
+		prices[v.ID] = product.PriceOf(v.ID)
+	}
+
+	now := effectiveAt(time.Now())
+	var points []interface{}
+	for variantID, price := range prices {
+		point := &models.PricePoint{
+			ID:          primitive.NewObjectID().Hex(),
+			ProductID:   product.ID,
+			VariantID:   variantID,
+			Price:       price,
+			EffectiveAt: now,
+			Actor:       reqctx.Actor(ctx),
+			RequestID:   reqctx.RequestID(ctx),
+		}
+		if previous, ok := latest[variantID]; ok {
+			if previous == price {
+				continue
+			}
+			point.PreviousPrice = &previous
+		}
+		points = append(points, point)
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	_, err = s.collection.InsertMany(ctx, points)
+	return err
+}
+
+// latest returns the latest recorded price of a product and each of its
+// variants, by variant ID.
+func (s *PriceHistoryStorage) latest(ctx context.Context, productID string) (map[string]float64, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID}}},
+		{{Key: "$sort", Value: bson.D{{Key: "effective_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{"$variant_id", ""}},
+			"price": bson.M{"$first": "$price"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	latest := map[string]float64{}
+	for cursor.Next(ctx) {
+		var row struct {
+			VariantID string  `bson:"_id"`
+			Price     float64 `bson:"price"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		latest[row.VariantID] = row.Price
+	}
+	return latest, cursor.Err()
+}
+
+func priceHistoryFilter(productID string, filter repos.PriceHistoryFilter) bson.M {
+	query := bson.M{"product_id": productID, "variant_id": variantMatch(filter.VariantID)}
+	effective := bson.M{}
+	if filter.From != "" {
+		effective["$gte"] = filter.From
+	}
+	if filter.To != "" {
+		effective["$lte"] = filter.To
+		// A bare end date covers the whole of that day.
+		if day, err := time.Parse(time.DateOnly, filter.To); err == nil {
+			delete(effective, "$lte")
+			effective["$lt"] = day.AddDate(0, 0, 1).Format(time.DateOnly)
+		}
+	}
+	if len(effective) > 0 {
+		query["effective_at"] = effective
+	}
+	return query
+}
+
+// variantMatch matches the points of one variant, or with an empty ID those
+// of the product itself.
+func variantMatch(variantID string) interface{} {
+	if variantID == "" {
+		return bson.M{"$exists": false}
+	}
+	return variantID
+}
+
+func (s *PriceHistoryStorage) FindByProduct(ctx context.Context, productID string, filter repos.PriceHistoryFilter, opts repos.ListOptions) ([]*models.PricePoint, error) {
+	list := listing{coll: s.collection, key: hexKey, sort: []sortKey{{key: "effective_at"}}}
+	return findPage[models.PricePoint](ctx, list, priceHistoryFilter(productID, filter), opts)
+}
+
+func (s *PriceHistoryStorage) CountByProduct(ctx context.Context, productID string, filter repos.PriceHistoryFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, priceHistoryFilter(productID, filter))
+}
+
+func (s *PriceHistoryStorage) PriceAt(ctx context.Context, productID, variantID string, at time.Time) (*models.PricePoint, error) {
+	filter := bson.M{
+		"product_id":   productID,
+		"variant_id":   variantMatch(variantID),
+		"effective_at": bson.M{"$lte": effectiveAt(at)},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "effective_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	var point models.PricePoint
+	err := s.collection.FindOne(ctx, filter, opts).Decode(&point)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &point, nil
+}
+
+func (s *PriceHistoryStorage) ChangeStats(ctx context.Context, startDate, endDate string) (*models.PriceChangeStats, error) {
+	effective := bson.M{"$gte": startDate, "$lte": endDate}
+	if day, err := time.Parse(time.DateOnly, endDate); err == nil {
+		effective = bson.M{"$gte": startDate, "$lt": day.AddDate(0, 0, 1).Format(time.DateOnly)}
+	}
+
+	// Variant points follow the product price, so only the product's own
+	// points count as changes.
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"effective_at":   effective,
+			"variant_id":     bson.M{"$exists": false},
+			"previous_price": bson.M{"$exists": true},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"changes":   bson.M{"$sum": 1},
+			"products":  bson.M{"$addToSet": "$product_id"},
+			"increases": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$price", "$previous_price"}}, 1, 0}}},
+			"decreases": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$lt": bson.A{"$price", "$previous_price"}}, 1, 0}}},
+			"average_change_percent": bson.M{"$avg": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$previous_price", 0}},
+				nil,
+				bson.M{"$multiply": bson.A{100, bson.M{"$divide": bson.A{
+					bson.M{"$subtract": bson.A{"$price", "$previous_price"}},
+					"$previous_price",
+				}}}},
+			}}},
+		}}},
+		{{Key: "$set", Value: bson.M{"products": bson.M{"$size": "$products"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	stats := &models.PriceChangeStats{}
+	if cursor.Next(ctx) {
+		var row struct {
+			Changes   int64 `bson:"changes"`
+			Products  int64 `bson:"products"`
+			Increases int64 `bson:"increases"`
+			Decreases int64 `bson:"decreases"`
+			// $avg yields null when every change was from a zero price.
+			Average *float64 `bson:"average_change_percent"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		stats.Changes, stats.Products = row.Changes, row.Products
+		stats.Increases, stats.Decreases = row.Increases, row.Decreases
+		if row.Average != nil {
+			stats.AverageChangePercent = math.Round(*row.Average*100) / 100
+		}
+	}
+	return stats, cursor.Err()
+}
+
+// seedPriceHistory records the current price of every product that has
+// none yet. Earlier prices were never kept, so the history of those
+// products starts when this runs.
+func seedPriceHistory(ctx context.Context, db *mongo.Database) error {
+	prices := NewPriceHistoryStorage(db.Collection("price_history"))
+	ctx = reqctx.WithActor(ctx, "migration")
+	return forEach(ctx, db.Collection("products"), bson.M{}, func(product *models.Product) error {
+		n, err := prices.collection.CountDocuments(ctx, bson.M{"product_id": product.ID}, options.Count().SetLimit(1))
+		if err != nil || n > 0 {
+			return err
+		}
+		return prices.Record(ctx, product)
+	})
+}
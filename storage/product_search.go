@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/search"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fuzzyCandidates caps how many trigram candidates are scored in memory.
+const fuzzyCandidates = 500
+
+// searchFields derives the denormalised search keys stored next to a
+// product: its normalised name for escaped substring/prefix matching and the
+// trigrams of its name and category for typo-tolerant lookups.
+func searchFields(product *models.Product) bson.D {
+	return bson.D{
+		{Key: "search_name", Value: search.Normalize(product.Name)},
+		{Key: "search_grams", Value: search.Trigrams(product.Name + " " + product.Category)},
+	}
+}
+
+func (p *ProductStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := p.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "category", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("product_text").
+				SetWeights(bson.D{
+					{Key: "name", Value: 10},
+					{Key: "category", Value: 5},
+					{Key: "description", Value: 1},
+				}),
+		},
+		{Keys: bson.D{{Key: "search_name", Value: 1}}},
+		{Keys: bson.D{{Key: "search_grams", Value: 1}}},
+		{Keys: bson.D{{Key: "sku", Value: 1}}},
+		{Keys: bson.D{{Key: "variants.sku", Value: 1}}},
+		{Keys: bson.D{{Key: "category", Value: 1}}},
+		{Keys: bson.D{{Key: "category_id", Value: 1}}},
+		// Attribute keys differ by category, so one wildcard index covers them all.
+		{Keys: bson.D{{Key: "attributes.$**", Value: 1}}},
+		{Keys: bson.D{{Key: "price", Value: 1}}},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		{Keys: bson.D{{Key: "locations.warehouse_id", Value: 1}}},
+	})
+	return err
+}
+
+// BackfillSearchFields computes the search keys of products written before
+// they existed.
+func (p *ProductStorage) BackfillSearchFields(ctx context.Context) error {
+	cursor, err := p.collection.Find(ctx, bson.M{"search_grams": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return err
+		}
+		objID, err := objectIDKey(product.ID)
+		if err != nil {
+			return err
+		}
+		_, err = p.collection.UpdateByID(ctx, objID, bson.D{{Key: "$set", Value: searchFields(&product)}})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+type scoredProduct struct {
+	models.Product `bson:",inline"`
+	Score          float64 `bson:"score"`
+}
+
+func (p *ProductStorage) Search(ctx context.Context, q string, filter repos.ProductFilter, limit int) (*models.ProductSearchResult, error) {
+	base, err := productFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	textFilter := bson.M{"$and": []bson.M{base, {"$text": bson.M{"$search": q}}}}
+	hits, err := p.textSearch(ctx, textFilter, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(hits) > 0 {
+		facets, err := p.facets(ctx, textFilter)
+		if err != nil {
+			return nil, err
+		}
+		return &models.ProductSearchResult{
+			Hits:   withHighlights(hits, search.NewMatcher(q, false)),
+			Facets: facets,
+		}, nil
+	}
+
+	// Fuzzy matches are decided in memory, so their facets are too.
+	hits, err = p.fuzzySearch(ctx, q, base)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]*models.Product, len(hits))
+	for i, hit := range hits {
+		matched[i] = hit.Product
+	}
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return &models.ProductSearchResult{
+		Hits:   withHighlights(hits, search.NewMatcher(q, true)),
+		Fuzzy:  true,
+		Facets: search.Facets(matched),
+	}, nil
+}
+
+// textSearch ranks matches of the product_text index by Mongo's text score.
+func (p *ProductStorage) textSearch(ctx context.Context, filter bson.M, limit int) ([]*models.ProductHit, error) {
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetLimit(int64(limit))
+
+	cursor, err := p.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var hits []*models.ProductHit
+	for cursor.Next(ctx) {
+		var doc scoredProduct
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		hits = append(hits, &models.ProductHit{Product: &doc.Product, Score: doc.Score})
+	}
+	return hits, cursor.Err()
+}
+
+// fuzzySearch pulls the products sharing at least one trigram with q and
+// ranks them in memory by trigram similarity, which tolerates typos that
+// the stemmed text index cannot. All hits above the threshold are returned.
+func (p *ProductStorage) fuzzySearch(ctx context.Context, q string, base bson.M) ([]*models.ProductHit, error) {
+	grams := search.Trigrams(q)
+	if len(grams) == 0 {
+		return nil, nil
+	}
+
+	filter := bson.M{"$and": []bson.M{base, {"search_grams": bson.M{"$in": grams}}}}
+	cursor, err := p.collection.Find(ctx, filter, options.Find().SetLimit(fuzzyCandidates))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	matcher := search.NewMatcher(q, true)
+	var hits []*models.ProductHit
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, err
+		}
+		score := matcher.Score(product.Name + " " + product.Category)
+		if score >= search.FuzzyThreshold {
+			hits = append(hits, &models.ProductHit{Product: &product, Score: score})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	return hits, nil
+}
+
+type facetBucket[T any] struct {
+	ID    T     `bson:"_id"`
+	Count int64 `bson:"count"`
+}
+
+// facets counts the products matching filter per category, price range and
+// availability in a single $facet aggregation.
+func (p *ProductStorage) facets(ctx context.Context, filter bson.M) (*models.ProductFacets, error) {
+	// The sentinel upper boundary turns the last range into an open-ended
+	// one; negative prices land in the ignored default bucket.
+	boundaries := append(slices.Clone(search.PriceBoundaries), math.MaxFloat64)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"categories": bson.A{
+				bson.M{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+			},
+			"prices": bson.A{
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$price",
+					"boundaries": boundaries,
+					"default":    "other",
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+			"availability": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$gt": bson.A{"$stock", 0}}, "count": bson.M{"$sum": 1}}},
+			},
+			"attributes": bson.A{
+				bson.M{"$project": bson.M{"attribute": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$attributes", bson.M{}}}}}},
+				bson.M{"$unwind": "$attribute"},
+				// Numbers take too many distinct values to be useful buckets.
+				bson.M{"$match": bson.M{"attribute.v": bson.M{"$type": bson.A{"string", "bool"}}}},
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"key": "$attribute.k", "value": bson.M{"$toString": "$attribute.v"}},
+					"count": bson.M{"$sum": 1},
+				}},
+			},
+		}}},
+	}
+
+	cursor, err := p.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []struct {
+		Categories   []facetBucket[string]      `bson:"categories"`
+		Prices       []facetBucket[interface{}] `bson:"prices"`
+		Availability []facetBucket[bool]        `bson:"availability"`
+		Attributes   []facetBucket[struct {
+			Key   string `bson:"key"`
+			Value string `bson:"value"`
+		}] `bson:"attributes"`
+	}
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+
+	facets := search.NewFacets()
+	if len(out) == 0 {
+		return facets, nil
+	}
+	for _, b := range out[0].Categories {
+		facets.Categories = append(facets.Categories, models.FacetCount{Value: b.ID, Count: b.Count})
+	}
+	search.SortFacetCounts(facets.Categories)
+	for _, b := range out[0].Prices {
+		min, ok := b.ID.(float64)
+		if !ok {
+			continue
+		}
+		for i := range facets.PriceRanges {
+			if facets.PriceRanges[i].Min == min {
+				facets.PriceRanges[i].Count = b.Count
+			}
+		}
+	}
+	for _, b := range out[0].Availability {
+		if b.ID {
+			facets.Availability.InStock = b.Count
+		} else {
+			facets.Availability.OutOfStock = b.Count
+		}
+	}
+	attributes := map[string][]models.FacetCount{}
+	for _, b := range out[0].Attributes {
+		attributes[b.ID.Key] = append(attributes[b.ID.Key], models.FacetCount{Value: b.ID.Value, Count: b.Count})
+	}
+	facets.Attributes = search.AttributeFacets(attributes)
+	return facets, nil
+}
+
+func withHighlights(hits []*models.ProductHit, m *search.Matcher) []*models.ProductHit {
+	for _, hit := range hits {
+		fields := map[string]string{
+			"name":        hit.Product.Name,
+			"category":    hit.Product.Category,
+			"description": hit.Product.Description,
+		}
+		for field, text := range fields {
+			if h := search.Highlight(text, m); h != "" {
+				if hit.Highlights == nil {
+					hit.Highlights = map[string]string{}
+				}
+				hit.Highlights[field] = h
+			}
+		}
+	}
+	if hits == nil {
+		hits = []*models.ProductHit{}
+	}
+	return hits
+}
@@ -10,10 +10,16 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/query"
+	"github.com/udevs/lesson3/pkg/search"
+	"github.com/udevs/lesson3/repos"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,24 +28,71 @@ import (
 
 type ProductStorage struct {
 	collection *mongo.Collection
+	// prices records price changes and ledger the stock movements;
+	// warehouses is read for the warehouse opening stock goes to. All three
+	// are nil for read-only uses.
+	prices     *PriceHistoryStorage
+	ledger     *StockLedgerStorage
+	warehouses *WarehouseStorage
 }
 
-func NewProductStorage(coll *mongo.Collection) *ProductStorage {
+func NewProductStorage(coll *mongo.Collection, prices *PriceHistoryStorage, ledger *StockLedgerStorage, warehouses *WarehouseStorage) *ProductStorage {
 	return &ProductStorage{
 		collection: coll,
+		prices:     prices,
+		ledger:     ledger,
+		warehouses: warehouses,
 	}
 }
 
+// recordPrices adds the prices of a product just written to the price
+// history.
+func (p *ProductStorage) recordPrices(ctx context.Context, product *models.Product) error {
+	if p.prices == nil {
+		return nil
+	}
+	return p.prices.Record(ctx, product)
+}
+
+// recordMovements adds stock movements just applied to the ledger.
+func (p *ProductStorage) recordMovements(ctx context.Context, movements ...*models.StockMovement) error {
+	if p.ledger == nil {
+		return nil
+	}
+	return p.ledger.Record(ctx, movements...)
+}
+
 func (p *ProductStorage) Create(ctx context.Context, product *models.Product) (*models.Product, error) {
-	curTime := time.Now().Format("2006-01-02")
+	curTime := timestamp(time.Now())
+	if err := p.prepare(ctx, product); err != nil {
+		return nil, err
+	}
+	if err := p.openingLocations(ctx, product); err != nil {
+		return nil, err
+	}
 
-	res, err := p.collection.InsertOne(ctx, bson.D{
+	doc := bson.D{
 		{Key: "name", Value: product.Name},
+		{Key: "sku", Value: product.SKU},
+		{Key: "type", Value: product.Type},
 		{Key: "price", Value: product.Price},
+		{Key: "tax_class", Value: product.TaxClass},
+		{Key: "cost", Value: product.Cost},
 		{Key: "stock", Value: product.Stock},
+		{Key: "locations", Value: product.Locations},
+		{Key: "reorder_point", Value: product.ReorderPoint},
+		{Key: "reorder_quantity", Value: product.ReorderQuantity},
+		{Key: "category_id", Value: product.CategoryID},
 		{Key: "category", Value: product.Category},
+		{Key: "description", Value: product.Description},
+		{Key: "options", Value: product.Options},
+		{Key: "variants", Value: product.Variants},
+		{Key: "bundle", Value: product.Bundle},
+		{Key: "attributes", Value: product.Attributes},
+		{Key: "version", Value: int64(1)},
 		{Key: "created_at", Value: curTime},
-	})
+	}
+	res, err := p.collection.InsertOne(ctx, append(doc, searchFields(product)...))
 
 	if err != nil {
 		return nil, err
@@ -51,14 +104,35 @@ func (p *ProductStorage) Create(ctx context.Context, product *models.Product) (*
 		return nil, err
 	}
 
-	return &models.Product{
-		ID:        objID.Hex(),
-		Name:      product.Name,
-		Category:  product.Category,
-		Stock:     product.Stock,
-		Price:     product.Price,
-		CreatedAt: curTime,
-	}, nil
+	created := &models.Product{
+		ID:              objID.Hex(),
+		Name:            product.Name,
+		SKU:             product.SKU,
+		Type:            product.Type,
+		CategoryID:      product.CategoryID,
+		Category:        product.Category,
+		Description:     product.Description,
+		Stock:           product.Stock,
+		Locations:       product.Locations,
+		Price:           product.Price,
+		TaxClass:        product.TaxClass,
+		Cost:            product.Cost,
+		ReorderPoint:    product.ReorderPoint,
+		ReorderQuantity: product.ReorderQuantity,
+		Options:         product.Options,
+		Variants:        product.Variants,
+		Bundle:          product.Bundle,
+		Attributes:      product.Attributes,
+		Version:         1,
+		CreatedAt:       curTime,
+	}
+	if err := p.recordPrices(ctx, created); err != nil {
+		return nil, err
+	}
+	if err := p.recordMovements(ctx, openingMovements(created)...); err != nil {
+		return nil, err
+	}
+	return created, nil
 }
 
 func (p *ProductStorage) FindByID(ctx context.Context, id string) (*models.Product, error) {
@@ -69,91 +143,490 @@ func (p *ProductStorage) FindByID(ctx context.Context, id string) (*models.Produ
 
 	res := p.collection.FindOne(ctx, bson.D{
 		{Key: "_id", Value: objID},
+		{Key: "deleted_at", Value: bson.M{"$exists": false}},
 	})
 	prod := models.Product{}
 
 	if err := res.Decode(&prod); err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return &prod, nil
 }
 
-func (p *ProductStorage) FindAll(ctx context.Context, page, limit int, search string) ([]*models.Product, error) {
-	var products []*models.Product
+func (p *ProductStorage) FindAll(ctx context.Context, filter repos.ProductFilter, opts repos.ListOptions) ([]*models.Product, error) {
+	query, err := productFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	sort, err := productQuerySchema.sort(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
 
-	filter := bson.D{}
-	if search != "" {
-		filter = bson.D{
-			{Key: "name", Value: bson.D{{Key: "$regex", Value: search}, {Key: "$options", Value: "i"}}},
-		}
+	list := listing{coll: p.collection, key: objectIDKey, sort: sort}
+	return findPage[models.Product](ctx, list, query, opts)
+}
+
+func (p *ProductStorage) Update(ctx context.Context, id string, product *models.Product) (*models.Product, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
 	}
 
-	opts := &options.FindOptions{}
-	opts.SetSkip(int64((page - 1) * limit))
-	opts.SetLimit(int64(limit))
+	filter := bson.D{{Key: "_id", Value: objID}, {Key: "deleted_at", Value: bson.M{"$exists": false}}}
+	if product.Version > 0 {
+		filter = append(filter, bson.E{Key: "version", Value: product.Version})
+	}
+	product.ID = id
+	if err := p.prepare(ctx, product); err != nil {
+		return nil, err
+	}
+
+	// Stock only changes through AdjustStock, so the update keeps the stored
+	// stock of the product and of the variants and locations it keeps; new
+	// variants start empty, and the stock of the locations dropped is
+	// written off in the ledger. Bundles take the stock derived by prepare. The
+	// cost is kept too, as receipts average into it.
+	set := bson.D{
+		{Key: "name", Value: product.Name},
+		{Key: "sku", Value: product.SKU},
+		{Key: "type", Value: product.Type},
+		{Key: "price", Value: product.Price},
+		{Key: "tax_class", Value: product.TaxClass},
+		{Key: "reorder_point", Value: product.ReorderPoint},
+		{Key: "reorder_quantity", Value: product.ReorderQuantity},
+		{Key: "category_id", Value: product.CategoryID},
+		{Key: "category", Value: product.Category},
+		{Key: "description", Value: product.Description},
+		{Key: "options", Value: product.Options},
+		{Key: "bundle", Value: product.Bundle},
+		{Key: "attributes", Value: product.Attributes},
+		{Key: "updated_at", Value: timestamp(time.Now())},
+	}
+	if product.Images != nil {
+		set = append(set, bson.E{Key: "images", Value: product.Images})
+	}
+	set = append(set, searchFields(product)...)
+	for i := range set {
+		// Values are taken as they are, not as expressions.
+		set[i].Value = bson.M{"$literal": set[i].Value}
+	}
+	set = append(set,
+		bson.E{Key: "variants", Value: keepVariantStock(product.Variants)},
+		bson.E{Key: "locations", Value: keepLocations(product.Variants)},
+		bson.E{Key: "version", Value: bson.M{"$add": bson.A{"$version", 1}}},
+	)
+	if !product.IsBundle() && len(product.Variants) == 0 {
+		// A product that loses its variants keeps the stock of the
+		// locations it keeps, which held none of theirs.
+		set = append(set, bson.E{Key: "stock", Value: bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$variants", bson.A{}}}}, 0}},
+			bson.M{"$sum": bson.M{"$map": bson.M{"input": keepLocations(nil), "in": "$$this.stock"}}},
+			"$stock",
+		}}})
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: set}}}
+	switch {
+	case product.IsBundle():
+		update = append(update, bson.D{{Key: "$set", Value: bson.M{"stock": product.Stock}}})
+	case len(product.Variants) > 0:
+		update = append(update, bson.D{{Key: "$set", Value: bson.M{"stock": bson.M{"$sum": "$variants.stock"}}}})
+	}
 
-	cursor, err := p.collection.Find(ctx, filter, opts)
+	// The stored product tells what stock the update dropped along with
+	// the variants, or locations, it removed.
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	var stored models.Product
+	err = p.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, p.collection, objID)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
+	if err := p.recordMovements(ctx, droppedStock(&stored, product.Variants)...); err != nil {
+		return nil, err
+	}
+	updated, err := p.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.recordPrices(ctx, updated); err != nil {
+		return nil, err
+	}
 
-	for cursor.Next(ctx) {
-		var product models.Product
-		if err := cursor.Decode(&product); err != nil {
+	if !updated.IsBundle() {
+		if err := p.refreshBundles(ctx, id); err != nil {
 			return nil, err
 		}
-		products = append(products, &product)
 	}
+	return updated, nil
+}
 
-	return products, nil
+// prepare fills in the derived fields of a product about to be written:
+// IDs of new variants, and the stock of products with variants or bundles.
+func (p *ProductStorage) prepare(ctx context.Context, product *models.Product) error {
+	if product.Type == "" {
+		product.Type = models.ProductTypeSimple
+	}
+	if product.IsBundle() {
+		stock, price, err := p.bundleTotals(ctx, product)
+		if err != nil {
+			return err
+		}
+		product.Stock, product.Price = stock, price
+		return nil
+	}
+	if len(product.Variants) == 0 {
+		return nil
+	}
+	stock := 0
+	for i := range product.Variants {
+		if product.Variants[i].ID == "" {
+			product.Variants[i].ID = primitive.NewObjectID().Hex()
+		}
+		stock += product.Variants[i].Stock
+	}
+	product.Stock = stock
+	return nil
 }
 
-func (p *ProductStorage) Update(ctx context.Context, id string, product *models.Product) (*models.Product, error) {
+// keepVariantStock is an update expression for the variants of a product
+// that takes the stock of each from the stored variant with the same ID.
+func keepVariantStock(variants []models.Variant) bson.M {
+	stored := bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$variants", bson.A{}}},
+		"cond":  bson.M{"$eq": bson.A{"$$this.id", "$$v.id"}},
+	}}
+	return bson.M{"$map": bson.M{
+		"input": bson.M{"$literal": variants},
+		"as":    "v",
+		"in": bson.M{"$mergeObjects": bson.A{"$$v", bson.M{
+			"stock": bson.M{"$sum": bson.M{"$map": bson.M{"input": stored, "in": "$$this.stock"}}},
+		}}},
+	}}
+}
+
+// keepLocations is an update expression for the locations of a product
+// that drops those of variants it no longer has.
+func keepLocations(variants []models.Variant) bson.M {
+	ids := bson.A{""}
+	if len(variants) > 0 {
+		ids = bson.A{}
+		for _, v := range variants {
+			ids = append(ids, v.ID)
+		}
+	}
+	return bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$locations", bson.A{}}},
+		"cond":  bson.M{"$in": bson.A{bson.M{"$ifNull": bson.A{"$$this.variant_id", ""}}, ids}},
+	}}
+}
+
+// openingLocations puts the stock a new product is created with into the
+// default warehouse.
+func (p *ProductStorage) openingLocations(ctx context.Context, product *models.Product) error {
+	product.Locations = nil
+	if product.IsBundle() || p.warehouses == nil {
+		return nil
+	}
+	var levels []models.StockLevel
+	if len(product.Variants) == 0 && product.Stock > 0 {
+		levels = append(levels, models.StockLevel{Stock: product.Stock})
+	}
+	for _, v := range product.Variants {
+		if v.Stock > 0 {
+			levels = append(levels, models.StockLevel{VariantID: v.ID, Stock: v.Stock})
+		}
+	}
+	if len(levels) == 0 {
+		return nil
+	}
+
+	warehouse, err := p.warehouses.Default(ctx)
+	if errors.Is(err, repos.ErrNotFound) {
+		return repos.ErrNoWarehouse
+	}
+	if err != nil {
+		return err
+	}
+	for i := range levels {
+		levels[i].WarehouseID = warehouse.ID
+	}
+	product.Locations = levels
+	return nil
+}
+
+func (p *ProductStorage) Delete(ctx context.Context, id string, version int64) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	update := bson.D{
-		{Key: "$set", Value: bson.D{
-			{Key: "name", Value: product.Name},
-			{Key: "price", Value: product.Price},
-			{Key: "stock", Value: product.Stock},
-			{Key: "category", Value: product.Category},
-			{Key: "updated_at", Value: primitive.DateTime(time.Now().Unix())},
-		}},
+	if err := softDelete(ctx, p.collection, objID, version); err != nil {
+		return err
 	}
+	return p.refreshBundles(ctx, id)
+}
 
-	_, err = p.collection.UpdateByID(ctx, objID, update)
+func (p *ProductStorage) Restore(ctx context.Context, id string) (*models.Product, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return p.FindByID(ctx, id)
+	var restored models.Product
+	if err := restore(ctx, p.collection, objID, &restored); err != nil {
+		return nil, err
+	}
+	if !restored.IsBundle() {
+		if err := p.refreshBundles(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return &restored, nil
 }
 
-func (p *ProductStorage) Delete(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+func (p *ProductStorage) FindDeleted(ctx context.Context, opts repos.ListOptions) ([]*models.Product, error) {
+	return findPage[models.Product](ctx, trashListing(p.collection, objectIDKey), trashed(), opts)
+}
+
+func (p *ProductStorage) CountDeleted(ctx context.Context) (int64, error) {
+	return p.collection.CountDocuments(ctx, trashed())
+}
+
+func (p *ProductStorage) Purge(ctx context.Context, cutoff time.Time) ([]*models.Product, error) {
+	return purge[models.Product](ctx, p.collection, cutoff)
+}
+
+func (p *ProductStorage) AdjustStock(ctx context.Context, movement *models.StockMovement) error {
+	productID, variantID, delta := movement.ProductID, movement.VariantID, movement.Quantity
+	objID, err := primitive.ObjectIDFromHex(productID)
 	if err != nil {
 		return err
 	}
+	if movement.WarehouseID == "" {
+		return repos.ErrInvalidReference
+	}
 
-	_, err = p.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: objID}})
+	// The stock condition and the increments are one atomic update, so
+	// concurrent orders can never take stock below zero. The stock of the
+	// location, of the variant and of the product move together.
+	base := live()
+	base["_id"] = objID
+	base["type"] = bson.M{"$ne": models.ProductTypeBundle}
+	inc := bson.M{"stock": delta, "version": 1}
+	var arrayFilters []interface{}
+	if variantID == "" {
+		// Products with variants keep their stock per variant.
+		base["variants.0"] = bson.M{"$exists": false}
+	} else {
+		base["variants.id"] = variantID
+		inc["variants.$[v].stock"] = delta
+		arrayFilters = append(arrayFilters, bson.M{"v.id": variantID})
+	}
+	set := bson.M{"updated_at": timestamp(time.Now())}
+
+	// Stock goes to the location of the product at the warehouse, or to a
+	// new one when it has none there yet.
+	location := bson.M{"warehouse_id": movement.WarehouseID, "variant_id": variantMatch(variantID)}
+	atLocation := bson.M{"warehouse_id": movement.WarehouseID, "variant_id": variantMatch(variantID)}
+	if delta < 0 {
+		atLocation["stock"] = bson.M{"$gte": -delta}
+	}
+	existing := withField(base, "locations", bson.M{"$elemMatch": atLocation})
+	existingInc := withField(inc, "locations.$[l].stock", delta)
+	existingFilters := append([]interface{}{bson.M{
+		"l.warehouse_id": movement.WarehouseID,
+		"l.variant_id":   variantMatch(variantID),
+	}}, arrayFilters...)
+	missing := withField(base, "locations", bson.M{"$not": bson.M{"$elemMatch": location}})
+	level := models.StockLevel{WarehouseID: movement.WarehouseID, VariantID: variantID, Stock: delta}
+
+	// A concurrent first receipt at the warehouse can push the location
+	// between the two updates, so they are tried twice.
+	var matched int64
+	for attempt := 0; attempt < 2 && matched == 0; attempt++ {
+		matched, err = p.updateStock(ctx, existing, bson.M{"$inc": existingInc, "$set": set}, existingFilters)
+		if err != nil {
+			return err
+		}
+		if matched > 0 || delta < 0 {
+			break
+		}
+		matched, err = p.updateStock(ctx, missing, bson.M{"$inc": inc, "$set": set, "$push": bson.M{"locations": level}}, arrayFilters)
+		if err != nil {
+			return err
+		}
+	}
+
+	if matched == 0 {
+		product, err := p.FindByID(ctx, productID)
+		if err != nil {
+			return err
+		}
+		if product.IsBundle() || (variantID == "") != (len(product.Variants) == 0) ||
+			(variantID != "" && product.Variant(variantID) == nil) {
+			return repos.ErrInvalidReference
+		}
+		return repos.ErrInsufficientStock
+	}
+	if delta > 0 && movement.UnitCost > 0 {
+		if err := p.averageCost(ctx, objID, delta, movement.UnitCost); err != nil {
+			return err
+		}
+	}
+	if err := p.recordMovements(ctx, movement); err != nil {
+		return err
+	}
+	return p.refreshBundles(ctx, productID)
+}
+
+// averageCost averages the unit cost of quantity items, just added to the
+// stock of a product, into the cost of the product.
+func (p *ProductStorage) averageCost(ctx context.Context, objID primitive.ObjectID, quantity int, unitCost float64) error {
+	before := bson.M{"$max": bson.A{bson.M{"$subtract": bson.A{"$stock", quantity}}, 0}}
+	cost := bson.M{"$divide": bson.A{
+		bson.M{"$add": bson.A{
+			bson.M{"$multiply": bson.A{before, bson.M{"$ifNull": bson.A{"$cost", 0}}}},
+			unitCost * float64(quantity),
+		}},
+		bson.M{"$add": bson.A{before, quantity}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"cost": bson.M{"$round": bson.A{cost, 2}}}}}}
+	_, err := p.collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
 	return err
 }
 
-func (p *ProductStorage) Count(ctx context.Context, search string) (int64, error) {
-	filter := bson.D{}
-	if search != "" {
-		filter = bson.D{
-			{Key: "name", Value: bson.D{{Key: "$regex", Value: search}, {Key: "$options", Value: "i"}}},
-		}
+// updateStock applies a stock update and returns how many products it
+// matched.
+func (p *ProductStorage) updateStock(ctx context.Context, filter, update bson.M, arrayFilters []interface{}) (int64, error) {
+	opts := options.Update()
+	if len(arrayFilters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
 	}
-	count, err := p.collection.CountDocuments(ctx, filter)
+	res, err := p.collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+// withField returns a copy of m with key set to value.
+func withField(m bson.M, key string, value interface{}) bson.M {
+	c := make(bson.M, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	c[key] = value
+	return c
+}
+
+func (p *ProductStorage) Count(ctx context.Context, filter repos.ProductFilter) (int64, error) {
+	query, err := productFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	count, err := p.collection.CountDocuments(ctx, query)
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
+
+func (p *ProductStorage) LowStock(ctx context.Context) ([]*models.Product, error) {
+	filter := live()
+	filter["reorder_point"] = bson.M{"$gt": 0}
+	filter["$expr"] = bson.M{"$lte": bson.A{"$stock", "$reorder_point"}}
+
+	products := []*models.Product{}
+	err := forEach(ctx, p.collection, filter, func(product *models.Product) error {
+		products = append(products, product)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// SetCategoryName refreshes the category name copied onto the products of
+// a renamed category.
+func (p *ProductStorage) SetCategoryName(ctx context.Context, categoryID, name string) ([]*models.Product, error) {
+	var renamed []*models.Product
+	var ids bson.A
+	stale := bson.M{"category_id": categoryID, "category": bson.M{"$ne": name}}
+	err := forEach(ctx, p.collection, stale, func(product *models.Product) error {
+		renamed = append(renamed, product)
+		id, err := primitive.ObjectIDFromHex(product.ID)
+		ids = append(ids, id)
+		return err
+	})
+	if err != nil || len(renamed) == 0 {
+		return nil, err
+	}
+
+	// Only the products read are renamed, so that the ones returned are
+	// those changed.
+	_, err = p.collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "category": bson.M{"$ne": name}},
+		bson.M{
+			"$set":   bson.M{"category": name},
+			"$unset": bson.M{"search_grams": ""},
+			"$inc":   bson.M{"version": 1},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The category name feeds the trigram keys, so recompute them.
+	return renamed, p.BackfillSearchFields(ctx)
+}
+
+func productFilter(filter repos.ProductFilter) (bson.M, error) {
+	var fields, attributes []query.Condition
+	for _, cond := range filter.Conditions {
+		if strings.HasPrefix(cond.Field, attributePrefix) {
+			attributes = append(attributes, cond)
+		} else {
+			fields = append(fields, cond)
+		}
+	}
+
+	clauses, err := productQuerySchema.filter(fields)
+	if err != nil {
+		return nil, err
+	}
+	clauses = append(clauses, live())
+	if filter.WarehouseID != "" {
+		clauses = append(clauses, bson.M{"locations": bson.M{"$elemMatch": bson.M{
+			"warehouse_id": filter.WarehouseID,
+			"stock":        bson.M{"$gt": 0},
+		}}})
+	}
+	for _, cond := range attributes {
+		clause, err := attributeFilter(cond)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	if filter.Search != "" {
+		// The pattern is built from escaped, normalised text only, so user
+		// input can never contribute regex syntax. Anchored prefixes can use
+		// the search_name index.
+		pattern := regexp.QuoteMeta(search.Normalize(filter.Search))
+		if filter.SearchPrefix {
+			pattern = "^" + pattern
+		}
+		clauses = append(clauses, bson.M{"search_name": bson.M{"$regex": pattern}})
+	}
+	return and(clauses), nil
+}
+
+// ForEach calls fn for every product outside the trash, stopping at the
+// first error.
+func (p *ProductStorage) ForEach(ctx context.Context, fn func(*models.Product) error) error {
+	return forEach(ctx, p.collection, live(), fn)
+}
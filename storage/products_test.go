@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/udevs/lesson3/models"
+)
+
+func TestPrepareStock(t *testing.T) {
+	tests := []struct {
+		name      string
+		product   *models.Product
+		wantStock int
+	}{
+		{
+			name:      "simple product keeps its stock",
+			product:   &models.Product{Stock: 7},
+			wantStock: 7,
+		},
+		{
+			name: "stock is the sum of the variants",
+			product: &models.Product{Stock: 100, Variants: []models.Variant{
+				{ID: "a", Stock: 3},
+				{Stock: 4},
+				{ID: "c"},
+			}},
+			wantStock: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&ProductStorage{}).prepare(context.Background(), tt.product); err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			if tt.product.Stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", tt.product.Stock, tt.wantStock)
+			}
+			if tt.product.Type != models.ProductTypeSimple {
+				t.Errorf("type = %q, want %q", tt.product.Type, models.ProductTypeSimple)
+			}
+			for _, v := range tt.product.Variants {
+				if v.ID == "" {
+					t.Errorf("variant without an ID: %+v", v)
+				}
+			}
+		})
+	}
+}
+
+func TestDroppedStock(t *testing.T) {
+	type drop struct {
+		warehouseID, variantID string
+		quantity               int
+	}
+	withVariants := &models.Product{
+		ID:       "p",
+		Stock:    9,
+		Variants: []models.Variant{{ID: "a", Stock: 4}, {ID: "b", Stock: 5}},
+		Locations: []models.StockLevel{
+			{WarehouseID: "w1", VariantID: "a", Stock: 4},
+			{WarehouseID: "w1", VariantID: "b", Stock: 2},
+			{WarehouseID: "w2", VariantID: "b", Stock: 3},
+		},
+	}
+	variants := func(ids ...string) []models.Variant {
+		vs := make([]models.Variant, 0, len(ids))
+		for _, id := range ids {
+			vs = append(vs, models.Variant{ID: id})
+		}
+		return vs
+	}
+
+	tests := []struct {
+		name     string
+		stored   *models.Product
+		variants []models.Variant
+		want     []drop
+	}{
+		{
+			name:     "all variants kept",
+			stored:   withVariants,
+			variants: variants("a", "b", "c"),
+		},
+		{
+			name:     "one variant removed",
+			stored:   withVariants,
+			variants: variants("a"),
+			want:     []drop{{"w1", "b", -2}, {"w2", "b", -3}},
+		},
+		{
+			name:   "all variants removed",
+			stored: withVariants,
+			want:   []drop{{"w1", "a", -4}, {"w1", "b", -2}, {"w2", "b", -3}},
+		},
+		{
+			name:     "empty location",
+			stored:   &models.Product{ID: "p", Locations: []models.StockLevel{{WarehouseID: "w1", VariantID: "a"}}},
+			variants: variants("b"),
+		},
+		{
+			name:     "simple product given variants",
+			stored:   &models.Product{ID: "p", Stock: 6, Locations: []models.StockLevel{{WarehouseID: "w1", Stock: 6}}},
+			variants: variants("a"),
+			want:     []drop{{"w1", "", -6}},
+		},
+		{
+			name:   "simple product kept simple",
+			stored: &models.Product{ID: "p", Stock: 6, Locations: []models.StockLevel{{WarehouseID: "w1", Stock: 6}}},
+		},
+		{
+			name:   "variants stocked before warehouses",
+			stored: &models.Product{ID: "p", Stock: 9, Variants: []models.Variant{{ID: "a", Stock: 4}, {ID: "b", Stock: 5}}},
+			want:   []drop{{"", "a", -4}, {"", "b", -5}},
+		},
+		{
+			name:   "bundle",
+			stored: &models.Product{ID: "p", Type: models.ProductTypeBundle, Stock: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []drop
+			for _, m := range droppedStock(tt.stored, tt.variants) {
+				if m.ProductID != "p" || m.Type != models.MovementAdjustment || m.Reason != variantRemoved {
+					t.Errorf("movement %+v", m)
+				}
+				got = append(got, drop{m.WarehouseID, m.VariantID, m.Quantity})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PromotionStorage keeps promotions, and in a second collection how often
+// each customer has used the promotions limited per customer.
+type PromotionStorage struct {
+	collection  *mongo.Collection
+	redemptions *mongo.Collection
+}
+
+func NewPromotionStorage(coll, redemptions *mongo.Collection) *PromotionStorage {
+	return &PromotionStorage{
+		collection:  coll,
+		redemptions: redemptions,
+	}
+}
+
+func (s *PromotionStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "code", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"code": bson.M{"$type": "string"}}),
+		},
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.redemptions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "customer_id", Value: 1}},
+	})
+	return err
+}
+
+// promotionDoc is the stored form of the terms of a promotion; the code is
+// left out when empty, so the unique index skips automatic promotions.
+func promotionDoc(promotion *models.Promotion) bson.M {
+	doc := bson.M{
+		"name":               promotion.Name,
+		"type":               promotion.Type,
+		"value":              promotion.Value,
+		"buy_quantity":       promotion.BuyQuantity,
+		"get_quantity":       promotion.GetQuantity,
+		"product_ids":        promotion.ProductIDs,
+		"category_ids":       promotion.CategoryIDs,
+		"min_order_value":    promotion.MinOrderValue,
+		"starts_at":          promotion.StartsAt,
+		"ends_at":            promotion.EndsAt,
+		"usage_limit":        promotion.UsageLimit,
+		"per_customer_limit": promotion.PerCustomerLimit,
+		"exclusive":          promotion.Exclusive,
+		"active":             promotion.Active,
+	}
+	if promotion.Code != "" {
+		doc["code"] = promotion.Code
+	}
+	return doc
+}
+
+func (s *PromotionStorage) Create(ctx context.Context, promotion *models.Promotion) (*models.Promotion, error) {
+	objID := primitive.NewObjectID()
+	promotion.ID = objID.Hex()
+	promotion.Code = strings.ToUpper(promotion.Code)
+	promotion.Uses = 0
+	promotion.Version = 1
+	promotion.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	promotion.UpdatedAt = promotion.CreatedAt
+
+	doc := promotionDoc(promotion)
+	doc["_id"] = objID
+	doc["uses"] = promotion.Uses
+	doc["version"] = promotion.Version
+	doc["created_at"] = promotion.CreatedAt
+	doc["updated_at"] = promotion.UpdatedAt
+	_, err := s.collection.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+func (s *PromotionStorage) FindByID(ctx context.Context, id string) (*models.Promotion, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var promotion models.Promotion
+	if err := s.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&promotion); err != nil {
+		return nil, notFound(err)
+	}
+	return &promotion, nil
+}
+
+func (s *PromotionStorage) FindByCode(ctx context.Context, code string) (*models.Promotion, error) {
+	if code == "" {
+		return nil, repos.ErrNotFound
+	}
+	var promotion models.Promotion
+	err := s.collection.FindOne(ctx, bson.M{"code": strings.ToUpper(code)}).Decode(&promotion)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &promotion, nil
+}
+
+func (s *PromotionStorage) FindAll(ctx context.Context, opts repos.ListOptions) ([]*models.Promotion, error) {
+	list := listing{coll: s.collection, key: objectIDKey, sort: []sortKey{{key: "created_at", desc: true}}}
+	return findPage[models.Promotion](ctx, list, bson.M{}, opts)
+}
+
+func (s *PromotionStorage) Count(ctx context.Context) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{})
+}
+
+func (s *PromotionStorage) Automatic(ctx context.Context) ([]*models.Promotion, error) {
+	var promotions []*models.Promotion
+	filter := bson.M{"active": true, "code": bson.M{"$exists": false}}
+	err := forEach(ctx, s.collection, filter, func(promotion *models.Promotion) error {
+		promotions = append(promotions, promotion)
+		return nil
+	})
+	return promotions, err
+}
+
+func (s *PromotionStorage) Update(ctx context.Context, id string, promotion *models.Promotion) (*models.Promotion, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	promotion.Code = strings.ToUpper(promotion.Code)
+
+	filter := bson.M{"_id": objID}
+	if promotion.Version > 0 {
+		filter["version"] = promotion.Version
+	}
+	set := promotionDoc(promotion)
+	set["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if promotion.Code == "" {
+		update["$unset"] = bson.M{"code": ""}
+	}
+
+	var updated models.Promotion
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *PromotionStorage) Delete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objID}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return missOrConflict(ctx, s.collection, objID)
+	}
+	_, err = s.redemptions.DeleteMany(ctx, bson.M{"promotion_id": id})
+	return err
+}
+
+// redemptionKey identifies the uses of a promotion by one customer.
+func redemptionKey(promotionID, customerID string) string {
+	return promotionID + ":" + customerID
+}
+
+func (s *PromotionStorage) Redeem(ctx context.Context, promotion *models.Promotion, customerID string) error {
+	objID, err := primitive.ObjectIDFromHex(promotion.ID)
+	if err != nil {
+		return err
+	}
+
+	// The limits are conditions of the increments, so concurrent orders
+	// cannot both take the last use.
+	filter := bson.M{"_id": objID}
+	if promotion.UsageLimit > 0 {
+		filter["uses"] = bson.M{"$lt": promotion.UsageLimit}
+	}
+	res, err := s.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := s.FindByID(ctx, promotion.ID); err != nil {
+			return err
+		}
+		return repos.ErrLimitReached
+	}
+
+	if promotion.PerCustomerLimit == 0 || customerID == "" {
+		return nil
+	}
+	// Once the customer has reached the limit the filter no longer
+	// matches and the upsert collides with the existing document.
+	_, err = s.redemptions.UpdateOne(ctx,
+		bson.M{"_id": redemptionKey(promotion.ID, customerID), "count": bson.M{"$lt": promotion.PerCustomerLimit}},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"promotion_id": promotion.ID, "customer_id": customerID},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err == nil {
+		return nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		err = repos.ErrLimitReached
+	}
+	_, undoErr := s.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$inc": bson.M{"uses": -1}})
+	return errors.Join(err, undoErr)
+}
+
+func (s *PromotionStorage) Release(ctx context.Context, promotion *models.Promotion, customerID string) error {
+	objID, err := primitive.ObjectIDFromHex(promotion.ID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": objID, "uses": bson.M{"$gt": 0}}
+	if _, err := s.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": -1}}); err != nil {
+		return err
+	}
+	if promotion.PerCustomerLimit == 0 || customerID == "" {
+		return nil
+	}
+	filter = bson.M{"_id": redemptionKey(promotion.ID, customerID), "count": bson.M{"$gt": 0}}
+	_, err = s.redemptions.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": -1}})
+	return err
+}
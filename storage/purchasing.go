@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SupplierStorage struct {
+	collection *mongo.Collection
+}
+
+func NewSupplierStorage(coll *mongo.Collection) *SupplierStorage {
+	return &SupplierStorage{
+		collection: coll,
+	}
+}
+
+func (s *SupplierStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	return err
+}
+
+func (s *SupplierStorage) Create(ctx context.Context, supplier *models.Supplier) (*models.Supplier, error) {
+	objID := primitive.NewObjectID()
+	supplier.ID = objID.Hex()
+	supplier.Version = 1
+	supplier.CreatedAt = timestamp(time.Now())
+	supplier.UpdatedAt = supplier.CreatedAt
+
+	_, err := s.collection.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "name", Value: supplier.Name},
+		{Key: "email", Value: supplier.Email},
+		{Key: "phone", Value: supplier.Phone},
+		{Key: "address", Value: supplier.Address},
+		{Key: "lead_time_days", Value: supplier.LeadTimeDays},
+		{Key: "note", Value: supplier.Note},
+		{Key: "version", Value: supplier.Version},
+		{Key: "created_at", Value: supplier.CreatedAt},
+		{Key: "updated_at", Value: supplier.UpdatedAt},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return supplier, nil
+}
+
+func (s *SupplierStorage) FindByID(ctx context.Context, id string) (*models.Supplier, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var supplier models.Supplier
+	if err := s.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&supplier); err != nil {
+		return nil, notFound(err)
+	}
+	return &supplier, nil
+}
+
+func (s *SupplierStorage) FindAll(ctx context.Context, opts repos.ListOptions) ([]*models.Supplier, error) {
+	list := listing{coll: s.collection, key: objectIDKey, sort: []sortKey{{key: "name"}}}
+	return findPage[models.Supplier](ctx, list, bson.M{}, opts)
+}
+
+func (s *SupplierStorage) Count(ctx context.Context) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{})
+}
+
+func (s *SupplierStorage) Update(ctx context.Context, id string, supplier *models.Supplier) (*models.Supplier, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": objID}
+	if supplier.Version > 0 {
+		filter["version"] = supplier.Version
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"name":           supplier.Name,
+			"email":          supplier.Email,
+			"phone":          supplier.Phone,
+			"address":        supplier.Address,
+			"lead_time_days": supplier.LeadTimeDays,
+			"note":           supplier.Note,
+			"updated_at":     timestamp(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	var updated models.Supplier
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *SupplierStorage) Delete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objID}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return missOrConflict(ctx, s.collection, objID)
+	}
+	return nil
+}
+
+type PurchaseOrderStorage struct {
+	collection *mongo.Collection
+}
+
+func NewPurchaseOrderStorage(coll *mongo.Collection) *PurchaseOrderStorage {
+	return &PurchaseOrderStorage{
+		collection: coll,
+	}
+}
+
+func (s *PurchaseOrderStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "supplier_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "lines.product_id", Value: 1}}},
+	})
+	return err
+}
+
+// totalCost sums the cost of every line of po.
+func totalCost(po *models.PurchaseOrder) float64 {
+	total := 0.0
+	for _, line := range po.Lines {
+		total += line.UnitCost * float64(line.Quantity)
+	}
+	return math.Round(total*100) / 100
+}
+
+func (s *PurchaseOrderStorage) Create(ctx context.Context, po *models.PurchaseOrder) (*models.PurchaseOrder, error) {
+	po.ID = primitive.NewObjectID().Hex()
+	po.Status = models.PurchaseOrderDraft
+	po.TotalCost = totalCost(po)
+	po.Version = 1
+	po.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	po.UpdatedAt = po.CreatedAt
+	po.SentAt, po.ReceivedAt = "", ""
+	for i := range po.Lines {
+		po.Lines[i].Received = 0
+	}
+
+	if _, err := s.collection.InsertOne(ctx, po); err != nil {
+		return nil, err
+	}
+	return po, nil
+}
+
+func (s *PurchaseOrderStorage) FindByID(ctx context.Context, id string) (*models.PurchaseOrder, error) {
+	if !primitive.IsValidObjectID(id) {
+		return nil, repos.ErrNotFound
+	}
+	var po models.PurchaseOrder
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&po); err != nil {
+		return nil, notFound(err)
+	}
+	return &po, nil
+}
+
+func purchaseOrderFilter(filter repos.PurchaseOrderFilter) bson.M {
+	query := bson.M{}
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+	if filter.SupplierID != "" {
+		query["supplier_id"] = filter.SupplierID
+	}
+	if filter.ProductID != "" {
+		query["lines.product_id"] = filter.ProductID
+	}
+	return query
+}
+
+func (s *PurchaseOrderStorage) FindAll(ctx context.Context, filter repos.PurchaseOrderFilter, opts repos.ListOptions) ([]*models.PurchaseOrder, error) {
+	list := listing{coll: s.collection, key: hexKey, sort: []sortKey{{key: "created_at", desc: true}}}
+	return findPage[models.PurchaseOrder](ctx, list, purchaseOrderFilter(filter), opts)
+}
+
+func (s *PurchaseOrderStorage) Count(ctx context.Context, filter repos.PurchaseOrderFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, purchaseOrderFilter(filter))
+}
+
+func (s *PurchaseOrderStorage) Update(ctx context.Context, id string, po *models.PurchaseOrder) (*models.PurchaseOrder, error) {
+	for i := range po.Lines {
+		po.Lines[i].Received = 0
+	}
+	filter := bson.M{"_id": id, "status": models.PurchaseOrderDraft}
+	if po.Version > 0 {
+		filter["version"] = po.Version
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"supplier_id":   po.SupplierID,
+			"warehouse_id":  po.WarehouseID,
+			"lines":         po.Lines,
+			"expected_date": po.ExpectedDate,
+			"note":          po.Note,
+			"total_cost":    totalCost(po),
+			"updated_at":    time.Now().UTC().Format(time.RFC3339),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	return s.findAndUpdate(ctx, id, filter, update)
+}
+
+func (s *PurchaseOrderStorage) SetStatus(ctx context.Context, id string, from []string, to string) (*models.PurchaseOrder, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	set := bson.M{"status": to, "updated_at": now}
+	if to == models.PurchaseOrderSent {
+		set["sent_at"] = now
+	}
+	filter := bson.M{"_id": id, "status": bson.M{"$in": from}}
+	return s.findAndUpdate(ctx, id, filter, bson.M{"$set": set, "$inc": bson.M{"version": 1}})
+}
+
+func (s *PurchaseOrderStorage) SetReceived(ctx context.Context, po *models.PurchaseOrder) (*models.PurchaseOrder, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	set := bson.M{"lines": po.Lines, "status": po.Status, "updated_at": now}
+	if po.Status == models.PurchaseOrderReceived {
+		set["received_at"] = now
+	}
+	filter := bson.M{"_id": po.ID, "version": po.Version}
+	return s.findAndUpdate(ctx, po.ID, filter, bson.M{"$set": set, "$inc": bson.M{"version": 1}})
+}
+
+// findAndUpdate applies a conditional update to the purchase order id and
+// tells a missing purchase order from one that did not match filter.
+func (s *PurchaseOrderStorage) findAndUpdate(ctx context.Context, id string, filter, update bson.M) (*models.PurchaseOrder, error) {
+	var updated models.PurchaseOrder
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.FindByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, repos.ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/query"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+	intField
+	// dateField holds UTC RFC 3339 timestamps.
+	dateField
+	// dayField holds calendar dates, YYYY-MM-DD.
+	dayField
+)
+
+// queryField describes a field clients may filter or sort on.
+type queryField struct {
+	key      string // document key
+	kind     fieldKind
+	sortable bool
+}
+
+// querySchema whitelists the fields of a collection by their API name.
+// Anything not listed is rejected, so clients can never reach arbitrary
+// document keys or inject operators.
+type querySchema map[string]queryField
+
+var productQuerySchema = querySchema{
+	"name":        {key: "name", kind: textField, sortable: true},
+	"sku":         {key: "sku", kind: textField, sortable: true},
+	"variant_sku": {key: "variants.sku", kind: textField},
+	"type":        {key: "type", kind: textField},
+	"category_id": {key: "category_id", kind: textField},
+	"category":    {key: "category", kind: textField, sortable: true},
+	"price":       {key: "price", kind: numberField, sortable: true},
+	"tax_class":   {key: "tax_class", kind: textField},
+	"stock":       {key: "stock", kind: intField, sortable: true},
+	"created_at":  {key: "created_at", kind: dateField, sortable: true},
+	"updated_at":  {key: "updated_at", kind: dateField, sortable: true},
+}
+
+var orderQuerySchema = querySchema{
+	"status":      {key: "status", kind: textField, sortable: true},
+	"customer_id": {key: "customer_id", kind: textField, sortable: true},
+	"total_price": {key: "total_price", kind: numberField, sortable: true},
+	"order_date":  {key: "order_date", kind: dayField, sortable: true},
+	"created_at":  {key: "created_at", kind: dateField, sortable: true},
+	"updated_at":  {key: "updated_at", kind: dateField, sortable: true},
+	"product_id":  {key: "products.product_id", kind: textField},
+	"variant_id":  {key: "products.variant_id", kind: textField},
+}
+
+var comparisonOps = map[query.Op]string{
+	query.Ne:  "$ne",
+	query.Gt:  "$gt",
+	query.Gte: "$gte",
+	query.Lt:  "$lt",
+	query.Lte: "$lte",
+	query.In:  "$in",
+	query.Nin: "$nin",
+}
+
+// filter translates the conditions into Mongo clauses meant to be ANDed.
+func (s querySchema) filter(conds []query.Condition) ([]bson.M, error) {
+	clauses := make([]bson.M, 0, len(conds))
+	for _, cond := range conds {
+		field, ok := s[cond.Field]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown filter field %q", query.ErrInvalid, cond.Field)
+		}
+
+		if cond.Op == query.Contains {
+			if field.kind != textField {
+				return nil, fmt.Errorf("%w: contains is only valid on text fields", query.ErrInvalid)
+			}
+			clauses = append(clauses, bson.M{field.key: bson.M{
+				"$regex":   regexp.QuoteMeta(cond.Value()),
+				"$options": "i",
+			}})
+			continue
+		}
+
+		if field.kind == dateField || field.kind == dayField {
+			clause, err := field.kind.dateClause(field.key, cond)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", query.ErrInvalid, cond.Field, err)
+			}
+			clauses = append(clauses, clause)
+			continue
+		}
+
+		values := make([]interface{}, len(cond.Values))
+		for i, raw := range cond.Values {
+			v, err := field.kind.parse(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", query.ErrInvalid, cond.Field, err)
+			}
+			values[i] = v
+		}
+
+		switch {
+		case cond.Op == query.Eq:
+			clauses = append(clauses, bson.M{field.key: values[0]})
+		case cond.Op.Multi():
+			clauses = append(clauses, bson.M{field.key: bson.M{comparisonOps[cond.Op]: values}})
+		default:
+			clauses = append(clauses, bson.M{field.key: bson.M{comparisonOps[cond.Op]: values[0]}})
+		}
+	}
+	return clauses, nil
+}
+
+// sort resolves the requested sort keys to document keys.
+func (s querySchema) sort(sorts []query.Sort) ([]sortKey, error) {
+	keys := make([]sortKey, 0, len(sorts))
+	for _, srt := range sorts {
+		field, ok := s[srt.Field]
+		if !ok || !field.sortable {
+			return nil, fmt.Errorf("%w: cannot sort by %q", query.ErrInvalid, srt.Field)
+		}
+		keys = append(keys, sortKey{key: field.key, desc: srt.Desc})
+	}
+	return keys, nil
+}
+
+func (k fieldKind) parse(raw string) (interface{}, error) {
+	switch k {
+	case numberField:
+		return strconv.ParseFloat(raw, 64)
+	case intField:
+		return strconv.ParseInt(raw, 10, 64)
+	default:
+		return raw, nil
+	}
+}
+
+// timestamp formats t the way dateField values are stored, in UTC so that
+// they order chronologically as text.
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// dateRange reads a date operand as the span it names: a whole day for
+// YYYY-MM-DD, one second for an RFC 3339 timestamp, in the storage format
+// of the field. Bare dates are days in UTC.
+func (k fieldKind) dateRange(raw string) (start, end string, err error) {
+	var from, to time.Time
+	if day, err := time.Parse(time.DateOnly, raw); err == nil {
+		from, to = day, day.AddDate(0, 0, 1)
+	} else if at, err := time.Parse(time.RFC3339, raw); err == nil {
+		from = at.UTC().Truncate(time.Second)
+		to = from.Add(time.Second)
+		if k == dayField {
+			from = from.Truncate(24 * time.Hour)
+			to = from.AddDate(0, 0, 1)
+		}
+	} else {
+		return "", "", fmt.Errorf("expected YYYY-MM-DD or RFC 3339 date, got %q", raw)
+	}
+
+	if k == dayField {
+		return from.Format(time.DateOnly), to.Format(time.DateOnly), nil
+	}
+	return timestamp(from), timestamp(to), nil
+}
+
+// dateClause translates a condition on a date field into a comparison of
+// the spans its operands name, so that lte 2024-05-01 takes in the whole
+// of that day.
+func (k fieldKind) dateClause(key string, cond query.Condition) (bson.M, error) {
+	ranges := make([]bson.M, len(cond.Values))
+	var start, end string
+	for i, raw := range cond.Values {
+		var err error
+		if start, end, err = k.dateRange(raw); err != nil {
+			return nil, err
+		}
+		ranges[i] = bson.M{key: bson.M{"$gte": start, "$lt": end}}
+	}
+
+	switch cond.Op {
+	case query.Eq:
+		return ranges[0], nil
+	case query.Ne:
+		return bson.M{"$nor": bson.A{ranges[0]}}, nil
+	case query.In:
+		return bson.M{"$or": ranges}, nil
+	case query.Nin:
+		return bson.M{"$nor": ranges}, nil
+	case query.Gt:
+		return bson.M{key: bson.M{"$gte": end}}, nil
+	case query.Gte:
+		return bson.M{key: bson.M{"$gte": start}}, nil
+	case query.Lt:
+		return bson.M{key: bson.M{"$lt": start}}, nil
+	default:
+		return bson.M{key: bson.M{"$lt": end}}, nil
+	}
+}
+
+// attributePrefix marks filters on category-defined product attributes,
+// e.g. filter[attr.screen_size][gte]=6.
+const attributePrefix = "attr."
+
+// attributeFilter translates a condition on a product attribute. Attribute
+// types vary by category, so an equality operand matches the value as text,
+// number or bool alike; range operands must be numbers.
+func attributeFilter(cond query.Condition) (bson.M, error) {
+	name := strings.TrimPrefix(cond.Field, attributePrefix)
+	if !models.AttributeKeyPattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: unknown filter field %q", query.ErrInvalid, cond.Field)
+	}
+	key := "attributes." + name
+
+	switch cond.Op {
+	case query.Contains:
+		return bson.M{key: bson.M{"$regex": regexp.QuoteMeta(cond.Value()), "$options": "i"}}, nil
+	case query.Eq, query.In:
+		return bson.M{key: bson.M{"$in": attributeValues(cond.Values)}}, nil
+	case query.Ne, query.Nin:
+		return bson.M{key: bson.M{"$nin": attributeValues(cond.Values)}}, nil
+	default:
+		n, err := strconv.ParseFloat(cond.Value(), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s needs a number", query.ErrInvalid, cond.Field, cond.Op)
+		}
+		return bson.M{key: bson.M{comparisonOps[cond.Op]: n}}, nil
+	}
+}
+
+// attributeValues lists every typed reading of the raw operands.
+func attributeValues(raw []string) bson.A {
+	values := bson.A{}
+	for _, r := range raw {
+		values = append(values, r)
+		if n, err := strconv.ParseFloat(r, 64); err == nil {
+			values = append(values, n)
+		}
+		if r == "true" || r == "false" {
+			values = append(values, r == "true")
+		}
+	}
+	return values
+}
+
+// and combines clauses into a single filter document.
+func and(clauses []bson.M) bson.M {
+	switch len(clauses) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return clauses[0]
+	default:
+		return bson.M{"$and": clauses}
+	}
+}
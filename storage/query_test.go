@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/udevs/lesson3/pkg/query"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDateFilter(t *testing.T) {
+	day := bson.M{"created_at": bson.M{"$gte": "2024-05-01T00:00:00Z", "$lt": "2024-05-02T00:00:00Z"}}
+
+	tests := []struct {
+		name   string
+		field  string
+		op     query.Op
+		values []string
+		want   bson.M
+		isErr  bool
+	}{
+		{
+			name:   "date-only lte takes in the whole day",
+			field:  "created_at",
+			op:     query.Lte,
+			values: []string{"2024-05-01"},
+			want:   bson.M{"created_at": bson.M{"$lt": "2024-05-02T00:00:00Z"}},
+		},
+		{
+			name:   "date-only gt starts the next day",
+			field:  "created_at",
+			op:     query.Gt,
+			values: []string{"2024-05-01"},
+			want:   bson.M{"created_at": bson.M{"$gte": "2024-05-02T00:00:00Z"}},
+		},
+		{
+			name:   "date-only gte",
+			field:  "updated_at",
+			op:     query.Gte,
+			values: []string{"2024-05-01"},
+			want:   bson.M{"updated_at": bson.M{"$gte": "2024-05-01T00:00:00Z"}},
+		},
+		{
+			name:   "date-only lt",
+			field:  "created_at",
+			op:     query.Lt,
+			values: []string{"2024-12-31"},
+			want:   bson.M{"created_at": bson.M{"$lt": "2024-12-31T00:00:00Z"}},
+		},
+		{
+			name:   "date-only eq is the day",
+			field:  "created_at",
+			op:     query.Eq,
+			values: []string{"2024-05-01"},
+			want:   day,
+		},
+		{
+			name:   "date-only ne",
+			field:  "created_at",
+			op:     query.Ne,
+			values: []string{"2024-05-01"},
+			want:   bson.M{"$nor": bson.A{day}},
+		},
+		{
+			name:   "offset normalised to UTC",
+			field:  "created_at",
+			op:     query.Lte,
+			values: []string{"2024-05-01T10:00:00+05:00"},
+			want:   bson.M{"created_at": bson.M{"$lt": "2024-05-01T05:00:01Z"}},
+		},
+		{
+			name:   "offset across midnight",
+			field:  "created_at",
+			op:     query.Gte,
+			values: []string{"2024-05-01T02:30:00+05:00"},
+			want:   bson.M{"created_at": bson.M{"$gte": "2024-04-30T21:30:00Z"}},
+		},
+		{
+			name:   "timestamp gt excludes the second",
+			field:  "created_at",
+			op:     query.Gt,
+			values: []string{"2024-05-01T10:00:00Z"},
+			want:   bson.M{"created_at": bson.M{"$gte": "2024-05-01T10:00:01Z"}},
+		},
+		{
+			name:   "in lists days",
+			field:  "created_at",
+			op:     query.In,
+			values: []string{"2024-05-01", "2024-05-03"},
+			want: bson.M{"$or": []bson.M{
+				day,
+				{"created_at": bson.M{"$gte": "2024-05-03T00:00:00Z", "$lt": "2024-05-04T00:00:00Z"}},
+			}},
+		},
+		{
+			name:   "day field stays a date",
+			field:  "order_date",
+			op:     query.Lte,
+			values: []string{"2024-05-01"},
+			want:   bson.M{"order_date": bson.M{"$lt": "2024-05-02"}},
+		},
+		{
+			name:   "day field takes the UTC day of a timestamp",
+			field:  "order_date",
+			op:     query.Eq,
+			values: []string{"2024-05-01T02:00:00+05:00"},
+			want:   bson.M{"order_date": bson.M{"$gte": "2024-04-30", "$lt": "2024-05-01"}},
+		},
+		{
+			name:   "not a date",
+			field:  "created_at",
+			op:     query.Gte,
+			values: []string{"yesterday"},
+			isErr:  true,
+		},
+		{
+			name:   "contains on a date",
+			field:  "created_at",
+			op:     query.Contains,
+			values: []string{"2024"},
+			isErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := productQuerySchema
+			if tt.field == "order_date" {
+				schema = orderQuerySchema
+			}
+			clauses, err := schema.filter([]query.Condition{{Field: tt.field, Op: tt.op, Values: tt.values}})
+			if tt.isErr {
+				if !errors.Is(err, query.ErrInvalid) {
+					t.Errorf("error = %v, want query.ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filter: %v", err)
+			}
+			if len(clauses) != 1 || !reflect.DeepEqual(clauses[0], tt.want) {
+				t.Errorf("got %v, want %v", clauses, tt.want)
+			}
+		})
+	}
+}
+
+func TestUTCTimestamp(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"2024-05-01T10:00:00+05:00", "2024-05-01T05:00:00Z"},
+		{"2024-05-01T10:00:00Z", "2024-05-01T10:00:00Z"},
+		{"2024-05-01", "2024-05-01T00:00:00Z"},
+		{"", ""},
+		{"garbage", "garbage"},
+	}
+	for _, tt := range tests {
+		if got := utcTimestamp(tt.raw); got != tt.want {
+			t.Errorf("utcTimestamp(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
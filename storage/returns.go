@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReturnStorage struct {
+	collection *mongo.Collection
+}
+
+func NewReturnStorage(coll *mongo.Collection) *ReturnStorage {
+	return &ReturnStorage{
+		collection: coll,
+	}
+}
+
+func (s *ReturnStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+	})
+	return err
+}
+
+func (s *ReturnStorage) Create(ctx context.Context, ret *models.Return) (*models.Return, error) {
+	ret.ID = primitive.NewObjectID().Hex()
+	ret.Status = models.ReturnRequested
+	ret.Version = 1
+	ret.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	ret.UpdatedAt = ret.CreatedAt
+	ret.ReceivedAt, ret.RefundedAt = "", ""
+
+	if _, err := s.collection.InsertOne(ctx, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *ReturnStorage) FindByID(ctx context.Context, id string) (*models.Return, error) {
+	if !primitive.IsValidObjectID(id) {
+		return nil, repos.ErrNotFound
+	}
+	var ret models.Return
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&ret); err != nil {
+		return nil, notFound(err)
+	}
+	return &ret, nil
+}
+
+func returnFilter(filter repos.ReturnFilter) bson.M {
+	query := bson.M{}
+	if filter.OrderID != "" {
+		query["order_id"] = filter.OrderID
+	}
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+	return query
+}
+
+func (s *ReturnStorage) FindAll(ctx context.Context, filter repos.ReturnFilter, opts repos.ListOptions) ([]*models.Return, error) {
+	list := listing{coll: s.collection, key: hexKey, sort: []sortKey{{key: "created_at", desc: true}}}
+	return findPage[models.Return](ctx, list, returnFilter(filter), opts)
+}
+
+func (s *ReturnStorage) Count(ctx context.Context, filter repos.ReturnFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, returnFilter(filter))
+}
+
+func (s *ReturnStorage) FindByOrder(ctx context.Context, orderID string) ([]*models.Return, error) {
+	returns := []*models.Return{}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	err := forEach(ctx, s.collection, bson.M{"order_id": orderID}, func(ret *models.Return) error {
+		returns = append(returns, ret)
+		return nil
+	}, opts)
+	return returns, err
+}
+
+func (s *ReturnStorage) Update(ctx context.Context, ret *models.Return) (*models.Return, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	set := bson.M{
+		"lines":            ret.Lines,
+		"status":           ret.Status,
+		"note":             ret.Note,
+		"warehouse_id":     ret.WarehouseID,
+		"refund":           ret.Refund,
+		"tax":              ret.Tax,
+		"payment_refunded": ret.PaymentRefunded,
+		"received_at":      ret.ReceivedAt,
+		"refunded_at":      ret.RefundedAt,
+		"updated_at":       now,
+	}
+	filter := bson.M{"_id": ret.ID, "version": ret.Version}
+
+	var updated models.Return
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": set, "$inc": bson.M{"version": 1}}, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.FindByID(ctx, ret.ID); err != nil {
+			return nil, err
+		}
+		return nil, repos.ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
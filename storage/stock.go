@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// openingBalance is the reason of the movements that start the ledger of a
+// product with the stock it already had.
+const openingBalance = "opening balance"
+
+// variantRemoved is the reason of the adjustments writing off the stock of
+// variants removed from a product.
+const variantRemoved = "variant removed"
+
+// StockLedgerStorage keeps the stock movements of products. Movements are
+// written by ProductStorage as it changes stock and never changed after.
+type StockLedgerStorage struct {
+	collection *mongo.Collection
+	// products is read to reconcile the ledger with the cached stock.
+	products *mongo.Collection
+}
+
+func NewStockLedgerStorage(coll, products *mongo.Collection) *StockLedgerStorage {
+	return &StockLedgerStorage{
+		collection: coll,
+		products:   products,
+	}
+}
+
+func (s *StockLedgerStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+	})
+	return err
+}
+
+// Record appends movements to the ledger, stamping them with the actor and
+// request of ctx.
+func (s *StockLedgerStorage) Record(ctx context.Context, movements ...*models.StockMovement) error {
+	if len(movements) == 0 {
+		return nil
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	docs := make([]interface{}, 0, len(movements))
+	for _, m := range movements {
+		m.ID = primitive.NewObjectID().Hex()
+		m.Actor = reqctx.Actor(ctx)
+		m.RequestID = reqctx.RequestID(ctx)
+		m.CreatedAt = now
+		docs = append(docs, m)
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+// openingMovements returns the receipts that account for the stock a
+// product holds: one per location, or for products stocked before
+// warehouses existed, one per stocked variant or for the product itself.
+// Bundles hold no stock of their own.
+func openingMovements(product *models.Product) []*models.StockMovement {
+	if product.IsBundle() {
+		return nil
+	}
+	var movements []*models.StockMovement
+	add := func(warehouseID, variantID string, stock int) {
+		if stock != 0 {
+			movements = append(movements, &models.StockMovement{
+				ProductID:   product.ID,
+				VariantID:   variantID,
+				WarehouseID: warehouseID,
+				Type:        models.MovementReceipt,
+				Quantity:    stock,
+				Reason:      openingBalance,
+			})
+		}
+	}
+	switch {
+	case len(product.Locations) > 0:
+		for _, l := range product.Locations {
+			add(l.WarehouseID, l.VariantID, l.Stock)
+		}
+	case len(product.Variants) == 0:
+		add("", "", product.Stock)
+	default:
+		for _, v := range product.Variants {
+			add("", v.ID, v.Stock)
+		}
+	}
+	return movements
+}
+
+// droppedStock returns the adjustments writing off the stock that stored
+// held for variants not among variants, or for the product itself once it
+// has variants, which an update drops along with them.
+func droppedStock(stored *models.Product, variants []models.Variant) []*models.StockMovement {
+	if stored.IsBundle() {
+		return nil
+	}
+	kept := map[string]bool{}
+	for _, v := range variants {
+		kept[v.ID] = true
+	}
+	if len(variants) == 0 {
+		kept[""] = true
+	}
+	var movements []*models.StockMovement
+	add := func(warehouseID, variantID string, stock int) {
+		if stock != 0 && !kept[variantID] {
+			movements = append(movements, &models.StockMovement{
+				ProductID:   stored.ID,
+				VariantID:   variantID,
+				WarehouseID: warehouseID,
+				Type:        models.MovementAdjustment,
+				Quantity:    -stock,
+				Reason:      variantRemoved,
+			})
+		}
+	}
+	switch {
+	case len(stored.Locations) > 0:
+		for _, l := range stored.Locations {
+			add(l.WarehouseID, l.VariantID, l.Stock)
+		}
+	case len(stored.Variants) == 0:
+		add("", "", stored.Stock)
+	default:
+		for _, v := range stored.Variants {
+			add("", v.ID, v.Stock)
+		}
+	}
+	return movements
+}
+
+func stockMovementFilter(productID string, filter repos.StockMovementFilter) bson.M {
+	query := bson.M{"product_id": productID}
+	if filter.VariantID != "" {
+		query["variant_id"] = filter.VariantID
+	}
+	if filter.WarehouseID != "" {
+		query["warehouse_id"] = filter.WarehouseID
+	}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	return query
+}
+
+func (s *StockLedgerStorage) FindByProduct(ctx context.Context, productID string, filter repos.StockMovementFilter, opts repos.ListOptions) ([]*models.StockMovement, error) {
+	list := listing{coll: s.collection, key: hexKey, sort: []sortKey{{key: "created_at", desc: true}}}
+	return findPage[models.StockMovement](ctx, list, stockMovementFilter(productID, filter), opts)
+}
+
+func (s *StockLedgerStorage) CountByProduct(ctx context.Context, productID string, filter repos.StockMovementFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, stockMovementFilter(productID, filter))
+}
+
+// ledgerKey identifies a stock level in the ledger. An empty warehouse
+// stands for the total over all warehouses.
+type ledgerKey struct {
+	productID   string
+	variantID   string
+	warehouseID string
+}
+
+// balances sums the ledger per location, and per product and variant over
+// all locations.
+func (s *StockLedgerStorage) balances(ctx context.Context) (map[ledgerKey]int, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"product_id":   "$product_id",
+				"variant_id":   bson.M{"$ifNull": bson.A{"$variant_id", ""}},
+				"warehouse_id": bson.M{"$ifNull": bson.A{"$warehouse_id", ""}},
+			},
+			"quantity": bson.M{"$sum": "$quantity"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	balances := map[ledgerKey]int{}
+	for cursor.Next(ctx) {
+		var row struct {
+			Key struct {
+				ProductID   string `bson:"product_id"`
+				VariantID   string `bson:"variant_id"`
+				WarehouseID string `bson:"warehouse_id"`
+			} `bson:"_id"`
+			Quantity int `bson:"quantity"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		if row.Key.WarehouseID != "" {
+			balances[ledgerKey{row.Key.ProductID, row.Key.VariantID, row.Key.WarehouseID}] += row.Quantity
+		}
+		balances[ledgerKey{row.Key.ProductID, row.Key.VariantID, ""}] += row.Quantity
+	}
+	return balances, cursor.Err()
+}
+
+// Reconcile compares the live products with the ledger, both their total
+// stock and their stock at each location. The ledger of deleted variants
+// is left out, as those no longer hold stock.
+func (s *StockLedgerStorage) Reconcile(ctx context.Context) (*models.StockReconciliation, error) {
+	balances, err := s.balances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.StockReconciliation{
+		CheckedAt:  time.Now().UTC().Format(time.RFC3339),
+		Mismatches: []models.StockMismatch{},
+	}
+	compare := func(product *models.Product, key ledgerKey, cached int) {
+		report.Checked++
+		ledger := balances[key]
+		if ledger != cached {
+			report.Mismatches = append(report.Mismatches, models.StockMismatch{
+				ProductID:   product.ID,
+				VariantID:   key.variantID,
+				WarehouseID: key.warehouseID,
+				Name:        product.Name,
+				Cached:      cached,
+				Ledger:      ledger,
+				Difference:  cached - ledger,
+			})
+		}
+	}
+
+	filter := live()
+	filter["type"] = bson.M{"$ne": models.ProductTypeBundle}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	err = forEach(ctx, s.products, filter, func(product *models.Product) error {
+		if len(product.Variants) == 0 {
+			compare(product, ledgerKey{product.ID, "", ""}, product.Stock)
+		}
+		for _, v := range product.Variants {
+			compare(product, ledgerKey{product.ID, v.ID, ""}, v.Stock)
+		}
+		for _, l := range product.Locations {
+			compare(product, ledgerKey{product.ID, l.VariantID, l.WarehouseID}, l.Stock)
+		}
+		return nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// seedStockLedger opens the ledger of every product that has none yet with
+// the stock the product holds.
+func seedStockLedger(ctx context.Context, db *mongo.Database) error {
+	ledger := NewStockLedgerStorage(db.Collection("stock_movements"), db.Collection("products"))
+	ctx = reqctx.WithActor(ctx, "migration")
+	return forEach(ctx, db.Collection("products"), bson.M{}, func(product *models.Product) error {
+		n, err := ledger.collection.CountDocuments(ctx, bson.M{"product_id": product.ID}, options.Count().SetLimit(1))
+		if err != nil || n > 0 {
+			return err
+		}
+		return ledger.Record(ctx, openingMovements(product)...)
+	})
+}
+
+// seedWarehouses moves the stock products held before warehouses existed
+// into a default warehouse, created when there is none, and assigns the
+// movements already in the ledger to it.
+func seedWarehouses(ctx context.Context, db *mongo.Database) error {
+	warehouses := NewWarehouseStorage(db.Collection("warehouses"))
+	warehouse, err := warehouses.Default(ctx)
+	if errors.Is(err, repos.ErrNotFound) {
+		warehouse, err = warehouses.Create(ctx, &models.Warehouse{Code: "main", Name: "Main warehouse"})
+	}
+	if err != nil {
+		return err
+	}
+
+	products := db.Collection("products")
+	filter := bson.M{"locations": bson.M{"$exists": false}, "type": bson.M{"$ne": models.ProductTypeBundle}}
+	err = forEach(ctx, products, filter, func(product *models.Product) error {
+		var levels []models.StockLevel
+		if len(product.Variants) == 0 && product.Stock != 0 {
+			levels = append(levels, models.StockLevel{WarehouseID: warehouse.ID, Stock: product.Stock})
+		}
+		for _, v := range product.Variants {
+			if v.Stock != 0 {
+				levels = append(levels, models.StockLevel{WarehouseID: warehouse.ID, VariantID: v.ID, Stock: v.Stock})
+			}
+		}
+		if len(levels) == 0 {
+			return nil
+		}
+		objID, err := primitive.ObjectIDFromHex(product.ID)
+		if err != nil {
+			return err
+		}
+		_, err = products.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"locations": levels}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("stock_movements").UpdateMany(ctx,
+		bson.M{"warehouse_id": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"warehouse_id": warehouse.ID}})
+	return err
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/pkg/suggest"
+	"github.com/udevs/lesson3/repos"
+)
+
+// SuggestingProducts keeps a suggest.Index in step with the writes that go
+// through the wrapped repository.
+type SuggestingProducts struct {
+	repos.ProductRepository
+	index *suggest.Index
+}
+
+func NewSuggestingProducts(inner repos.ProductRepository, index *suggest.Index) *SuggestingProducts {
+	return &SuggestingProducts{ProductRepository: inner, index: index}
+}
+
+func (s *SuggestingProducts) Create(ctx context.Context, product *models.Product) (*models.Product, error) {
+	created, err := s.ProductRepository.Create(ctx, product)
+	if err == nil {
+		afterCommit(ctx, func() { s.index.Put(suggestItem(created)) })
+	}
+	return created, err
+}
+
+func (s *SuggestingProducts) Update(ctx context.Context, id string, product *models.Product) (*models.Product, error) {
+	updated, err := s.ProductRepository.Update(ctx, id, product)
+	if err == nil {
+		afterCommit(ctx, func() { s.index.Put(suggestItem(updated)) })
+	}
+	return updated, err
+}
+
+func (s *SuggestingProducts) Delete(ctx context.Context, id string, version int64) error {
+	err := s.ProductRepository.Delete(ctx, id, version)
+	if err == nil {
+		afterCommit(ctx, func() { s.index.Remove(id) })
+	}
+	return err
+}
+
+func (s *SuggestingProducts) Restore(ctx context.Context, id string) (*models.Product, error) {
+	restored, err := s.ProductRepository.Restore(ctx, id)
+	if err == nil {
+		afterCommit(ctx, func() { s.index.Put(suggestItem(restored)) })
+	}
+	return restored, err
+}
+
+// PopularityTrackingOrders boosts ordered products in a suggest.Index.
+type PopularityTrackingOrders struct {
+	repos.OrderRepository
+	index *suggest.Index
+}
+
+func NewPopularityTrackingOrders(inner repos.OrderRepository, index *suggest.Index) *PopularityTrackingOrders {
+	return &PopularityTrackingOrders{OrderRepository: inner, index: index}
+}
+
+func (o *PopularityTrackingOrders) Create(ctx context.Context, order *models.Order) (*models.Order, error) {
+	created, err := o.OrderRepository.Create(ctx, order)
+	if err == nil {
+		afterCommit(ctx, func() {
+			for _, line := range created.Products {
+				o.index.AddPopularity(line.ProductID, float64(line.Quantity))
+			}
+		})
+	}
+	return created, err
+}
+
+// LoadSuggestIndex fills index with every product and the quantities ordered
+// of each so far.
+func LoadSuggestIndex(ctx context.Context, index *suggest.Index, products *ProductStorage, orders *OrdersStorage) error {
+	err := products.ForEach(ctx, func(p *models.Product) error {
+		index.Put(suggestItem(p))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	popularity, err := orders.ProductPopularity(ctx)
+	if err != nil {
+		return err
+	}
+	for id, quantity := range popularity {
+		index.AddPopularity(id, float64(quantity))
+	}
+	return nil
+}
+
+func suggestItem(p *models.Product) suggest.Item {
+	item := suggest.Item{ID: p.ID, Name: p.Name, SKU: p.SKU}
+	for _, v := range p.Variants {
+		item.VariantSKUs = append(item.VariantSKUs, v.SKU)
+	}
+	return item
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TaxRateStorage struct {
+	collection *mongo.Collection
+}
+
+func NewTaxRateStorage(coll *mongo.Collection) *TaxRateStorage {
+	return &TaxRateStorage{
+		collection: coll,
+	}
+}
+
+func (s *TaxRateStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "country", Value: 1}, {Key: "region", Value: 1}, {Key: "tax_class", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	return err
+}
+
+// normalizeTaxRate upper-cases the region codes and gives the rate the
+// standard class when it names none.
+func normalizeTaxRate(rate *models.TaxRate) {
+	rate.Country = strings.ToUpper(strings.TrimSpace(rate.Country))
+	rate.Region = strings.ToUpper(strings.TrimSpace(rate.Region))
+	rate.TaxClass = strings.TrimSpace(rate.TaxClass)
+	if rate.TaxClass == "" {
+		rate.TaxClass = models.TaxClassStandard
+	}
+}
+
+func taxRateKey(rate *models.TaxRate) bson.M {
+	return bson.M{"country": rate.Country, "region": rate.Region, "tax_class": rate.TaxClass}
+}
+
+func (s *TaxRateStorage) Create(ctx context.Context, rate *models.TaxRate) (*models.TaxRate, error) {
+	normalizeTaxRate(rate)
+	objID := primitive.NewObjectID()
+	rate.ID = objID.Hex()
+	rate.Version = 1
+	rate.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	rate.UpdatedAt = rate.CreatedAt
+
+	doc := bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "name", Value: rate.Name},
+		{Key: "country", Value: rate.Country},
+		{Key: "region", Value: rate.Region},
+		{Key: "tax_class", Value: rate.TaxClass},
+		{Key: "rate", Value: rate.Rate},
+		{Key: "version", Value: rate.Version},
+		{Key: "created_at", Value: rate.CreatedAt},
+		{Key: "updated_at", Value: rate.UpdatedAt},
+	}
+	_, err := s.collection.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
+
+func (s *TaxRateStorage) FindByID(ctx context.Context, id string) (*models.TaxRate, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var rate models.TaxRate
+	if err := s.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&rate); err != nil {
+		return nil, notFound(err)
+	}
+	return &rate, nil
+}
+
+func taxRateFilter(filter repos.TaxRateFilter) bson.M {
+	query := bson.M{}
+	if filter.Country != "" {
+		query["country"] = strings.ToUpper(filter.Country)
+	}
+	return query
+}
+
+func (s *TaxRateStorage) FindAll(ctx context.Context, filter repos.TaxRateFilter, opts repos.ListOptions) ([]*models.TaxRate, error) {
+	list := listing{coll: s.collection, key: objectIDKey, sort: []sortKey{{key: "country"}, {key: "region"}, {key: "tax_class"}}}
+	return findPage[models.TaxRate](ctx, list, taxRateFilter(filter), opts)
+}
+
+func (s *TaxRateStorage) Count(ctx context.Context, filter repos.TaxRateFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, taxRateFilter(filter))
+}
+
+func (s *TaxRateStorage) ForCountry(ctx context.Context, country string) ([]*models.TaxRate, error) {
+	var rates []*models.TaxRate
+	filter := taxRateFilter(repos.TaxRateFilter{Country: country})
+	err := forEach(ctx, s.collection, filter, func(rate *models.TaxRate) error {
+		rates = append(rates, rate)
+		return nil
+	})
+	return rates, err
+}
+
+func (s *TaxRateStorage) Update(ctx context.Context, id string, rate *models.TaxRate) (*models.TaxRate, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	normalizeTaxRate(rate)
+
+	filter := bson.M{"_id": objID}
+	if rate.Version > 0 {
+		filter["version"] = rate.Version
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"name":       rate.Name,
+			"country":    rate.Country,
+			"region":     rate.Region,
+			"tax_class":  rate.TaxClass,
+			"rate":       rate.Rate,
+			"updated_at": time.Now().UTC().Format(time.RFC3339),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	var updated models.TaxRate
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *TaxRateStorage) Upsert(ctx context.Context, rate *models.TaxRate) (*models.TaxRate, error) {
+	normalizeTaxRate(rate)
+	now := time.Now().UTC().Format(time.RFC3339)
+	update := bson.M{
+		"$set":         bson.M{"name": rate.Name, "rate": rate.Rate, "updated_at": now},
+		"$inc":         bson.M{"version": 1},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+
+	var upserted models.TaxRate
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := s.collection.FindOneAndUpdate(ctx, taxRateKey(rate), update, opts).Decode(&upserted); err != nil {
+		return nil, err
+	}
+	return &upserted, nil
+}
+
+func (s *TaxRateStorage) Delete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objID}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return missOrConflict(ctx, s.collection, objID)
+	}
+	return nil
+}
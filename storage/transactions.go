@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs transactions on a replica set or sharded cluster. A
+// standalone server has no transactions; there the work runs as it is.
+type Transactor struct {
+	client    *mongo.Client
+	supported bool
+}
+
+// NewTransactor asks the server whether it supports transactions.
+func NewTransactor(ctx context.Context, client *mongo.Client) (*Transactor, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return nil, err
+	}
+	return &Transactor{
+		client:    client,
+		supported: hello.SetName != "" || hello.Msg == "isdbgrid",
+	}, nil
+}
+
+func (t *Transactor) Atomic() bool {
+	return t.supported
+}
+
+// InTransaction runs fn, in a transaction where the server supports them.
+// Work fn queues with afterCommit runs once fn has succeeded and the
+// transaction committed; an attempt that is retried or fails drops it.
+func (t *Transactor) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	hooks := &commitHooks{}
+	attempt := func(ctx context.Context) error {
+		hooks.reset()
+		return fn(context.WithValue(ctx, commitHooksKey{}, hooks))
+	}
+	if err := t.run(ctx, attempt); err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+func (t *Transactor) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !t.supported {
+		return fn(ctx)
+	}
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+type commitHooksKey struct{}
+
+// commitHooks is the work queued by the attempt of a transaction in
+// progress.
+type commitHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+func (h *commitHooks) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = nil
+}
+
+func (h *commitHooks) run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// afterCommit runs fn once the transaction ctx belongs to has committed, or
+// at once outside a transaction. Decorators update what lives outside the
+// database, such as in-memory indexes and stock alerts, through it, so that
+// writes a transaction undoes, or repeats, are not reflected there.
+func afterCommit(ctx context.Context, fn func()) {
+	hooks, ok := ctx.Value(commitHooksKey{}).(*commitHooks)
+	if !ok {
+		fn()
+		return
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.fns = append(hooks.fns, fn)
+}
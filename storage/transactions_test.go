@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAfterCommit(t *testing.T) {
+	fail := errors.New("fail")
+
+	tests := []struct {
+		name    string
+		queue   []string
+		err     error
+		wantRan []string
+	}{
+		{name: "run after success", queue: []string{"a", "b"}, wantRan: []string{"a", "b"}},
+		{name: "dropped on failure", queue: []string{"a"}, err: fail, wantRan: nil},
+		{name: "nothing queued", wantRan: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			tx := &Transactor{}
+			err := tx.InTransaction(context.Background(), func(ctx context.Context) error {
+				for _, name := range tt.queue {
+					afterCommit(ctx, func() { ran = append(ran, name) })
+				}
+				if len(ran) != 0 {
+					t.Errorf("ran %v before the commit", ran)
+				}
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", ran, tt.wantRan)
+			}
+		})
+	}
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		ran := false
+		afterCommit(context.Background(), func() { ran = true })
+		if !ran {
+			t.Error("did not run at once")
+		}
+	})
+
+	t.Run("retried attempt", func(t *testing.T) {
+		hooks := &commitHooks{}
+		ctx := context.WithValue(context.Background(), commitHooksKey{}, hooks)
+		count := 0
+		afterCommit(ctx, func() { count++ })
+		hooks.reset()
+		afterCommit(ctx, func() { count++ })
+		hooks.run()
+		if count != 1 {
+			t.Errorf("ran %d times, want 1", count)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/udevs/lesson3/pkg/reqctx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// live matches the documents that are not in the trash.
+func live() bson.M {
+	return bson.M{"deleted_at": bson.M{"$exists": false}}
+}
+
+// trashed matches the documents in the trash.
+func trashed() bson.M {
+	return bson.M{"deleted_at": bson.M{"$exists": true}}
+}
+
+// deletedAt formats t for deleted_at. Timestamps are kept in UTC so that
+// comparing them as strings orders them in time.
+func deletedAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// trashListing pages through the trash of coll, most recently deleted first.
+func trashListing(coll *mongo.Collection, key func(string) (interface{}, error)) listing {
+	return listing{coll: coll, key: key, sort: []sortKey{{key: "deleted_at", desc: true}}}
+}
+
+// softDelete moves the document with id to the trash if its stored version
+// equals version; a zero version skips the check. The caller in ctx is
+// recorded as the one who deleted it.
+func softDelete(ctx context.Context, coll *mongo.Collection, id interface{}, version int64) error {
+	filter := live()
+	filter["_id"] = id
+	if version > 0 {
+		filter["version"] = version
+	}
+
+	res, err := coll.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"deleted_at": deletedAt(time.Now()),
+			"deleted_by": reqctx.Actor(ctx),
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return missOrConflict(ctx, coll, id)
+	}
+	return nil
+}
+
+// restore takes the document with id out of the trash and decodes it into
+// out. It fails with ErrNotFound when the document is not in the trash.
+func restore(ctx context.Context, coll *mongo.Collection, id interface{}, out interface{}) error {
+	filter := trashed()
+	filter["_id"] = id
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := coll.FindOneAndUpdate(ctx, filter,
+		bson.M{
+			"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+			"$set":   bson.M{"updated_at": timestamp(time.Now())},
+			"$inc":   bson.M{"version": 1},
+		},
+		opts,
+	).Decode(out)
+	return notFound(err)
+}
+
+// purge permanently removes the documents of coll deleted before cutoff and
+// returns them. A document restored while the purge runs is left alone.
+func purge[T any](ctx context.Context, coll *mongo.Collection, cutoff time.Time) ([]*T, error) {
+	var purged []*T
+	filter := bson.M{"deleted_at": bson.M{"$lt": deletedAt(cutoff)}}
+	err := forEach(ctx, coll, filter, func(doc *bson.Raw) error {
+		res, err := coll.DeleteOne(ctx, bson.M{
+			"_id":        doc.Lookup("_id"),
+			"deleted_at": doc.Lookup("deleted_at"),
+		})
+		if err != nil || res.DeletedCount == 0 {
+			return err
+		}
+		var out T
+		if err := bson.Unmarshal(*doc, &out); err != nil {
+			return err
+		}
+		purged = append(purged, &out)
+		return nil
+	})
+	return purged, err
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/udevs/lesson3/models"
+	"github.com/udevs/lesson3/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// byPriority orders warehouses the way WarehouseRepository lists them.
+var byPriority = bson.D{{Key: "priority", Value: 1}, {Key: "code", Value: 1}}
+
+type WarehouseStorage struct {
+	collection *mongo.Collection
+}
+
+func NewWarehouseStorage(coll *mongo.Collection) *WarehouseStorage {
+	return &WarehouseStorage{
+		collection: coll,
+	}
+}
+
+func (s *WarehouseStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: byPriority},
+	})
+	return err
+}
+
+func (s *WarehouseStorage) Create(ctx context.Context, warehouse *models.Warehouse) (*models.Warehouse, error) {
+	objID := primitive.NewObjectID()
+	warehouse.ID = objID.Hex()
+	warehouse.Version = 1
+	warehouse.CreatedAt = timestamp(time.Now())
+	warehouse.UpdatedAt = warehouse.CreatedAt
+
+	_, err := s.collection.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: objID},
+		{Key: "code", Value: warehouse.Code},
+		{Key: "name", Value: warehouse.Name},
+		{Key: "address", Value: warehouse.Address},
+		{Key: "location", Value: warehouse.Location},
+		{Key: "priority", Value: warehouse.Priority},
+		{Key: "version", Value: warehouse.Version},
+		{Key: "created_at", Value: warehouse.CreatedAt},
+		{Key: "updated_at", Value: warehouse.UpdatedAt},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	return warehouse, nil
+}
+
+func (s *WarehouseStorage) FindByID(ctx context.Context, id string) (*models.Warehouse, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var warehouse models.Warehouse
+	if err := s.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&warehouse); err != nil {
+		return nil, notFound(err)
+	}
+	return &warehouse, nil
+}
+
+func (s *WarehouseStorage) FindAll(ctx context.Context) ([]*models.Warehouse, error) {
+	warehouses := []*models.Warehouse{}
+	err := forEach(ctx, s.collection, bson.M{}, func(w *models.Warehouse) error {
+		warehouses = append(warehouses, w)
+		return nil
+	}, options.Find().SetSort(byPriority))
+	if err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+func (s *WarehouseStorage) Default(ctx context.Context) (*models.Warehouse, error) {
+	var warehouse models.Warehouse
+	err := s.collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(byPriority)).Decode(&warehouse)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &warehouse, nil
+}
+
+func (s *WarehouseStorage) Update(ctx context.Context, id string, warehouse *models.Warehouse) (*models.Warehouse, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": objID}
+	if warehouse.Version > 0 {
+		filter["version"] = warehouse.Version
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"code":       warehouse.Code,
+			"name":       warehouse.Name,
+			"address":    warehouse.Address,
+			"location":   warehouse.Location,
+			"priority":   warehouse.Priority,
+			"updated_at": timestamp(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	var updated models.Warehouse
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, repos.ErrDuplicate
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, missOrConflict(ctx, s.collection, objID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *WarehouseStorage) Delete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objID}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return missOrConflict(ctx, s.collection, objID)
+	}
+	return nil
+}
+
+type TransferStorage struct {
+	collection *mongo.Collection
+}
+
+func NewTransferStorage(coll *mongo.Collection) *TransferStorage {
+	return &TransferStorage{
+		collection: coll,
+	}
+}
+
+func (s *TransferStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "from_warehouse_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "to_warehouse_id", Value: 1}, {Key: "status", Value: 1}}},
+	})
+	return err
+}
+
+func (s *TransferStorage) Create(ctx context.Context, transfer *models.Transfer) (*models.Transfer, error) {
+	transfer.ID = primitive.NewObjectID().Hex()
+	transfer.Status = models.TransferPending
+	transfer.Version = 1
+	transfer.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	transfer.UpdatedAt = transfer.CreatedAt
+
+	if _, err := s.collection.InsertOne(ctx, transfer); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func (s *TransferStorage) FindByID(ctx context.Context, id string) (*models.Transfer, error) {
+	if !primitive.IsValidObjectID(id) {
+		return nil, repos.ErrNotFound
+	}
+	var transfer models.Transfer
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&transfer); err != nil {
+		return nil, notFound(err)
+	}
+	return &transfer, nil
+}
+
+func transferFilter(filter repos.TransferFilter) bson.M {
+	query := bson.M{}
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+	if filter.WarehouseID != "" {
+		query["$or"] = bson.A{
+			bson.M{"from_warehouse_id": filter.WarehouseID},
+			bson.M{"to_warehouse_id": filter.WarehouseID},
+		}
+	}
+	return query
+}
+
+func (s *TransferStorage) FindAll(ctx context.Context, filter repos.TransferFilter, opts repos.ListOptions) ([]*models.Transfer, error) {
+	list := listing{coll: s.collection, key: hexKey, sort: []sortKey{{key: "created_at", desc: true}}}
+	return findPage[models.Transfer](ctx, list, transferFilter(filter), opts)
+}
+
+func (s *TransferStorage) Count(ctx context.Context, filter repos.TransferFilter) (int64, error) {
+	return s.collection.CountDocuments(ctx, transferFilter(filter))
+}
+
+func (s *TransferStorage) SetStatus(ctx context.Context, id, from, to string) (*models.Transfer, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	set := bson.M{"status": to, "updated_at": now}
+	unset := bson.M{}
+	switch to {
+	case models.TransferInTransit:
+		set["shipped_at"] = now
+	case models.TransferReceived:
+		set["received_at"] = now
+	case models.TransferPending:
+		unset["shipped_at"] = ""
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated models.Transfer
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": from}, update, opts).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.FindByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, repos.ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}